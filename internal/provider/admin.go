@@ -0,0 +1,178 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// AdminReconcilePath is the path of the admin endpoint that reconciles the
+// load balancer of a service on demand.
+const AdminReconcilePath = "/reconcile-service"
+
+// EventReasonManualReconcile is the reason of the event recorded on services
+// whose load balancer is reconciled through the admin endpoint.
+const EventReasonManualReconcile = "ManualReconcile"
+
+// adminReconcileTimeout bounds reconciling a service through the admin
+// endpoint.
+const adminReconcileTimeout = 2 * time.Minute
+
+// adminReconcileHandler reconciles the load balancer of the service named by
+// the namespace and name query parameters of a POST request, so an operator
+// can force a floating IP to be re-attached during an incident without
+// editing the service. Only services whose load balancer the service
+// controller already created are reconciled.
+type adminReconcileHandler struct {
+	updater     loadBalancerUpdater
+	k8sClient   kubernetes.Interface
+	clusterName string
+
+	// recorder records events on services. No events are recorded when nil.
+	recorder record.EventRecorder
+}
+
+// ServeHTTP implements [http.Handler].
+func (h *adminReconcileHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	namespace, name := r.URL.Query().Get("namespace"), r.URL.Query().Get("name")
+	if namespace == "" || name == "" {
+		http.Error(rw, "namespace and name are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), adminReconcileTimeout)
+	defer cancel()
+
+	status, err := h.reconcile(ctx, namespace, name)
+	if err != nil {
+		http.Error(rw, err.Error(), status)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(rw, "reconciled service %s/%s\n", namespace, name)
+}
+
+// reconcile updates the load balancer of the service, returning the HTTP
+// status to respond with when it fails.
+func (h *adminReconcileHandler) reconcile(
+	ctx context.Context,
+	namespace string,
+	name string,
+) (int, error) {
+	service, err := h.k8sClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return http.StatusNotFound, fmt.Errorf("service %s/%s not found", namespace, name)
+		}
+		return http.StatusInternalServerError, fmt.Errorf(
+			"failed getting service %s/%s: %w", namespace, name, err,
+		)
+	}
+	if !isReconciledService(service) || isIgnored(service) {
+		return http.StatusConflict, fmt.Errorf(
+			"service %s/%s has no load balancer managed by the cloud controller manager",
+			namespace, name,
+		)
+	}
+
+	nodeList, err := h.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed listing nodes: %w", err)
+	}
+	nodes := make([]*v1.Node, 0, len(nodeList.Items))
+	for i := range nodeList.Items {
+		if !excludedFromLoadBalancers(&nodeList.Items[i]) {
+			nodes = append(nodes, &nodeList.Items[i])
+		}
+	}
+
+	klog.InfoS("manually reconciling load balancer", "service", klog.KObj(service))
+	if h.recorder != nil {
+		h.recorder.Event(service, v1.EventTypeNormal, EventReasonManualReconcile,
+			"Load balancer reconcile triggered through the admin endpoint")
+	}
+
+	if err := h.updater.UpdateLoadBalancer(ctx, h.clusterName, service, nodes); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf(
+			"failed reconciling load balancer of service %s/%s: %w", namespace, name, err,
+		)
+	}
+
+	return http.StatusOK, nil
+}
+
+// requireAdminToken serves requests with next only when they carry the bearer
+// token in tokenFile, since the admin endpoints are served over plain HTTP on
+// the host network, where any pod sharing it can reach them. The token is read
+// on every request so that it can be rotated without a restart.
+func requireAdminToken(tokenFile string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			klog.ErrorS(err, "failed reading admin token file")
+			http.Error(rw, "admin token unavailable", http.StatusInternalServerError)
+			return
+		}
+		want := strings.TrimSpace(string(data))
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// serveAdmin serves the admin endpoints over HTTP on the configured address
+// until ctx is done. Requests must be authenticated with the admin token.
+func serveAdmin(ctx context.Context, config *AdminConfig, reconcile http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle(AdminReconcilePath, requireAdminToken(config.TokenFile, reconcile))
+
+	server := &http.Server{
+		Addr:              config.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.ErrorS(err, "failed shutting down admin server")
+		}
+	}()
+
+	klog.InfoS("serving admin endpoints", "address", config.Address)
+
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Fatalf("failed serving admin endpoints: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestAdminReconcileHandler(t *testing.T) {
+	reconciled := newLBService(nil)
+	reconciled.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "203.0.113.1"}}
+
+	pending := newLBService(nil)
+	pending.Name = "pending"
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}
+
+	// Nodes excluded from load balancers are not passed to the update.
+	excluded := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "excluded",
+		Labels: map[string]string{v1.LabelNodeExcludeBalancers: "true"},
+	}}
+
+	tt := []struct {
+		name       string
+		method     string
+		query      string
+		updateErr  error
+		wantStatus int
+		wantCalls  []string
+		wantEvent  bool
+	}{
+		{
+			name:       "Reconciles",
+			method:     http.MethodPost,
+			query:      "namespace=ns&name=svc",
+			wantStatus: http.StatusOK,
+			wantCalls:  []string{"cluster/ns/svc nodes=1"},
+			wantEvent:  true,
+		},
+		{
+			name:       "UpdateFails",
+			method:     http.MethodPost,
+			query:      "namespace=ns&name=svc",
+			updateErr:  errBoom,
+			wantStatus: http.StatusInternalServerError,
+			wantCalls:  []string{"cluster/ns/svc nodes=1"},
+			wantEvent:  true,
+		},
+		{
+			name:       "ServiceNotFound",
+			method:     http.MethodPost,
+			query:      "namespace=ns&name=missing",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "NoLoadBalancerYet",
+			method:     http.MethodPost,
+			query:      "namespace=ns&name=pending",
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "MissingName",
+			method:     http.MethodPost,
+			query:      "namespace=ns",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "MethodNotAllowed",
+			method:     http.MethodGet,
+			query:      "namespace=ns&name=svc",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			updater := &fakeLoadBalancerUpdater{err: tc.updateErr}
+			recorder := record.NewFakeRecorder(10)
+			handler := &adminReconcileHandler{
+				updater:     updater,
+				k8sClient:   fake.NewSimpleClientset(reconciled, pending, node, excluded),
+				clusterName: "cluster",
+				recorder:    recorder,
+			}
+
+			req := httptest.NewRequestWithContext(
+				t.Context(), tc.method, AdminReconcilePath+"?"+tc.query, nil,
+			)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			if calls := updater.calls(); !slices.Equal(calls, tc.wantCalls) {
+				t.Fatalf("updates = %v, want %v", calls, tc.wantCalls)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if !tc.wantEvent {
+					t.Fatalf("unexpected event %q", event)
+				}
+				if !strings.Contains(event, EventReasonManualReconcile) {
+					t.Fatalf("event = %q, want reason %s", event, EventReasonManualReconcile)
+				}
+			default:
+				if tc.wantEvent {
+					t.Fatal("expected an event")
+				}
+			}
+		})
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("admin-token\n"), 0o600); err != nil {
+		t.Fatalf("failed writing token file: %v", err)
+	}
+
+	tt := []struct {
+		name          string
+		tokenFile     string
+		authorization string
+		wantStatus    int
+	}{
+		{
+			name:          "ValidToken",
+			tokenFile:     tokenFile,
+			authorization: "Bearer admin-token",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "WrongToken",
+			tokenFile:     tokenFile,
+			authorization: "Bearer other-token",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "NoToken",
+			tokenFile:  tokenFile,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "NotBearer",
+			tokenFile:     tokenFile,
+			authorization: "Basic YWRtaW4tdG9rZW4=",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "TokenFileMissing",
+			tokenFile:     filepath.Join(t.TempDir(), "missing"),
+			authorization: "Bearer admin-token",
+			wantStatus:    http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			served := false
+			handler := requireAdminToken(tc.tokenFile, http.HandlerFunc(
+				func(http.ResponseWriter, *http.Request) { served = true },
+			))
+
+			req := httptest.NewRequestWithContext(
+				t.Context(), http.MethodPost, AdminReconcilePath, nil,
+			)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			if served != (tc.wantStatus == http.StatusOK) {
+				t.Fatalf("served = %v, want %v", served, !served)
+			}
+		})
+	}
+}
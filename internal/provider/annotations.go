@@ -0,0 +1,51 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// annotationsMergePatch builds a JSON merge patch that updates current to match
+// desired. Keys in desired with an empty value are removed. It returns a nil
+// patch when current already matches desired so callers can skip the API call.
+func annotationsMergePatch(current, desired map[string]string) ([]byte, error) {
+	return metadataMergePatch("annotations", current, desired)
+}
+
+// labelsMergePatch is like [annotationsMergePatch] but for labels.
+func labelsMergePatch(current, desired map[string]string) ([]byte, error) {
+	return metadataMergePatch("labels", current, desired)
+}
+
+// metadataMergePatch builds a JSON merge patch that updates the string map
+// field of an object's metadata from current to match desired.
+func metadataMergePatch(field string, current, desired map[string]string) ([]byte, error) {
+	// A JSON merge patch removes a key when its value is null.
+	values := make(map[string]any)
+	for key, value := range desired {
+		existing, ok := current[key]
+		switch {
+		case value == "" && ok:
+			values[key] = nil
+		case value != "" && existing != value:
+			values[key] = value
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{field: values},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling %s patch: %w", field, err)
+	}
+
+	return patch, nil
+}
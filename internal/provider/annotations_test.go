@@ -0,0 +1,65 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"testing"
+)
+
+func TestAnnotationsMergePatch(t *testing.T) {
+	tt := []struct {
+		name     string
+		current  map[string]string
+		desired  map[string]string
+		expected string
+	}{
+		{
+			name:     "adds missing annotation",
+			current:  nil,
+			desired:  map[string]string{"a": "1"},
+			expected: `{"metadata":{"annotations":{"a":"1"}}}`,
+		},
+		{
+			name:     "updates changed annotation",
+			current:  map[string]string{"a": "1"},
+			desired:  map[string]string{"a": "2"},
+			expected: `{"metadata":{"annotations":{"a":"2"}}}`,
+		},
+		{
+			name:     "removes annotation with empty value",
+			current:  map[string]string{"a": "1", "b": "2"},
+			desired:  map[string]string{"a": ""},
+			expected: `{"metadata":{"annotations":{"a":null}}}`,
+		},
+		{
+			name:     "unchanged returns nil patch",
+			current:  map[string]string{"a": "1"},
+			desired:  map[string]string{"a": "1", "b": ""},
+			expected: "",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, err := annotationsMergePatch(tc.current, tc.desired)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(patch) != tc.expected {
+				t.Fatalf("patch = %s, want %s", patch, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLabelsMergePatch(t *testing.T) {
+	patch, err := labelsMergePatch(map[string]string{"a": "1"}, map[string]string{"a": "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `{"metadata":{"labels":{"a":"2"}}}`; string(patch) != expected {
+		t.Fatalf("patch = %s, want %s", patch, expected)
+	}
+}
@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseBaseURL parses an Oxide API base URL, which must be an absolute http or
+// https URL without a query or fragment. Its path, if any, is the prefix the
+// API is served under.
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme %q must be http or https", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("query and fragment are not allowed")
+	}
+	return u, nil
+}
+
+// basePathTransport prefixes the path of requests to the Oxide API with the
+// path of the configured base URL. The Oxide client resolves its absolute API
+// paths against the base URL, which keeps only its scheme and host, so it
+// would otherwise drop the prefix of an API served behind a path, such as by
+// a reverse proxy.
+type basePathTransport struct {
+	base http.RoundTripper
+
+	// host is the host of the base URL. Requests to other hosts, such as those
+	// of region endpoints, are sent unchanged.
+	host string
+
+	// prefix and rawPrefix are the path of the base URL, without a trailing
+	// slash, unescaped and escaped.
+	prefix    string
+	rawPrefix string
+}
+
+// newBasePathTransport returns a transport that sends requests to the host of
+// baseURL under its path. It returns base when baseURL is empty or has no
+// path.
+func newBasePathTransport(base http.RoundTripper, baseURL string) (http.RoundTripper, error) {
+	if baseURL == "" {
+		return base, nil
+	}
+
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+
+	prefix := strings.TrimSuffix(u.Path, "/")
+	if prefix == "" {
+		return base, nil
+	}
+
+	return &basePathTransport{
+		base:      base,
+		host:      u.Host,
+		prefix:    prefix,
+		rawPrefix: strings.TrimSuffix(u.EscapedPath(), "/"),
+	}, nil
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (t *basePathTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	// A round tripper must not modify the request it was given.
+	req = req.Clone(req.Context())
+	req.URL.Path = t.prefix + req.URL.Path
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = t.rawPrefix + req.URL.RawPath
+	}
+
+	return t.base.RoundTrip(req)
+}
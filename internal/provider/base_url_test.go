@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+func TestBaseURL(t *testing.T) {
+	// newServer returns a test server recording the paths it is requested at.
+	newServer := func(t *testing.T, paths *[]string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*paths = append(*paths, r.URL.EscapedPath())
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"user","display_name":"user","silo_id":"silo",` +
+				`"silo_name":"silo","fleet_viewer":false,"silo_admin":false}`))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	// newClient returns an Oxide client configured like the cloud provider.
+	newClient := func(t *testing.T, cfg *Config) *oxide.Client {
+		transport, err := newBasePathTransport(http.DefaultTransport, cfg.BaseURL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client, err := oxide.NewClient(append(cfg.ClientOptions(),
+			oxide.WithToken("token"),
+			oxide.WithHTTPClient(&http.Client{Transport: transport}),
+		)...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return client
+	}
+
+	tt := []struct {
+		name     string
+		path     string
+		host     bool
+		expected string
+	}{
+		{name: "Prefix", path: "/oxide", expected: "/oxide/v1/me"},
+		{name: "PrefixWithTrailingSlash", path: "/oxide/", expected: "/oxide/v1/me"},
+		{name: "NestedPrefix", path: "/rack-1/oxide", expected: "/rack-1/oxide/v1/me"},
+		{name: "NoPrefix", path: "", expected: "/v1/me"},
+		{name: "OverridesHost", path: "/oxide", host: true, expected: "/oxide/v1/me"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			paths := make([]string, 0)
+			server := newServer(t, &paths)
+
+			cfg, err := ParseConfig(strings.NewReader("baseURL: " + server.URL + tc.path + "\n"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.host {
+				cfg.Host = "https://oxide.invalid"
+			}
+
+			if _, err := newClient(t, cfg).CurrentUserView(t.Context()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(paths) != 1 || paths[0] != tc.expected {
+				t.Fatalf("requested paths = %v, want [%s]", paths, tc.expected)
+			}
+		})
+	}
+
+	t.Run("OtherHostUnchanged", func(t *testing.T) {
+		paths := make([]string, 0)
+		server := newServer(t, &paths)
+
+		transport, err := newBasePathTransport(
+			http.DefaultTransport, "https://oxide.sys.example.com/oxide",
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client, err := oxide.NewClient(
+			oxide.WithHost(server.URL),
+			oxide.WithToken("token"),
+			oxide.WithHTTPClient(&http.Client{Transport: transport}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := client.CurrentUserView(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(paths) != 1 || paths[0] != "/v1/me" {
+			t.Fatalf("requested paths = %v, want [/v1/me]", paths)
+		}
+	})
+}
@@ -0,0 +1,398 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/utils/clock"
+)
+
+// The chaos tests inject random Oxide API failures. A failing run is
+// reproduced with the seed and rate it logs, for example:
+//
+//	go test ./internal/provider -run Chaos -chaos.seed=7 -chaos.rate=0.5
+var (
+	chaosSeed = flag.Uint64("chaos.seed", 1, "seed of the failures injected by chaos tests")
+	chaosRate = flag.Float64("chaos.rate", 0.3, "rate of the failures injected by chaos tests")
+)
+
+// errTooManyRequests stands in for an Oxide API rate limiting response, which
+// the SDK has no sentinel error for.
+var errTooManyRequests = errors.New("HTTP 429")
+
+// chaosMaxAttempts bounds how many times an operation is retried, as the
+// controllers would on their next sync, before a chaos test gives up on it
+// converging.
+const chaosMaxAttempts = 100
+
+// chaos decides which Oxide API calls fail. Failures are drawn from a seeded
+// source so that runs are reproducible.
+type chaos struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	rate float64
+
+	// maxConsecutive bounds the consecutive failures of a method, modeling
+	// incidents that are shorter than the retries meant to ride them out.
+	maxConsecutive int
+	consecutive    map[string]int
+
+	// injected counts the injected failures by method.
+	injected map[string]int
+}
+
+func newChaos(seed uint64, rate float64, maxConsecutive int) *chaos {
+	return &chaos{
+		rand:           rand.New(rand.NewPCG(seed, seed)),
+		rate:           rate,
+		maxConsecutive: maxConsecutive,
+		consecutive:    make(map[string]int),
+		injected:       make(map[string]int),
+	}
+}
+
+// fail returns one of errs, picked at random, when the call to method should
+// fail, or nil.
+func (c *chaos) fail(method string, errs ...error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.consecutive[method] >= c.maxConsecutive || c.rand.Float64() >= c.rate {
+		c.consecutive[method] = 0
+		return nil
+	}
+
+	c.consecutive[method]++
+	c.injected[method]++
+	return errs[c.rand.IntN(len(errs))]
+}
+
+// total returns the number of injected failures.
+func (c *chaos) total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int
+	for _, n := range c.injected {
+		total += n
+	}
+	return total
+}
+
+// chaosReadErrors are the failures injected into reads.
+var chaosReadErrors = []error{
+	oxide.ErrHTTP503, oxide.ErrInternalError, errTooManyRequests, context.DeadlineExceeded,
+}
+
+// chaosWriteErrors are the failures injected into writes before they take
+// effect. A timeout is injected after the write takes effect instead.
+var chaosWriteErrors = []error{oxide.ErrHTTP503, oxide.ErrInternalError, errTooManyRequests}
+
+// chaosFloatingIPs is an in-memory Oxide floating IP API that injects
+// failures. Writes that time out have taken effect, as when only the response
+// is lost.
+type chaosFloatingIPs struct {
+	*fakeFloatingIPs
+
+	chaos *chaos
+}
+
+var _ oxideLoadBalancerClient = (*chaosFloatingIPs)(nil)
+
+// write injects a failure before or after apply, which modifies the floating
+// IPs.
+func (c *chaosFloatingIPs) write(
+	method string,
+	apply func() (*oxide.FloatingIp, error),
+) (*oxide.FloatingIp, error) {
+	if err := c.chaos.fail(method, chaosWriteErrors...); err != nil {
+		return nil, err
+	}
+
+	fip, err := apply()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.chaos.fail(method+"/after", context.DeadlineExceeded); err != nil {
+		return nil, err
+	}
+	return fip, nil
+}
+
+// FloatingIpView never fails with a spurious not-found error, since a floating
+// IP that is not found is treated as deleted.
+func (c *chaosFloatingIPs) FloatingIpView(
+	ctx context.Context, params oxide.FloatingIpViewParams,
+) (*oxide.FloatingIp, error) {
+	if err := c.chaos.fail("FloatingIpView", chaosReadErrors...); err != nil {
+		return nil, err
+	}
+	return c.fakeFloatingIPs.FloatingIpView(ctx, params)
+}
+
+func (c *chaosFloatingIPs) FloatingIpCreate(
+	ctx context.Context, params oxide.FloatingIpCreateParams,
+) (*oxide.FloatingIp, error) {
+	return c.write("FloatingIpCreate", func() (*oxide.FloatingIp, error) {
+		return c.fakeFloatingIPs.FloatingIpCreate(ctx, params)
+	})
+}
+
+func (c *chaosFloatingIPs) FloatingIpUpdate(
+	ctx context.Context, params oxide.FloatingIpUpdateParams,
+) (*oxide.FloatingIp, error) {
+	return c.write("FloatingIpUpdate", func() (*oxide.FloatingIp, error) {
+		return c.fakeFloatingIPs.FloatingIpUpdate(ctx, params)
+	})
+}
+
+func (c *chaosFloatingIPs) FloatingIpDelete(
+	ctx context.Context, params oxide.FloatingIpDeleteParams,
+) error {
+	_, err := c.write("FloatingIpDelete", func() (*oxide.FloatingIp, error) {
+		return nil, c.fakeFloatingIPs.FloatingIpDelete(ctx, params)
+	})
+	return err
+}
+
+func (c *chaosFloatingIPs) FloatingIpAttach(
+	ctx context.Context, params oxide.FloatingIpAttachParams,
+) (*oxide.FloatingIp, error) {
+	return c.write("FloatingIpAttach", func() (*oxide.FloatingIp, error) {
+		return c.fakeFloatingIPs.FloatingIpAttach(ctx, params)
+	})
+}
+
+func (c *chaosFloatingIPs) FloatingIpDetach(
+	ctx context.Context, params oxide.FloatingIpDetachParams,
+) (*oxide.FloatingIp, error) {
+	return c.write("FloatingIpDetach", func() (*oxide.FloatingIp, error) {
+		return c.fakeFloatingIPs.FloatingIpDetach(ctx, params)
+	})
+}
+
+func (c *chaosFloatingIPs) IpPoolListAllPages(
+	ctx context.Context, params oxide.IpPoolListParams,
+) ([]oxide.SiloIpPool, error) {
+	if err := c.chaos.fail("IpPoolListAllPages", chaosReadErrors...); err != nil {
+		return nil, err
+	}
+	return c.fakeFloatingIPs.IpPoolListAllPages(ctx, params)
+}
+
+func (c *chaosFloatingIPs) FloatingIpListAllPages(
+	ctx context.Context, params oxide.FloatingIpListParams,
+) ([]oxide.FloatingIp, error) {
+	if err := c.chaos.fail("FloatingIpListAllPages", chaosReadErrors...); err != nil {
+		return nil, err
+	}
+	return c.fakeFloatingIPs.FloatingIpListAllPages(ctx, params)
+}
+
+// chaosInstances is an Oxide instance API serving a fixed set of instances
+// that injects failures, including not-found errors for existing instances.
+type chaosInstances struct {
+	oxideInstanceClient
+
+	chaos     *chaos
+	instances []oxide.Instance
+}
+
+func (c *chaosInstances) InstanceView(
+	_ context.Context, params oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	err := c.chaos.fail("InstanceView", append(chaosReadErrors, oxide.ErrObjectNotFound)...)
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(c.instances, func(instance oxide.Instance) bool {
+		return instance.Id == string(params.Instance)
+	})
+	if i == -1 {
+		return nil, oxide.ErrObjectNotFound
+	}
+	instance := c.instances[i]
+	return &instance, nil
+}
+
+func (c *chaosInstances) InstanceNetworkInterfaceList(
+	ctx context.Context, params oxide.InstanceNetworkInterfaceListParams,
+) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+	if err := c.chaos.fail("InstanceNetworkInterfaceList", chaosReadErrors...); err != nil {
+		return nil, err
+	}
+	return c.oxideInstanceClient.InstanceNetworkInterfaceList(ctx, params)
+}
+
+func (c *chaosInstances) InstanceExternalIpList(
+	ctx context.Context, params oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	if err := c.chaos.fail("InstanceExternalIpList", chaosReadErrors...); err != nil {
+		return nil, err
+	}
+	return c.oxideInstanceClient.InstanceExternalIpList(ctx, params)
+}
+
+// converge calls op until it succeeds, as a controller would on its next
+// syncs, and fails the test when it doesn't.
+func converge[T any](t *testing.T, what string, op func() (T, error)) T {
+	t.Helper()
+
+	var err error
+	for range chaosMaxAttempts {
+		var result T
+		if result, err = op(); err == nil {
+			return result
+		}
+	}
+	t.Fatalf("%s did not converge after %d attempts: %v", what, chaosMaxAttempts, err)
+	panic("unreachable")
+}
+
+func TestChaosLoadBalancer(t *testing.T) {
+	t.Logf("chaos seed %d, rate %v", *chaosSeed, *chaosRate)
+	c := newChaos(*chaosSeed, *chaosRate, 3)
+
+	client := &chaosFloatingIPs{fakeFloatingIPs: newFakeFloatingIPs(), chaos: c}
+	nodeA := newLBNode("node-a", instIDOld, "10.0.0.10")
+	nodeB := newLBNode("node-b", instIDNew, "10.0.0.20")
+
+	// onlyFloatingIP returns the only floating IP, failing the test when there is
+	// any other number of floating IPs.
+	onlyFloatingIP := func(t *testing.T) oxide.FloatingIp {
+		t.Helper()
+		fips := client.list()
+		if len(fips) != 1 {
+			t.Fatalf("floating ips = %d, want 1", len(fips))
+		}
+		return fips[0]
+	}
+
+	for round := range 20 {
+		svc := newLBService(nil)
+		svc.Name = fmt.Sprintf("svc-%d", round)
+		lb := &LoadBalancer{
+			project:       "test",
+			k8sClient:     fake.NewSimpleClientset(svc),
+			client:        client,
+			defaultPools:  &defaultPoolCache{},
+			locks:         &keyMutex{},
+			clock:         clock.RealClock{},
+			attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+		}
+
+		status := converge(t, "ensure", func() (*v1.LoadBalancerStatus, error) {
+			return lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{nodeA})
+		})
+		fip := onlyFloatingIP(t)
+		if fip.InstanceId != instIDOld {
+			t.Fatalf("round %d: floating ip attached to %q, want %q",
+				round, fip.InstanceId, instIDOld)
+		}
+		if status.Ingress[0].IP != fip.Ip {
+			t.Fatalf("round %d: status ip = %q, want %q", round, status.Ingress[0].IP, fip.Ip)
+		}
+
+		// The backing node is replaced.
+		converge(t, "update", func() (struct{}, error) {
+			return struct{}{}, lb.UpdateLoadBalancer(
+				t.Context(), "cluster", svc, []*v1.Node{nodeB},
+			)
+		})
+		if moved := onlyFloatingIP(t); moved.Id != fip.Id || moved.InstanceId != instIDNew {
+			t.Fatalf("round %d: floating ip %s attached to %q, want %s attached to %q",
+				round, moved.Id, moved.InstanceId, fip.Id, instIDNew)
+		}
+
+		converge(t, "delete", func() (struct{}, error) {
+			return struct{}{}, lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc)
+		})
+		if leaked := len(client.list()); leaked != 0 {
+			t.Fatalf("round %d: leaked %d floating ips", round, leaked)
+		}
+	}
+
+	if *chaosRate > 0 && c.total() == 0 {
+		t.Fatal("no failures were injected")
+	}
+	t.Logf("injected failures: %v", c.injected)
+}
+
+func TestChaosInstances(t *testing.T) {
+	t.Logf("chaos seed %d, rate %v", *chaosSeed, *chaosRate)
+
+	// Consecutive not-found errors for an existing instance are fewer than the
+	// re-checks confirming them.
+	const rechecks = 3
+	c := newChaos(*chaosSeed, *chaosRate, rechecks)
+
+	instance := instanceRunning
+	instance.Hostname = "node-1"
+	instancesV2 := &InstancesV2{
+		client: &chaosInstances{
+			oxideInstanceClient: &mockOxideClient{
+				InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			},
+			chaos:     c,
+			instances: []oxide.Instance{instance},
+		},
+		project:   "test",
+		k8sClient: fake.NewSimpleClientset(),
+		recheck: newNotFoundRecheck(
+			clock.RealClock{},
+			wait.Backoff{Duration: time.Microsecond, Steps: rechecks},
+			maxConcurrentNotFoundRechecks,
+		),
+	}
+
+	for round := range 100 {
+		exists, err := instancesV2.InstanceExists(t.Context(), &nodeWithProviderID)
+		if err == nil && !exists {
+			t.Fatalf("round %d: existing instance reported as not existing", round)
+		}
+
+		exists = converge(t, "instance exists", func() (bool, error) {
+			return instancesV2.InstanceExists(t.Context(), &nodeDoesNotExistInOxide)
+		})
+		if exists {
+			t.Fatalf("round %d: deleted instance reported as existing", round)
+		}
+
+		metadata := converge(t, "instance metadata",
+			func() (*cloudprovider.InstanceMetadata, error) {
+				return instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			},
+		)
+		want := []v1.NodeAddress{
+			{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+			{Type: v1.NodeHostName, Address: "node-1"},
+		}
+		if !slices.Equal(metadata.NodeAddresses, want) {
+			t.Fatalf("round %d: node addresses = %v, want %v",
+				round, metadata.NodeAddresses, want)
+		}
+	}
+
+	if *chaosRate > 0 && c.total() == 0 {
+		t.Fatal("no failures were injected")
+	}
+	t.Logf("injected failures: %v", c.injected)
+}
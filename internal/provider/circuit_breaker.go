@@ -0,0 +1,212 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// circuitState is the state of a circuit breaker. Its value is reported by
+// the circuit breaker state metric.
+type circuitState int
+
+const (
+	// circuitClosed sends requests, counting consecutive failures.
+	circuitClosed circuitState = iota
+
+	// circuitHalfOpen sends a single probe request once the cooldown has
+	// elapsed, failing the others fast until the probe completes.
+	circuitHalfOpen
+
+	// circuitOpen fails requests fast until the cooldown has elapsed.
+	circuitOpen
+)
+
+// String returns the name of the state.
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	}
+	return fmt.Sprintf("circuitState(%d)", int(s))
+}
+
+// circuitBreaker stops sending requests to an Oxide API endpoint that failed
+// threshold consecutive times, so that retries of every reconcile don't add to
+// the load of an API that is already failing. Once the cooldown has elapsed,
+// it lets a single probe request through and closes again when it succeeds.
+type circuitBreaker struct {
+	clock     clock.PassiveClock
+	host      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// allow returns [ErrCircuitOpen] when the request must fail fast. Otherwise,
+// the caller must report the outcome of the request with record.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.clock.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, b.host)
+		}
+		b.setState(circuitHalfOpen)
+		return nil
+	case circuitHalfOpen:
+		// The probe request is still in flight.
+		return fmt.Errorf("%w for %s", ErrCircuitOpen, b.host)
+	}
+	return nil
+}
+
+// record reports the outcome of a request that was allowed.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+// release reports that a request that was allowed was canceled by its
+// caller, which says nothing about the API. A canceled probe returns the
+// breaker to open without restarting the cooldown, so that the next request
+// is sent as the probe instead.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.setState(circuitOpen)
+	}
+}
+
+// setState transitions the breaker to state, logging and reporting changes.
+// The caller must hold mu.
+func (b *circuitBreaker) setState(state circuitState) {
+	if b.state == state {
+		return
+	}
+
+	klog.InfoS("oxide api circuit breaker changed state",
+		"host", b.host, "from", b.state, "to", state, "failures", b.failures)
+	b.state = state
+	oxideCircuitBreakerState.WithLabelValues(b.host).Set(float64(state))
+}
+
+// circuitBreakerTransport fails requests to an Oxide API endpoint fast while
+// its circuit breaker is open. Each endpoint has its own breaker, so an outage
+// of one region does not stop requests to the others.
+type circuitBreakerTransport struct {
+	base      http.RoundTripper
+	clock     clock.PassiveClock
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// newCircuitBreakerTransport returns a transport that opens the circuit
+// breaker of an endpoint as configured. It returns base when config is nil.
+func newCircuitBreakerTransport(
+	base http.RoundTripper,
+	clock clock.PassiveClock,
+	config *CircuitBreakerConfig,
+) http.RoundTripper {
+	if config == nil {
+		return base
+	}
+
+	return &circuitBreakerTransport{
+		base:      base,
+		clock:     clock,
+		threshold: config.FailureThreshold,
+		cooldown:  config.Cooldown.Duration,
+		breakers:  make(map[string]*circuitBreaker),
+	}
+}
+
+// breaker returns the circuit breaker of the host, creating it when needed.
+func (t *circuitBreakerTransport) breaker(host string) *circuitBreaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	breaker, ok := t.breakers[host]
+	if !ok {
+		breaker = &circuitBreaker{
+			clock:     t.clock,
+			host:      host,
+			threshold: t.threshold,
+			cooldown:  t.cooldown,
+		}
+		t.breakers[host] = breaker
+		oxideCircuitBreakerState.WithLabelValues(host).Set(float64(circuitClosed))
+	}
+	return breaker
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breaker := t.breaker(req.URL.Host)
+	if err := breaker.allow(); err != nil {
+		// A round tripper must close the request body, even on errors.
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		// A request canceled by its caller says nothing about the API.
+		if errors.Is(err, context.Canceled) {
+			breaker.release()
+		} else {
+			breaker.record(true)
+		}
+	default:
+		breaker.record(isOxideOutage(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// isOxideOutage reports whether a response status indicates the Oxide API is
+// failing, as opposed to rejecting the request. Insufficient capacity is a
+// valid answer to a request that is unrelated to the health of the API.
+func isOxideOutage(status int) bool {
+	return status >= http.StatusInternalServerError &&
+		status != http.StatusInsufficientStorage
+}
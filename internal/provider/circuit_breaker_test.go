@@ -0,0 +1,258 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestCircuitBreakerTransport(t *testing.T) {
+	registerMetrics()
+
+	config := &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		Cooldown:         metav1.Duration{Duration: 30 * time.Second},
+	}
+
+	// newTransport returns a circuit breaker transport whose requests are
+	// answered with the current status, counting the requests sent.
+	newTransport := func(
+		status *int, sent *int,
+	) (*circuitBreakerTransport, *clocktesting.FakeClock) {
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		base := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			*sent++
+			return &http.Response{
+				StatusCode: *status,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		})
+		transport := newCircuitBreakerTransport(base, fakeClock, config)
+		return transport.(*circuitBreakerTransport), fakeClock
+	}
+
+	send := func(t *testing.T, transport http.RoundTripper, host string) error {
+		t.Helper()
+		req, err := http.NewRequestWithContext(
+			t.Context(), http.MethodGet, "https://"+host+"/v1/me", nil,
+		)
+		if err != nil {
+			t.Fatalf("failed creating request: %v", err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	state := func(t *testing.T, host string) circuitState {
+		t.Helper()
+		value, err := testutil.GetGaugeMetricValue(oxideCircuitBreakerState.WithLabelValues(host))
+		if err != nil {
+			t.Fatalf("failed reading gauge: %v", err)
+		}
+		return circuitState(value)
+	}
+
+	t.Run("States", func(t *testing.T) {
+		status, sent := http.StatusServiceUnavailable, 0
+		transport, fakeClock := newTransport(&status, &sent)
+		host := "states.example.com"
+
+		// Closed: failures below the threshold are sent.
+		if err := send(t, transport, host); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := state(t, host); got != circuitClosed {
+			t.Fatalf("state = %v, want %v", got, circuitClosed)
+		}
+
+		// Open: the threshold is reached, and requests fail fast.
+		if err := send(t, transport, host); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := state(t, host); got != circuitOpen {
+			t.Fatalf("state = %v, want %v", got, circuitOpen)
+		}
+		if err := send(t, transport, host); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err = %v, want circuit open", err)
+		}
+		if sent != 2 {
+			t.Fatalf("sent = %d, want 2", sent)
+		}
+
+		// Half-open: a failed probe opens the breaker for another cooldown.
+		fakeClock.Step(config.Cooldown.Duration)
+		if err := send(t, transport, host); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := state(t, host); got != circuitOpen {
+			t.Fatalf("state = %v, want %v after a failed probe", got, circuitOpen)
+		}
+		if err := send(t, transport, host); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err = %v, want circuit open", err)
+		}
+
+		// Closed: a successful probe closes the breaker.
+		status = http.StatusOK
+		fakeClock.Step(config.Cooldown.Duration)
+		if err := send(t, transport, host); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := state(t, host); got != circuitClosed {
+			t.Fatalf("state = %v, want %v after a successful probe", got, circuitClosed)
+		}
+		if err := send(t, transport, host); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sent != 5 {
+			t.Fatalf("sent = %d, want 5", sent)
+		}
+	})
+
+	t.Run("HalfOpenSendsSingleProbe", func(t *testing.T) {
+		status, sent := http.StatusBadGateway, 0
+		transport, fakeClock := newTransport(&status, &sent)
+		host := "probe.example.com"
+
+		for range config.FailureThreshold {
+			_ = send(t, transport, host)
+		}
+		fakeClock.Step(config.Cooldown.Duration)
+
+		// The probe is in flight until its outcome is recorded.
+		breaker := transport.breaker(host)
+		if err := breaker.allow(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := state(t, host); got != circuitHalfOpen {
+			t.Fatalf("state = %v, want %v", got, circuitHalfOpen)
+		}
+		if err := send(t, transport, host); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err = %v, want circuit open while probing", err)
+		}
+		breaker.record(false)
+		if got := state(t, host); got != circuitClosed {
+			t.Fatalf("state = %v, want %v", got, circuitClosed)
+		}
+	})
+
+	t.Run("CanceledProbeIsReleased", func(t *testing.T) {
+		status, sent := http.StatusBadGateway, 0
+		transport, fakeClock := newTransport(&status, &sent)
+		host := "canceled.example.com"
+
+		for range config.FailureThreshold {
+			_ = send(t, transport, host)
+		}
+		fakeClock.Step(config.Cooldown.Duration)
+
+		// The probe's caller goes away before the API answers.
+		base := transport.base
+		transport.base = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, context.Canceled
+		})
+		if err := send(t, transport, host); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want canceled", err)
+		}
+		if got := state(t, host); got != circuitOpen {
+			t.Fatalf("state = %v, want %v", got, circuitOpen)
+		}
+
+		// The next request is sent as the probe without waiting for another
+		// cooldown.
+		transport.base = base
+		status = http.StatusOK
+		if err := send(t, transport, host); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := state(t, host); got != circuitClosed {
+			t.Fatalf("state = %v, want %v", got, circuitClosed)
+		}
+	})
+
+	t.Run("SuccessResetsFailures", func(t *testing.T) {
+		status, sent := http.StatusInternalServerError, 0
+		transport, _ := newTransport(&status, &sent)
+		host := "reset.example.com"
+
+		for _, s := range []int{
+			http.StatusInternalServerError,
+			http.StatusOK,
+			http.StatusInternalServerError,
+			// Client errors and insufficient capacity aren't outages.
+			http.StatusNotFound,
+			http.StatusInsufficientStorage,
+		} {
+			status = s
+			if err := send(t, transport, host); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if got := state(t, host); got != circuitClosed {
+			t.Fatalf("state = %v, want %v", got, circuitClosed)
+		}
+	})
+
+	t.Run("BreakerPerHost", func(t *testing.T) {
+		status, sent := http.StatusServiceUnavailable, 0
+		transport, _ := newTransport(&status, &sent)
+
+		for range config.FailureThreshold {
+			_ = send(t, transport, "west.example.com")
+		}
+		if err := send(t, transport, "west.example.com"); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err = %v, want circuit open", err)
+		}
+		if err := send(t, transport, "east.example.com"); err != nil {
+			t.Fatalf("unexpected error for another host: %v", err)
+		}
+	})
+
+	t.Run("InstanceExistsFailsWhileOpen", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(server.Close)
+
+		transport := newCircuitBreakerTransport(
+			http.DefaultTransport, clocktesting.NewFakeClock(time.Now()), config,
+		)
+		client, err := oxide.NewClient(
+			oxide.WithHost(server.URL),
+			oxide.WithToken("token"),
+			oxide.WithHTTPClient(&http.Client{Transport: transport}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		instancesV2 := &InstancesV2{client: client, project: "test"}
+
+		for range config.FailureThreshold + 1 {
+			exists, err := instancesV2.InstanceExists(t.Context(), &nodeWithProviderID)
+			if err == nil {
+				t.Fatalf("expected error, got exists=%t", exists)
+			}
+		}
+		if requests != config.FailureThreshold {
+			t.Fatalf("requests = %d, want %d", requests, config.FailureThreshold)
+		}
+	})
+}
@@ -0,0 +1,985 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvProject is the environment variable that contains the Oxide project.
+const EnvProject = "OXIDE_PROJECT"
+
+// envOrganization is the environment variable that contained the Oxide
+// organization before silos replaced organizations. Instances are scoped by
+// project within the silo of the token, so it is ignored.
+const envOrganization = "OXIDE_ORGANIZATION"
+
+// redacted replaces secret values when printing the configuration.
+const redacted = "REDACTED"
+
+// Config is the configuration for the Oxide cloud provider. It is read from
+// the file passed via the --cloud-config flag and merged with the environment
+// by [LoadConfig].
+type Config struct {
+	// Host is the Oxide API endpoint. The OXIDE_HOST environment variable
+	// takes precedence when set.
+	Host string `json:"host,omitempty"`
+
+	// BaseURL is the full URL the Oxide API is served under, for an API
+	// fronted by a path prefix (e.g., https://api.example.com/oxide). It takes
+	// precedence over Host and the OXIDE_HOST environment variable.
+	BaseURL string `json:"baseURL,omitempty"`
+
+	// Token is the Oxide API token. The OXIDE_TOKEN environment variable takes
+	// precedence when set.
+	Token string `json:"token,omitempty"`
+
+	// TokenFile is the path to a file containing the Oxide API token, such as
+	// a mounted Kubernetes secret. It takes precedence over Token and the
+	// OXIDE_TOKEN environment variable and is read again when the Oxide API
+	// rejects the token so that the token can be rotated without a restart.
+	// Tokens from Token or OXIDE_TOKEN cannot be rotated.
+	TokenFile string `json:"tokenFile,omitempty"`
+
+	// Project is the Oxide project containing the Kubernetes nodes. The
+	// OXIDE_PROJECT environment variable takes precedence when set.
+	Project string `json:"project,omitempty"`
+
+	// Regions maps a region name to the Oxide API endpoint serving it. When
+	// set, nodes are looked up against the endpoint of the region named by
+	// their topology.kubernetes.io/region label, or against every region when
+	// the label is absent. An instance missing from its labeled region is
+	// looked up in the other regions before the node is considered deleted.
+	// When empty, the endpoint from OXIDE_HOST is used for all nodes.
+	Regions map[string]RegionConfig `json:"regions,omitempty"`
+
+	// NodeLabels names the Oxide-derived labels applied to nodes (e.g.,
+	// project, vpc, image, region). Defaults to [DefaultNodeLabels] when unset.
+	// An empty list disables them.
+	NodeLabels []string `json:"nodeLabels"`
+
+	// NodeLabelKeys maps node label names, such as project, to the keys to
+	// label nodes with instead of the default oxide.computer keys, to follow
+	// an organization's label conventions.
+	NodeLabelKeys map[string]string `json:"nodeLabelKeys,omitempty"`
+
+	// NodeAddressTypes names the types of addresses reported for nodes, out of
+	// Hostname, InternalIP, and ExternalIP. Defaults to
+	// [DefaultNodeAddressTypes] when unset.
+	NodeAddressTypes []v1.NodeAddressType `json:"nodeAddressTypes"`
+
+	// InternalIPsFromPrimaryNICOnly reports only the addresses of an
+	// instance's primary network interface as node internal IPs, leaving out
+	// secondary network interfaces that may be on isolated networks. By
+	// default, the addresses of all network interfaces are reported.
+	InternalIPsFromPrimaryNICOnly bool `json:"internalIPsFromPrimaryNICOnly,omitempty"`
+
+	// InternalIPIncludeCIDRs, when set, are the only CIDRs whose network
+	// interface addresses are reported as node internal IPs.
+	InternalIPIncludeCIDRs []string `json:"internalIPIncludeCIDRs,omitempty"`
+
+	// InternalIPExcludeCIDRs are CIDRs whose network interface addresses are
+	// not reported as node internal IPs, such as a management subnet's. By
+	// default, no addresses are excluded.
+	InternalIPExcludeCIDRs []string `json:"internalIPExcludeCIDRs,omitempty"`
+
+	// InvalidHostnames is how instance hostnames that are not valid DNS names
+	// are reported as node hostname addresses, since DNS-dependent components
+	// may fail on them. One of [InvalidHostnamesSkip], the default, which
+	// omits the hostname address, or [InvalidHostnamesSanitize], which
+	// reports the hostname rewritten into a valid DNS name.
+	InvalidHostnames string `json:"invalidHostnames,omitempty"`
+
+	// NodeExternalIPKinds names the kinds of instance external IPs reported as
+	// node external IPs, out of ephemeral, floating, and snat. Kinds that are
+	// not listed, including kinds added to Oxide in the future, are excluded.
+	// Defaults to [DefaultNodeExternalIPKinds] when unset. An empty list
+	// reports no external IPs.
+	NodeExternalIPKinds []oxide.ExternalIpKind `json:"nodeExternalIPKinds"`
+
+	// EgressIPsAnnotation records the ephemeral and SNAT IPs of a node's
+	// instance, which it sends outbound traffic from, in the
+	// oxide.computer/egress-ips node annotation for troubleshooting egress.
+	// It does not change the addresses reported for the node.
+	EgressIPsAnnotation bool `json:"egressIPsAnnotation,omitempty"`
+
+	// DiskAnnotations records the boot disk name, disk count, and sorted disk
+	// names of a node's instance in the oxide.computer/boot-disk,
+	// oxide.computer/disk-count, and oxide.computer/disks node annotations
+	// for storage tooling. It lists the instance's disks on every node sync.
+	DiskAnnotations bool `json:"diskAnnotations,omitempty"`
+
+	// SledAnnotations records the ID and baseboard serial and part numbers of
+	// the sled running a node's instance in the oxide.computer/sled-id,
+	// oxide.computer/sled-serial, and oxide.computer/sled-part node
+	// annotations for asset tracking. Looking up the sled requires the fleet
+	// viewer role.
+	SledAnnotations bool `json:"sledAnnotations,omitempty"`
+
+	// EndpointAnnotation records the Oxide API endpoint a node's instance was
+	// looked up at, that of the node's region or the default endpoint, in the
+	// oxide.computer/endpoint node annotation, so that support tooling can
+	// tell which endpoint manages each node.
+	EndpointAnnotation bool `json:"endpointAnnotation,omitempty"`
+
+	// LegacyInstances provides the legacy Instances cloud provider interface
+	// on top of InstancesV2 for older components that still query it. The
+	// cloud node controllers prefer InstancesV2 either way.
+	LegacyInstances bool `json:"legacyInstances,omitempty"`
+
+	// InstanceMetadataPolicy is how node metadata is built when looking up
+	// part of it fails. With [InstanceMetadataStrict], the default, the node
+	// is not updated until every lookup succeeds. With
+	// [InstanceMetadataBestEffort], the provider ID and instance type are
+	// always reported, and the addresses, labels, and annotations that could
+	// not be looked up are left out, keeping the node's current addresses.
+	InstanceMetadataPolicy string `json:"instanceMetadataPolicy,omitempty"`
+
+	// ShutdownInstanceStates names the instance run states, out of stopping,
+	// stopped, and failed, in which a node is reported as existing but shut
+	// down, so that its node object is kept and tainted rather than deleted.
+	// A stopped instance is reported as not existing unless stopped is listed,
+	// so its node is deleted like that of a deleted instance. A failed instance
+	// is always reported as existing, so listing failed keeps its node for
+	// manual recovery, whereas a destroyed instance is always reported as not
+	// existing. Defaults to [DefaultShutdownInstanceStates] when unset.
+	ShutdownInstanceStates []oxide.InstanceState `json:"shutdownInstanceStates"`
+
+	// NodeSyncConcurrency limits the Oxide API calls in flight across all node
+	// syncs, so that a burst of node registrations does not overwhelm the
+	// Oxide API. Defaults to [DefaultNodeSyncConcurrency] when unset.
+	NodeSyncConcurrency int `json:"nodeSyncConcurrency"`
+
+	// FollowRecreatedInstances replaces the provider ID of a node whose
+	// instance was deleted with that of the instance recreated with the
+	// node's name, if there is one, rather than deleting the node. Since a
+	// node's provider ID cannot be changed, the node object is deleted and
+	// created again with the same labels, annotations, taints, and spec.
+	FollowRecreatedInstances bool `json:"followRecreatedInstances,omitempty"`
+
+	// NodeNameTransform, when set, derives the names of the instances of
+	// nodes without a provider ID from the node names, for provisioners that
+	// name nodes differently from their instances. By default, instances are
+	// looked up by the node name.
+	NodeNameTransform *NodeNameTransformConfig `json:"nodeNameTransform,omitempty"`
+
+	// InstanceIndexInterval, when set, enables looking up the instances of
+	// nodes without a provider ID in an index of the project's instances that
+	// is refreshed at this interval, instead of viewing each instance. Nodes
+	// missing from the index, and checks of whether a node exists or is shut
+	// down, look the instance up directly.
+	InstanceIndexInterval *metav1.Duration `json:"instanceIndexInterval,omitempty"`
+
+	// InstanceMetadataCacheTTL, when set, enables reusing the metadata built
+	// for nodes that already have a provider ID for up to this long while
+	// their instance is unchanged, rather than looking up its network
+	// interfaces, external IPs, disks, and sled on every resync.
+	InstanceMetadataCacheTTL *metav1.Duration `json:"instanceMetadataCacheTTL,omitempty"`
+
+	// NodeDriftInterval, when set, enables comparing the labels and addresses
+	// of nodes to those derived from their instances at this interval, and
+	// reporting nodes that differ in a warning event and metric.
+	NodeDriftInterval *metav1.Duration `json:"nodeDriftInterval,omitempty"`
+
+	// NodeDriftCorrect updates nodes found by NodeDriftInterval to differ from
+	// their instances to match them, rather than only reporting them.
+	NodeDriftCorrect bool `json:"nodeDriftCorrect,omitempty"`
+
+	// ServiceReconcileDebounce, when set, enables reconciling the load
+	// balancers of services as soon as the services or nodes change, rather
+	// than waiting for the service controller to resync. Changes to a service
+	// within this period are coalesced into a single reconcile.
+	ServiceReconcileDebounce *metav1.Duration `json:"serviceReconcileDebounce,omitempty"`
+
+	// FloatingIPPool is the IP pool to allocate floating IPs from for services
+	// without floating IP annotations, optionally followed by comma-separated
+	// fallback pools. Defaults to the silo's default IP pool. It only selects
+	// the pool of new floating IPs, and changing it never recreates existing
+	// ones.
+	FloatingIPPool string `json:"floatingIPPool,omitempty"`
+
+	// NamespaceFloatingIPPools maps namespaces to the IP pool to allocate
+	// floating IPs from for their services, overriding FloatingIPPool. A pool
+	// annotated on the namespace itself overrides both.
+	NamespaceFloatingIPPools map[string]string `json:"namespaceFloatingIPPools,omitempty"`
+
+	// FloatingIPCleanup is what is done with the floating IPs of services that
+	// are no longer of type LoadBalancer. With [FloatingIPCleanupDelete], the
+	// default, they are deleted. With [FloatingIPCleanupDryRun], they are only
+	// logged, so the cleanup can be checked before it deletes anything.
+	FloatingIPCleanup string `json:"floatingIPCleanup,omitempty"`
+
+	// FloatingIPPreallocation, when set, keeps a number of spare floating IPs
+	// allocated that are assigned to new services instead of creating floating
+	// IPs, so that services get an address without waiting on the IP pool.
+	FloatingIPPreallocation *PreallocationConfig `json:"floatingIPPreallocation,omitempty"`
+
+	// IngressNodeSelector, when set, is a label selector for the nodes that
+	// floating IPs may be attached to, such as dedicated ingress nodes. Services
+	// override it with the oxide.computer/ingress-node-selector annotation.
+	// Floating IPs fall back to any node when no selected node is eligible.
+	IngressNodeSelector string `json:"ingressNodeSelector,omitempty"`
+
+	// MinLoadBalancerNodes is the number of nodes that must be eligible to
+	// back a floating IP before a floating IP is first attached, so that
+	// floating IPs are not attached to the lone node of a cluster that is
+	// still bootstrapping. Defaults to [DefaultMinLoadBalancerNodes] when
+	// unset.
+	MinLoadBalancerNodes int `json:"minLoadBalancerNodes"`
+
+	// LoadBalancerOperationTimeout, when set, bounds each reconcile of a load
+	// balancer, such as attaching its floating IP. Services override it with
+	// the oxide.computer/operation-timeout annotation. Reconciles are only
+	// bounded by the timeout of each Oxide API request when unset.
+	LoadBalancerOperationTimeout *metav1.Duration `json:"loadBalancerOperationTimeout,omitempty"`
+
+	// FloatingIPAttachMode is how floating IPs are attached for services
+	// without the oxide.computer/attach-mode annotation. Defaults to
+	// [AttachModeSingleNode], the only mode supported.
+	FloatingIPAttachMode string `json:"floatingIPAttachMode,omitempty"`
+
+	// FloatingIPFailoverGracePeriod, when set, is how long the node backing a
+	// floating IP may be not ready or shut down before the floating IP moves
+	// to another node, so that floating IPs stay put while their nodes reboot.
+	// Cordoned and excluded nodes lose their floating IPs right away.
+	FloatingIPFailoverGracePeriod *metav1.Duration `json:"floatingIPFailoverGracePeriod,omitempty"`
+
+	// NodeScoring, when set, attaches floating IPs to the eligible node with
+	// the highest score instead of the first one by name, spreading floating
+	// IPs across nodes.
+	NodeScoring *NodeScoringConfig `json:"nodeScoring,omitempty"`
+
+	// Preflight, when set, checks at startup that the token can perform every
+	// Oxide operation the cloud controller manager needs, and exits naming the
+	// operations that failed. One of [PreflightRead] or [PreflightWrite], which
+	// creates and deletes a throwaway floating IP.
+	Preflight string `json:"preflight,omitempty"`
+
+	// PreflightInstance is the name or ID of an instance in the project that
+	// the write preflight checks attach the throwaway floating IP to and
+	// detach it from. It should not be a node serving traffic, since the
+	// floating IP stays attached when detaching it fails. Attaching is not
+	// checked when empty.
+	PreflightInstance string `json:"preflightInstance,omitempty"`
+
+	// StartupValidationTimeout bounds how long the token, project, and
+	// floating IP pools are validated at startup while the Oxide API is
+	// unreachable or failing with server errors, retrying with backoff, such
+	// as during a coordinated rack restart. Failures the Oxide API reports,
+	// such as a rejected token or a missing project, are not retried. Defaults
+	// to [DefaultStartupValidationTimeout] when unset.
+	StartupValidationTimeout *metav1.Duration `json:"startupValidationTimeout,omitempty"`
+
+	// HTTPTransport tunes the HTTP transport used for Oxide API requests.
+	// Unset values default to [DefaultHTTPTransport].
+	HTTPTransport HTTPTransportConfig `json:"httpTransport"`
+
+	// CircuitBreaker, when set, fails Oxide API requests fast for a cooldown
+	// after an endpoint failed consecutively, so retries don't add to the load
+	// of a failing API. Unset values default to [DefaultCircuitBreaker].
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker,omitempty"`
+
+	// Admin, when set, serves admin endpoints for operators, such as
+	// reconciling the load balancer of a service on demand.
+	Admin *AdminConfig `json:"admin,omitempty"`
+
+	// Webhook, when set, serves a validating admission webhook that rejects
+	// services of type LoadBalancer with invalid Oxide annotations.
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+}
+
+// DefaultNodeAddressTypes are the types of addresses reported for nodes
+// when none are configured.
+var DefaultNodeAddressTypes = []v1.NodeAddressType{
+	v1.NodeInternalIP,
+	v1.NodeExternalIP,
+	v1.NodeHostName,
+}
+
+// DefaultNodeExternalIPKinds are the kinds of external IPs reported as node
+// external IPs when none are configured. SNAT IPs are shared between instances
+// for outbound traffic only, so they are not reachable addresses of the node.
+var DefaultNodeExternalIPKinds = []oxide.ExternalIpKind{
+	oxide.ExternalIpKindEphemeral,
+	oxide.ExternalIpKindFloating,
+}
+
+const (
+	// InvalidHostnamesSkip omits invalid instance hostnames from node
+	// addresses.
+	InvalidHostnamesSkip = "skip"
+
+	// InvalidHostnamesSanitize reports invalid instance hostnames rewritten
+	// into valid DNS names.
+	InvalidHostnamesSanitize = "sanitize"
+)
+
+const (
+	// InstanceMetadataStrict fails building node metadata when any part of it
+	// fails.
+	InstanceMetadataStrict = "strict"
+
+	// InstanceMetadataBestEffort builds node metadata without the parts that
+	// failed.
+	InstanceMetadataBestEffort = "bestEffort"
+)
+
+const (
+	// FloatingIPCleanupDelete deletes the floating IPs of services that are no
+	// longer of type LoadBalancer.
+	FloatingIPCleanupDelete = "delete"
+
+	// FloatingIPCleanupDryRun logs the floating IPs of services that are no
+	// longer of type LoadBalancer without deleting them.
+	FloatingIPCleanupDryRun = "dryRun"
+)
+
+// DefaultNodeSyncConcurrency is the number of Oxide API calls in flight across
+// all node syncs when none is configured.
+const DefaultNodeSyncConcurrency = 16
+
+// DefaultStartupValidationTimeout is how long the startup validation is
+// retried while the Oxide API is unreachable when no timeout is configured.
+const DefaultStartupValidationTimeout = 5 * time.Minute
+
+// DefaultMinLoadBalancerNodes is the number of nodes that must be eligible to
+// back a floating IP before it is first attached when none is configured.
+const DefaultMinLoadBalancerNodes = 1
+
+// DefaultShutdownInstanceStates are the instance run states in which a node
+// is reported as shut down when none are configured.
+var DefaultShutdownInstanceStates = []oxide.InstanceState{oxide.InstanceStateStopped}
+
+// shutdownCapableInstanceStates are the instance run states that may be
+// configured as shut down. An instance in any of them is not running its
+// workloads.
+var shutdownCapableInstanceStates = []oxide.InstanceState{
+	oxide.InstanceStateStopping,
+	oxide.InstanceStateStopped,
+	oxide.InstanceStateFailed,
+}
+
+// HTTPTransportConfig tunes the HTTP transport used for Oxide API requests.
+// Every request is additionally bounded by the overall Oxide request timeout
+// and the deadline of its context, whichever is shorter.
+type HTTPTransportConfig struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept open to each
+	// Oxide API endpoint for reuse.
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost"`
+
+	// MaxConnsPerHost limits the number of connections to each Oxide API
+	// endpoint. Zero means no limit.
+	MaxConnsPerHost int `json:"maxConnsPerHost"`
+
+	// DialTimeout bounds establishing a TCP connection.
+	DialTimeout metav1.Duration `json:"dialTimeout"`
+
+	// KeepAlive is the interval between TCP keep-alive probes.
+	KeepAlive metav1.Duration `json:"keepAlive"`
+
+	// TLSHandshakeTimeout bounds the TLS handshake.
+	TLSHandshakeTimeout metav1.Duration `json:"tlsHandshakeTimeout"`
+
+	// ResponseHeaderTimeout bounds waiting for the response headers after the
+	// request is written.
+	ResponseHeaderTimeout metav1.Duration `json:"responseHeaderTimeout"`
+}
+
+// CircuitBreakerConfig configures the Oxide API circuit breaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests to an
+	// endpoint, such as connection errors and server errors, that open its
+	// circuit breaker.
+	FailureThreshold int `json:"failureThreshold"`
+
+	// Cooldown is how long requests fail fast once the circuit breaker opens,
+	// before a single request probes whether the API recovered.
+	Cooldown metav1.Duration `json:"cooldown"`
+}
+
+// DefaultCircuitBreaker is the circuit breaker configuration used for values
+// that are not configured.
+var DefaultCircuitBreaker = CircuitBreakerConfig{
+	FailureThreshold: 5,
+	Cooldown:         metav1.Duration{Duration: 30 * time.Second},
+}
+
+// NodeNameTransformConfig configures how the instance name of a node is
+// derived from the node name. The prefix and suffix are trimmed first, then
+// the regex, if any, is applied.
+type NodeNameTransformConfig struct {
+	// TrimPrefix is removed from the start of node names, such as `k8s-`.
+	TrimPrefix string `json:"trimPrefix,omitempty"`
+
+	// TrimSuffix is removed from the end of node names.
+	TrimSuffix string `json:"trimSuffix,omitempty"`
+
+	// Regex, when set, is matched against node names, and its first capture
+	// group is the instance name. Node names it does not match are used as
+	// they are.
+	Regex string `json:"regex,omitempty"`
+}
+
+// NodeScoringConfig configures the scoring of the nodes a floating IP may be
+// attached to. A node's score is lowered by each weight times the respective
+// count, and nodes with equal scores are ordered by name. Nodes that are not
+// ready, whose instance is not running, or that are otherwise ineligible are
+// never selected, whatever their score.
+type NodeScoringConfig struct {
+	// FloatingIPWeight lowers the score of a node for each floating IP of
+	// another service already attached to it.
+	FloatingIPWeight int `json:"floatingIPWeight"`
+
+	// PodWeight lowers the score of a node for each pod scheduled on it that
+	// hasn't terminated. Pods are only listed from the Kubernetes API when it
+	// is positive.
+	PodWeight int `json:"podWeight"`
+}
+
+// PreallocationConfig configures the spare floating IPs preallocated
+// for services. Spares are allocated in the nodes' project and are only
+// assigned to services whose floating IP would be allocated from the same
+// pool.
+type PreallocationConfig struct {
+	// Count is the number of spare floating IPs to keep allocated.
+	Count int `json:"count"`
+
+	// Pool is the IP pool to preallocate floating IPs from. Defaults to the
+	// first pool of FloatingIPPool, or the silo's default IP pool.
+	Pool string `json:"pool,omitempty"`
+
+	// DeleteReleased deletes the floating IPs of deleted services rather than
+	// returning them to the spares.
+	DeleteReleased bool `json:"deleteReleased,omitempty"`
+}
+
+// AdminConfig configures the admin endpoints.
+type AdminConfig struct {
+	// Address is the address the admin endpoints are served on over plain
+	// HTTP. Defaults to [DefaultAdminAddress], which only accepts connections
+	// from the host, when unset.
+	Address string `json:"address"`
+
+	// TokenFile is the path to a file containing the bearer token that
+	// requests to the admin endpoints must be authenticated with, such as a
+	// mounted Kubernetes secret. It is read on every request, so the token
+	// can be rotated without a restart.
+	TokenFile string `json:"tokenFile"`
+}
+
+// DefaultAdminAddress is the address the admin endpoints are served on when
+// none is configured. It is only reachable from the host, such as through
+// kubectl port-forward or exec.
+const DefaultAdminAddress = "127.0.0.1:10280"
+
+// WebhookConfig configures the service validating webhook.
+type WebhookConfig struct {
+	// Address is the address the webhook listens on. Defaults to
+	// [DefaultWebhookAddress] when unset.
+	Address string `json:"address"`
+
+	// CertFile and KeyFile are the paths of the TLS certificate and key the
+	// webhook serves, which the API server must trust.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+// DefaultWebhookAddress is the address the service validating webhook listens
+// on when none is configured.
+const DefaultWebhookAddress = ":9443"
+
+// DefaultHTTPTransport is the HTTP transport configuration used for values
+// that are not configured. It keeps more idle connections than the Go
+// default since the cloud controller manager issues many concurrent requests
+// to the same endpoint during node churn.
+var DefaultHTTPTransport = HTTPTransportConfig{
+	MaxIdleConnsPerHost:   32,
+	DialTimeout:           metav1.Duration{Duration: 10 * time.Second},
+	KeepAlive:             metav1.Duration{Duration: 30 * time.Second},
+	TLSHandshakeTimeout:   metav1.Duration{Duration: 10 * time.Second},
+	ResponseHeaderTimeout: metav1.Duration{Duration: time.Minute},
+}
+
+// RegionConfig is the configuration for a single Oxide region.
+type RegionConfig struct {
+	// Host is the Oxide API endpoint for the region (e.g.,
+	// https://oxide.sys.example.com). The top-level token is used to
+	// authenticate.
+	Host string `json:"host"`
+}
+
+// LoadConfig parses the configuration from r and merges it with the
+// environment. This is the configuration the cloud provider is initialized
+// with.
+func LoadConfig(r io.Reader) (*Config, error) {
+	cfg, err := ParseConfig(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv(envOrganization) != "" {
+		klog.Warningf("ignoring %s, Oxide instances are scoped by %s within the silo of the token",
+			envOrganization, EnvProject)
+	}
+
+	for env, field := range map[string]*string{
+		oxide.HostEnvVar:  &cfg.Host,
+		oxide.TokenEnvVar: &cfg.Token,
+		EnvProject:        &cfg.Project,
+	} {
+		if value := os.Getenv(env); value != "" {
+			*field = value
+		}
+	}
+
+	token, err := cfg.LoadToken()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Token = token
+
+	return cfg, nil
+}
+
+// LoadToken returns the current Oxide API token. It reads the token from
+// TokenFile, when set, so a rotated token is returned.
+func (c *Config) LoadToken() (string, error) {
+	if c.TokenFile == "" {
+		return c.Token, nil
+	}
+
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed reading token file: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ParseConfig reads and validates the configuration from r. A nil r, which
+// is passed when --cloud-config is unset, yields an empty configuration.
+func ParseConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+	if r == nil {
+		cfg.setDefaults()
+		return cfg, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading config: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed parsing config: %w", err)
+	}
+
+	cfg.setDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// setDefaults fills in defaults for values that were not configured.
+func (c *Config) setDefaults() {
+	if c.NodeLabels == nil {
+		c.NodeLabels = slices.Clone(DefaultNodeLabels)
+	}
+	if c.NodeAddressTypes == nil {
+		c.NodeAddressTypes = slices.Clone(DefaultNodeAddressTypes)
+	}
+	if c.NodeExternalIPKinds == nil {
+		c.NodeExternalIPKinds = slices.Clone(DefaultNodeExternalIPKinds)
+	}
+	if c.ShutdownInstanceStates == nil {
+		c.ShutdownInstanceStates = slices.Clone(DefaultShutdownInstanceStates)
+	}
+	if c.NodeSyncConcurrency == 0 {
+		c.NodeSyncConcurrency = DefaultNodeSyncConcurrency
+	}
+	if c.InvalidHostnames == "" {
+		c.InvalidHostnames = InvalidHostnamesSkip
+	}
+	if c.InstanceMetadataPolicy == "" {
+		c.InstanceMetadataPolicy = InstanceMetadataStrict
+	}
+	if c.MinLoadBalancerNodes == 0 {
+		c.MinLoadBalancerNodes = DefaultMinLoadBalancerNodes
+	}
+	if c.FloatingIPCleanup == "" {
+		c.FloatingIPCleanup = FloatingIPCleanupDelete
+	}
+	c.HTTPTransport.setDefaults()
+	if c.CircuitBreaker != nil {
+		if c.CircuitBreaker.FailureThreshold == 0 {
+			c.CircuitBreaker.FailureThreshold = DefaultCircuitBreaker.FailureThreshold
+		}
+		if c.CircuitBreaker.Cooldown.Duration == 0 {
+			c.CircuitBreaker.Cooldown = DefaultCircuitBreaker.Cooldown
+		}
+	}
+	if c.Admin != nil && c.Admin.Address == "" {
+		c.Admin.Address = DefaultAdminAddress
+	}
+	if c.Webhook != nil && c.Webhook.Address == "" {
+		c.Webhook.Address = DefaultWebhookAddress
+	}
+}
+
+// setDefaults fills in [DefaultHTTPTransport] for values that were not
+// configured.
+func (c *HTTPTransportConfig) setDefaults() {
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = DefaultHTTPTransport.MaxIdleConnsPerHost
+	}
+	for _, d := range []struct{ value, fallback *metav1.Duration }{
+		{&c.DialTimeout, &DefaultHTTPTransport.DialTimeout},
+		{&c.KeepAlive, &DefaultHTTPTransport.KeepAlive},
+		{&c.TLSHandshakeTimeout, &DefaultHTTPTransport.TLSHandshakeTimeout},
+		{&c.ResponseHeaderTimeout, &DefaultHTTPTransport.ResponseHeaderTimeout},
+	} {
+		if d.value.Duration == 0 {
+			*d.value = *d.fallback
+		}
+	}
+}
+
+// validate checks the transport configuration for negative values.
+func (c *HTTPTransportConfig) validate() error {
+	errs := make([]error, 0)
+	for name, value := range map[string]int64{
+		"maxIdleConnsPerHost":   int64(c.MaxIdleConnsPerHost),
+		"maxConnsPerHost":       int64(c.MaxConnsPerHost),
+		"dialTimeout":           int64(c.DialTimeout.Duration),
+		"keepAlive":             int64(c.KeepAlive.Duration),
+		"tlsHandshakeTimeout":   int64(c.TLSHandshakeTimeout.Duration),
+		"responseHeaderTimeout": int64(c.ResponseHeaderTimeout.Duration),
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Errorf("http transport: %s must not be negative", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Transport returns an HTTP transport configured from c, based on
+// [http.DefaultTransport].
+func (c *HTTPTransportConfig) Transport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   c.DialTimeout.Duration,
+		KeepAlive: c.KeepAlive.Duration,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = c.MaxConnsPerHost
+	transport.TLSHandshakeTimeout = c.TLSHandshakeTimeout.Duration
+	transport.ResponseHeaderTimeout = c.ResponseHeaderTimeout.Duration
+	return transport
+}
+
+// Validate checks the configuration for errors, returning all of them.
+func (c *Config) Validate() error {
+	errs := make([]error, 0)
+
+	if c.BaseURL != "" {
+		if _, err := parseBaseURL(c.BaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid base url: %w", err))
+		}
+	}
+
+	for _, name := range c.RegionNames() {
+		region := c.Regions[name]
+		if region.Host == "" {
+			errs = append(errs, fmt.Errorf("region %q: host is required", name))
+			continue
+		}
+
+		host := region.Host
+		if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+			host = "https://" + host
+		}
+		if _, err := url.ParseRequestURI(host); err != nil {
+			errs = append(errs, fmt.Errorf("region %q: invalid host: %w", name, err))
+		}
+	}
+
+	for namespace, pool := range c.NamespaceFloatingIPPools {
+		if len(splitIPPools(pool)) == 0 {
+			errs = append(errs, fmt.Errorf("namespace %q: floating ip pool is empty", namespace))
+		}
+	}
+
+	if c.CircuitBreaker != nil {
+		if c.CircuitBreaker.FailureThreshold < 0 {
+			errs = append(errs, errors.New("circuit breaker: failureThreshold must be positive"))
+		}
+		if c.CircuitBreaker.Cooldown.Duration < 0 {
+			errs = append(errs, errors.New("circuit breaker: cooldown must be positive"))
+		}
+	}
+
+	if c.NodeScoring != nil {
+		if c.NodeScoring.FloatingIPWeight < 0 || c.NodeScoring.PodWeight < 0 {
+			errs = append(errs, errors.New("node scoring: weights must not be negative"))
+		}
+		if c.NodeScoring.FloatingIPWeight == 0 && c.NodeScoring.PodWeight == 0 {
+			errs = append(errs, errors.New("node scoring: at least one weight must be positive"))
+		}
+	}
+
+	if c.LoadBalancerOperationTimeout != nil {
+		if err := checkOperationTimeout(c.LoadBalancerOperationTimeout.Duration); err != nil {
+			errs = append(errs, fmt.Errorf("load balancer %w", err))
+		}
+	}
+
+	if c.FloatingIPAttachMode != "" {
+		if err := checkAttachMode(c.FloatingIPAttachMode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.FloatingIPPreallocation != nil && c.FloatingIPPreallocation.Count <= 0 {
+		errs = append(errs, errors.New("floating ip preallocation: count must be positive"))
+	}
+
+	if c.Admin != nil && c.Admin.TokenFile == "" {
+		errs = append(errs, errors.New("admin: tokenFile is required"))
+	}
+
+	if c.Webhook != nil {
+		if c.Webhook.CertFile == "" || c.Webhook.KeyFile == "" {
+			errs = append(errs, errors.New("webhook: certFile and keyFile are required"))
+		}
+	}
+
+	if _, err := labels.Parse(c.IngressNodeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid ingress node selector: %w", err))
+	}
+
+	for _, name := range c.NodeLabels {
+		if err := validNodeLabel(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name, key := range c.NodeLabelKeys {
+		if err := validNodeLabelKey(name, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	keyNames := make(map[string]string, len(nodeLabelKeys))
+	for _, name := range slices.Sorted(maps.Keys(nodeLabelKeys)) {
+		key := nodeLabelKeys[name]
+		if override, ok := c.NodeLabelKeys[name]; ok {
+			key = override
+		}
+		if other, ok := keyNames[key]; ok {
+			errs = append(errs, fmt.Errorf(
+				"node labels %q and %q have the same key %q", other, name, key,
+			))
+		}
+		keyNames[key] = name
+	}
+
+	if len(c.NodeAddressTypes) == 0 {
+		errs = append(errs, errors.New("node address types must not be empty"))
+	}
+	for _, addressType := range c.NodeAddressTypes {
+		if !slices.Contains(DefaultNodeAddressTypes, addressType) {
+			errs = append(errs, fmt.Errorf("unknown node address type %q", addressType))
+		}
+	}
+
+	if _, err := newInternalIPFilter(
+		c.InternalIPIncludeCIDRs, c.InternalIPExcludeCIDRs,
+	); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := newNodeNameTransform(c.NodeNameTransform); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, kind := range c.NodeExternalIPKinds {
+		if !slices.Contains(externalIPKinds, kind) {
+			errs = append(errs, fmt.Errorf(
+				"unknown node external ip kind %q, must be one of %v", kind, externalIPKinds,
+			))
+		}
+	}
+
+	for _, state := range c.ShutdownInstanceStates {
+		if !slices.Contains(shutdownCapableInstanceStates, state) {
+			errs = append(errs, fmt.Errorf(
+				"unknown shutdown instance state %q, must be one of %v",
+				state, shutdownCapableInstanceStates,
+			))
+		}
+	}
+
+	switch c.InvalidHostnames {
+	case InvalidHostnamesSkip, InvalidHostnamesSanitize:
+	default:
+		errs = append(errs, fmt.Errorf(
+			"unknown invalid hostnames handling %q, must be %q or %q",
+			c.InvalidHostnames, InvalidHostnamesSkip, InvalidHostnamesSanitize,
+		))
+	}
+
+	switch c.InstanceMetadataPolicy {
+	case InstanceMetadataStrict, InstanceMetadataBestEffort:
+	default:
+		errs = append(errs, fmt.Errorf(
+			"unknown instance metadata policy %q, must be %q or %q",
+			c.InstanceMetadataPolicy, InstanceMetadataStrict, InstanceMetadataBestEffort,
+		))
+	}
+
+	switch c.FloatingIPCleanup {
+	case FloatingIPCleanupDelete, FloatingIPCleanupDryRun:
+	default:
+		errs = append(errs, fmt.Errorf(
+			"unknown floating ip cleanup %q, must be %q or %q",
+			c.FloatingIPCleanup, FloatingIPCleanupDelete, FloatingIPCleanupDryRun,
+		))
+	}
+
+	switch c.Preflight {
+	case "", PreflightRead, PreflightWrite:
+	default:
+		errs = append(errs, fmt.Errorf(
+			"unknown preflight %q, must be %q or %q", c.Preflight, PreflightRead, PreflightWrite,
+		))
+	}
+	if c.PreflightInstance != "" && c.Preflight != PreflightWrite {
+		errs = append(errs, fmt.Errorf("preflight instance requires preflight %q", PreflightWrite))
+	}
+
+	if c.NodeSyncConcurrency < 0 {
+		errs = append(errs, errors.New("node sync concurrency must not be negative"))
+	}
+
+	if c.MinLoadBalancerNodes < 0 {
+		errs = append(errs, errors.New("min load balancer nodes must not be negative"))
+	}
+
+	if c.StartupValidationTimeout != nil && c.StartupValidationTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("startup validation timeout must be positive"))
+	}
+
+	if c.FloatingIPFailoverGracePeriod != nil && c.FloatingIPFailoverGracePeriod.Duration <= 0 {
+		errs = append(errs, errors.New("floating ip failover grace period must be positive"))
+	}
+
+	if c.InstanceIndexInterval != nil && c.InstanceIndexInterval.Duration <= 0 {
+		errs = append(errs, errors.New("instance index interval must be positive"))
+	}
+
+	if c.InstanceMetadataCacheTTL != nil && c.InstanceMetadataCacheTTL.Duration <= 0 {
+		errs = append(errs, errors.New("instance metadata cache ttl must be positive"))
+	}
+
+	if c.NodeDriftInterval != nil && c.NodeDriftInterval.Duration <= 0 {
+		errs = append(errs, errors.New("node drift interval must be positive"))
+	}
+
+	if c.ServiceReconcileDebounce != nil && c.ServiceReconcileDebounce.Duration <= 0 {
+		errs = append(errs, errors.New("service reconcile debounce must be positive"))
+	}
+
+	if err := c.HTTPTransport.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ClientOptions returns the options to build an Oxide client from the
+// configuration. Unset values are omitted so the client can fall back to its
+// own defaults (e.g., OXIDE_PROFILE).
+func (c *Config) ClientOptions() []oxide.ClientOption {
+	opts := make([]oxide.ClientOption, 0)
+	if host := cmp.Or(c.BaseURL, c.Host); host != "" {
+		opts = append(opts, oxide.WithHost(host))
+	}
+	if c.Token != "" {
+		opts = append(opts, oxide.WithToken(c.Token))
+	}
+	return opts
+}
+
+// Redacted returns a copy of the configuration with secrets redacted so it is
+// safe to print or log.
+func (c *Config) Redacted() *Config {
+	cfg := *c
+	if cfg.Token != "" {
+		cfg.Token = redacted
+	}
+	return &cfg
+}
+
+// Print writes the redacted configuration to w as YAML.
+func (c *Config) Print(w io.Writer) error {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Errorf("failed marshaling config: %w", err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// FloatingIPPools returns the IP pools referenced by the configuration,
+// including fallback pools, in sorted order, without duplicates.
+func (c *Config) FloatingIPPools() []string {
+	pools := splitIPPools(c.FloatingIPPool)
+	for _, namespacePools := range c.NamespaceFloatingIPPools {
+		pools = append(pools, splitIPPools(namespacePools)...)
+	}
+	if c.FloatingIPPreallocation != nil && c.FloatingIPPreallocation.Pool != "" {
+		pools = append(pools, c.FloatingIPPreallocation.Pool)
+	}
+	slices.Sort(pools)
+	return slices.Compact(pools)
+}
+
+// Endpoints maps the configured region names, and the empty region of the
+// default endpoint, to their Oxide API endpoint.
+func (c *Config) Endpoints() map[string]string {
+	endpoints := map[string]string{"": cmp.Or(c.BaseURL, c.Host)}
+	for name, region := range c.Regions {
+		endpoints[name] = region.Host
+	}
+	return endpoints
+}
+
+// RegionNames returns the configured region names in sorted order.
+func (c *Config) RegionNames() []string {
+	return slices.Sorted(maps.Keys(c.Regions))
+}
@@ -0,0 +1,486 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("NilReader", func(t *testing.T) {
+		cfg, err := ParseConfig(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(cfg.Regions) != 0 {
+			t.Fatalf("regions = %v, want none", cfg.Regions)
+		}
+		if !slices.Equal(cfg.NodeLabels, DefaultNodeLabels) {
+			t.Fatalf("node labels = %v, want %v", cfg.NodeLabels, DefaultNodeLabels)
+		}
+		if !slices.Equal(cfg.NodeAddressTypes, DefaultNodeAddressTypes) {
+			t.Fatalf("node address types = %v, want %v",
+				cfg.NodeAddressTypes, DefaultNodeAddressTypes,
+			)
+		}
+	})
+
+	t.Run("NodeLabels", func(t *testing.T) {
+		cfg, err := ParseConfig(strings.NewReader("nodeLabels: [vpc, image]\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Equal(cfg.NodeLabels, []string{"vpc", "image"}) {
+			t.Fatalf("node labels = %v, want [vpc image]", cfg.NodeLabels)
+		}
+
+		cfg, err = ParseConfig(strings.NewReader("nodeLabels: []\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(cfg.NodeLabels) != 0 {
+			t.Fatalf("node labels = %v, want none", cfg.NodeLabels)
+		}
+	})
+
+	t.Run("TwoRegions", func(t *testing.T) {
+		cfg, err := ParseConfig(strings.NewReader(`
+regions:
+  west:
+    host: https://oxide-west.sys.example.com
+  east:
+    host: oxide-east.sys.example.com
+`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		names := cfg.RegionNames()
+		if !slices.Equal(names, []string{"east", "west"}) {
+			t.Fatalf("region names = %v, want [east west]", names)
+		}
+		if got := cfg.Regions["west"].Host; got != "https://oxide-west.sys.example.com" {
+			t.Fatalf("west host = %q, want %q", got, "https://oxide-west.sys.example.com")
+		}
+	})
+
+	t.Run("FloatingIPPools", func(t *testing.T) {
+		cfg, err := ParseConfig(strings.NewReader(`
+floatingIPPool: public, overflow
+namespaceFloatingIPPools:
+  prod: public
+  dev: internal
+`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []string{"internal", "overflow", "public"}
+		if pools := cfg.FloatingIPPools(); !slices.Equal(pools, want) {
+			t.Fatalf("pools = %v, want %v", pools, want)
+		}
+	})
+
+	t.Run("HTTPTransport", func(t *testing.T) {
+		cfg, err := ParseConfig(strings.NewReader(`
+httpTransport:
+  maxIdleConnsPerHost: 64
+  maxConnsPerHost: 128
+  tlsHandshakeTimeout: 5s
+`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		transport := cfg.HTTPTransport.Transport()
+		if transport.MaxIdleConnsPerHost != 64 {
+			t.Fatalf("max idle conns per host = %d, want 64", transport.MaxIdleConnsPerHost)
+		}
+		if transport.MaxConnsPerHost != 128 {
+			t.Fatalf("max conns per host = %d, want 128", transport.MaxConnsPerHost)
+		}
+		if transport.TLSHandshakeTimeout != 5*time.Second {
+			t.Fatalf("tls handshake timeout = %v, want 5s", transport.TLSHandshakeTimeout)
+		}
+
+		// Unset values fall back to the defaults.
+		want := DefaultHTTPTransport.ResponseHeaderTimeout.Duration
+		if transport.ResponseHeaderTimeout != want {
+			t.Fatalf("response header timeout = %v, want %v",
+				transport.ResponseHeaderTimeout, want,
+			)
+		}
+		if cfg.HTTPTransport.DialTimeout != DefaultHTTPTransport.DialTimeout {
+			t.Fatalf("dial timeout = %v, want %v",
+				cfg.HTTPTransport.DialTimeout, DefaultHTTPTransport.DialTimeout,
+			)
+		}
+		if transport.DialContext == nil {
+			t.Fatal("dial context is unset")
+		}
+	})
+
+	t.Run("ShutdownInstanceStates", func(t *testing.T) {
+		cfg, err := ParseConfig(strings.NewReader("shutdownInstanceStates: []\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.ShutdownInstanceStates == nil || len(cfg.ShutdownInstanceStates) != 0 {
+			t.Fatalf("shutdown instance states = %v, want none", cfg.ShutdownInstanceStates)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		tt := []struct {
+			name     string
+			config   string
+			errorMsg string
+		}{
+			{
+				name:     "base url without scheme",
+				config:   "baseURL: oxide.sys.example.com/api\n",
+				errorMsg: `invalid base url: scheme "" must be http or https`,
+			},
+			{
+				name:     "base url with query",
+				config:   "baseURL: https://oxide.sys.example.com/api?x=1\n",
+				errorMsg: "invalid base url: query and fragment are not allowed",
+			},
+			{
+				name:     "region without host",
+				config:   "regions:\n  west: {}\n",
+				errorMsg: `region "west": host is required`,
+			},
+			{
+				name:     "region with invalid host",
+				config:   "regions:\n  west:\n    host: \"https://oxide west\"\n",
+				errorMsg: `region "west": invalid host`,
+			},
+			{
+				name:     "namespace with empty pool",
+				config:   "namespaceFloatingIPPools:\n  dev: \"\"\n",
+				errorMsg: `namespace "dev": floating ip pool is empty`,
+			},
+			{
+				name:     "unknown node label",
+				config:   "nodeLabels: [flavor]\n",
+				errorMsg: `unknown node label "flavor"`,
+			},
+			{
+				name:     "invalid node label key",
+				config:   "nodeLabelKeys:\n  project: \"example.com/my project\"\n",
+				errorMsg: `invalid key "example.com/my project" for node label "project"`,
+			},
+			{
+				name:     "unknown remapped node label",
+				config:   "nodeLabelKeys:\n  flavor: example.com/flavor\n",
+				errorMsg: `unknown node label "flavor"`,
+			},
+			{
+				name:     "duplicate node label key",
+				config:   "nodeLabelKeys:\n  project: oxide.computer/region\n",
+				errorMsg: `node labels "project" and "region" have the same key`,
+			},
+			{
+				name:     "negative http transport value",
+				config:   "httpTransport:\n  dialTimeout: -1s\n",
+				errorMsg: "http transport: dialTimeout must not be negative",
+			},
+			{
+				name:     "unknown node address type",
+				config:   "nodeAddressTypes: [InternalDNS]\n",
+				errorMsg: `unknown node address type "InternalDNS"`,
+			},
+			{
+				name:     "no node address types",
+				config:   "nodeAddressTypes: []\n",
+				errorMsg: "node address types must not be empty",
+			},
+			{
+				name:     "unknown node external ip kind",
+				config:   "nodeExternalIPKinds: [nat64]\n",
+				errorMsg: `unknown node external ip kind "nat64"`,
+			},
+			{
+				name:     "negative node sync concurrency",
+				config:   "nodeSyncConcurrency: -1\n",
+				errorMsg: "node sync concurrency must not be negative",
+			},
+			{
+				name:     "negative min load balancer nodes",
+				config:   "minLoadBalancerNodes: -1\n",
+				errorMsg: "min load balancer nodes must not be negative",
+			},
+			{
+				name:     "zero startup validation timeout",
+				config:   "startupValidationTimeout: 0s\n",
+				errorMsg: "startup validation timeout must be positive",
+			},
+			{
+				name:     "zero floating ip failover grace period",
+				config:   "floatingIPFailoverGracePeriod: 0s\n",
+				errorMsg: "floating ip failover grace period must be positive",
+			},
+			{
+				name:     "unknown shutdown instance state",
+				config:   "shutdownInstanceStates: [running]\n",
+				errorMsg: `unknown shutdown instance state "running"`,
+			},
+			{
+				name:     "invalid internal ip include cidr",
+				config:   "internalIPIncludeCIDRs: [172.30.0.0]\n",
+				errorMsg: "invalid internal ip include cidr",
+			},
+			{
+				name:     "invalid internal ip exclude cidr",
+				config:   "internalIPExcludeCIDRs: [172.30.0.0/33]\n",
+				errorMsg: "invalid internal ip exclude cidr",
+			},
+			{
+				name:     "invalid node name transform regex",
+				config:   "nodeNameTransform:\n  regex: \"k8s-(.*\"\n",
+				errorMsg: "invalid node name transform regex",
+			},
+			{
+				name:     "node name transform regex without capture group",
+				config:   "nodeNameTransform:\n  regex: \"^k8s-.*$\"\n",
+				errorMsg: "node name transform regex must have a capture group",
+			},
+			{
+				name:     "invalid ingress node selector",
+				config:   "ingressNodeSelector: in valid\n",
+				errorMsg: "invalid ingress node selector",
+			},
+			{
+				name:     "non-positive node drift interval",
+				config:   "nodeDriftInterval: 0s\n",
+				errorMsg: "node drift interval must be positive",
+			},
+			{
+				name:     "non-positive service reconcile debounce",
+				config:   "serviceReconcileDebounce: 0s\n",
+				errorMsg: "service reconcile debounce must be positive",
+			},
+			{
+				name:     "negative circuit breaker threshold",
+				config:   "circuitBreaker:\n  failureThreshold: -1\n",
+				errorMsg: "circuit breaker: failureThreshold must be positive",
+			},
+			{
+				name:     "negative node scoring weight",
+				config:   "nodeScoring:\n  floatingIPWeight: 1\n  podWeight: -1\n",
+				errorMsg: "node scoring: weights must not be negative",
+			},
+			{
+				name:     "node scoring without weights",
+				config:   "nodeScoring: {}\n",
+				errorMsg: "node scoring: at least one weight must be positive",
+			},
+			{
+				name:     "load balancer operation timeout too short",
+				config:   "loadBalancerOperationTimeout: 1s\n",
+				errorMsg: "load balancer operation timeout must be between 10s and 1h0m0s",
+			},
+			{
+				name:     "unknown floating ip attach mode",
+				config:   "floatingIPAttachMode: anycast\n",
+				errorMsg: `unknown attach mode "anycast"`,
+			},
+			{
+				name:     "floating ip preallocation without count",
+				config:   "floatingIPPreallocation:\n  pool: spares\n",
+				errorMsg: "floating ip preallocation: count must be positive",
+			},
+			{
+				name:     "admin without token file",
+				config:   "admin:\n  address: 127.0.0.1:10280\n",
+				errorMsg: "admin: tokenFile is required",
+			},
+			{
+				name:     "webhook without certificate",
+				config:   "webhook:\n  address: \":8443\"\n",
+				errorMsg: "webhook: certFile and keyFile are required",
+			},
+			{
+				name:     "unknown invalid hostnames handling",
+				config:   "invalidHostnames: drop\n",
+				errorMsg: `unknown invalid hostnames handling "drop"`,
+			},
+			{
+				name:     "unknown instance metadata policy",
+				config:   "instanceMetadataPolicy: lenient\n",
+				errorMsg: `unknown instance metadata policy "lenient"`,
+			},
+			{
+				name:     "unknown floating ip cleanup",
+				config:   "floatingIPCleanup: never\n",
+				errorMsg: `unknown floating ip cleanup "never"`,
+			},
+			{
+				name:     "unknown preflight",
+				config:   "preflight: all\n",
+				errorMsg: `unknown preflight "all"`,
+			},
+			{
+				name:     "preflight instance without write",
+				config:   "preflight: read\npreflightInstance: canary\n",
+				errorMsg: `preflight instance requires preflight "write"`,
+			},
+			{
+				name:     "non-positive instance index interval",
+				config:   "instanceIndexInterval: 0s\n",
+				errorMsg: "instance index interval must be positive",
+			},
+			{
+				name:     "non-positive instance metadata cache ttl",
+				config:   "instanceMetadataCacheTTL: -1m\n",
+				errorMsg: "instance metadata cache ttl must be positive",
+			},
+			{
+				name:     "unknown field",
+				config:   "regionz: {}\n",
+				errorMsg: "failed parsing config",
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := ParseConfig(strings.NewReader(tc.config))
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Fatalf("error = %v, want %q", err, tc.errorMsg)
+				}
+			})
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	const file = `
+host: https://file.sys.example.com
+token: file-token
+project: file-project
+`
+
+	t.Run("FileOnly", func(t *testing.T) {
+		t.Setenv("OXIDE_HOST", "")
+		t.Setenv("OXIDE_TOKEN", "")
+		t.Setenv("OXIDE_PROJECT", "")
+
+		cfg, err := LoadConfig(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Host != "https://file.sys.example.com" ||
+			cfg.Token != "file-token" ||
+			cfg.Project != "file-project" {
+			t.Fatalf("config = %+v, want values from file", cfg)
+		}
+	})
+
+	t.Run("EnvironmentTakesPrecedence", func(t *testing.T) {
+		t.Setenv("OXIDE_HOST", "https://env.sys.example.com")
+		t.Setenv("OXIDE_TOKEN", "env-token")
+		t.Setenv("OXIDE_PROJECT", "env-project")
+
+		cfg, err := LoadConfig(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Host != "https://env.sys.example.com" ||
+			cfg.Token != "env-token" ||
+			cfg.Project != "env-project" {
+			t.Fatalf("config = %+v, want values from environment", cfg)
+		}
+	})
+
+	t.Run("OrganizationIgnored", func(t *testing.T) {
+		t.Setenv("OXIDE_PROJECT", "")
+		t.Setenv("OXIDE_ORGANIZATION", "env-organization")
+
+		cfg, err := LoadConfig(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Project != "file-project" {
+			t.Fatalf("project = %q, want the project from the file", cfg.Project)
+		}
+	})
+
+	t.Run("TokenFile", func(t *testing.T) {
+		// The token file takes precedence so that the token can be rotated.
+		t.Setenv("OXIDE_TOKEN", "env-token")
+
+		path := filepath.Join(t.TempDir(), "token")
+		if err := os.WriteFile(path, []byte("file-token-1\n"), 0o600); err != nil {
+			t.Fatalf("failed writing token file: %v", err)
+		}
+
+		cfg, err := LoadConfig(strings.NewReader(file + "tokenFile: " + path + "\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Token != "file-token-1" {
+			t.Fatalf("token = %q, want the token from the token file", cfg.Token)
+		}
+
+		// A rotated token is returned on the next load.
+		if err := os.WriteFile(path, []byte("file-token-2"), 0o600); err != nil {
+			t.Fatalf("failed writing token file: %v", err)
+		}
+		if token, _ := cfg.LoadToken(); token != "file-token-2" {
+			t.Fatalf("reloaded token = %q, want %q", token, "file-token-2")
+		}
+	})
+
+	t.Run("PrintRedactsToken", func(t *testing.T) {
+		t.Setenv("OXIDE_HOST", "")
+		t.Setenv("OXIDE_TOKEN", "env-token")
+		t.Setenv("OXIDE_PROJECT", "")
+
+		cfg, err := LoadConfig(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var out bytes.Buffer
+		if err := cfg.Print(&out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := "floatingIPCleanup: delete\n" +
+			"host: https://file.sys.example.com\n" +
+			"httpTransport:\n" +
+			"  dialTimeout: 10s\n" +
+			"  keepAlive: 30s\n" +
+			"  maxConnsPerHost: 0\n" +
+			"  maxIdleConnsPerHost: 32\n" +
+			"  responseHeaderTimeout: 1m0s\n" +
+			"  tlsHandshakeTimeout: 10s\n" +
+			"instanceMetadataPolicy: strict\n" +
+			"invalidHostnames: skip\n" +
+			"minLoadBalancerNodes: 1\n" +
+			"nodeAddressTypes:\n- InternalIP\n- ExternalIP\n- Hostname\n" +
+			"nodeExternalIPKinds:\n- ephemeral\n- floating\n" +
+			"nodeLabels:\n- project\n- region\n" +
+			"nodeSyncConcurrency: 16\n" +
+			"project: file-project\n" +
+			"shutdownInstanceStates:\n- stopped\n" +
+			"token: REDACTED\n"
+		if out.String() != want {
+			t.Fatalf("printed config = %q, want %q", out.String(), want)
+		}
+		if cfg.Token != "env-token" {
+			t.Fatalf("token = %q, redacting must not modify the config", cfg.Token)
+		}
+	})
+}
@@ -0,0 +1,372 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// isEphemeral reports whether the service's load balancer uses an ephemeral IP
+// because of [AnnotationAddressType].
+func isEphemeral(service *v1.Service) bool {
+	return service.Annotations[AnnotationAddressType] == AddressTypeEphemeral
+}
+
+// checkAddressType returns an error when the service's [AnnotationAddressType]
+// is unknown, or is [AddressTypeEphemeral] together with annotations that only
+// apply to floating IPs.
+func checkAddressType(service *v1.Service) error {
+	addressType, ok := service.Annotations[AnnotationAddressType]
+	if !ok || addressType == AddressTypeStatic {
+		return nil
+	}
+	if addressType != AddressTypeEphemeral {
+		return fmt.Errorf(
+			"invalid %s value %q, must be %q or %q",
+			AnnotationAddressType, addressType, AddressTypeStatic, AddressTypeEphemeral,
+		)
+	}
+
+	for _, annotation := range []string{
+		AnnotationFloatingIP, AnnotationSharedIPKey, AnnotationFloatingIPProject,
+	} {
+		if _, ok := service.Annotations[annotation]; ok {
+			return fmt.Errorf(
+				"annotation %s cannot be used with %s %q",
+				annotation, AnnotationAddressType, AddressTypeEphemeral,
+			)
+		}
+	}
+
+	return nil
+}
+
+// ensureEphemeralLoadBalancer attaches an ephemeral IP to the instance of the
+// target node, unless one that another load balancer attached is there
+// already, records them on the service's backing annotations, and returns the
+// load balancer status. The ephemeral IP on the instance that previously
+// backed the service is detached first. An instance with an ephemeral IP that
+// the cloud controller manager did not attach cannot back the service, since
+// an instance has at most one ephemeral IP. The annotations are the only record
+// of which ephemeral IPs the cloud controller manager attached, so an ephemeral
+// IP that was just attached is detached again when recording it fails.
+func (l *LoadBalancer) ensureEphemeralLoadBalancer(
+	ctx context.Context,
+	service *v1.Service,
+	targetNode *v1.Node,
+	instanceID string,
+) (*v1.LoadBalancerStatus, error) {
+	others, err := l.ephemeralIPServices(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool)
+	previous := service.Annotations[AnnotationBackingInstance]
+	switch {
+	case previous == instanceID:
+		owned[service.Annotations[AnnotationBackingEphemeralIP]] = true
+	case previous != "":
+		if err := l.detachEphemeralIP(ctx, service, previous, others); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, other := range others {
+		if other.Annotations[AnnotationBackingInstance] != instanceID {
+			continue
+		}
+		owned[other.Annotations[AnnotationBackingEphemeralIP]] = true
+		if port, ok := conflictingPort(service, &other); ok {
+			return nil, fmt.Errorf(
+				"port %d/%s is already exposed on the ephemeral ip of instance %s "+
+					"by service %s/%s",
+				port.Port, port.Protocol, instanceID, other.Namespace, other.Name,
+			)
+		}
+	}
+	delete(owned, "")
+
+	ephemeralIP, err := l.instanceEphemeralIP(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	attached := false
+	switch {
+	case ephemeralIP == nil:
+		ephemeralIP, err = l.attachEphemeralIP(ctx, service, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		attached = true
+	case !owned[ephemeralIP.Ip]:
+		return nil, fmt.Errorf(
+			"instance %s of node %s already has ephemeral ip %s, "+
+				"which was not attached by the cloud controller manager",
+			instanceID, targetNode.Name, ephemeralIP.Ip,
+		)
+	}
+
+	err = l.patchAnnotations(ctx, service, map[string]string{
+		AnnotationBackingNode:        targetNode.Name,
+		AnnotationBackingInstance:    instanceID,
+		AnnotationBackingIPPool:      ephemeralIP.IpPoolId,
+		AnnotationBackingEphemeralIP: ephemeralIP.Ip,
+	})
+	if err != nil {
+		if attached {
+			// Otherwise, the next reconcile takes the unrecorded ephemeral IP
+			// for one the cloud controller manager did not attach.
+			detachErr := l.detachInstanceEphemeralIP(ctx, service, instanceID, ephemeralIP.Ip)
+			return nil, errors.Join(err, detachErr)
+		}
+		return nil, err
+	}
+
+	return ephemeralLoadBalancerStatus(service, ephemeralIP.Ip, targetNode), nil
+}
+
+// attachEphemeralIP attaches an ephemeral IP to the instance, allocated from
+// the pool or IP version selected by the service's annotations, or the silo's
+// default IP pool.
+func (l *LoadBalancer) attachEphemeralIP(
+	ctx context.Context,
+	service *v1.Service,
+	instanceID string,
+) (*oxide.ExternalIpEphemeral, error) {
+	annotations, err := l.annotationsWithDefaultPool(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	allocator, err := addressAllocatorFromAnnotations(annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing annotations: %w", err)
+	}
+	auto, ok := allocator.AsAuto()
+	if !ok {
+		return nil, errors.New("ephemeral ips cannot be allocated with an explicit address")
+	}
+	if err := l.checkIPFamily(ctx, service, allocator, nil); err != nil {
+		return nil, err
+	}
+
+	externalIP, err := l.client.InstanceEphemeralIpAttach(
+		ctx, oxide.InstanceEphemeralIpAttachParams{
+			Instance: oxide.NameOrId(instanceID),
+			Body:     &oxide.EphemeralIpCreate{PoolSelector: auto.PoolSelector},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed attaching ephemeral ip to instance %s: %w", instanceID, err,
+		)
+	}
+	l.poolUtilization.changed()
+
+	ephemeralIP, ok := externalIP.AsEphemeral()
+	if !ok {
+		return nil, fmt.Errorf(
+			"attaching ephemeral ip to instance %s returned a %s ip",
+			instanceID, externalIP.Kind(),
+		)
+	}
+
+	klog.InfoS("attached ephemeral ip", "service", klog.KObj(service),
+		"instanceID", instanceID, "ip", ephemeralIP.Ip)
+	return ephemeralIP, nil
+}
+
+// detachEphemeralIP detaches the ephemeral IP recorded on the service from the
+// instance, unless one of the other services uses it. An ephemeral IP that is
+// already gone, or was replaced by one the cloud controller manager did not
+// attach, is left alone.
+func (l *LoadBalancer) detachEphemeralIP(
+	ctx context.Context,
+	service *v1.Service,
+	instanceID string,
+	others []v1.Service,
+) error {
+	ip := service.Annotations[AnnotationBackingEphemeralIP]
+	if ip == "" {
+		return nil
+	}
+
+	for _, other := range others {
+		if other.Annotations[AnnotationBackingInstance] == instanceID &&
+			other.Annotations[AnnotationBackingEphemeralIP] == ip {
+			klog.V(2).InfoS("keeping ephemeral ip used by another service",
+				"service", klog.KObj(service), "other", klog.KObj(&other), "ip", ip)
+			return nil
+		}
+	}
+
+	ephemeralIP, err := l.instanceEphemeralIP(ctx, instanceID)
+	if errors.Is(err, oxide.ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ephemeralIP == nil || ephemeralIP.Ip != ip {
+		return nil
+	}
+
+	return l.detachInstanceEphemeralIP(ctx, service, instanceID, ip)
+}
+
+// detachInstanceEphemeralIP detaches the ephemeral IP ip of the service from
+// the instance. An ephemeral IP that is already gone is not an error.
+func (l *LoadBalancer) detachInstanceEphemeralIP(
+	ctx context.Context,
+	service *v1.Service,
+	instanceID string,
+	ip string,
+) error {
+	version := oxide.IpVersionV4
+	if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() {
+		version = oxide.IpVersionV6
+	}
+
+	err := l.client.InstanceEphemeralIpDetach(ctx, oxide.InstanceEphemeralIpDetachParams{
+		Instance:  oxide.NameOrId(instanceID),
+		IpVersion: version,
+	})
+	if err != nil && !errors.Is(err, oxide.ErrObjectNotFound) {
+		return fmt.Errorf(
+			"failed detaching ephemeral ip %s from instance %s: %w", ip, instanceID, err,
+		)
+	}
+	l.poolUtilization.changed()
+
+	klog.InfoS("detached ephemeral ip", "service", klog.KObj(service),
+		"instanceID", instanceID, "ip", ip)
+	return nil
+}
+
+// deleteEphemeralLoadBalancer detaches the service's ephemeral IP, unless
+// another service uses it, and removes the backing annotations.
+func (l *LoadBalancer) deleteEphemeralLoadBalancer(
+	ctx context.Context,
+	service *v1.Service,
+) error {
+	if instanceID := service.Annotations[AnnotationBackingInstance]; instanceID != "" {
+		others, err := l.ephemeralIPServices(ctx, service)
+		if err != nil {
+			return err
+		}
+		if err := l.detachEphemeralIP(ctx, service, instanceID, others); err != nil {
+			return err
+		}
+	}
+
+	return l.patchBackingAnnotations(ctx, service, "", "", "")
+}
+
+// getEphemeralLoadBalancer returns the status and management state of the
+// service's ephemeral IP. A recorded ephemeral IP that is no longer attached to
+// its instance is degraded, so that the load balancer is still cleaned up.
+func (l *LoadBalancer) getEphemeralLoadBalancer(
+	ctx context.Context,
+	service *v1.Service,
+) (*v1.LoadBalancerStatus, loadBalancerState, error) {
+	instanceID := service.Annotations[AnnotationBackingInstance]
+	ip := service.Annotations[AnnotationBackingEphemeralIP]
+	if instanceID == "" || ip == "" {
+		return nil, loadBalancerAbsent, nil
+	}
+
+	ephemeralIP, err := l.instanceEphemeralIP(ctx, instanceID)
+	if err != nil && !errors.Is(err, oxide.ErrObjectNotFound) {
+		return nil, loadBalancerAbsent, err
+	}
+	if ephemeralIP == nil || ephemeralIP.Ip != ip {
+		return toLoadBalancerStatus(service, nil, nil), loadBalancerDegraded, nil
+	}
+
+	node, err := l.nodeForInstance(ctx, instanceID)
+	if err != nil {
+		return nil, loadBalancerAbsent, err
+	}
+	if node == nil {
+		return ephemeralLoadBalancerStatus(service, ip, nil), loadBalancerDegraded, nil
+	}
+
+	return ephemeralLoadBalancerStatus(service, ip, node), loadBalancerReady, nil
+}
+
+// instanceEphemeralIP returns the ephemeral IP attached to the instance, or nil
+// when it has none.
+func (l *LoadBalancer) instanceEphemeralIP(
+	ctx context.Context,
+	instanceID string,
+) (*oxide.ExternalIpEphemeral, error) {
+	externalIPs, err := l.client.InstanceExternalIpList(
+		ctx, oxide.InstanceExternalIpListParams{Instance: oxide.NameOrId(instanceID)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed listing external ips of instance %s: %w", instanceID, err,
+		)
+	}
+
+	for _, externalIP := range externalIPs.Items {
+		if ephemeralIP, ok := externalIP.AsEphemeral(); ok {
+			return ephemeralIP, nil
+		}
+	}
+	return nil, nil
+}
+
+// ephemeralIPServices returns the other load balancer services with
+// [AddressTypeEphemeral]. Services being deleted are not counted.
+func (l *LoadBalancer) ephemeralIPServices(
+	ctx context.Context,
+	service *v1.Service,
+) ([]v1.Service, error) {
+	services, err := l.k8sClient.CoreV1().Services(metav1.NamespaceAll).List(
+		ctx, metav1.ListOptions{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed listing kubernetes services: %w", err,
+		)
+	}
+
+	others := make([]v1.Service, 0)
+	for _, other := range services.Items {
+		if other.Namespace == service.Namespace && other.Name == service.Name {
+			continue
+		}
+
+		if other.Spec.Type != v1.ServiceTypeLoadBalancer ||
+			other.DeletionTimestamp != nil ||
+			!isEphemeral(&other) {
+			continue
+		}
+
+		others = append(others, other)
+	}
+
+	return others, nil
+}
+
+// ephemeralLoadBalancerStatus builds the load balancer status of an ephemeral
+// IP, which is reported like a floating IP.
+func ephemeralLoadBalancerStatus(
+	service *v1.Service,
+	ip string,
+	node *v1.Node,
+) *v1.LoadBalancerStatus {
+	return toLoadBalancerStatus(service, &oxide.FloatingIp{Ip: ip}, node)
+}
@@ -0,0 +1,346 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/clock"
+)
+
+func TestEphemeralLoadBalancer(t *testing.T) {
+	nodeA := newLBNode("node-a", instIDOld, "10.0.0.10")
+	nodeB := newLBNode("node-b", instIDNew, "10.0.0.20")
+
+	newService := func(name string, addressType string, port int32) *v1.Service {
+		svc := newLBService(map[string]string{AnnotationAddressType: addressType})
+		svc.Name = name
+		svc.Spec.Ports = []v1.ServicePort{{Port: port, Protocol: v1.ProtocolTCP}}
+		return svc
+	}
+
+	newLB := func(client *fakeFloatingIPs, services ...*v1.Service) *LoadBalancer {
+		k8sClient := fake.NewSimpleClientset(nodeA.DeepCopy(), nodeB.DeepCopy())
+		for _, svc := range services {
+			if err := k8sClient.Tracker().Add(svc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return &LoadBalancer{
+			project:       "test",
+			k8sClient:     k8sClient,
+			client:        client,
+			defaultPools:  &defaultPoolCache{},
+			locks:         &keyMutex{},
+			clock:         clock.RealClock{},
+			attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+		}
+	}
+
+	// get returns the service as patched by the load balancer.
+	get := func(t *testing.T, lb *LoadBalancer, svc *v1.Service) *v1.Service {
+		t.Helper()
+		got, err := lb.k8sClient.CoreV1().Services(svc.Namespace).Get(
+			t.Context(), svc.Name, metav1.GetOptions{},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return got
+	}
+
+	ensure := func(t *testing.T, lb *LoadBalancer, svc *v1.Service, node *v1.Node) string {
+		t.Helper()
+		status, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", get(t, lb, svc), []*v1.Node{node},
+		)
+		if err != nil {
+			t.Fatalf("ensure %s: unexpected error: %v", svc.Name, err)
+		}
+		return status.Ingress[0].IP
+	}
+
+	deleteLB := func(t *testing.T, lb *LoadBalancer, svc *v1.Service) {
+		t.Helper()
+		if err := lb.EnsureLoadBalancerDeleted(
+			t.Context(), "cluster", get(t, lb, svc),
+		); err != nil {
+			t.Fatalf("delete %s: unexpected error: %v", svc.Name, err)
+		}
+		if err := lb.k8sClient.CoreV1().Services(svc.Namespace).Delete(
+			t.Context(), svc.Name, metav1.DeleteOptions{},
+		); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	assertWrites := func(t *testing.T, client *fakeFloatingIPs, want ...string) {
+		t.Helper()
+		if got := client.takeWrites(); !slices.Equal(got, want) {
+			t.Fatalf("writes = %q, want %q", got, want)
+		}
+	}
+
+	assertEphemeralIPs := func(t *testing.T, client *fakeFloatingIPs, want map[string]string) {
+		t.Helper()
+		if got := client.ephemeralIPs(); !maps.Equal(got, want) {
+			t.Fatalf("ephemeral ips = %v, want %v", got, want)
+		}
+	}
+
+	t.Run("Static", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newService("svc", AddressTypeStatic, 80)
+		lb := newLB(client, svc)
+
+		if ip := ensure(t, lb, svc, nodeA); ip != "203.0.113.1" {
+			t.Fatalf("status ip = %q, want %q", ip, "203.0.113.1")
+		}
+		assertWrites(t, client, "create cluster-ns-svc", "attach cluster-ns-svc "+instIDOld)
+
+		deleteLB(t, lb, svc)
+		assertWrites(t, client, "detach cluster-ns-svc "+instIDOld, "delete cluster-ns-svc")
+		if fips := client.list(); len(fips) != 0 {
+			t.Fatalf("leaked floating ips: %+v", fips)
+		}
+		assertEphemeralIPs(t, client, map[string]string{})
+	})
+
+	t.Run("EnsureUpdateDelete", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newService("svc", AddressTypeEphemeral, 80)
+		lb := newLB(client, svc)
+
+		if ip := ensure(t, lb, svc, nodeA); ip != "198.51.100.1" {
+			t.Fatalf("status ip = %q, want %q", ip, "198.51.100.1")
+		}
+		assertWrites(t, client, "attach-ephemeral 198.51.100.1 "+instIDOld)
+		if got := get(t, lb, svc).Annotations[AnnotationBackingEphemeralIP]; got !=
+			"198.51.100.1" {
+			t.Fatalf("backing ephemeral ip = %q, want %q", got, "198.51.100.1")
+		}
+
+		// Ensuring again reuses the ephemeral IP.
+		if ip := ensure(t, lb, svc, nodeA); ip != "198.51.100.1" {
+			t.Fatalf("status ip = %q, want %q", ip, "198.51.100.1")
+		}
+		assertWrites(t, client)
+
+		status, exists, err := lb.GetLoadBalancer(t.Context(), "cluster", get(t, lb, svc))
+		if err != nil || !exists {
+			t.Fatalf("get: exists = %v, err = %v, want exists", exists, err)
+		}
+		if got := status.Ingress[1].IP; got != "10.0.0.10" {
+			t.Fatalf("status node ip = %q, want %q", got, "10.0.0.10")
+		}
+
+		// The ephemeral IP of the old node is detached before the new node
+		// gets one.
+		if err := lb.UpdateLoadBalancer(
+			t.Context(), "cluster", get(t, lb, svc), []*v1.Node{nodeB},
+		); err != nil {
+			t.Fatalf("update: unexpected error: %v", err)
+		}
+		assertWrites(t, client,
+			"detach-ephemeral 198.51.100.1 "+instIDOld,
+			"attach-ephemeral 198.51.100.2 "+instIDNew,
+		)
+		assertEphemeralIPs(t, client, map[string]string{instIDNew: "198.51.100.2"})
+
+		deleteLB(t, lb, svc)
+		assertWrites(t, client, "detach-ephemeral 198.51.100.2 "+instIDNew)
+		assertEphemeralIPs(t, client, map[string]string{})
+		if fips := client.list(); len(fips) != 0 {
+			t.Fatalf("created floating ips: %+v", fips)
+		}
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		web := newService("web", AddressTypeEphemeral, 80)
+		api := newService("api", AddressTypeEphemeral, 443)
+		lb := newLB(client, web, api)
+
+		// Services backed by the same node share its ephemeral IP.
+		webIP := ensure(t, lb, web, nodeA)
+		if apiIP := ensure(t, lb, api, nodeA); apiIP != webIP {
+			t.Fatalf("api ip = %q, want %q", apiIP, webIP)
+		}
+		assertWrites(t, client, "attach-ephemeral 198.51.100.1 "+instIDOld)
+
+		// The ephemeral IP is kept while another service uses it.
+		deleteLB(t, lb, web)
+		assertWrites(t, client)
+
+		deleteLB(t, lb, api)
+		assertWrites(t, client, "detach-ephemeral 198.51.100.1 "+instIDOld)
+	})
+
+	t.Run("SharedPortConflict", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		web := newService("web", AddressTypeEphemeral, 443)
+		api := newService("api", AddressTypeEphemeral, 443)
+		lb := newLB(client, web, api)
+
+		ensure(t, lb, web, nodeA)
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", get(t, lb, api), []*v1.Node{nodeA},
+		)
+		if err == nil || !strings.Contains(err.Error(), "port 443/TCP is already exposed") {
+			t.Fatalf("err = %v, want port conflict", err)
+		}
+	})
+
+	t.Run("ForeignEphemeralIP", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newService("svc", AddressTypeEphemeral, 80)
+		lb := newLB(client, svc)
+
+		// The instance was created with an ephemeral IP of its own.
+		if _, err := client.InstanceEphemeralIpAttach(
+			t.Context(), oxide.InstanceEphemeralIpAttachParams{Instance: instIDOld},
+		); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.takeWrites()
+
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", get(t, lb, svc), []*v1.Node{nodeA},
+		)
+		if err == nil || !strings.Contains(err.Error(), "not attached by the cloud controller") {
+			t.Fatalf("err = %v, want foreign ephemeral ip", err)
+		}
+
+		// Deleting the load balancer leaves the instance's ephemeral IP alone.
+		deleteLB(t, lb, svc)
+		assertWrites(t, client)
+		assertEphemeralIPs(t, client, map[string]string{instIDOld: "198.51.100.1"})
+	})
+
+	t.Run("RecordFails", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newService("svc", AddressTypeEphemeral, 80)
+		lb := newLB(client, svc)
+		k8sClient := lb.k8sClient.(*fake.Clientset)
+		k8sClient.PrependReactor("patch", "services", func(
+			k8stesting.Action,
+		) (bool, runtime.Object, error) {
+			return true, nil, errBoom
+		})
+
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", get(t, lb, svc), []*v1.Node{nodeA},
+		)
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want %v", err, errBoom)
+		}
+
+		// The ephemeral IP that could not be recorded is detached again.
+		assertWrites(t, client,
+			"attach-ephemeral 198.51.100.1 "+instIDOld,
+			"detach-ephemeral 198.51.100.1 "+instIDOld,
+		)
+		assertEphemeralIPs(t, client, map[string]string{})
+
+		// So that the next reconcile attaches one instead of rejecting it.
+		k8sClient.ReactionChain = k8sClient.ReactionChain[1:]
+		ensure(t, lb, svc, nodeA)
+		if got := get(t, lb, svc).Annotations[AnnotationBackingInstance]; got != instIDOld {
+			t.Fatalf("backing instance = %q, want %q", got, instIDOld)
+		}
+	})
+
+	t.Run("DetachedOutOfBand", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newService("svc", AddressTypeEphemeral, 80)
+		lb := newLB(client, svc)
+
+		ensure(t, lb, svc, nodeA)
+		if err := client.InstanceEphemeralIpDetach(
+			t.Context(), oxide.InstanceEphemeralIpDetachParams{Instance: instIDOld},
+		); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.takeWrites()
+
+		// The load balancer still exists so that it is cleaned up.
+		_, exists, err := lb.GetLoadBalancer(t.Context(), "cluster", get(t, lb, svc))
+		if err != nil || !exists {
+			t.Fatalf("get: exists = %v, err = %v, want exists", exists, err)
+		}
+
+		deleteLB(t, lb, svc)
+		assertWrites(t, client)
+	})
+}
+
+func TestCheckAddressType(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		errorMsg    string
+	}{
+		{name: "Unset"},
+		{
+			name:        "Static",
+			annotations: map[string]string{AnnotationAddressType: AddressTypeStatic},
+		},
+		{
+			name: "Ephemeral",
+			annotations: map[string]string{
+				AnnotationAddressType:    AddressTypeEphemeral,
+				AnnotationFloatingIPPool: "public",
+			},
+		},
+		{
+			name:        "Unknown",
+			annotations: map[string]string{AnnotationAddressType: "reserved"},
+			errorMsg:    `invalid oxide.computer/address-type value "reserved"`,
+		},
+		{
+			name: "EphemeralSharedIPKey",
+			annotations: map[string]string{
+				AnnotationAddressType: AddressTypeEphemeral,
+				AnnotationSharedIPKey: "web",
+			},
+			errorMsg: "annotation oxide.computer/shared-ip-key cannot be used with",
+		},
+		{
+			name: "EphemeralExplicitIP",
+			annotations: map[string]string{
+				AnnotationAddressType: AddressTypeEphemeral,
+				AnnotationFloatingIP:  "203.0.113.5",
+			},
+			errorMsg: "annotation oxide.computer/floating-ip cannot be used with",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAddressType(newLBService(tt.annotations))
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Fatalf("err = %v, want %q", err, tt.errorMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+)
+
+// Errors returned by the provider. They wrap the underlying Oxide API error,
+// when there is one, so callers can match either with [errors.Is].
+var (
+	// ErrInstanceNotFound is returned when the Oxide instance of a node does
+	// not exist.
+	ErrInstanceNotFound = errors.New("oxide instance not found")
+
+	// ErrInstanceProjectMismatch is returned when the Oxide instance found by
+	// a node's name belongs to a project other than the configured one.
+	ErrInstanceProjectMismatch = errors.New("oxide instance belongs to another project")
+
+	// ErrAmbiguousInstanceName is returned when more than one Oxide instance
+	// in the configured project has a node's name.
+	ErrAmbiguousInstanceName = errors.New("oxide instance name is ambiguous")
+
+	// ErrNoNetworkInterfaces is returned when a running Oxide instance has no
+	// network interfaces, so its node would have no internal IP.
+	ErrNoNetworkInterfaces = errors.New("oxide instance has no network interfaces")
+
+	// ErrFloatingIPNotFound is returned when the floating IP of a load
+	// balancer does not exist.
+	ErrFloatingIPNotFound = errors.New("floating ip not found")
+
+	// ErrPoolExhausted is returned when a floating IP cannot be allocated
+	// because every IP pool it may be allocated from is exhausted.
+	ErrPoolExhausted = errors.New("all ip pools are exhausted")
+
+	// ErrIPFamilyMismatch is returned when the floating IP of a service would
+	// be allocated with an IP version that is not one of the service's IP
+	// families.
+	ErrIPFamilyMismatch = errors.New("ip version does not match the service's ip families")
+
+	// ErrProviderIDInvalid is returned when parsing a malformed Oxide provider
+	// ID.
+	ErrProviderIDInvalid = errors.New("invalid provider id")
+
+	// ErrForeignProviderID is returned when parsing a provider ID that belongs
+	// to another cloud provider, such as a node managed by a different cloud
+	// controller manager during a migration.
+	ErrForeignProviderID = errors.New("provider id belongs to another cloud provider")
+
+	// ErrCircuitOpen is returned for Oxide API requests that are not sent
+	// because the API failed persistently and its circuit breaker is open.
+	ErrCircuitOpen = errors.New("oxide api circuit breaker is open")
+)
@@ -0,0 +1,510 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/clock"
+)
+
+// fakeFloatingIPs is an in-memory Oxide floating IP API. Like Oxide, it
+// rejects attaching a floating IP that is already attached, and detaching or
+// deleting one that is not detached, with an invalid request error. It records
+// the writes made through it, in order, as "<method> <name> [<instance>]".
+// Ephemeral IPs are kept by instance ID, and their writes are recorded with
+// their address as the name.
+type fakeFloatingIPs struct {
+	mu        sync.Mutex
+	fips      map[string]*oxide.FloatingIp
+	ephemeral map[string]*oxide.ExternalIpEphemeral
+	nextIP    int
+	writes    []string
+}
+
+var _ oxideLoadBalancerClient = (*fakeFloatingIPs)(nil)
+
+func newFakeFloatingIPs() *fakeFloatingIPs {
+	return &fakeFloatingIPs{
+		fips:      make(map[string]*oxide.FloatingIp),
+		ephemeral: make(map[string]*oxide.ExternalIpEphemeral),
+	}
+}
+
+// find returns the floating IP with the given name or ID. The caller must
+// hold mu.
+func (f *fakeFloatingIPs) find(nameOrID oxide.NameOrId) (*oxide.FloatingIp, error) {
+	for _, fip := range f.fips {
+		if fip.Id == string(nameOrID) || string(fip.Name) == string(nameOrID) {
+			return fip, nil
+		}
+	}
+	return nil, oxide.ErrObjectNotFound
+}
+
+// record records a write. The caller must hold mu.
+func (f *fakeFloatingIPs) record(method string, fip *oxide.FloatingIp, instanceID string) {
+	f.writes = append(f.writes, strings.TrimSpace(
+		fmt.Sprintf("%s %s %s", method, fip.Name, instanceID),
+	))
+}
+
+// list returns a copy of the floating IPs, ordered by name.
+func (f *fakeFloatingIPs) list() []oxide.FloatingIp {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fips := make([]oxide.FloatingIp, 0, len(f.fips))
+	for _, fip := range f.fips {
+		fips = append(fips, *fip)
+	}
+	slices.SortFunc(fips, func(a, b oxide.FloatingIp) int {
+		return strings.Compare(string(a.Name), string(b.Name))
+	})
+	return fips
+}
+
+// takeWrites returns the writes recorded since the previous call.
+func (f *fakeFloatingIPs) takeWrites() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	writes := f.writes
+	f.writes = nil
+	return writes
+}
+
+func (f *fakeFloatingIPs) FloatingIpView(
+	_ context.Context, params oxide.FloatingIpViewParams,
+) (*oxide.FloatingIp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fip, err := f.find(params.FloatingIp)
+	if err != nil {
+		return nil, err
+	}
+	result := *fip
+	return &result, nil
+}
+
+func (f *fakeFloatingIPs) FloatingIpCreate(
+	_ context.Context, params oxide.FloatingIpCreateParams,
+) (*oxide.FloatingIp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.find(oxide.NameOrId(params.Body.Name)); err == nil {
+		return nil, oxide.ErrObjectAlreadyExists
+	}
+
+	f.nextIP++
+	fip := &oxide.FloatingIp{
+		Id:          fmt.Sprintf("fip-%d", f.nextIP),
+		Name:        params.Body.Name,
+		Description: params.Body.Description,
+		Ip:          fmt.Sprintf("203.0.113.%d", f.nextIP),
+		IpPoolId:    "pool-1",
+	}
+	f.fips[fip.Id] = fip
+	f.record("create", fip, "")
+
+	result := *fip
+	return &result, nil
+}
+
+func (f *fakeFloatingIPs) FloatingIpUpdate(
+	_ context.Context, params oxide.FloatingIpUpdateParams,
+) (*oxide.FloatingIp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fip, err := f.find(params.FloatingIp)
+	if err != nil {
+		return nil, err
+	}
+	if name := params.Body.Name; name != "" && name != fip.Name {
+		if _, err := f.find(oxide.NameOrId(name)); err == nil {
+			return nil, oxide.ErrObjectAlreadyExists
+		}
+		fip.Name = name
+	}
+	fip.Description = params.Body.Description
+	f.record("update", fip, "")
+
+	result := *fip
+	return &result, nil
+}
+
+func (f *fakeFloatingIPs) FloatingIpDelete(
+	_ context.Context, params oxide.FloatingIpDeleteParams,
+) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fip, err := f.find(params.FloatingIp)
+	if err != nil {
+		return err
+	}
+	if fip.InstanceId != "" {
+		return fmt.Errorf("%w: floating ip is in use", oxide.ErrInvalidRequest)
+	}
+	delete(f.fips, fip.Id)
+	f.record("delete", fip, "")
+	return nil
+}
+
+func (f *fakeFloatingIPs) FloatingIpAttach(
+	_ context.Context, params oxide.FloatingIpAttachParams,
+) (*oxide.FloatingIp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fip, err := f.find(params.FloatingIp)
+	if err != nil {
+		return nil, err
+	}
+	if fip.InstanceId != "" {
+		return nil, fmt.Errorf("%w: floating ip is already attached", oxide.ErrInvalidRequest)
+	}
+	fip.InstanceId = string(params.Body.Parent)
+	f.record("attach", fip, fip.InstanceId)
+
+	result := *fip
+	return &result, nil
+}
+
+func (f *fakeFloatingIPs) FloatingIpDetach(
+	_ context.Context, params oxide.FloatingIpDetachParams,
+) (*oxide.FloatingIp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fip, err := f.find(params.FloatingIp)
+	if err != nil {
+		return nil, err
+	}
+	if fip.InstanceId == "" {
+		return nil, fmt.Errorf("%w: floating ip is not attached", oxide.ErrInvalidRequest)
+	}
+	f.record("detach", fip, fip.InstanceId)
+	fip.InstanceId = ""
+
+	result := *fip
+	return &result, nil
+}
+
+func (f *fakeFloatingIPs) IpPoolView(
+	context.Context, oxide.IpPoolViewParams,
+) (*oxide.SiloIpPool, error) {
+	return nil, errUnexpectedOxideCall
+}
+
+func (f *fakeFloatingIPs) IpPoolListAllPages(
+	context.Context, oxide.IpPoolListParams,
+) ([]oxide.SiloIpPool, error) {
+	return []oxide.SiloIpPool{{Id: "pool-1", IsDefault: new(true)}}, nil
+}
+
+func (f *fakeFloatingIPs) FloatingIpListAllPages(
+	context.Context, oxide.FloatingIpListParams,
+) ([]oxide.FloatingIp, error) {
+	return f.list(), nil
+}
+
+func (f *fakeFloatingIPs) InstanceExternalIpList(
+	_ context.Context, params oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	page := &oxide.ExternalIpResultsPage{}
+	if ephemeral, ok := f.ephemeral[string(params.Instance)]; ok {
+		result := *ephemeral
+		page.Items = append(page.Items, oxide.ExternalIp{Value: &result})
+	}
+	for _, fip := range f.fips {
+		if fip.InstanceId == string(params.Instance) {
+			page.Items = append(page.Items, oxide.ExternalIp{
+				Value: &oxide.ExternalIpFloating{Id: fip.Id, Name: fip.Name, Ip: fip.Ip},
+			})
+		}
+	}
+	return page, nil
+}
+
+func (f *fakeFloatingIPs) InstanceEphemeralIpAttach(
+	_ context.Context, params oxide.InstanceEphemeralIpAttachParams,
+) (*oxide.ExternalIp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	instanceID := string(params.Instance)
+	if _, ok := f.ephemeral[instanceID]; ok {
+		return nil, fmt.Errorf("%w: instance has an ephemeral ip", oxide.ErrInvalidRequest)
+	}
+
+	f.nextIP++
+	ephemeral := &oxide.ExternalIpEphemeral{
+		Ip:       fmt.Sprintf("198.51.100.%d", f.nextIP),
+		IpPoolId: "pool-1",
+	}
+	f.ephemeral[instanceID] = ephemeral
+	f.record("attach-ephemeral", &oxide.FloatingIp{Name: oxide.Name(ephemeral.Ip)}, instanceID)
+
+	result := *ephemeral
+	return &oxide.ExternalIp{Value: &result}, nil
+}
+
+func (f *fakeFloatingIPs) InstanceEphemeralIpDetach(
+	_ context.Context, params oxide.InstanceEphemeralIpDetachParams,
+) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	instanceID := string(params.Instance)
+	ephemeral, ok := f.ephemeral[instanceID]
+	if !ok {
+		return oxide.ErrObjectNotFound
+	}
+	delete(f.ephemeral, instanceID)
+	f.record("detach-ephemeral", &oxide.FloatingIp{Name: oxide.Name(ephemeral.Ip)}, instanceID)
+	return nil
+}
+
+// ephemeralIPs returns the addresses of the ephemeral IPs by instance ID.
+func (f *fakeFloatingIPs) ephemeralIPs() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ips := make(map[string]string, len(f.ephemeral))
+	for instanceID, ephemeral := range f.ephemeral {
+		ips[instanceID] = ephemeral.Ip
+	}
+	return ips
+}
+
+func TestLoadBalancerLifecycle(t *testing.T) {
+	nodeA := newLBNode("node-a", instIDOld, "10.0.0.10")
+	nodeB := newLBNode("node-b", instIDNew, "10.0.0.20")
+
+	newLB := func(client *fakeFloatingIPs, services ...*v1.Service) *LoadBalancer {
+		k8sClient := fake.NewSimpleClientset()
+		for _, svc := range services {
+			if err := k8sClient.Tracker().Add(svc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return &LoadBalancer{
+			project:       "test",
+			k8sClient:     k8sClient,
+			client:        client,
+			defaultPools:  &defaultPoolCache{},
+			locks:         &keyMutex{},
+			clock:         clock.RealClock{},
+			attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+		}
+	}
+
+	assertWrites := func(t *testing.T, client *fakeFloatingIPs, want ...string) {
+		t.Helper()
+		if got := client.takeWrites(); !slices.Equal(got, want) {
+			t.Fatalf("writes = %q, want %q", got, want)
+		}
+	}
+
+	assertNoFloatingIPs := func(t *testing.T, client *fakeFloatingIPs) {
+		t.Helper()
+		if fips := client.list(); len(fips) != 0 {
+			t.Fatalf("leaked floating ips: %+v", fips)
+		}
+	}
+
+	t.Run("EnsureUpdateDelete", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newLBService(nil)
+		lb := newLB(client, svc)
+
+		status, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{nodeA})
+		if err != nil {
+			t.Fatalf("ensure: unexpected error: %v", err)
+		}
+		if status.Ingress[0].IP != "203.0.113.1" {
+			t.Fatalf("status ip = %q, want %q", status.Ingress[0].IP, "203.0.113.1")
+		}
+		assertWrites(t, client,
+			"create cluster-ns-svc",
+			"attach cluster-ns-svc "+instIDOld,
+		)
+
+		// Ensuring again is a no-op.
+		if _, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{nodeA},
+		); err != nil {
+			t.Fatalf("ensure: unexpected error: %v", err)
+		}
+		assertWrites(t, client)
+
+		// The floating IP is detached before it is attached to the new node.
+		if err := lb.UpdateLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{nodeB},
+		); err != nil {
+			t.Fatalf("update: unexpected error: %v", err)
+		}
+		assertWrites(t, client,
+			"detach cluster-ns-svc "+instIDOld,
+			"attach cluster-ns-svc "+instIDNew,
+		)
+
+		// The floating IP is detached before it is deleted.
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc); err != nil {
+			t.Fatalf("delete: unexpected error: %v", err)
+		}
+		assertWrites(t, client,
+			"detach cluster-ns-svc "+instIDNew,
+			"delete cluster-ns-svc",
+		)
+		assertNoFloatingIPs(t, client)
+
+		// Deleting a load balancer that is gone is a no-op.
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc); err != nil {
+			t.Fatalf("delete: unexpected error: %v", err)
+		}
+		assertWrites(t, client)
+	})
+
+	t.Run("SharedFloatingIP", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		web := newSharedLBService("web", "shared", 80)
+		api := newSharedLBService("api", "shared", 443)
+		lb := newLB(client, web, api)
+
+		for _, svc := range []*v1.Service{web, api} {
+			if _, err := lb.EnsureLoadBalancer(
+				t.Context(), "cluster", svc, []*v1.Node{nodeA},
+			); err != nil {
+				t.Fatalf("ensure %s: unexpected error: %v", svc.Name, err)
+			}
+		}
+		assertWrites(t, client,
+			"create cluster-shared-shared",
+			"attach cluster-shared-shared "+instIDOld,
+		)
+
+		// The floating IP is kept while another service references it.
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", web); err != nil {
+			t.Fatalf("delete: unexpected error: %v", err)
+		}
+		if err := lb.k8sClient.CoreV1().Services(web.Namespace).Delete(
+			t.Context(), web.Name, metav1.DeleteOptions{},
+		); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertWrites(t, client)
+		if fips := client.list(); len(fips) != 1 {
+			t.Fatalf("floating ips = %d, want 1", len(fips))
+		}
+
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", api); err != nil {
+			t.Fatalf("delete: unexpected error: %v", err)
+		}
+		assertWrites(t, client,
+			"detach cluster-shared-shared "+instIDOld,
+			"delete cluster-shared-shared",
+		)
+		assertNoFloatingIPs(t, client)
+	})
+
+	t.Run("AttachedOutOfBand", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newLBService(nil)
+		lb := newLB(client, svc)
+
+		if _, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{nodeA},
+		); err != nil {
+			t.Fatalf("ensure: unexpected error: %v", err)
+		}
+		client.takeWrites()
+
+		// An operator moves the floating IP to another instance by hand.
+		if _, err := client.FloatingIpDetach(t.Context(), oxide.FloatingIpDetachParams{
+			FloatingIp: "cluster-ns-svc",
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := client.FloatingIpAttach(t.Context(), oxide.FloatingIpAttachParams{
+			FloatingIp: "cluster-ns-svc",
+			Body:       &oxide.FloatingIpAttach{Parent: instIDNew},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.takeWrites()
+
+		if err := lb.UpdateLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{nodeA},
+		); err != nil {
+			t.Fatalf("update: unexpected error: %v", err)
+		}
+		assertWrites(t, client,
+			"detach cluster-ns-svc "+instIDNew,
+			"attach cluster-ns-svc "+instIDOld,
+		)
+
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc); err != nil {
+			t.Fatalf("delete: unexpected error: %v", err)
+		}
+		assertNoFloatingIPs(t, client)
+	})
+
+	t.Run("DeletedOutOfBand", func(t *testing.T) {
+		client := newFakeFloatingIPs()
+		svc := newLBService(nil)
+		lb := newLB(client, svc)
+
+		if _, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{nodeA},
+		); err != nil {
+			t.Fatalf("ensure: unexpected error: %v", err)
+		}
+		if _, err := client.FloatingIpDetach(t.Context(), oxide.FloatingIpDetachParams{
+			FloatingIp: "cluster-ns-svc",
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := client.FloatingIpDelete(t.Context(), oxide.FloatingIpDeleteParams{
+			FloatingIp: "cluster-ns-svc",
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.takeWrites()
+
+		// An update does not recreate the floating IP, which is left to the
+		// next ensure.
+		err := lb.UpdateLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{nodeA})
+		if !errors.Is(err, ErrFloatingIPNotFound) {
+			t.Fatalf("update: err = %v, want floating ip not found", err)
+		}
+		assertWrites(t, client)
+
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc); err != nil {
+			t.Fatalf("delete: unexpected error: %v", err)
+		}
+		assertWrites(t, client)
+		assertNoFloatingIPs(t, client)
+	})
+}
@@ -0,0 +1,227 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// floatingIPCleanupInterval is the interval at which floating IPs of services
+// that are no longer of type LoadBalancer are cleaned up.
+const floatingIPCleanupInterval = 10 * time.Minute
+
+// floatingIPCleanupConcurrency is the number of floating IPs cleaned up at
+// once.
+const floatingIPCleanupConcurrency = 4
+
+// errFloatingIPCleanupAborted is returned when the floating IP cleanup stops
+// before deleting anything because it could not get a complete view of the
+// floating IPs, services, and nodes.
+var errFloatingIPCleanupAborted = errors.New("aborted floating ip cleanup")
+
+// cleanUpFloatingIPs deletes the floating IPs owned by services that still
+// exist but are no longer of type LoadBalancer. The service controller deletes
+// them when a service changes type, but a service flipping types quickly can
+// leave its floating IP behind. Floating IPs of services that don't exist, that
+// share a floating IP, or that are ignored with [AnnotationIgnore] are left
+// alone, as are floating IPs without a complete ownership record, floating IPs
+// of other clusters sharing the project, and floating IPs attached to the
+// instance of a node, which an in-flight reconcile of the service may still be
+// using. Floating IPs are cleaned up in order of name, a
+// few at a time.
+//
+// Nothing is deleted unless the floating IPs, services, and nodes were all
+// listed completely, since a floating IP may only look stale because a
+// listing failed or was cut short. The cleanup is aborted otherwise.
+func (l *LoadBalancer) cleanUpFloatingIPs(ctx context.Context) error {
+	fips, err := l.client.FloatingIpListAllPages(ctx, oxide.FloatingIpListParams{
+		Project: oxide.NameOrId(l.project),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: failed listing floating ips: %w", errFloatingIPCleanupAborted, err)
+	}
+
+	var owned []floatingIPOwner
+	slices.SortFunc(fips, func(a, b oxide.FloatingIp) int {
+		return strings.Compare(string(a.Name), string(b.Name))
+	})
+	for _, fip := range fips {
+		owner, ok := parseFloatingIPOwner(fip.Description)
+		// A service of the same name in another cluster sharing the project
+		// says nothing about this cluster's services.
+		if ok && owner != (floatingIPOwner{}) && owner.Cluster == l.clusterName {
+			owned = append(owned, owner)
+		}
+	}
+	if len(owned) == 0 {
+		return nil
+	}
+
+	services, err := l.k8sClient.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err == nil && services.Continue != "" {
+		err = errors.New("service list is incomplete")
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed listing services: %w", errFloatingIPCleanupAborted, err)
+	}
+	servicesByName := make(map[types.NamespacedName]*v1.Service, len(services.Items))
+	for i := range services.Items {
+		servicesByName[types.NamespacedName{
+			Namespace: services.Items[i].Namespace,
+			Name:      services.Items[i].Name,
+		}] = &services.Items[i]
+	}
+
+	var stale []floatingIPOwner
+	for _, owner := range owned {
+		service, ok := servicesByName[owner.service()]
+		if ok && isStaleLoadBalancerService(service, owner) {
+			stale = append(stale, owner)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	liveInstances, err := l.nodeInstanceIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errFloatingIPCleanupAborted, err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []error
+		slots = make(chan struct{}, floatingIPCleanupConcurrency)
+	)
+	for _, owner := range stale {
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+
+			service := servicesByName[owner.service()]
+			if err := l.cleanUpFloatingIP(ctx, owner, service, liveInstances); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// nodeInstanceIDs returns the IDs of the instances of the cluster's nodes, or
+// an error when the nodes cannot be listed completely.
+func (l *LoadBalancer) nodeInstanceIDs(ctx context.Context) (map[string]bool, error) {
+	nodes, err := l.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err == nil && nodes.Continue != "" {
+		err = errors.New("node list is incomplete")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed listing nodes: %w", err)
+	}
+
+	instanceIDs := make(map[string]bool, len(nodes.Items))
+	for _, node := range nodes.Items {
+		providerID, err := ParseProviderID(node.Spec.ProviderID)
+		if err != nil {
+			continue
+		}
+		instanceIDs[providerID.InstanceID] = true
+	}
+
+	return instanceIDs, nil
+}
+
+// cleanUpFloatingIP deletes the floating IP of the owner's listed service when
+// the service is still no longer of type LoadBalancer and the floating IP is not attached
+// to one of liveInstances.
+func (l *LoadBalancer) cleanUpFloatingIP(
+	ctx context.Context,
+	owner floatingIPOwner,
+	service *v1.Service,
+	liveInstances map[string]bool,
+) error {
+	name := l.GetLoadBalancerName(ctx, owner.Cluster, service)
+	defer l.locks.lock(name)()
+
+	// The service may have changed back to type LoadBalancer since it was
+	// listed or while waiting for the lock.
+	service, err := l.k8sClient.CoreV1().Services(owner.Namespace).Get(
+		ctx, owner.Service, metav1.GetOptions{},
+	)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf(
+			"failed getting service %s/%s: %w", owner.Namespace, owner.Service, err,
+		)
+	}
+	if !isStaleLoadBalancerService(service, owner) {
+		return nil
+	}
+
+	fip, err := l.client.FloatingIpView(ctx, oxide.FloatingIpViewParams{
+		FloatingIp: oxide.NameOrId(name),
+		Project:    oxide.NameOrId(l.project),
+	})
+	if err != nil {
+		if errors.Is(err, oxide.ErrObjectNotFound) {
+			return nil
+		}
+		return floatingIPViewError(name, err)
+	}
+	if current, _ := parseFloatingIPOwner(fip.Description); current != owner {
+		return nil
+	}
+	if fip.InstanceId != "" && liveInstances[fip.InstanceId] {
+		klog.V(2).InfoS("skipping cleanup of floating ip attached to a node",
+			"service", klog.KObj(service), "floatingIP", name, "instanceID", fip.InstanceId)
+		return nil
+	}
+
+	if l.cleanupDryRun {
+		klog.InfoS("would delete floating ip of service that is no longer a load balancer",
+			"service", klog.KObj(service), "floatingIP", name, "type", service.Spec.Type)
+		return nil
+	}
+
+	klog.InfoS("deleting floating ip of service that is no longer a load balancer",
+		"service", klog.KObj(service), "floatingIP", name, "type", service.Spec.Type)
+
+	if err := l.deleteFloatingIP(ctx, fip); err != nil {
+		return err
+	}
+
+	return l.patchBackingAnnotations(ctx, service, "", "", "")
+}
+
+// isStaleLoadBalancerService reports whether service is the owner's service
+// and no longer needs its floating IP because it is not of type LoadBalancer.
+func isStaleLoadBalancerService(service *v1.Service, owner floatingIPOwner) bool {
+	if owner.UID != "" && service.UID != owner.UID {
+		return false
+	}
+	if service.Annotations[AnnotationSharedIPKey] != "" || isIgnored(service) {
+		return false
+	}
+	return service.Spec.Type != v1.ServiceTypeLoadBalancer
+}
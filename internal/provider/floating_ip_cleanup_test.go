@@ -0,0 +1,269 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/clock"
+)
+
+func TestCleanUpFloatingIPs(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+
+	tests := []struct {
+		name string
+		// change modifies the service after its load balancer is ensured.
+		change func(*v1.Service)
+		// remove deletes the service after its load balancer is ensured.
+		remove bool
+		// cluster is the cluster the load balancer is ensured in, when not the
+		// cleanup's.
+		cluster string
+		// liveNode adds the node the floating IP is attached to to the cluster.
+		liveNode    bool
+		dryRun      bool
+		wantDeleted bool
+	}{
+		{
+			name:        "ChangedToClusterIP",
+			change:      func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP },
+			wantDeleted: true,
+		},
+		{
+			name:        "ChangedToNodePort",
+			change:      func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeNodePort },
+			wantDeleted: true,
+		},
+		{
+			name:     "AttachedToLiveNode",
+			change:   func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP },
+			liveNode: true,
+		},
+		{
+			name:   "DryRun",
+			change: func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP },
+			dryRun: true,
+		},
+		{
+			name:    "OtherCluster",
+			change:  func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP },
+			cluster: "other",
+		},
+		{
+			name:   "StillLoadBalancer",
+			change: func(*v1.Service) {},
+		},
+		{
+			name:   "Removed",
+			remove: true,
+		},
+		{
+			name: "Recreated",
+			change: func(svc *v1.Service) {
+				svc.UID = "uid-2"
+				svc.Spec.Type = v1.ServiceTypeClusterIP
+			},
+		},
+		{
+			name: "Ignored",
+			change: func(svc *v1.Service) {
+				svc.Annotations = map[string]string{AnnotationIgnore: "true"}
+				svc.Spec.Type = v1.ServiceTypeClusterIP
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newLBService(nil)
+			svc.UID = "uid-1"
+			k8sClient := fake.NewSimpleClientset(svc)
+			if tt.liveNode {
+				k8sClient = fake.NewSimpleClientset(svc, node)
+			}
+			client := newFakeFloatingIPs()
+			lb := &LoadBalancer{
+				project:       "test",
+				k8sClient:     k8sClient,
+				client:        client,
+				clusterName:   "cluster",
+				defaultPools:  &defaultPoolCache{},
+				locks:         &keyMutex{},
+				clock:         clock.RealClock{},
+				attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+				cleanupDryRun: tt.dryRun,
+			}
+
+			cluster := cmp.Or(tt.cluster, "cluster")
+			_, err := lb.EnsureLoadBalancer(t.Context(), cluster, svc, []*v1.Node{node})
+			if err != nil {
+				t.Fatalf("unexpected error ensuring load balancer: %v", err)
+			}
+
+			services := k8sClient.CoreV1().Services("ns")
+			if tt.remove {
+				if err := services.Delete(t.Context(), "svc", metav1.DeleteOptions{}); err != nil {
+					t.Fatalf("unexpected error deleting service: %v", err)
+				}
+			} else {
+				current, _ := services.Get(t.Context(), "svc", metav1.GetOptions{})
+				tt.change(current)
+				_, err := services.Update(t.Context(), current, metav1.UpdateOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error updating service: %v", err)
+				}
+			}
+
+			client.takeWrites()
+			if err := lb.cleanUpFloatingIPs(t.Context()); err != nil {
+				t.Fatalf("unexpected error cleaning up floating ips: %v", err)
+			}
+
+			if writes := client.takeWrites(); !tt.wantDeleted && len(writes) > 0 {
+				t.Fatalf("unexpected floating ip writes %v", writes)
+			}
+
+			if deleted := len(client.list()) == 0; deleted != tt.wantDeleted {
+				t.Fatalf("floating ip deleted = %t, want %t", deleted, tt.wantDeleted)
+			}
+			if tt.wantDeleted {
+				got, _ := services.Get(t.Context(), "svc", metav1.GetOptions{})
+				assertBackingAnnotations(t, got, "", "")
+			}
+		})
+	}
+
+	t.Run("Unowned", func(t *testing.T) {
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+			client: &fakeOxideLBClient{
+				FloatingIpListAllPagesFn: func(
+					context.Context, oxide.FloatingIpListParams,
+				) ([]oxide.FloatingIp, error) {
+					return []oxide.FloatingIp{
+						{Id: "fip-1", Name: "manual", Description: "created by hand"},
+					}, nil
+				},
+			},
+			locks: &keyMutex{},
+		}
+
+		if err := lb.cleanUpFloatingIPs(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Aborts", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			resource string
+			// list returns the listing of the resource, or an error.
+			list func() (runtime.Object, error)
+		}{
+			{
+				name:     "ServiceListError",
+				resource: "services",
+				list:     func() (runtime.Object, error) { return nil, errBoom },
+			},
+			{
+				name:     "ServiceListIncomplete",
+				resource: "services",
+				list: func() (runtime.Object, error) {
+					return &v1.ServiceList{ListMeta: metav1.ListMeta{Continue: "next"}}, nil
+				},
+			},
+			{
+				name:     "NodeListError",
+				resource: "nodes",
+				list:     func() (runtime.Object, error) { return nil, errBoom },
+			},
+			{
+				name:     "NodeListIncomplete",
+				resource: "nodes",
+				list: func() (runtime.Object, error) {
+					return &v1.NodeList{ListMeta: metav1.ListMeta{Continue: "next"}}, nil
+				},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				svc := newLBService(nil)
+				svc.Spec.Type = v1.ServiceTypeClusterIP
+				k8sClient := fake.NewSimpleClientset(svc)
+				k8sClient.PrependReactor("list", tt.resource, func(
+					k8stesting.Action,
+				) (bool, runtime.Object, error) {
+					list, err := tt.list()
+					return true, list, err
+				})
+
+				client := newFakeFloatingIPs()
+				_, err := client.FloatingIpCreate(t.Context(), oxide.FloatingIpCreateParams{
+					Project: "test",
+					Body: &oxide.FloatingIpCreate{
+						Name:        "cluster-ns-svc",
+						Description: ownedDescription,
+					},
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				client.takeWrites()
+
+				lb := &LoadBalancer{
+					project:     "test",
+					k8sClient:   k8sClient,
+					client:      client,
+					clusterName: "cluster",
+					locks:       &keyMutex{},
+					clock:       clock.RealClock{},
+				}
+
+				err = lb.cleanUpFloatingIPs(t.Context())
+				if !errors.Is(err, errFloatingIPCleanupAborted) {
+					t.Fatalf("err = %v, want an aborted cleanup", err)
+				}
+				if writes := client.takeWrites(); len(writes) > 0 {
+					t.Fatalf("unexpected floating ip writes %v", writes)
+				}
+				if len(client.list()) != 1 {
+					t.Fatal("floating ip was deleted")
+				}
+			})
+		}
+	})
+
+	t.Run("ListError", func(t *testing.T) {
+		lb := &LoadBalancer{
+			project: "test",
+			client: &fakeOxideLBClient{
+				FloatingIpListAllPagesFn: func(
+					context.Context, oxide.FloatingIpListParams,
+				) ([]oxide.FloatingIp, error) {
+					return nil, errBoom
+				},
+			},
+		}
+
+		err := lb.cleanUpFloatingIPs(t.Context())
+		if !errors.Is(err, errBoom) || !errors.Is(err, errFloatingIPCleanupAborted) {
+			t.Fatalf("err = %v, want an aborted cleanup with errBoom", err)
+		}
+	})
+}
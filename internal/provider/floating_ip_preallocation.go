@@ -0,0 +1,400 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/klog/v2"
+)
+
+// spareFloatingIPInterval is the interval at which the preallocated floating
+// IPs are reconciled with Oxide.
+const spareFloatingIPInterval = 5 * time.Minute
+
+// spareFloatingIPs is the in-memory set of preallocated floating IPs that are
+// not assigned to any service yet, so that [LoadBalancer.EnsureLoadBalancer]
+// can assign one instead of creating a floating IP. Spare floating IPs are
+// named and described as spares of the cluster in Oxide, which is the source
+// of truth the set is reconciled against. A nil set preallocates nothing.
+type spareFloatingIPs struct {
+	clusterName string
+
+	// count is the number of spare floating IPs to keep allocated.
+	count int
+
+	// pool is the IP pool to preallocate from. The silo's default IP pool is
+	// used when empty.
+	pool string
+
+	// poolID is the ID of the IP pool spare floating IPs were allocated
+	// from, once any was seen.
+	poolID string
+
+	// deleteReleased deletes the floating IPs of deleted services rather than
+	// returning them to the spares.
+	deleteReleased bool
+
+	mu sync.Mutex
+
+	// free are the spare floating IPs available for assignment.
+	free []oxide.FloatingIp
+
+	// taken are the IDs of the spare floating IPs being assigned to a
+	// service, which reconciling must not make available again.
+	taken map[string]bool
+
+	// listings counts the listings of the floating IPs by reconciling.
+	listings uint64
+
+	// assigned maps the IDs of the spare floating IPs that were assigned to a
+	// service to the value of listings when the assignment finished. A
+	// listing that started before then may still show them as spares.
+	assigned map[string]uint64
+}
+
+// newSpareFloatingIPs returns the set of spare floating IPs configured by
+// config, or nil when preallocation is disabled.
+func newSpareFloatingIPs(clusterName string, config *Config) *spareFloatingIPs {
+	preallocation := config.FloatingIPPreallocation
+	if preallocation == nil {
+		return nil
+	}
+
+	pool := preallocation.Pool
+	if pool == "" {
+		if pools := splitIPPools(config.FloatingIPPool); len(pools) > 0 {
+			pool = pools[0]
+		}
+	}
+
+	return &spareFloatingIPs{
+		clusterName:    clusterName,
+		count:          preallocation.Count,
+		pool:           pool,
+		deleteReleased: preallocation.DeleteReleased,
+		taken:          make(map[string]bool),
+		assigned:       make(map[string]uint64),
+	}
+}
+
+// record returns the description of the cluster's spare floating IPs.
+func (s *spareFloatingIPs) record() string {
+	return fmt.Sprintf("%s%s cluster=%s spare=true]",
+		ownershipRecordPrefix, ownershipRecordVersion, url.QueryEscape(s.clusterName))
+}
+
+// isSpare reports whether the floating IP is an unattached spare of the
+// cluster.
+func (s *spareFloatingIPs) isSpare(fip oxide.FloatingIp) bool {
+	return fip.Description == s.record() && fip.InstanceId == ""
+}
+
+// allocator returns the allocator to preallocate floating IPs with.
+func (s *spareFloatingIPs) allocator() oxide.AddressAllocator {
+	if s.pool == "" {
+		return oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}}
+	}
+	return explicitPoolAllocator(s.pool)
+}
+
+// spareName returns a new, unique name for a spare floating IP.
+func (s *spareFloatingIPs) spareName() string {
+	suffix := "-spare-" + uuid.NewString()[:8]
+	prefix := s.clusterName[:min(len(s.clusterName), 63-len(suffix))]
+	return strings.TrimRight(prefix, "-") + suffix
+}
+
+// matches reports whether a floating IP allocated with the resolved allocator
+// may be a spare floating IP from the pool with ID poolID.
+func (s *spareFloatingIPs) matches(resolved oxide.AddressAllocator, poolID string) bool {
+	pool := allocatorPool(resolved)
+	return pool != "" && (pool == poolID || pool == s.pool)
+}
+
+// take removes and returns a free spare floating IP that may be assigned to a
+// floating IP allocated with the resolved allocator.
+func (s *spareFloatingIPs) take(resolved oxide.AddressAllocator) (oxide.FloatingIp, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.free, func(fip oxide.FloatingIp) bool {
+		return s.matches(resolved, fip.IpPoolId)
+	})
+	if i == -1 {
+		return oxide.FloatingIp{}, false
+	}
+
+	fip := s.free[i]
+	s.free = slices.Delete(s.free, i, i+1)
+	s.taken[fip.Id] = true
+	return fip, true
+}
+
+// done marks the assignment of the spare floating IP with the given ID as
+// finished.
+func (s *spareFloatingIPs) done(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.taken, id)
+	s.assigned[id] = s.listings
+}
+
+// startListing returns the number of the listing of the floating IPs that is
+// about to start.
+func (s *spareFloatingIPs) startListing() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listings++
+	return s.listings
+}
+
+// stale reports whether the spare floating IP with the given ID may have been
+// assigned to a service since the listing started. The caller must hold mu.
+func (s *spareFloatingIPs) stale(id string, listing uint64) bool {
+	assigned, ok := s.assigned[id]
+	return s.taken[id] || ok && assigned >= listing
+}
+
+// put makes the spare floating IP available for assignment unless enough
+// spare floating IPs are available, returning whether it was added.
+func (s *spareFloatingIPs) put(fip oxide.FloatingIp) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.poolID = fip.IpPoolId
+	delete(s.assigned, fip.Id)
+	if slices.ContainsFunc(s.free, func(free oxide.FloatingIp) bool {
+		return free.Id == fip.Id
+	}) {
+		return true
+	}
+	if len(s.free) >= s.count {
+		return false
+	}
+	s.free = append(s.free, fip)
+	return true
+}
+
+// wants reports whether the floating IP can be returned to the spares, since
+// it was allocated from their pool and fewer spares than configured are
+// available.
+func (s *spareFloatingIPs) wants(fip *oxide.FloatingIp) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.poolID != "" && fip.IpPoolId == s.poolID && len(s.free) < s.count
+}
+
+// assignSpareFloatingIP renames a spare floating IP to name and describes it
+// with description, returning the floating IP and whether a spare was
+// assigned. Spares are only assigned to floating IPs in the nodes' project
+// that would be allocated from the pool they were allocated from. Spares that
+// were deleted outside of the cloud controller manager are skipped.
+func (l *LoadBalancer) assignSpareFloatingIP(
+	ctx context.Context,
+	project string,
+	name string,
+	allocator oxide.AddressAllocator,
+	description string,
+) (*oxide.FloatingIp, bool, error) {
+	if l.spares == nil || project != l.project {
+		return nil, false, nil
+	}
+
+	resolved, err := l.withDefaultPool(ctx, allocator)
+	if err != nil {
+		return nil, false, fmt.Errorf(
+			"failed resolving ip pool for floating ip %s: %w", name, err,
+		)
+	}
+
+	for {
+		spare, ok := l.spares.take(resolved)
+		if !ok {
+			return nil, false, nil
+		}
+
+		fip, err := l.client.FloatingIpUpdate(ctx, oxide.FloatingIpUpdateParams{
+			FloatingIp: oxide.NameOrId(spare.Id),
+			Body: &oxide.FloatingIpUpdate{
+				Name:        oxide.Name(name),
+				Description: description,
+			},
+		})
+		l.spares.done(spare.Id)
+		if errors.Is(err, oxide.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf(
+				"failed assigning spare floating ip %s as %s: %w", spare.Name, name, err,
+			)
+		}
+
+		klog.InfoS("assigned spare floating ip",
+			"floatingIP", name, "spare", spare.Name, "ip", fip.Ip)
+		return fip, true, nil
+	}
+}
+
+// releaseFloatingIP returns the floating IP of a deleted service to the
+// spares, detaching it and renaming it as a spare, when it is in the nodes'
+// project, was allocated from the spares' pool, and fewer spares than
+// configured are available. Otherwise, or when returning it fails, the
+// floating IP is deleted.
+func (l *LoadBalancer) releaseFloatingIP(
+	ctx context.Context,
+	project string,
+	fip *oxide.FloatingIp,
+) error {
+	s := l.spares
+	if s == nil || s.deleteReleased || project != l.project || !s.wants(fip) {
+		return l.deleteFloatingIP(ctx, fip)
+	}
+
+	spare, err := l.returnFloatingIP(ctx, fip)
+	if err != nil {
+		klog.ErrorS(err, "failed returning floating ip to spares, deleting it",
+			"floatingIP", fip.Name)
+		return l.deleteFloatingIP(ctx, fip)
+	}
+	if !s.put(*spare) {
+		return l.deleteFloatingIP(ctx, spare)
+	}
+
+	klog.InfoS("returned floating ip to spares", "floatingIP", fip.Name, "spare", spare.Name)
+	return nil
+}
+
+// returnFloatingIP detaches the floating IP and renames it as a spare.
+func (l *LoadBalancer) returnFloatingIP(
+	ctx context.Context,
+	fip *oxide.FloatingIp,
+) (*oxide.FloatingIp, error) {
+	if fip.InstanceId != "" {
+		_, err := l.client.FloatingIpDetach(ctx, oxide.FloatingIpDetachParams{
+			FloatingIp: oxide.NameOrId(fip.Id),
+		})
+		if err != nil && !errors.Is(err, oxide.ErrObjectNotFound) {
+			return nil, fmt.Errorf("failed detaching floating ip %s: %w", fip.Name, err)
+		}
+		if err := l.waitForDetach(ctx, fip); err != nil {
+			return nil, err
+		}
+	}
+
+	spare, err := l.client.FloatingIpUpdate(ctx, oxide.FloatingIpUpdateParams{
+		FloatingIp: oxide.NameOrId(fip.Id),
+		Body: &oxide.FloatingIpUpdate{
+			Name:        oxide.Name(l.spares.spareName()),
+			Description: l.spares.record(),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed renaming floating ip %s as a spare: %w", fip.Name, err)
+	}
+	return spare, nil
+}
+
+// reconcileSpareFloatingIPs makes the spare floating IPs in Oxide available
+// for assignment and tops them up to the configured count, deleting any
+// spares beyond it. Spares that were assigned to a service while they were
+// listed are neither made available again nor deleted.
+func (l *LoadBalancer) reconcileSpareFloatingIPs(ctx context.Context) error {
+	s := l.spares
+	if s == nil {
+		return nil
+	}
+
+	listing := s.startListing()
+	fips, err := l.client.FloatingIpListAllPages(ctx, oxide.FloatingIpListParams{
+		Project: oxide.NameOrId(l.project),
+	})
+	if err != nil {
+		return fmt.Errorf("failed listing floating ips: %w", err)
+	}
+
+	// The listing may be stale by the time it is used, so each spare is
+	// fetched again to check it is still one.
+	spares := make([]oxide.FloatingIp, 0, len(fips))
+	for _, fip := range fips {
+		if !s.isSpare(fip) {
+			continue
+		}
+		current, err := l.client.FloatingIpView(ctx, oxide.FloatingIpViewParams{
+			FloatingIp: oxide.NameOrId(fip.Id),
+		})
+		if errors.Is(err, oxide.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed getting spare floating ip %s: %w", fip.Name, err)
+		}
+		if s.isSpare(*current) {
+			spares = append(spares, *current)
+		}
+	}
+
+	s.mu.Lock()
+	free := make([]oxide.FloatingIp, 0, s.count)
+	var excess []oxide.FloatingIp
+	for _, fip := range spares {
+		if s.stale(fip.Id, listing) {
+			continue
+		}
+		s.poolID = fip.IpPoolId
+		if len(free) < s.count {
+			free = append(free, fip)
+		} else {
+			excess = append(excess, fip)
+		}
+	}
+	s.free = free
+	missing := s.count - len(free)
+	// Spares assigned before the listing started were listed as assigned, so
+	// later listings need not skip them.
+	maps.DeleteFunc(s.assigned, func(_ string, assigned uint64) bool {
+		return assigned < listing
+	})
+	s.mu.Unlock()
+
+	var errs []error
+	for _, fip := range excess {
+		klog.InfoS("deleting excess spare floating ip", "floatingIP", fip.Name)
+		if err := l.deleteFloatingIP(ctx, &fip); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for range missing {
+		fip, err := l.createFloatingIP(
+			ctx, l.project, s.spareName(), s.allocator(), nil, s.record(),
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed preallocating floating ip: %w", err))
+			break
+		}
+
+		klog.InfoS("preallocated spare floating ip", "floatingIP", fip.Name, "ip", fip.Ip)
+		if !s.put(*fip) {
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,296 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/clock"
+)
+
+func TestSpareFloatingIPs(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+
+	// methods returns the methods of the writes, in order.
+	methods := func(writes []string) []string {
+		var methods []string
+		for _, write := range writes {
+			method, _, _ := strings.Cut(write, " ")
+			methods = append(methods, method)
+		}
+		return methods
+	}
+
+	// spares returns the names of the spare floating IPs.
+	spares := func(client *fakeFloatingIPs, s *spareFloatingIPs) []string {
+		var names []string
+		for _, fip := range client.list() {
+			if s.isSpare(fip) {
+				names = append(names, string(fip.Name))
+			}
+		}
+		return names
+	}
+
+	tests := []struct {
+		name           string
+		count          int
+		deleteReleased bool
+		// services are the names of the services whose load balancers are
+		// ensured, in order.
+		services []string
+		// delete deletes the load balancer of the first service afterwards.
+		delete        bool
+		wantMethods   []string
+		wantSpares    int
+		wantFloatings []string
+	}{
+		{
+			name:          "AssignsSpare",
+			count:         2,
+			services:      []string{"svc"},
+			wantMethods:   []string{"update", "attach"},
+			wantSpares:    1,
+			wantFloatings: []string{"cluster-ns-svc"},
+		},
+		{
+			name:          "ExhaustedCreatesNew",
+			count:         1,
+			services:      []string{"svc", "other"},
+			wantMethods:   []string{"update", "attach", "create", "attach"},
+			wantFloatings: []string{"cluster-ns-other", "cluster-ns-svc"},
+		},
+		{
+			name:          "ReturnsToSpares",
+			count:         1,
+			services:      []string{"svc"},
+			delete:        true,
+			wantMethods:   []string{"update", "attach", "detach", "update"},
+			wantSpares:    1,
+			wantFloatings: []string{},
+		},
+		{
+			name:           "DeleteReleased",
+			count:          1,
+			deleteReleased: true,
+			services:       []string{"svc"},
+			delete:         true,
+			wantMethods:    []string{"update", "attach", "detach", "delete"},
+			wantFloatings:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeFloatingIPs()
+			k8sClient := fake.NewSimpleClientset()
+			services := make([]*v1.Service, 0, len(tt.services))
+			for _, name := range tt.services {
+				svc := newLBService(nil)
+				svc.Name = name
+				if err := k8sClient.Tracker().Add(svc); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				services = append(services, svc)
+			}
+
+			s := newSpareFloatingIPs("cluster", &Config{
+				FloatingIPPreallocation: &PreallocationConfig{
+					Count:          tt.count,
+					DeleteReleased: tt.deleteReleased,
+				},
+			})
+			lb := &LoadBalancer{
+				project:       "test",
+				k8sClient:     k8sClient,
+				client:        client,
+				defaultPools:  &defaultPoolCache{},
+				locks:         &keyMutex{},
+				clock:         clock.RealClock{},
+				attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+				spares:        s,
+			}
+
+			if err := lb.reconcileSpareFloatingIPs(t.Context()); err != nil {
+				t.Fatalf("unexpected error reconciling spares: %v", err)
+			}
+			if got := spares(client, s); len(got) != tt.count {
+				t.Fatalf("spares = %v, want %d", got, tt.count)
+			}
+			client.takeWrites()
+
+			for _, svc := range services {
+				_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+				if err != nil {
+					t.Fatalf("unexpected error ensuring load balancer: %v", err)
+				}
+			}
+			if tt.delete {
+				err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", services[0])
+				if err != nil {
+					t.Fatalf("unexpected error deleting load balancer: %v", err)
+				}
+			}
+
+			writes := client.takeWrites()
+			if got := methods(writes); !slices.Equal(got, tt.wantMethods) {
+				t.Fatalf("writes = %v, want methods %v", writes, tt.wantMethods)
+			}
+
+			if got := spares(client, s); len(got) != tt.wantSpares {
+				t.Fatalf("spares = %v, want %d", got, tt.wantSpares)
+			}
+			floatings := []string{}
+			for _, fip := range client.list() {
+				if !s.isSpare(fip) {
+					floatings = append(floatings, string(fip.Name))
+				}
+			}
+			if !slices.Equal(floatings, tt.wantFloatings) {
+				t.Fatalf("floating ips = %v, want %v", floatings, tt.wantFloatings)
+			}
+
+			// The spares available for assignment match Oxide once reconciled.
+			if err := lb.reconcileSpareFloatingIPs(t.Context()); err != nil {
+				t.Fatalf("unexpected error reconciling spares: %v", err)
+			}
+			if got := spares(client, s); len(got) != tt.count {
+				t.Fatalf("spares after reconciling = %v, want %d", got, tt.count)
+			}
+		})
+	}
+}
+
+// interleavedFloatingIPs runs a hook once after a call to one of its methods
+// returns, before the result is used, to interleave another operation with it.
+type interleavedFloatingIPs struct {
+	*fakeFloatingIPs
+
+	// after maps method names to the hook to run after the method.
+	after map[string]func()
+}
+
+// interleave runs the hook of the method, if any, and removes it.
+func (c *interleavedFloatingIPs) interleave(method string) {
+	if hook, ok := c.after[method]; ok {
+		delete(c.after, method)
+		hook()
+	}
+}
+
+func (c *interleavedFloatingIPs) FloatingIpListAllPages(
+	ctx context.Context, params oxide.FloatingIpListParams,
+) ([]oxide.FloatingIp, error) {
+	fips, err := c.fakeFloatingIPs.FloatingIpListAllPages(ctx, params)
+	c.interleave("FloatingIpListAllPages")
+	return fips, err
+}
+
+func (c *interleavedFloatingIPs) FloatingIpView(
+	ctx context.Context, params oxide.FloatingIpViewParams,
+) (*oxide.FloatingIp, error) {
+	fip, err := c.fakeFloatingIPs.FloatingIpView(ctx, params)
+	c.interleave("FloatingIpView")
+	return fip, err
+}
+
+func TestReconcileSpareFloatingIPsDuringAssignment(t *testing.T) {
+	// The spare is assigned after it was listed, or after it was fetched
+	// again, either way after reconciling saw it as a spare.
+	for _, method := range []string{"FloatingIpListAllPages", "FloatingIpView"} {
+		t.Run(method, func(t *testing.T) {
+			fips := newFakeFloatingIPs()
+			client := &interleavedFloatingIPs{fakeFloatingIPs: fips}
+			s := newSpareFloatingIPs("cluster", &Config{
+				FloatingIPPreallocation: &PreallocationConfig{Count: 1},
+			})
+			lb := &LoadBalancer{
+				project:      "test",
+				k8sClient:    fake.NewSimpleClientset(),
+				client:       client,
+				defaultPools: &defaultPoolCache{},
+				locks:        &keyMutex{},
+				clock:        clock.RealClock{},
+				spares:       s,
+			}
+
+			if err := lb.reconcileSpareFloatingIPs(t.Context()); err != nil {
+				t.Fatalf("unexpected error reconciling spares: %v", err)
+			}
+			spare := fips.list()[0]
+
+			client.after = map[string]func(){method: func() {
+				_, ok, err := lb.assignSpareFloatingIP(
+					t.Context(), "test", "cluster-ns-svc",
+					oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}},
+					"assigned",
+				)
+				if err != nil || !ok {
+					t.Errorf("assigned = %t, err = %v, want the spare assigned", ok, err)
+				}
+			}}
+			if err := lb.reconcileSpareFloatingIPs(t.Context()); err != nil {
+				t.Fatalf("unexpected error reconciling spares: %v", err)
+			}
+
+			// The assigned floating IP is kept, and a new spare replaces it.
+			listed := fips.list()
+			if !slices.ContainsFunc(listed, func(fip oxide.FloatingIp) bool {
+				return fip.Id == spare.Id && fip.Name == "cluster-ns-svc"
+			}) {
+				t.Fatalf("floating ips = %+v, want %s assigned", listed, spare.Id)
+			}
+			if len(s.free) != 1 || s.free[0].Id == spare.Id {
+				t.Fatalf("free spares = %+v, want a new spare", s.free)
+			}
+
+			// A later listing sees the assignment, so it need not be skipped.
+			if err := lb.reconcileSpareFloatingIPs(t.Context()); err != nil {
+				t.Fatalf("unexpected error reconciling spares: %v", err)
+			}
+			if len(s.assigned) != 0 {
+				t.Fatalf("assigned = %v, want none", s.assigned)
+			}
+		})
+	}
+}
+
+func TestSpareFloatingIPRecord(t *testing.T) {
+	client := newFakeFloatingIPs()
+	s := newSpareFloatingIPs("cluster", &Config{
+		FloatingIPPreallocation: &PreallocationConfig{Count: 1},
+	})
+	lb := &LoadBalancer{
+		project:      "test",
+		k8sClient:    fake.NewSimpleClientset(),
+		client:       client,
+		defaultPools: &defaultPoolCache{},
+		locks:        &keyMutex{},
+		clock:        clock.RealClock{},
+		spares:       s,
+	}
+
+	if err := lb.reconcileSpareFloatingIPs(t.Context()); err != nil {
+		t.Fatalf("unexpected error reconciling spares: %v", err)
+	}
+	spare := client.list()[0]
+	if _, ok := parseFloatingIPOwner(spare.Description); ok {
+		t.Fatalf("spare description %q parsed as an ownership record", spare.Description)
+	}
+	if !s.isSpare(spare) {
+		t.Fatalf("floating ip %+v is not a spare", spare)
+	}
+	if s.isSpare(oxide.FloatingIp{Description: spare.Description, InstanceId: instID1}) {
+		t.Fatal("attached floating ip is a spare")
+	}
+}
@@ -0,0 +1,153 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// minInstanceIndexRefreshInterval rate limits refreshing the instance index
+// on a miss, so nodes without an instance don't list the project on every
+// lookup.
+const minInstanceIndexRefreshInterval = 10 * time.Second
+
+// instanceIndex maps instance names to instances per region, built from a
+// single instance list of the project. It serves name-based lookups of nodes
+// without a provider ID, which otherwise cost one instance view per node.
+// Since the indexed instances are as old as the last refresh, only lookups
+// that tolerate a slightly stale instance use the index, which excludes those
+// deciding on the instance's run state. See [InstancesV2.getCurrentInstance].
+// A nil index indexes nothing.
+type instanceIndex struct {
+	clock clock.PassiveClock
+
+	// interval is how long the index is used before it is refreshed.
+	interval time.Duration
+
+	// refreshing serializes refreshes, so that lookups that need a refresh at
+	// the same time share a single instance list rather than each listing the
+	// instances, and lookups served by the index never wait for a refresh.
+	refreshing sync.Mutex
+
+	mu      sync.Mutex
+	regions map[string]*regionInstanceIndex
+}
+
+// regionInstanceIndex is the index of a single region.
+type regionInstanceIndex struct {
+	// instances maps names to the instances with the name. Names are unique
+	// within a project, but the list is not trusted to hold only the
+	// project's instances.
+	instances map[string][]oxide.Instance
+	refreshed time.Time
+}
+
+// newInstanceIndex returns an instance index that is refreshed every
+// interval.
+func newInstanceIndex(clock clock.PassiveClock, interval time.Duration) *instanceIndex {
+	return &instanceIndex{
+		clock:    clock,
+		interval: interval,
+		regions:  make(map[string]*regionInstanceIndex),
+	}
+}
+
+// lookup returns the instances with the given name in the project of the
+// region, refreshing the index with client when it is older than the
+// interval. On a miss, the index is refreshed once more, at most every
+// [minInstanceIndexRefreshInterval], to pick up new instances. Failing to
+// refresh is logged and treated as a miss so that callers fall back to
+// viewing the instance directly.
+func (x *instanceIndex) lookup(
+	ctx context.Context,
+	client oxideInstanceClient,
+	project string,
+	region string,
+	name string,
+) ([]oxide.Instance, bool) {
+	if x == nil {
+		return nil, false
+	}
+
+	x.mu.Lock()
+	index := x.regions[region]
+	x.mu.Unlock()
+
+	if index == nil || x.clock.Since(index.refreshed) >= x.interval {
+		index = x.refresh(ctx, client, project, region, index)
+		if index == nil {
+			return nil, false
+		}
+	}
+
+	instances, ok := index.instances[name]
+	if !ok && x.clock.Since(index.refreshed) >= minInstanceIndexRefreshInterval {
+		index = x.refresh(ctx, client, project, region, index)
+		if index == nil {
+			return nil, false
+		}
+		instances, ok = index.instances[name]
+	}
+	if !ok {
+		return nil, false
+	}
+
+	return slices.Clone(instances), true
+}
+
+// refresh rebuilds the index of the region from every page of the project's
+// instances, so that instances past the first page are indexed too. Instances
+// are indexed by their exact name. The index is not refreshed again when
+// another lookup already replaced the stale index while this one waited. It
+// returns nil when the instances cannot be listed.
+func (x *instanceIndex) refresh(
+	ctx context.Context,
+	client oxideInstanceClient,
+	project string,
+	region string,
+	stale *regionInstanceIndex,
+) *regionInstanceIndex {
+	x.refreshing.Lock()
+	defer x.refreshing.Unlock()
+
+	x.mu.Lock()
+	current := x.regions[region]
+	x.mu.Unlock()
+	if current != stale {
+		return current
+	}
+
+	instances, err := client.InstanceListAllPages(ctx, oxide.InstanceListParams{
+		Project: oxide.NameOrId(project),
+	})
+	if err != nil {
+		klog.V(2).InfoS("failed refreshing instance index",
+			"region", region, "err", fmt.Errorf("failed listing instances: %w", err))
+		return nil
+	}
+
+	index := &regionInstanceIndex{
+		instances: make(map[string][]oxide.Instance, len(instances)),
+		refreshed: x.clock.Now(),
+	}
+	for _, instance := range instances {
+		name := string(instance.Name)
+		index.instances[name] = append(index.instances[name], instance)
+	}
+
+	x.mu.Lock()
+	x.regions[region] = index
+	x.mu.Unlock()
+
+	return index
+}
@@ -0,0 +1,291 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// newPagedInstanceClient returns an Oxide client for a server that lists the
+// pages of instances in order and answers any other request with an object
+// not found error. It records the paths and queries of the requests sent.
+func newPagedInstanceClient(
+	t *testing.T, pages [][]oxide.Instance, requests *[]string,
+) *oxide.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != "/v1/instances" {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error_code": "ObjectNotFound",
+				"message":    "not found",
+				"request_id": "request",
+			})
+			return
+		}
+
+		// Page tokens are the index of the page they continue with.
+		page, _ := strconv.Atoi(r.URL.Query().Get("page_token"))
+		results := oxide.InstanceResultsPage{Items: pages[page]}
+		if page+1 < len(pages) {
+			results.NextPage = strconv.Itoa(page + 1)
+		}
+		_ = json.NewEncoder(w).Encode(results)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := oxide.NewClient(oxide.WithHost(server.URL), oxide.WithToken("token"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return client
+}
+
+func TestInstanceIndex(t *testing.T) {
+	instanceNode2 := oxide.Instance{
+		Name:     oxide.Name("node-2"),
+		Id:       "22222222-2222-2222-2222-222222222222",
+		RunState: oxide.InstanceStateRunning,
+	}
+	node2 := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+
+	clock := clocktesting.NewFakePassiveClock(time.Now())
+
+	newInstancesV2 := func(mock *mockOxideClient) (*InstancesV2, *countingOxideClient) {
+		client := &countingOxideClient{oxideInstanceClient: mock}
+		return &InstancesV2{
+			client:    client,
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+			index:     newInstanceIndex(clock, time.Minute),
+		}, client
+	}
+
+	assertFound := func(t *testing.T, instancesV2 *InstancesV2, node *v1.Node) {
+		t.Helper()
+		instance, _, err := instancesV2.getInstance(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(instance.Name) != node.Name {
+			t.Fatalf("instance = %s, want %s", instance.Name, node.Name)
+		}
+	}
+
+	t.Run("HitsAvoidViews", func(t *testing.T) {
+		instancesV2, client := newInstancesV2(&mockOxideClient{
+			InstanceListAllPagesOutput: []oxide.Instance{instanceRunning, instanceNode2},
+			InstanceViewError:          errBoom,
+		})
+
+		for range 2 {
+			assertFound(t, instancesV2, &nodeWithoutProviderID)
+			assertFound(t, instancesV2, &node2)
+		}
+
+		if client.calls != 1 {
+			t.Fatalf("oxide api calls = %d, want a single instance list", client.calls)
+		}
+	})
+
+	t.Run("MissFallsBackToView", func(t *testing.T) {
+		instancesV2, client := newInstancesV2(&mockOxideClient{
+			InstanceListAllPagesOutput: []oxide.Instance{instanceNode2},
+			InstanceViewOutput:         &instanceRunning,
+		})
+
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+		if client.calls != 2 {
+			t.Fatalf("oxide api calls = %d, want instance list and view", client.calls)
+		}
+
+		// A miss right after a refresh does not list the instances again.
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+		if client.calls != 3 {
+			t.Fatalf("oxide api calls = %d, want another instance view only", client.calls)
+		}
+
+		// A miss refreshes the index again once the minimum interval passed.
+		clock.SetTime(clock.Now().Add(minInstanceIndexRefreshInterval))
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+		if client.calls != 5 {
+			t.Fatalf("oxide api calls = %d, want another instance list and view", client.calls)
+		}
+	})
+
+	t.Run("ListErrorFallsBackToView", func(t *testing.T) {
+		instancesV2, _ := newInstancesV2(&mockOxideClient{
+			InstanceListAllPagesError: errBoom,
+			InstanceViewOutput:        &instanceRunning,
+		})
+
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+	})
+
+	t.Run("ProviderIDBypassesIndex", func(t *testing.T) {
+		instancesV2, _ := newInstancesV2(&mockOxideClient{
+			InstanceListAllPagesError: errUnexpectedOxideCall,
+			InstanceViewOutput:        &instanceRunning,
+		})
+
+		assertFound(t, instancesV2, &nodeWithProviderID)
+		if len(instancesV2.index.regions) != 0 {
+			t.Fatal("index was refreshed for a node with a provider id")
+		}
+	})
+
+	t.Run("RunStateBypassesIndex", func(t *testing.T) {
+		instancesV2, client := newInstancesV2(&mockOxideClient{
+			InstanceListAllPagesOutput: []oxide.Instance{instanceRunning},
+			InstanceViewOutput:         &instanceStopped,
+		})
+		instancesV2.shutdownStates = []oxide.InstanceState{oxide.InstanceStateStopped}
+
+		// The index is refreshed while the instance is running.
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+
+		shutdown, err := instancesV2.InstanceShutdown(t.Context(), &nodeWithoutProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !shutdown {
+			t.Fatal("node is not shut down, want the stopped instance viewed directly")
+		}
+		if client.calls != 2 {
+			t.Fatalf("oxide api calls = %d, want an instance list and view", client.calls)
+		}
+	})
+
+	t.Run("ConcurrentMissesShareRefresh", func(t *testing.T) {
+		listing := make(chan struct{})
+		client := &blockingListClient{
+			mockOxideClient: &mockOxideClient{
+				InstanceListAllPagesOutput: []oxide.Instance{instanceRunning},
+			},
+			listing: listing,
+		}
+		index := newInstanceIndex(clock, time.Minute)
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				if _, ok := index.lookup(
+					t.Context(), client, "test", "", string(instanceRunning.Name),
+				); !ok {
+					t.Error("instance not found in index")
+				}
+			})
+		}
+		close(listing)
+		wg.Wait()
+
+		if lists := client.lists.Load(); lists != 1 {
+			t.Fatalf("instance lists = %d, want 1", lists)
+		}
+	})
+
+	t.Run("SecondPage", func(t *testing.T) {
+		var requests []string
+		client := newPagedInstanceClient(t, [][]oxide.Instance{
+			{instanceRunning},
+			{instanceNode2},
+		}, &requests)
+		instancesV2 := &InstancesV2{
+			client:    client,
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+			index:     newInstanceIndex(clock, time.Minute),
+		}
+
+		// Viewing instances fails, so node-2 is only found by listing the
+		// second page.
+		assertFound(t, instancesV2, &node2)
+		if len(requests) != 2 {
+			t.Fatalf("requests = %v, want two instance list pages", requests)
+		}
+	})
+
+	t.Run("ViewByNameScopedToProject", func(t *testing.T) {
+		var requests []string
+		client := newPagedInstanceClient(t, [][]oxide.Instance{{}}, &requests)
+		instancesV2 := &InstancesV2{
+			client:    client,
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+		}
+
+		exists, err := instancesV2.InstanceExists(t.Context(), &node2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Fatal("node-2 exists, want not found")
+		}
+		if want := []string{"/v1/instances/node-2?project=test"}; !slices.Equal(requests, want) {
+			t.Fatalf("requests = %v, want %v", requests, want)
+		}
+	})
+
+	t.Run("RefreshedAfterInterval", func(t *testing.T) {
+		mock := &mockOxideClient{
+			InstanceListAllPagesOutput: []oxide.Instance{instanceRunning},
+			InstanceViewError:          errBoom,
+		}
+		instancesV2, client := newInstancesV2(mock)
+
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+
+		mock.InstanceListAllPagesOutput = []oxide.Instance{instanceRunning, instanceNode2}
+		// Just before the interval, the stale index still serves lookups and
+		// misses are only refreshed at the minimum interval.
+		clock.SetTime(clock.Now().Add(time.Minute - time.Nanosecond))
+		assertFound(t, instancesV2, &nodeWithoutProviderID)
+		if client.calls != 1 {
+			t.Fatalf("oxide api calls = %d, want a single instance list", client.calls)
+		}
+
+		clock.SetTime(clock.Now().Add(time.Nanosecond))
+		assertFound(t, instancesV2, &node2)
+		if client.calls != 2 {
+			t.Fatalf("oxide api calls = %d, want two instance lists", client.calls)
+		}
+	})
+}
+
+// blockingListClient blocks listing instances until listing is closed, and
+// counts the lists.
+type blockingListClient struct {
+	*mockOxideClient
+	listing <-chan struct{}
+	lists   atomic.Int32
+}
+
+func (c *blockingListClient) InstanceListAllPages(
+	ctx context.Context,
+	params oxide.InstanceListParams,
+) ([]oxide.Instance, error) {
+	c.lists.Add(1)
+	<-c.listing
+	return c.mockOxideClient.InstanceListAllPages(ctx, params)
+}
@@ -0,0 +1,123 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/utils/clock"
+)
+
+// instanceMetadataCache caches the metadata built for nodes that already have
+// a provider ID, so that the cloud node controller's periodic resyncs don't
+// list the network interfaces, external IPs, disks, and sleds of every
+// instance again. Cached metadata is used while the instance is unchanged and
+// for at most the TTL, which bounds how long changes that don't modify the
+// instance, such as attaching an external IP, go unnoticed. A nil cache caches
+// nothing.
+type instanceMetadataCache struct {
+	clock clock.PassiveClock
+
+	// ttl is how long metadata is cached.
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]instanceMetadataCacheEntry
+}
+
+// instanceMetadataCacheEntry is the metadata built for a node from the
+// instance as it was viewed at the time.
+type instanceMetadataCacheEntry struct {
+	instance oxide.Instance
+	metadata *cloudprovider.InstanceMetadata
+	expires  time.Time
+}
+
+// newInstanceMetadataCache returns an instance metadata cache that caches
+// metadata for ttl.
+func newInstanceMetadataCache(clock clock.PassiveClock, ttl time.Duration) *instanceMetadataCache {
+	return &instanceMetadataCache{
+		clock:   clock,
+		ttl:     ttl,
+		entries: make(map[string]instanceMetadataCacheEntry),
+	}
+}
+
+// get returns a copy of the metadata cached for the node, as long as it was
+// built from the same instance and has not expired.
+func (c *instanceMetadataCache) get(
+	nodeName string,
+	instance *oxide.Instance,
+) (*cloudprovider.InstanceMetadata, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[nodeName]
+	if !ok || !c.clock.Now().Before(entry.expires) || !sameInstance(&entry.instance, instance) {
+		return nil, false
+	}
+	return copyInstanceMetadata(entry.metadata), true
+}
+
+// set caches the metadata built for the node from the instance, and drops
+// expired entries, such as those of deleted nodes.
+func (c *instanceMetadataCache) set(
+	nodeName string,
+	instance *oxide.Instance,
+	metadata *cloudprovider.InstanceMetadata,
+) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	maps.DeleteFunc(c.entries, func(_ string, entry instanceMetadataCacheEntry) bool {
+		return !now.Before(entry.expires)
+	})
+	c.entries[nodeName] = instanceMetadataCacheEntry{
+		instance: *instance,
+		metadata: copyInstanceMetadata(metadata),
+		expires:  now.Add(c.ttl),
+	}
+}
+
+// sameInstance reports whether a and b are views of the same instance without
+// any change in between. Oxide has no resource versions, so the modification
+// and run state update times stand in for one.
+func sameInstance(a, b *oxide.Instance) bool {
+	sameTime := func(a, b *time.Time) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Equal(*b)
+	}
+	return a.Id == b.Id &&
+		a.RunState == b.RunState &&
+		sameTime(a.TimeModified, b.TimeModified) &&
+		sameTime(a.TimeRunStateUpdated, b.TimeRunStateUpdated)
+}
+
+// copyInstanceMetadata returns a copy of the metadata that shares nothing
+// with it, since callers may modify the metadata they are returned.
+func copyInstanceMetadata(
+	metadata *cloudprovider.InstanceMetadata,
+) *cloudprovider.InstanceMetadata {
+	copied := *metadata
+	copied.NodeAddresses = slices.Clone(metadata.NodeAddresses)
+	copied.AdditionalLabels = maps.Clone(metadata.AdditionalLabels)
+	return &copied
+}
@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestInstanceMetadataCache(t *testing.T) {
+	const ttl = 5 * time.Minute
+	modified := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		node v1.Node
+		// change changes the instance or advances the clock between the two
+		// lookups.
+		change      func(instance *oxide.Instance, clock *clocktesting.FakeClock)
+		wantLookups int
+	}{
+		{
+			name:        "Unchanged",
+			node:        nodeWithProviderID,
+			change:      func(*oxide.Instance, *clocktesting.FakeClock) {},
+			wantLookups: 2,
+		},
+		{
+			name: "InstanceModified",
+			node: nodeWithProviderID,
+			change: func(instance *oxide.Instance, _ *clocktesting.FakeClock) {
+				instance.TimeModified = new(modified.Add(time.Minute))
+			},
+			wantLookups: 4,
+		},
+		{
+			name: "RunStateChanged",
+			node: nodeWithProviderID,
+			change: func(instance *oxide.Instance, _ *clocktesting.FakeClock) {
+				instance.RunState = oxide.InstanceStateRebooting
+			},
+			wantLookups: 4,
+		},
+		{
+			name: "Expired",
+			node: nodeWithProviderID,
+			change: func(_ *oxide.Instance, clock *clocktesting.FakeClock) {
+				clock.Step(ttl)
+			},
+			wantLookups: 4,
+		},
+		{
+			name:        "WithoutProviderID",
+			node:        nodeWithoutProviderID,
+			change:      func(*oxide.Instance, *clocktesting.FakeClock) {},
+			wantLookups: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := instanceRunning
+			instance.TimeModified = new(modified)
+			mock := &mockOxideClient{
+				InstanceViewOutput:                 &instance,
+				InstanceListAllPagesOutput:         []oxide.Instance{instance},
+				InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			}
+			client := &countingOxideClient{oxideInstanceClient: mock}
+			clock := clocktesting.NewFakeClock(time.Now())
+			i := &InstancesV2{
+				client:        client,
+				project:       "test",
+				k8sClient:     fake.NewSimpleClientset(tt.node.DeepCopy()),
+				metadataCache: newInstanceMetadataCache(clock, ttl),
+			}
+
+			first, err := i.InstanceMetadata(t.Context(), &tt.node)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Callers may modify the metadata they are returned.
+			first.NodeAddresses[0].Address = "192.0.2.1"
+
+			tt.change(&instance, clock)
+			mock.InstanceListAllPagesOutput = []oxide.Instance{instance}
+
+			second, err := i.InstanceMetadata(t.Context(), &tt.node)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Viewing the instance is the only call that is always made.
+			if lookups := client.calls - len(client.views); lookups != tt.wantLookups {
+				t.Fatalf("lookups = %d, want %d", lookups, tt.wantLookups)
+			}
+			want := []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "172.30.0.5"}}
+			if !reflect.DeepEqual(second.NodeAddresses, want) {
+				t.Fatalf("addresses = %v, want %v", second.NodeAddresses, want)
+			}
+		})
+	}
+}
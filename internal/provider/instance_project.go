@@ -0,0 +1,139 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+// projectIDCache caches the ID of the configured project per region, since
+// each region's endpoint is a separate rack with projects of its own. A nil
+// cache caches nothing.
+type projectIDCache struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+// get returns the cached project ID of the region.
+func (c *projectIDCache) get(region string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := c.ids[region]
+	return id, ok
+}
+
+// set caches the project ID of the region.
+func (c *projectIDCache) set(region string, id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ids == nil {
+		c.ids = make(map[string]string)
+	}
+	c.ids[region] = id
+}
+
+// instanceInProject returns the only one of the instances named name that
+// belongs to the configured project. Names are only unique within a project,
+// so an instance found by name in another project, such as one returned by a
+// lookup that searched more than the configured project, is an
+// [ErrInstanceProjectMismatch] error rather than the node's instance, and
+// several instances named name in the project are an [ErrAmbiguousInstanceName]
+// error. Instances without a project ID cannot be verified and are assumed to
+// belong to the project.
+func (i *InstancesV2) instanceInProject(
+	ctx context.Context,
+	client oxideInstanceClient,
+	region string,
+	name string,
+	instances []oxide.Instance,
+) (*oxide.Instance, error) {
+	var (
+		projectID string
+		matches   []oxide.Instance
+		others    []string
+	)
+	for _, instance := range instances {
+		if instance.ProjectId == "" {
+			matches = append(matches, instance)
+			continue
+		}
+
+		if projectID == "" {
+			var err error
+			projectID, err = i.projectID(ctx, client, region)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if instance.ProjectId == projectID {
+			matches = append(matches, instance)
+		} else if !slices.Contains(others, instance.ProjectId) {
+			others = append(others, instance.ProjectId)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf(
+			"%w: instance %s was found in project %s, not in project %s",
+			ErrInstanceProjectMismatch, name, strings.Join(others, ", "), i.project,
+		)
+	case 1:
+		return &matches[0], nil
+	default:
+		ids := make([]string, 0, len(matches))
+		for _, instance := range matches {
+			ids = append(ids, instance.Id)
+		}
+		return nil, fmt.Errorf(
+			"%w: instances %s in project %s are all named %s",
+			ErrAmbiguousInstanceName, strings.Join(ids, ", "), i.project, name,
+		)
+	}
+}
+
+// projectID returns the ID of the configured project in the region, viewing
+// the project when it is configured by name.
+func (i *InstancesV2) projectID(
+	ctx context.Context,
+	client oxideInstanceClient,
+	region string,
+) (string, error) {
+	if err := uuid.Validate(i.project); err == nil {
+		return i.project, nil
+	}
+
+	if id, ok := i.projectIDs.get(region); ok {
+		return id, nil
+	}
+
+	project, err := client.ProjectView(ctx, oxide.ProjectViewParams{
+		Project: oxide.NameOrId(i.project),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed viewing oxide project %s: %w", i.project, err)
+	}
+	i.projectIDs.set(region, project.Id)
+
+	return project.Id, nil
+}
@@ -0,0 +1,164 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestInstanceInProject(t *testing.T) {
+	const (
+		projectA = "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"
+		projectB = "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+	)
+
+	// node-1 is the name of an instance in each project.
+	instanceA := instanceRunning
+	instanceA.ProjectId = projectA
+	instanceB := instanceRunning
+	instanceB.Id = "99999999-9999-9999-9999-999999999999"
+	instanceB.ProjectId = projectB
+
+	tests := []struct {
+		name    string
+		project string
+		node    v1.Node
+		client  *mockOxideClient
+		// indexed looks the instance up in the instance index.
+		indexed bool
+		wantID  string
+		wantErr error
+	}{
+		{
+			name:    "IndexDisambiguatesByProject",
+			project: "test",
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceListAllPagesOutput: []oxide.Instance{instanceB, instanceA},
+				InstanceViewError:          errUnexpectedOxideCall,
+				ProjectViewOutput:          &oxide.Project{Id: projectA, Name: "test"},
+			},
+			indexed: true,
+			wantID:  instanceA.Id,
+		},
+		{
+			name:    "IndexOnlyInOtherProject",
+			project: "test",
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceListAllPagesOutput: []oxide.Instance{instanceB},
+				InstanceViewError:          errUnexpectedOxideCall,
+				ProjectViewOutput:          &oxide.Project{Id: projectA, Name: "test"},
+			},
+			indexed: true,
+			wantErr: ErrInstanceProjectMismatch,
+		},
+		{
+			name:    "IndexAmbiguousInProject",
+			project: projectA,
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceListAllPagesOutput: []oxide.Instance{instanceA, instanceA},
+				InstanceViewError:          errUnexpectedOxideCall,
+				ProjectViewError:           errUnexpectedOxideCall,
+			},
+			indexed: true,
+			wantErr: ErrAmbiguousInstanceName,
+		},
+		{
+			name:    "ViewInProject",
+			project: "test",
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceViewOutput: &instanceA,
+				ProjectViewOutput:  &oxide.Project{Id: projectA, Name: "test"},
+			},
+			wantID: instanceA.Id,
+		},
+		{
+			name:    "ViewInOtherProject",
+			project: "test",
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceViewOutput: &instanceB,
+				ProjectViewOutput:  &oxide.Project{Id: projectA, Name: "test"},
+			},
+			wantErr: ErrInstanceProjectMismatch,
+		},
+		{
+			name:    "ProjectConfiguredByID",
+			project: projectA,
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceViewOutput: &instanceB,
+				ProjectViewError:   errUnexpectedOxideCall,
+			},
+			wantErr: ErrInstanceProjectMismatch,
+		},
+		{
+			name:    "ProjectViewFails",
+			project: "test",
+			node:    nodeWithoutProviderID,
+			client: &mockOxideClient{
+				InstanceViewOutput: &instanceA,
+				ProjectViewError:   errBoom,
+			},
+			wantErr: errBoom,
+		},
+		{
+			// Instance IDs are unique across projects.
+			name:    "ProviderIDNotVerified",
+			project: "test",
+			node:    nodeWithProviderID,
+			client: &mockOxideClient{
+				InstanceViewOutput: &instanceB,
+				ProjectViewError:   errUnexpectedOxideCall,
+			},
+			wantID: instanceB.Id,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &InstancesV2{
+				client:     tt.client,
+				project:    tt.project,
+				k8sClient:  fake.NewSimpleClientset(),
+				projectIDs: &projectIDCache{},
+			}
+			if tt.indexed {
+				clock := clocktesting.NewFakePassiveClock(time.Now())
+				i.index = newInstanceIndex(clock, time.Minute)
+			}
+
+			instance, _, err := i.getInstance(t.Context(), &tt.node)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if instance.Id != tt.wantID {
+				t.Fatalf("instance = %s, want %s", instance.Id, tt.wantID)
+			}
+
+			// The project ID is resolved once.
+			tt.client.ProjectViewError = errUnexpectedOxideCall
+			if _, _, err := i.getInstance(t.Context(), &tt.node); err != nil {
+				t.Fatalf("unexpected error on second lookup: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// instanceSledCacheTTL is how long the sleds instances run on are cached.
+// Instances only change sleds when they migrate, which invalidates the cache,
+// so the TTL bounds how long other changes, such as sleds being added, go
+// unnoticed.
+const instanceSledCacheTTL = 5 * time.Minute
+
+// instanceSledCache caches the sled each instance runs on per region. The
+// Oxide API has no way to look up the sled of an instance, only the instances
+// of a sled, so without the cache every node sync would list the instances of
+// every sled. A nil cache caches nothing.
+type instanceSledCache struct {
+	clock clock.PassiveClock
+
+	// refresh serializes listing the sleds, so that lookups that miss at the
+	// same time share a single listing rather than each making their own.
+	refresh sync.Mutex
+
+	mu      sync.Mutex
+	regions map[string]instanceSleds
+}
+
+// instanceSleds are the sleds the instances of a region run on, as listed at
+// the time.
+type instanceSleds struct {
+	// sleds maps instance IDs to the sled they run on. It is nil when the
+	// sleds could not be listed for lack of the fleet viewer role.
+	sleds  map[string]oxide.Sled
+	listed time.Time
+}
+
+// newInstanceSledCache returns an empty instance sled cache.
+func newInstanceSledCache(clock clock.PassiveClock) *instanceSledCache {
+	return &instanceSledCache{clock: clock, regions: make(map[string]instanceSleds)}
+}
+
+// instanceSled returns the sled the instance is running on, or nil when it is
+// not running on any sled, is migrating between sleds, or the sleds cannot be
+// listed for lack of the fleet viewer role. The sleds are listed again when
+// the cache has expired, or a running instance is missing from it, such as
+// one that just started.
+func (c *instanceSledCache) instanceSled(
+	ctx context.Context,
+	client oxideInstanceClient,
+	region string,
+	instance *oxide.Instance,
+) (*oxide.Sled, error) {
+	if c == nil {
+		sleds, err := listInstanceSleds(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		return sledOf(sleds, instance.Id), nil
+	}
+
+	// The sled of a migrating instance is about to change, so it is listed
+	// again once the instance has settled.
+	if instance.RunState == oxide.InstanceStateMigrating {
+		c.mu.Lock()
+		delete(c.regions, region)
+		c.mu.Unlock()
+		return nil, nil
+	}
+
+	cached, ok := c.get(region)
+	if ok && c.fresh(cached, instance) {
+		return sledOf(cached.sleds, instance.Id), nil
+	}
+
+	c.refresh.Lock()
+	defer c.refresh.Unlock()
+
+	// Another lookup may have listed the sleds while this one waited.
+	if latest, ok := c.get(region); ok && latest.listed.After(cached.listed) {
+		return sledOf(latest.sleds, instance.Id), nil
+	}
+
+	listed := c.clock.Now()
+	sleds, err := listInstanceSleds(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.regions[region] = instanceSleds{sleds: sleds, listed: listed}
+	c.mu.Unlock()
+
+	return sledOf(sleds, instance.Id), nil
+}
+
+// get returns the sleds cached for the region.
+func (c *instanceSledCache) get(region string) (instanceSleds, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.regions[region]
+	return cached, ok
+}
+
+// fresh returns whether the cached sleds can be used for the instance: they
+// have not expired, and include the instance unless it is not running or the
+// sleds could not be listed.
+func (c *instanceSledCache) fresh(cached instanceSleds, instance *oxide.Instance) bool {
+	if c.clock.Since(cached.listed) >= instanceSledCacheTTL {
+		return false
+	}
+	if _, ok := cached.sleds[instance.Id]; ok || cached.sleds == nil {
+		return true
+	}
+	return instance.RunState != oxide.InstanceStateRunning
+}
+
+// sledOf returns the sled the instance runs on, or nil when it is not listed.
+func sledOf(sleds map[string]oxide.Sled, instanceID string) *oxide.Sled {
+	if sled, ok := sleds[instanceID]; ok {
+		return &sled
+	}
+	return nil
+}
+
+// listInstanceSleds returns the sleds instances run on by instance ID. Listing
+// sleds and their instances requires the fleet viewer role, so a forbidden
+// error is logged and returns no sleds.
+func listInstanceSleds(
+	ctx context.Context,
+	client oxideInstanceClient,
+) (map[string]oxide.Sled, error) {
+	sleds, err := client.SledListAllPages(ctx, oxide.SledListParams{})
+	if err != nil {
+		if errors.Is(err, oxide.ErrHTTP403) {
+			klog.V(2).InfoS("not permitted to list sleds, omitting sled-derived labels")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed listing sleds: %w", err)
+	}
+
+	instanceSleds := make(map[string]oxide.Sled)
+	for _, sled := range sleds {
+		instances, err := client.SledInstanceListAllPages(ctx, oxide.SledInstanceListParams{
+			SledId: sled.Id,
+		})
+		if err != nil {
+			if errors.Is(err, oxide.ErrHTTP403) {
+				klog.V(2).InfoS("not permitted to list sled instances, omitting sled labels",
+					"sled", sled.Id)
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed listing instances on sled %s: %w", sled.Id, err)
+		}
+
+		for _, instance := range instances {
+			instanceSleds[instance.Id] = sled
+		}
+	}
+
+	return instanceSleds, nil
+}
@@ -0,0 +1,174 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// sledListingClient counts the listings of sleds.
+type sledListingClient struct {
+	*mockOxideClient
+	listings int
+}
+
+func (c *sledListingClient) SledListAllPages(
+	ctx context.Context,
+	params oxide.SledListParams,
+) ([]oxide.Sled, error) {
+	c.listings++
+	return c.mockOxideClient.SledListAllPages(ctx, params)
+}
+
+func TestInstanceSledCache(t *testing.T) {
+	running := func(id string) *oxide.Instance {
+		return &oxide.Instance{Id: id, RunState: oxide.InstanceStateRunning}
+	}
+	newClient := func() *sledListingClient {
+		return &sledListingClient{mockOxideClient: &mockOxideClient{
+			SledListAllPagesOutput: []oxide.Sled{
+				{Id: "sled-1", RackId: "rack-1"},
+				{Id: "sled-2", RackId: "rack-1"},
+			},
+			SledInstanceListAllPagesOutput: map[string][]oxide.SledInstance{
+				"sled-1": {{Id: instID1}},
+				"sled-2": {{Id: instIDOld}},
+			},
+		}}
+	}
+	assertSled := func(t *testing.T, sled *oxide.Sled, err error, want string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got string
+		if sled != nil {
+			got = sled.Id
+		}
+		if got != want {
+			t.Fatalf("sled = %q, want %q", got, want)
+		}
+	}
+
+	t.Run("Cached", func(t *testing.T) {
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		cache := newInstanceSledCache(fakeClock)
+		client := newClient()
+
+		sled, err := cache.instanceSled(t.Context(), client, "", running(instID1))
+		assertSled(t, sled, err, "sled-1")
+		sled, err = cache.instanceSled(t.Context(), client, "", running(instIDOld))
+		assertSled(t, sled, err, "sled-2")
+
+		// Stopped instances are on no sled, and don't list the sleds again.
+		stopped := running(instIDNew)
+		stopped.RunState = oxide.InstanceStateStopped
+		sled, err = cache.instanceSled(t.Context(), client, "", stopped)
+		assertSled(t, sled, err, "")
+		if client.listings != 1 {
+			t.Fatalf("listings = %d, want 1", client.listings)
+		}
+
+		// Each region's sleds are listed separately.
+		sled, err = cache.instanceSled(t.Context(), client, "west", running(instID1))
+		assertSled(t, sled, err, "sled-1")
+		if client.listings != 2 {
+			t.Fatalf("listings = %d, want 2", client.listings)
+		}
+
+		fakeClock.Step(instanceSledCacheTTL)
+		client.SledInstanceListAllPagesOutput["sled-1"] = nil
+		sled, err = cache.instanceSled(t.Context(), client, "", running(instID1))
+		assertSled(t, sled, err, "")
+		if client.listings != 3 {
+			t.Fatalf("listings = %d, want 3 after the cache expired", client.listings)
+		}
+	})
+
+	t.Run("StartedInstance", func(t *testing.T) {
+		cache := newInstanceSledCache(clocktesting.NewFakeClock(time.Now()))
+		client := newClient()
+
+		sled, err := cache.instanceSled(t.Context(), client, "", running(instID1))
+		assertSled(t, sled, err, "sled-1")
+
+		client.SledInstanceListAllPagesOutput["sled-2"] = append(
+			client.SledInstanceListAllPagesOutput["sled-2"], oxide.SledInstance{Id: instIDNew},
+		)
+		sled, err = cache.instanceSled(t.Context(), client, "", running(instIDNew))
+		assertSled(t, sled, err, "sled-2")
+		if client.listings != 2 {
+			t.Fatalf("listings = %d, want 2", client.listings)
+		}
+	})
+
+	t.Run("Migration", func(t *testing.T) {
+		cache := newInstanceSledCache(clocktesting.NewFakeClock(time.Now()))
+		client := newClient()
+
+		sled, err := cache.instanceSled(t.Context(), client, "", running(instID1))
+		assertSled(t, sled, err, "sled-1")
+
+		migrating := running(instID1)
+		migrating.RunState = oxide.InstanceStateMigrating
+		sled, err = cache.instanceSled(t.Context(), client, "", migrating)
+		assertSled(t, sled, err, "")
+
+		// The instance settles on another sled.
+		client.SledInstanceListAllPagesOutput = map[string][]oxide.SledInstance{
+			"sled-2": {{Id: instID1}},
+		}
+		sled, err = cache.instanceSled(t.Context(), client, "", running(instID1))
+		assertSled(t, sled, err, "sled-2")
+	})
+
+	for _, tc := range []struct {
+		name string
+		fail func(*mockOxideClient)
+	}{
+		{
+			name: "SledsForbidden",
+			fail: func(c *mockOxideClient) { c.SledListAllPagesError = oxide.ErrHTTP403 },
+		},
+		{
+			name: "SledInstancesForbidden",
+			fail: func(c *mockOxideClient) { c.SledInstanceListAllPagesError = oxide.ErrHTTP403 },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := newInstanceSledCache(clocktesting.NewFakeClock(time.Now()))
+			client := newClient()
+			tc.fail(client.mockOxideClient)
+
+			for range 2 {
+				sled, err := cache.instanceSled(t.Context(), client, "", running(instID1))
+				assertSled(t, sled, err, "")
+			}
+			if client.listings != 1 {
+				t.Fatalf("listings = %d, want 1", client.listings)
+			}
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		cache := newInstanceSledCache(clocktesting.NewFakeClock(time.Now()))
+		client := newClient()
+		client.SledInstanceListAllPagesError = errBoom
+
+		if _, err := cache.instanceSled(t.Context(), client, "", running(instID1)); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		// Errors are not cached.
+		client.SledInstanceListAllPagesError = nil
+		sled, err := cache.instanceSled(t.Context(), client, "", running(instID1))
+		assertSled(t, sled, err, "sled-1")
+	})
+}
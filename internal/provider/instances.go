@@ -0,0 +1,166 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+var _ cloudprovider.Instances = (*Instances)(nil)
+
+// Instances implements the legacy [cloudprovider.Instances] interface on top of
+// [InstancesV2] for older components that still query it. The cloud node
+// controllers use [InstancesV2] whenever it is available. Its lookups never
+// modify nodes. It is only provided when [Config.LegacyInstances] is set.
+type Instances struct {
+	v2 *InstancesV2
+}
+
+// newInstances returns a legacy instances implementation backed by v2, which
+// is made read-only.
+func newInstances(v2 *InstancesV2) *Instances {
+	v2.readOnly = true
+	return &Instances{v2: v2}
+}
+
+// NodeAddresses returns the addresses of the node with the given name.
+func (i *Instances) NodeAddresses(
+	ctx context.Context,
+	name types.NodeName,
+) ([]v1.NodeAddress, error) {
+	node, err := i.node(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return i.nodeAddresses(ctx, node)
+}
+
+// NodeAddressesByProviderID returns the addresses of the node with the given
+// provider ID.
+func (i *Instances) NodeAddressesByProviderID(
+	ctx context.Context,
+	providerID string,
+) ([]v1.NodeAddress, error) {
+	return i.nodeAddresses(ctx, nodeForProviderID(providerID))
+}
+
+// InstanceID returns the ID of the Oxide instance of the node with the given
+// name.
+func (i *Instances) InstanceID(ctx context.Context, name types.NodeName) (string, error) {
+	node, err := i.node(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	instance, _, err := i.v2.getInstance(ctx, node)
+	if err != nil {
+		return "", legacyInstanceError(err)
+	}
+	return instance.Id, nil
+}
+
+// InstanceType returns the type of the Oxide instance of the node with the
+// given name, formatted as "<ncpus>-<memory in GiB>".
+func (i *Instances) InstanceType(ctx context.Context, name types.NodeName) (string, error) {
+	node, err := i.node(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return i.instanceType(ctx, node)
+}
+
+// InstanceTypeByProviderID returns the type of the Oxide instance with the
+// given provider ID.
+func (i *Instances) InstanceTypeByProviderID(
+	ctx context.Context,
+	providerID string,
+) (string, error) {
+	return i.instanceType(ctx, nodeForProviderID(providerID))
+}
+
+// AddSSHKeyToAllInstances is not implemented. SSH keys are managed in Oxide.
+func (i *Instances) AddSSHKeyToAllInstances(context.Context, string, []byte) error {
+	return cloudprovider.NotImplemented
+}
+
+// CurrentNodeName returns the hostname as the node name, since nodes are named
+// after their instances' hostnames.
+func (i *Instances) CurrentNodeName(_ context.Context, hostname string) (types.NodeName, error) {
+	return types.NodeName(hostname), nil
+}
+
+// InstanceExistsByProviderID reports whether the Oxide instance with the given
+// provider ID exists. See [InstancesV2.InstanceExists].
+func (i *Instances) InstanceExistsByProviderID(
+	ctx context.Context,
+	providerID string,
+) (bool, error) {
+	return i.v2.InstanceExists(ctx, nodeForProviderID(providerID))
+}
+
+// InstanceShutdownByProviderID reports whether the Oxide instance with the
+// given provider ID is shut down. See [InstancesV2.InstanceShutdown].
+func (i *Instances) InstanceShutdownByProviderID(
+	ctx context.Context,
+	providerID string,
+) (bool, error) {
+	return i.v2.InstanceShutdown(ctx, nodeForProviderID(providerID))
+}
+
+// node returns the node with the given name, so that its provider ID is used
+// to look up its instance when set. A node that doesn't exist yet is looked up
+// by name.
+func (i *Instances) node(ctx context.Context, name types.NodeName) (*v1.Node, error) {
+	node, err := i.v2.k8sClient.CoreV1().Nodes().Get(ctx, string(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: string(name)}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed getting node %s: %w", name, err)
+	}
+	return node, nil
+}
+
+// nodeAddresses returns the node's addresses from its instance metadata.
+func (i *Instances) nodeAddresses(ctx context.Context, node *v1.Node) ([]v1.NodeAddress, error) {
+	metadata, err := i.v2.InstanceMetadata(ctx, node)
+	if err != nil {
+		return nil, legacyInstanceError(err)
+	}
+	return metadata.NodeAddresses, nil
+}
+
+// instanceType returns the type of the node's instance.
+func (i *Instances) instanceType(ctx context.Context, node *v1.Node) (string, error) {
+	instance, _, err := i.v2.getInstance(ctx, node)
+	if err != nil {
+		return "", legacyInstanceError(err)
+	}
+	return instanceType(instance), nil
+}
+
+// nodeForProviderID returns a node that only has the given provider ID, for
+// looking up its instance.
+func nodeForProviderID(providerID string) *v1.Node {
+	return &v1.Node{Spec: v1.NodeSpec{ProviderID: providerID}}
+}
+
+// legacyInstanceError marks errors for instances that were not found with
+// [cloudprovider.InstanceNotFound], which callers of [cloudprovider.Instances]
+// check for.
+func legacyInstanceError(err error) error {
+	if errors.Is(err, ErrInstanceNotFound) {
+		return fmt.Errorf("%w: %w", cloudprovider.InstanceNotFound, err)
+	}
+	return err
+}
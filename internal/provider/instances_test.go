@@ -0,0 +1,163 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+func TestInstances(t *testing.T) {
+	instance := instanceRunning
+	instance.Ncpus = 4
+	instance.Memory = 8 * gibibyte
+
+	newInstances := func(mock *mockOxideClient) (*Instances, *fake.Clientset) {
+		k8sClient := fake.NewSimpleClientset(nodeWithProviderID.DeepCopy())
+		return newInstances(&InstancesV2{
+			client:    mock,
+			project:   "test",
+			k8sClient: k8sClient,
+		}), k8sClient
+	}
+	found := func() *mockOxideClient {
+		return &mockOxideClient{
+			InstanceViewOutput:                 &instance,
+			InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+			InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+		}
+	}
+	providerID := nodeWithProviderID.Spec.ProviderID
+	wantAddresses := []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "172.30.0.5"}}
+
+	t.Run("NodeAddresses", func(t *testing.T) {
+		instances, k8sClient := newInstances(found())
+
+		addresses, err := instances.NodeAddresses(t.Context(), "node-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Equal(addresses, wantAddresses) {
+			t.Fatalf("addresses = %v, want %v", addresses, wantAddresses)
+		}
+
+		// Lookups never modify the node.
+		for _, action := range k8sClient.Actions() {
+			if action.GetVerb() != "get" {
+				t.Fatalf("unexpected kubernetes %s", action.GetVerb())
+			}
+		}
+	})
+
+	t.Run("NodeAddressesByProviderID", func(t *testing.T) {
+		instances, _ := newInstances(found())
+
+		addresses, err := instances.NodeAddressesByProviderID(t.Context(), providerID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Equal(addresses, wantAddresses) {
+			t.Fatalf("addresses = %v, want %v", addresses, wantAddresses)
+		}
+	})
+
+	t.Run("InstanceID", func(t *testing.T) {
+		instances, _ := newInstances(found())
+
+		id, err := instances.InstanceID(t.Context(), "node-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != instance.Id {
+			t.Fatalf("instance id = %q, want %q", id, instance.Id)
+		}
+	})
+
+	t.Run("InstanceType", func(t *testing.T) {
+		instances, _ := newInstances(found())
+
+		instanceType, err := instances.InstanceType(t.Context(), "node-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if instanceType != "4-8" {
+			t.Fatalf("instance type = %q, want %q", instanceType, "4-8")
+		}
+
+		instanceType, err = instances.InstanceTypeByProviderID(t.Context(), providerID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if instanceType != "4-8" {
+			t.Fatalf("instance type by provider id = %q, want %q", instanceType, "4-8")
+		}
+	})
+
+	t.Run("InstanceExistsByProviderID", func(t *testing.T) {
+		instances, _ := newInstances(found())
+
+		exists, err := instances.InstanceExistsByProviderID(t.Context(), providerID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists {
+			t.Fatal("expected instance to exist")
+		}
+	})
+
+	t.Run("InstanceShutdownByProviderID", func(t *testing.T) {
+		instances, _ := newInstances(&mockOxideClient{InstanceViewOutput: &instanceStopped})
+
+		shutdown, err := instances.InstanceShutdownByProviderID(t.Context(), providerID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !shutdown {
+			t.Fatal("expected stopped instance to be shut down")
+		}
+	})
+
+	t.Run("CurrentNodeName", func(t *testing.T) {
+		instances, _ := newInstances(found())
+
+		name, err := instances.CurrentNodeName(t.Context(), "node-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "node-1" {
+			t.Fatalf("node name = %q, want node-1", name)
+		}
+	})
+
+	t.Run("AddSSHKeyToAllInstances", func(t *testing.T) {
+		instances, _ := newInstances(found())
+
+		err := instances.AddSSHKeyToAllInstances(t.Context(), "user", []byte("key"))
+		if !errors.Is(err, cloudprovider.NotImplemented) {
+			t.Fatalf("err = %v, want not implemented", err)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		instances, _ := newInstances(&mockOxideClient{InstanceViewError: oxide.ErrObjectNotFound})
+
+		if _, err := instances.InstanceID(t.Context(), "node-2"); !errors.Is(
+			err, cloudprovider.InstanceNotFound,
+		) {
+			t.Fatalf("err = %v, want instance not found", err)
+		}
+		if _, err := instances.NodeAddressesByProviderID(t.Context(), providerID); !errors.Is(
+			err, cloudprovider.InstanceNotFound,
+		) {
+			t.Fatalf("err = %v, want instance not found", err)
+		}
+	})
+}
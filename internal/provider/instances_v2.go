@@ -5,21 +5,98 @@
 package provider
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/oxidecomputer/oxide.go/oxide"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
 )
 
+const (
+	// AnnotationInstanceID is set on nodes to the ID of the node's Oxide
+	// instance, even when the instance was looked up by name.
+	AnnotationInstanceID = "oxide.computer/instance-id"
+
+	// AnnotationInstanceCreated is set on nodes to the creation time of the
+	// node's Oxide instance in RFC 3339 format.
+	AnnotationInstanceCreated = "oxide.computer/instance-created"
+
+	// AnnotationProjectID is set on nodes to the ID of the Oxide project
+	// containing the node's instance.
+	AnnotationProjectID = "oxide.computer/project-id"
+
+	// AnnotationBootDisk is set on nodes to the name of the boot disk of the
+	// node's Oxide instance, when enabled with diskAnnotations.
+	AnnotationBootDisk = "oxide.computer/boot-disk"
+
+	// AnnotationDiskCount is set on nodes to the number of disks attached to
+	// the node's Oxide instance, including the boot disk, when enabled with
+	// diskAnnotations.
+	AnnotationDiskCount = "oxide.computer/disk-count"
+
+	// AnnotationDisks is set on nodes to the sorted, comma-separated names of
+	// the disks attached to the node's Oxide instance, when enabled with
+	// diskAnnotations. At most [maxAnnotatedDisks] names are listed.
+	AnnotationDisks = "oxide.computer/disks"
+
+	// AnnotationEgressIPs is set on nodes to the comma-separated ephemeral and
+	// SNAT IPs the node's Oxide instance sends outbound traffic from, as
+	// kind=ip pairs with the port range of SNAT IPs, when enabled with
+	// egressIPsAnnotation. It is meant for troubleshooting egress only.
+	AnnotationEgressIPs = "oxide.computer/egress-ips"
+
+	// AnnotationEndpoint is set on nodes to the Oxide API endpoint their
+	// instance was looked up at, when enabled with endpointAnnotation, to tell
+	// which endpoint manages a node in clusters spanning several of them.
+	AnnotationEndpoint = "oxide.computer/endpoint"
+
+	// AnnotationSledID, AnnotationSledSerial, and AnnotationSledPart are set
+	// on nodes to the ID, baseboard serial number, and baseboard part number
+	// of the sled running the node's Oxide instance, when enabled with
+	// sledAnnotations, to tie nodes to physical inventory.
+	AnnotationSledID     = "oxide.computer/sled-id"
+	AnnotationSledSerial = "oxide.computer/sled-serial"
+	AnnotationSledPart   = "oxide.computer/sled-part"
+)
+
+// EventReasonSetProviderID is the reason of the event recorded on a node when
+// its provider ID is first set from its Oxide instance.
+const EventReasonSetProviderID = "SetProviderID"
+
+// maxAnnotatedDisks bounds the number of disk names in [AnnotationDisks]. It
+// matches the maximum number of disks Oxide attaches to an instance.
+const maxAnnotatedDisks = 12
+
 var _ cloudprovider.InstancesV2 = (*InstancesV2)(nil)
 
 // gibibyte is the number of bytes in a gibibyte.
 const gibibyte = 1024 * 1024 * 1024
 
+// instanceStatesNotReadyForMetadata are the instance states in which the
+// instance's network interfaces and external IPs may not be populated yet.
+// [InstancesV2.InstanceMetadata] returns an error instead of committing an
+// address-less node for an instance in one of these states so that the cloud
+// node controller retries once the instance is running.
+var instanceStatesNotReadyForMetadata = []oxide.InstanceState{
+	oxide.InstanceStateCreating,
+	oxide.InstanceStateStarting,
+}
+
 type oxideInstanceClient interface {
 	InstanceNetworkInterfaceList(
 		context.Context,
@@ -30,13 +107,125 @@ type oxideInstanceClient interface {
 		oxide.InstanceExternalIpListParams,
 	) (*oxide.ExternalIpResultsPage, error)
 	InstanceView(context.Context, oxide.InstanceViewParams) (*oxide.Instance, error)
+	InstanceListAllPages(context.Context, oxide.InstanceListParams) ([]oxide.Instance, error)
+	ProjectView(context.Context, oxide.ProjectViewParams) (*oxide.Project, error)
+	DiskView(context.Context, oxide.DiskViewParams) (*oxide.Disk, error)
+	InstanceDiskListAllPages(context.Context, oxide.InstanceDiskListParams) ([]oxide.Disk, error)
+	CurrentUserView(context.Context) (*oxide.CurrentUser, error)
+	SledListAllPages(context.Context, oxide.SledListParams) ([]oxide.Sled, error)
+	SledInstanceListAllPages(
+		context.Context,
+		oxide.SledInstanceListParams,
+	) ([]oxide.SledInstance, error)
 }
 
 // InstancesV2 implements [cloudprovider.InstancesV2] to provide Oxide specific
 // instance functionality.
 type InstancesV2 struct {
-	client  oxideInstanceClient
-	project string
+	client    oxideInstanceClient
+	project   string
+	k8sClient kubernetes.Interface
+
+	// regionClients maps region names to the Oxide client for the region's
+	// endpoint. When empty, client is used for all nodes.
+	regionClients map[string]oxideInstanceClient
+
+	// nodeLabels names the Oxide-derived labels to add to nodes. See
+	// [DefaultNodeLabels].
+	nodeLabels []string
+
+	// labelKeys maps node label names to the keys to label nodes with,
+	// overriding [nodeLabelKeys].
+	labelKeys map[string]string
+
+	// nodeAddressTypes names the types of addresses to report for nodes. All
+	// addresses are reported when empty.
+	nodeAddressTypes []v1.NodeAddressType
+
+	// invalidHostnames is how hostnames that are not valid DNS names are
+	// reported, one of [InvalidHostnamesSkip], the default when empty, or
+	// [InvalidHostnamesSanitize].
+	invalidHostnames string
+
+	// primaryNICOnly reports only the addresses of the instance's primary
+	// network interface as node internal IPs.
+	primaryNICOnly bool
+
+	// internalIPFilter selects the network interface addresses reported as
+	// node internal IPs. All addresses are reported when nil.
+	internalIPFilter *internalIPFilter
+
+	// egressIPsAnnotation records the instance's egress IPs in the
+	// [AnnotationEgressIPs] node annotation.
+	egressIPsAnnotation bool
+
+	// diskAnnotations records the instance's disks in the
+	// [AnnotationBootDisk], [AnnotationDiskCount], and [AnnotationDisks] node
+	// annotations.
+	diskAnnotations bool
+
+	// sledAnnotations records the sled running the instance in the
+	// [AnnotationSledID], [AnnotationSledSerial], and [AnnotationSledPart]
+	// node annotations.
+	sledAnnotations bool
+
+	// endpoints maps region names, and the empty region of client, to their
+	// Oxide API endpoint, which is recorded in the [AnnotationEndpoint] node
+	// annotation. The annotation is not set when nil.
+	endpoints map[string]string
+
+	// externalIPKinds names the kinds of external IPs reported as node
+	// external IPs. [DefaultNodeExternalIPKinds] are used when nil.
+	externalIPKinds []oxide.ExternalIpKind
+
+	// bestEffortMetadata builds instance metadata without the addresses,
+	// labels, and annotations that could not be looked up, rather than
+	// failing. See [InstanceMetadataBestEffort].
+	bestEffortMetadata bool
+
+	// shutdownStates names the instance run states in which nodes are
+	// reported as shut down. [DefaultShutdownInstanceStates] are used when nil.
+	shutdownStates []oxide.InstanceState
+
+	// recheck confirms not-found instances before their nodes are reported as
+	// not existing. Not-found instances are not re-checked when nil.
+	recheck *notFoundRecheck
+
+	// index, when set, serves lookups of nodes without a provider ID by name.
+	index *instanceIndex
+
+	// nameTransform derives the instance names of nodes without a provider ID
+	// from their node names. Node names are used as they are when nil.
+	nameTransform *nodeNameTransform
+
+	// projectIDs caches the ID of the project per region, which instances
+	// found by name are verified to belong to.
+	projectIDs *projectIDCache
+
+	// sleds caches the sled each instance runs on. The sleds are listed on
+	// every lookup when nil.
+	sleds *instanceSledCache
+
+	// metadataCache, when set, serves the metadata of nodes with a provider ID
+	// whose instance is unchanged. See [instanceMetadataCache].
+	metadataCache *instanceMetadataCache
+
+	// nodeManagement, when set, tracks which nodes were resolved to their
+	// instance when synced.
+	nodeManagement *nodeManagement
+
+	// followRecreated replaces the provider ID of nodes whose instance was
+	// deleted with that of an instance recreated with the node's name, rather
+	// than reporting the node as not existing.
+	followRecreated bool
+
+	// recorder records events on nodes. No events are recorded when nil.
+	recorder record.EventRecorder
+
+	// readOnly builds instance metadata without patching the node's
+	// annotations and labels or recording events, for lookups that don't
+	// initialize or sync the node, such as those of [Instances].
+	readOnly bool
 }
 
 // InstanceExists checks whether the provided Kubernetes node exists as an instance
@@ -47,13 +236,50 @@ func (i *InstancesV2) InstanceExists(ctx context.Context, node *v1.Node) (bool,
 	defer cancel()
 
 	// Get the instance, either from the provider ID or by looking up by name.
-	_, err := i.getInstance(ctx, node)
+	instance, _, err := i.getCurrentInstance(ctx, node)
+	i.nodeManagement.record(node.Name, err)
 	if err != nil {
-		if errors.Is(err, oxide.ErrObjectNotFound) {
-			return false, nil
+		if errors.Is(err, ErrInstanceNotFound) {
+			gone, err := i.recheck.confirm(ctx, func(ctx context.Context) error {
+				_, _, err := i.getCurrentInstance(ctx, node)
+				return err
+			})
+			if err != nil {
+				return false, err
+			}
+			if gone && i.followRecreated && node.Spec.ProviderID != "" && !i.readOnly {
+				return i.followRecreatedInstance(ctx, node)
+			}
+			return !gone, nil
+		}
+		// Report nodes owned by another cloud provider as existing so they
+		// are never deleted based on a lookup in the wrong cloud.
+		if errors.Is(err, ErrForeignProviderID) {
+			klog.V(4).InfoS("skipping node owned by another cloud provider",
+				"node", klog.KObj(node), "providerID", node.Spec.ProviderID)
+			return true, nil
 		}
 		return false, err
 	}
+
+	// A destroyed instance is gone for good, unlike a failed one, which keeps
+	// its node, shut down when failed is a shutdown state, so it can be
+	// recovered by hand.
+	if instance.RunState == oxide.InstanceStateDestroyed {
+		klog.V(2).InfoS("reporting destroyed instance as not existing",
+			"node", klog.KObj(node), "instance", instance.Id)
+		return false, nil
+	}
+
+	// Stopped instances only keep their node when they are reported as shut
+	// down instead.
+	if instance.RunState == oxide.InstanceStateStopped &&
+		!slices.Contains(i.shutdownInstanceStates(), oxide.InstanceStateStopped) {
+		klog.V(2).InfoS("reporting stopped instance as not existing",
+			"node", klog.KObj(node), "instance", instance.Id)
+		return false, nil
+	}
+
 	return true, nil
 }
 
@@ -68,86 +294,195 @@ func (i *InstancesV2) InstanceMetadata(
 	defer cancel()
 
 	// Get the instance, either from the provider ID or by looking up by name.
-	instance, err := i.getInstance(ctx, node)
+	instance, region, err := i.getInstance(ctx, node)
+	i.nodeManagement.record(node.Name, err)
 	if err != nil {
 		return nil, err
 	}
+	client := i.clientForRegion(region)
 
-	nics, err := i.client.InstanceNetworkInterfaceList(
+	// Nodes being initialized, and the nameless nodes of read-only lookups,
+	// always get fresh metadata.
+	cacheable := node.Spec.ProviderID != "" && !i.readOnly
+	if cacheable {
+		if metadata, ok := i.metadataCache.get(node.Name, instance); ok {
+			klog.V(4).InfoS("using cached instance metadata",
+				"node", klog.KObj(node), "instanceID", instance.Id)
+			return metadata, nil
+		}
+	}
+
+	nics, err := client.InstanceNetworkInterfaceList(
 		ctx,
 		oxide.InstanceNetworkInterfaceListParams{
 			Instance: oxide.NameOrId(instance.Id),
 		},
 	)
+	// keptAddresses are the node's current addresses that are reported again
+	// because listing their source failed in best-effort mode.
+	keptAddresses := make([]v1.NodeAddress, 0)
 	if err != nil {
-		return nil, fmt.Errorf("failed listing instance network interfaces: %w", err)
+		err = fmt.Errorf("failed listing instance network interfaces: %w", err)
+
+		// A node without internal IPs would be unreachable, so the node must
+		// already have some to keep, like below.
+		internalIPs := nodeAddressesOfType(node.Status.Addresses, v1.NodeInternalIP)
+		if len(internalIPs) == 0 {
+			return nil, err
+		}
+		if err := i.degradeMetadata(instance, err); err != nil {
+			return nil, err
+		}
+		nics = &oxide.InstanceNetworkInterfaceResultsPage{}
+		keptAddresses = append(keptAddresses, internalIPs...)
+	}
+
+	// A running instance has all of its network interfaces, which are created
+	// along with the instance. Without one, the node would have no internal IP
+	// and be unreachable, so the node is not initialized rather than committed
+	// with only its hostname. The error is retried in case the interfaces are
+	// not listed yet, and names the instance for an operator to fix otherwise.
+	// Instances in other states are handled below, or, like stopped
+	// instances, keep their node's addresses until they run again.
+	if len(nics.Items) == 0 && len(keptAddresses) == 0 &&
+		instance.RunState == oxide.InstanceStateRunning {
+		return nil, fmt.Errorf("%w: instance %s is running", ErrNoNetworkInterfaces, instance.Id)
 	}
 
-	externalIPs, err := i.client.InstanceExternalIpList(ctx, oxide.InstanceExternalIpListParams{
+	externalIPs, err := client.InstanceExternalIpList(ctx, oxide.InstanceExternalIpListParams{
 		Instance: oxide.NameOrId(instance.Id),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed listing instance external ips: %w", err)
+		err = fmt.Errorf("failed listing instance external ips: %w", err)
+		if err := i.degradeMetadata(instance, err); err != nil {
+			return nil, err
+		}
+		externalIPs = &oxide.ExternalIpResultsPage{}
+		keptAddresses = append(keptAddresses,
+			nodeAddressesOfType(node.Status.Addresses, v1.NodeExternalIP)...)
 	}
 
 	nodeAddresses := make([]v1.NodeAddress, 0)
-	nodeAddresses = append(nodeAddresses, v1.NodeAddress{
-		Type:    v1.NodeHostName,
-		Address: instance.Hostname,
-	})
+	if hostname, ok := i.nodeHostname(node, instance); ok {
+		nodeAddresses = append(nodeAddresses, v1.NodeAddress{
+			Type:    v1.NodeHostName,
+			Address: hostname,
+		})
+	}
 
-	for _, nic := range nics.Items {
-		if v4, ok := nic.IpStack.AsV4(); ok {
-			nodeAddresses = append(nodeAddresses, v1.NodeAddress{
-				Type:    v1.NodeInternalIP,
-				Address: v4.Value.Ip,
-			})
+	for _, nic := range slices.SortedStableFunc(slices.Values(nics.Items), compareNICs) {
+		if i.primaryNICOnly && !isPrimaryNIC(nic) {
+			continue
 		}
 
+		var ips []string
+		if v4, ok := nic.IpStack.AsV4(); ok {
+			ips = append(ips, v4.Value.Ip)
+		}
 		if v6, ok := nic.IpStack.AsV6(); ok {
-			nodeAddresses = append(nodeAddresses, v1.NodeAddress{
-				Type:    v1.NodeInternalIP,
-				Address: v6.Value.Ip,
-			})
+			ips = append(ips, v6.Value.Ip)
 		}
-
 		if dualStack, ok := nic.IpStack.AsDualStack(); ok {
+			ips = append(ips, dualStack.Value.V4.Ip, dualStack.Value.V6.Ip)
+		}
+
+		for _, ip := range ips {
+			if !i.internalIPFilter.allows(ip) {
+				klog.V(4).InfoS("skipping filtered internal ip",
+					"node", klog.KObj(node), "instanceID", instance.Id, "ip", ip)
+				continue
+			}
 			nodeAddresses = append(nodeAddresses, v1.NodeAddress{
 				Type:    v1.NodeInternalIP,
-				Address: dualStack.Value.V4.Ip,
-			})
-			nodeAddresses = append(nodeAddresses, v1.NodeAddress{
-				Type:    v1.NodeInternalIP,
-				Address: dualStack.Value.V6.Ip,
+				Address: ip,
 			})
 		}
 	}
 
-	for _, externalIP := range externalIPs.Items {
-		if _, ok := externalIP.AsSnat(); ok {
+	externalIPItems := slices.Values(externalIPs.Items)
+	for _, externalIP := range slices.SortedStableFunc(externalIPItems, compareExternalIPs) {
+		kind := externalIP.Kind()
+		if !slices.Contains(i.nodeExternalIPKinds(), kind) {
+			if !slices.Contains(externalIPKinds, kind) {
+				klog.V(2).InfoS("skipping external ip of unknown kind",
+					"node", klog.KObj(node), "instanceID", instance.Id, "kind", kind)
+			}
 			continue
 		}
 
-		if ephemeral, ok := externalIP.AsEphemeral(); ok {
-			nodeAddresses = append(nodeAddresses, v1.NodeAddress{
-				Type:    v1.NodeExternalIP,
-				Address: ephemeral.Ip,
-			})
+		nodeAddresses = append(nodeAddresses, v1.NodeAddress{
+			Type:    v1.NodeExternalIP,
+			Address: externalIPAddress(externalIP),
+		})
+	}
+
+	nodeAddresses = append(nodeAddresses, keptAddresses...)
+	nodeAddresses = sortNodeAddresses(dedupNodeAddresses(nodeAddresses))
+
+	if slices.Contains(instanceStatesNotReadyForMetadata, instance.RunState) &&
+		!hasIPAddress(nodeAddresses) {
+		return nil, fmt.Errorf(
+			"instance %s is %s and has no ip addresses yet",
+			instance.Id, instance.RunState,
+		)
+	}
+
+	labels, err := i.additionalLabels(ctx, client, instance, nics.Items, region)
+	if err != nil {
+		return nil, err
+	}
+
+	i.holdRackDuringMigration(node, instance, labels)
+
+	// Each rack is an Oxide region of its own, so without configured regions
+	// the rack is also the node's region, which lets standard topology
+	// constraints on regions spread nodes across racks.
+	var rack string
+	for _, key := range i.rackLabelKeys() {
+		rack = cmp.Or(rack, labels[key])
+	}
+	rackIsRegion := region == "" && rack != ""
+	if rackIsRegion && slices.Contains(i.nodeLabels, NodeLabelRegion) {
+		labels[i.nodeLabelKey(NodeLabelRegion)] = rack
+	}
+
+	if !i.readOnly {
+		err = i.patchInstanceAnnotations(
+			ctx, client, node, instance, region, externalIPs.Items,
+		)
+		if err := i.degradeMetadata(instance, err); err != nil {
+			return nil, err
 		}
 
-		if floating, ok := externalIP.AsFloating(); ok {
-			nodeAddresses = append(nodeAddresses, v1.NodeAddress{
-				Type:    v1.NodeExternalIP,
-				Address: floating.Ip,
-			})
+		err = i.patchZoneLabels(ctx, node, rack, rackIsRegion)
+		if err := i.degradeMetadata(instance, err); err != nil {
+			return nil, err
 		}
 	}
 
-	return &cloudprovider.InstanceMetadata{
-		ProviderID:    NewProviderID(instance.Id),
-		InstanceType:  fmt.Sprintf("%d-%d", instance.Ncpus, instance.Memory/gibibyte),
-		NodeAddresses: nodeAddresses,
-	}, nil
+	providerID := ProviderID{InstanceID: instance.Id}.String()
+
+	// The cloud node controller sets the provider ID of a node being
+	// initialized from the returned metadata. The event distinguishes a node
+	// whose instance was found from one the controller never got to.
+	if node.Spec.ProviderID == "" && i.recorder != nil && !i.readOnly {
+		i.recorder.Eventf(node, v1.EventTypeNormal, EventReasonSetProviderID,
+			"Setting provider ID %s for instance %s in project %s",
+			providerID, instance.Id, i.project)
+	}
+
+	metadata := &cloudprovider.InstanceMetadata{
+		ProviderID:       providerID,
+		InstanceType:     instanceType(instance),
+		NodeAddresses:    filterNodeAddresses(nodeAddresses, i.nodeAddressTypes),
+		Region:           cmp.Or(region, rack),
+		Zone:             rack,
+		AdditionalLabels: labels,
+	}
+	if cacheable {
+		i.metadataCache.set(node.Name, instance, metadata)
+	}
+	return metadata, nil
 }
 
 // InstanceShutdown checks whether the provided node is shut down in Oxide.
@@ -159,37 +494,636 @@ func (i *InstancesV2) InstanceShutdown(ctx context.Context, node *v1.Node) (bool
 	defer cancel()
 
 	// Get the instance, either from the provider ID or by looking up by name.
-	instance, err := i.getInstance(ctx, node)
+	instance, _, err := i.getCurrentInstance(ctx, node)
+	i.nodeManagement.record(node.Name, err)
 	if err != nil {
-		if errors.Is(err, oxide.ErrObjectNotFound) {
+		if errors.Is(err, ErrInstanceNotFound) {
 			return true, nil
 		}
+		if errors.Is(err, ErrForeignProviderID) {
+			klog.V(4).InfoS("skipping node owned by another cloud provider",
+				"node", klog.KObj(node), "providerID", node.Spec.ProviderID)
+			return false, nil
+		}
 		return false, err
 	}
-	return instance.RunState == oxide.InstanceStateStopped, nil
+	if instance.RunState == oxide.InstanceStateDestroyed {
+		return true, nil
+	}
+	return slices.Contains(i.shutdownInstanceStates(), instance.RunState), nil
+}
+
+// degradeMetadata returns err, an error building part of the instance's
+// metadata, unless metadata is built on a best-effort basis, in which case it
+// logs err and returns nil so the metadata is built without that part.
+func (i *InstancesV2) degradeMetadata(instance *oxide.Instance, err error) error {
+	if err == nil || !i.bestEffortMetadata {
+		return err
+	}
+
+	klog.Warningf("building metadata of instance %s without a part that failed: %v",
+		instance.Id, err)
+	return nil
+}
+
+// nodeExternalIPKinds returns the kinds of external IPs reported as node
+// external IPs.
+func (i *InstancesV2) nodeExternalIPKinds() []oxide.ExternalIpKind {
+	if i.externalIPKinds == nil {
+		return DefaultNodeExternalIPKinds
+	}
+	return i.externalIPKinds
+}
+
+// shutdownInstanceStates returns the instance run states in which nodes are
+// reported as shut down.
+func (i *InstancesV2) shutdownInstanceStates() []oxide.InstanceState {
+	if i.shutdownStates == nil {
+		return DefaultShutdownInstanceStates
+	}
+	return i.shutdownStates
+}
+
+// patchInstanceAnnotations records the ID, creation time, and project of the
+// node's Oxide instance as node annotations so external tooling can join
+// Kubernetes nodes with Oxide instances, along with its disks, egress IPs, and
+// sled when enabled. It is a no-op when the annotations are already up to date.
+func (i *InstancesV2) patchInstanceAnnotations(
+	ctx context.Context,
+	client oxideInstanceClient,
+	node *v1.Node,
+	instance *oxide.Instance,
+	region string,
+	externalIPs []oxide.ExternalIp,
+) error {
+	var created string
+	if instance.TimeCreated != nil {
+		created = instance.TimeCreated.UTC().Format(time.RFC3339)
+	}
+
+	disks, err := i.diskAnnotationValues(ctx, client, instance)
+	if err != nil {
+		return err
+	}
+	annotations, err := i.sledAnnotationValues(ctx, client, node, instance, region)
+	if err != nil {
+		return err
+	}
+	maps.Copy(annotations, disks)
+	maps.Copy(annotations, map[string]string{
+		AnnotationInstanceID:      instance.Id,
+		AnnotationInstanceCreated: created,
+		AnnotationProjectID:       instance.ProjectId,
+		AnnotationEgressIPs:       i.egressIPs(externalIPs),
+		AnnotationEndpoint:        i.endpoints[region],
+	})
+
+	patch, err := annotationsMergePatch(node.Annotations, annotations)
+	if err != nil || patch == nil {
+		return err
+	}
+
+	_, err = i.k8sClient.CoreV1().Nodes().Patch(
+		ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed patching annotations for node %s: %w", node.Name, err)
+	}
+
+	return nil
+}
+
+// diskAnnotationValues returns the disk annotations of the node's instance.
+// Their values are empty, which removes the annotations, when they are
+// disabled, in which case the disks are not listed.
+func (i *InstancesV2) diskAnnotationValues(
+	ctx context.Context,
+	client oxideInstanceClient,
+	instance *oxide.Instance,
+) (map[string]string, error) {
+	annotations := map[string]string{
+		AnnotationBootDisk:  "",
+		AnnotationDiskCount: "",
+		AnnotationDisks:     "",
+	}
+	if !i.diskAnnotations {
+		return annotations, nil
+	}
+
+	disks, err := client.InstanceDiskListAllPages(ctx, oxide.InstanceDiskListParams{
+		Instance: oxide.NameOrId(instance.Id),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing instance disks: %w", err)
+	}
+
+	names := make([]string, 0, len(disks))
+	for _, disk := range disks {
+		if disk.Id == instance.BootDiskId {
+			annotations[AnnotationBootDisk] = string(disk.Name)
+		}
+		names = append(names, string(disk.Name))
+	}
+	slices.Sort(names)
+
+	annotations[AnnotationDiskCount] = strconv.Itoa(len(disks))
+	annotations[AnnotationDisks] = strings.Join(names[:min(len(names), maxAnnotatedDisks)], ",")
+	return annotations, nil
+}
+
+// sledAnnotationValues returns the sled annotations of the node's instance.
+// Their values are empty, which removes the annotations, when they are
+// disabled. The node's current values are kept while the instance is migrating
+// or its sled is unknown, such as when it is stopped or the sleds cannot be
+// listed, so that the annotations only change once the instance has settled on
+// another sled.
+func (i *InstancesV2) sledAnnotationValues(
+	ctx context.Context,
+	client oxideInstanceClient,
+	node *v1.Node,
+	instance *oxide.Instance,
+	region string,
+) (map[string]string, error) {
+	annotations := map[string]string{
+		AnnotationSledID:     "",
+		AnnotationSledSerial: "",
+		AnnotationSledPart:   "",
+	}
+	if !i.sledAnnotations {
+		return annotations, nil
+	}
+
+	for key := range annotations {
+		annotations[key] = node.Annotations[key]
+	}
+	if instance.RunState == oxide.InstanceStateMigrating {
+		return annotations, nil
+	}
+
+	sled, err := i.sleds.instanceSled(ctx, client, region, instance)
+	if err != nil || sled == nil {
+		return annotations, err
+	}
+
+	annotations[AnnotationSledID] = sled.Id
+	annotations[AnnotationSledSerial] = sled.Baseboard.Serial
+	annotations[AnnotationSledPart] = sled.Baseboard.Part
+	return annotations, nil
+}
+
+// egressIPs formats the ephemeral and SNAT IPs of an instance for the
+// [AnnotationEgressIPs] annotation. It returns the empty string, which removes
+// the annotation, when the annotation is disabled.
+func (i *InstancesV2) egressIPs(externalIPs []oxide.ExternalIp) string {
+	if !i.egressIPsAnnotation {
+		return ""
+	}
+
+	egress := make([]string, 0, len(externalIPs))
+	for _, externalIP := range slices.SortedStableFunc(
+		slices.Values(externalIPs), compareExternalIPs,
+	) {
+		switch kind := externalIP.Kind(); kind {
+		case oxide.ExternalIpKindEphemeral:
+			egress = append(egress, fmt.Sprintf("%s=%s", kind, externalIPAddress(externalIP)))
+		case oxide.ExternalIpKindSnat:
+			snat, _ := externalIP.AsSnat()
+			pair := fmt.Sprintf("%s=%s", kind, snat.Ip)
+			if snat.FirstPort != nil && snat.LastPort != nil {
+				pair += fmt.Sprintf(":%d-%d", *snat.FirstPort, *snat.LastPort)
+			}
+			egress = append(egress, pair)
+		}
+	}
+	return strings.Join(egress, ",")
+}
+
+// holdRackDuringMigration keeps the node's current rack and zone labels while
+// the instance is migrating or its sled is unknown. Sled views are not
+// consistent while an instance moves between sleds, and the labels should only
+// change once the instance has settled on its new sled.
+func (i *InstancesV2) holdRackDuringMigration(
+	node *v1.Node,
+	instance *oxide.Instance,
+	labels map[string]string,
+) {
+	for _, key := range i.rackLabelKeys() {
+		current, ok := node.Labels[key]
+		if !ok {
+			continue
+		}
+
+		if rack, ok := labels[key]; !ok || instance.RunState == oxide.InstanceStateMigrating {
+			if rack != current {
+				klog.V(2).InfoS("keeping rack label until instance settles on a sled",
+					"node", klog.KObj(node), "label", key, "rack", current,
+					"state", instance.RunState)
+			}
+			labels[key] = current
+		}
+	}
+}
+
+// patchZoneLabels updates the rack and zone labels of an initialized node
+// whose instance has moved to a sled in another rack, along with its region
+// labels when the rack is its region. The cloud node controller only applies
+// the zone and region when initializing a node, so without this the labels of
+// a migrated instance would go stale. Nodes that were never labeled with a
+// rack are left alone.
+func (i *InstancesV2) patchZoneLabels(
+	ctx context.Context,
+	node *v1.Node,
+	rack string,
+	rackIsRegion bool,
+) error {
+	var (
+		current string
+		labeled bool
+	)
+	labels := map[string]string{v1.LabelTopologyZone: rack}
+	for _, key := range i.rackLabelKeys() {
+		if value, ok := node.Labels[key]; ok && !labeled {
+			current, labeled = value, true
+		}
+		labels[key] = rack
+	}
+	if !labeled || rack == "" || rack == current {
+		return nil
+	}
+
+	if rackIsRegion {
+		labels[v1.LabelTopologyRegion] = rack
+		if slices.Contains(i.nodeLabels, NodeLabelRegion) {
+			labels[i.nodeLabelKey(NodeLabelRegion)] = rack
+		}
+	}
+
+	patch, err := labelsMergePatch(node.Labels, labels)
+	if err != nil || patch == nil {
+		return err
+	}
+
+	klog.InfoS("instance moved to another rack, updating zone labels",
+		"node", klog.KObj(node), "from", current, "to", rack)
+
+	_, err = i.k8sClient.CoreV1().Nodes().Patch(
+		ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed patching zone labels for node %s: %w", node.Name, err)
+	}
+
+	return nil
+}
+
+// nodeHostname returns the instance's hostname to report as the node's
+// hostname address, or false when no hostname address should be reported.
+// Hostnames that are not valid DNS names are skipped or sanitized according to
+// invalidHostnames.
+func (i *InstancesV2) nodeHostname(node *v1.Node, instance *oxide.Instance) (string, bool) {
+	hostname := instance.Hostname
+	if hostname == "" {
+		return "", false
+	}
+	if len(validation.IsDNS1123Subdomain(hostname)) == 0 {
+		return hostname, true
+	}
+
+	if i.invalidHostnames == InvalidHostnamesSanitize {
+		sanitized := sanitizeHostname(hostname)
+		if len(validation.IsDNS1123Subdomain(sanitized)) == 0 {
+			klog.Warningf("sanitized invalid hostname %q of instance %s for node %s to %q",
+				hostname, instance.Id, node.Name, sanitized)
+			return sanitized, true
+		}
+	}
+
+	klog.Warningf("skipping invalid hostname %q of instance %s for node %s",
+		hostname, instance.Id, node.Name)
+	return "", false
+}
+
+// sanitizeHostname rewrites hostname into a DNS name by lowercasing it,
+// replacing characters other than letters, digits, dots, and hyphens with
+// hyphens, and trimming labels to start and end with a letter or digit. The
+// result may still be invalid, such as when no letters or digits remain.
+func sanitizeHostname(hostname string) string {
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.ToLower(hostname))
+
+	labels := make([]string, 0)
+	for label := range strings.SplitSeq(mapped, ".") {
+		label = strings.Trim(label, "-")
+		if len(label) > validation.DNS1123LabelMaxLength {
+			label = strings.TrimRight(label[:validation.DNS1123LabelMaxLength], "-")
+		}
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+
+	return strings.Join(labels, ".")
+}
+
+// dedupNodeAddresses removes duplicate addresses of the same type, keeping
+// the first one, and hostname addresses that are identical to an internal IP.
+// The order of the remaining addresses is preserved.
+func dedupNodeAddresses(addresses []v1.NodeAddress) []v1.NodeAddress {
+	internalIPs := make(map[string]struct{})
+	for _, address := range addresses {
+		if address.Type == v1.NodeInternalIP {
+			internalIPs[address.Address] = struct{}{}
+		}
+	}
+
+	seen := make(map[v1.NodeAddress]struct{}, len(addresses))
+	return slices.DeleteFunc(addresses, func(address v1.NodeAddress) bool {
+		if _, ok := seen[address]; ok {
+			return true
+		}
+		seen[address] = struct{}{}
+
+		_, isInternalIP := internalIPs[address.Address]
+		return address.Type == v1.NodeHostName && isInternalIP
+	})
+}
+
+// nodeAddressTypeOrder is the order of node addresses by type, from most to
+// least preferred. Consumers such as the kubelet and the API server pick the
+// first suitable address, so internal IPs come before external IPs, and both
+// come before the hostname.
+var nodeAddressTypeOrder = []v1.NodeAddressType{
+	v1.NodeInternalIP,
+	v1.NodeExternalIP,
+	v1.NodeHostName,
+	v1.NodeInternalDNS,
+	v1.NodeExternalDNS,
+}
+
+// sortNodeAddresses sorts addresses by [nodeAddressTypeOrder]. Addresses of the
+// same type keep their order.
+func sortNodeAddresses(addresses []v1.NodeAddress) []v1.NodeAddress {
+	rank := func(address v1.NodeAddress) int {
+		if i := slices.Index(nodeAddressTypeOrder, address.Type); i >= 0 {
+			return i
+		}
+		return len(nodeAddressTypeOrder)
+	}
+	slices.SortStableFunc(addresses, func(a, b v1.NodeAddress) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+	return addresses
+}
+
+// compareNICs orders the primary network interface first, followed by the
+// others by name, so node addresses don't depend on the order the Oxide API
+// lists network interfaces in.
+func compareNICs(a, b oxide.InstanceNetworkInterface) int {
+	if isPrimaryNIC(a) != isPrimaryNIC(b) {
+		if isPrimaryNIC(a) {
+			return -1
+		}
+		return 1
+	}
+	return cmp.Compare(a.Name, b.Name)
+}
+
+// isPrimaryNIC reports whether the network interface is the instance's primary
+// network interface.
+func isPrimaryNIC(nic oxide.InstanceNetworkInterface) bool {
+	return nic.Primary != nil && *nic.Primary
+}
+
+// externalIPKinds are the known kinds of external IPs, in the order their
+// addresses are reported for nodes.
+var externalIPKinds = []oxide.ExternalIpKind{
+	oxide.ExternalIpKindEphemeral,
+	oxide.ExternalIpKindFloating,
+	oxide.ExternalIpKindSnat,
+}
+
+// compareExternalIPs orders external IPs by [externalIPKinds], with unknown
+// kinds last, and then by address, so node addresses don't depend on the order
+// the Oxide API lists external IPs in.
+func compareExternalIPs(a, b oxide.ExternalIp) int {
+	rank := func(externalIP oxide.ExternalIp) int {
+		if i := slices.Index(externalIPKinds, externalIP.Kind()); i >= 0 {
+			return i
+		}
+		return len(externalIPKinds)
+	}
+	return cmp.Or(
+		cmp.Compare(rank(a), rank(b)),
+		cmp.Compare(externalIPAddress(a), externalIPAddress(b)),
+	)
+}
+
+// externalIPAddress returns the address of an external IP, or the empty
+// string for unknown kinds.
+func externalIPAddress(externalIP oxide.ExternalIp) string {
+	if ephemeral, ok := externalIP.AsEphemeral(); ok {
+		return ephemeral.Ip
+	}
+	if floating, ok := externalIP.AsFloating(); ok {
+		return floating.Ip
+	}
+	if snat, ok := externalIP.AsSnat(); ok {
+		return snat.Ip
+	}
+	return ""
+}
+
+// instanceType returns the instance type reported for the instance, formatted
+// as <ncpus>-<memory in GiB> (e.g., 4-16). The format is used for the
+// node.kubernetes.io/instance-type label, so any implementation reporting
+// instance types must use this helper to avoid relabeling every node.
+func instanceType(instance *oxide.Instance) string {
+	return fmt.Sprintf("%d-%d", instance.Ncpus, instance.Memory/gibibyte)
+}
+
+// filterNodeAddresses returns the addresses of the given types, or all
+// addresses when no types are given.
+func filterNodeAddresses(
+	addresses []v1.NodeAddress,
+	types []v1.NodeAddressType,
+) []v1.NodeAddress {
+	if len(types) == 0 {
+		return addresses
+	}
+	return slices.DeleteFunc(addresses, func(address v1.NodeAddress) bool {
+		return !slices.Contains(types, address.Type)
+	})
+}
+
+// nodeAddressesOfType returns the addresses of the given type.
+func nodeAddressesOfType(
+	addresses []v1.NodeAddress,
+	addressType v1.NodeAddressType,
+) []v1.NodeAddress {
+	return filterNodeAddresses(slices.Clone(addresses), []v1.NodeAddressType{addressType})
+}
+
+// hasIPAddress reports whether addresses contains an internal or external IP
+// address.
+func hasIPAddress(addresses []v1.NodeAddress) bool {
+	return slices.ContainsFunc(addresses, func(address v1.NodeAddress) bool {
+		return address.Type == v1.NodeInternalIP || address.Type == v1.NodeExternalIP
+	})
 }
 
 // getInstance retrieves the instance either from the node's provider ID
-// or by looking up the instance by name.
-func (i *InstancesV2) getInstance(ctx context.Context, node *v1.Node) (*oxide.Instance, error) {
-	var params oxide.InstanceViewParams
+// or by looking up the instance by name, as derived from the node name by the
+// configured transform. It also returns the region the instance was found in,
+// which is empty when no regions are configured. Lookups by name may be served
+// by the instance index, so the instance may be slightly stale.
+func (i *InstancesV2) getInstance(
+	ctx context.Context,
+	node *v1.Node,
+) (*oxide.Instance, string, error) {
+	return i.findInstance(ctx, node, true)
+}
+
+// getCurrentInstance is [InstancesV2.getInstance] without the instance index,
+// for decisions on the instance's run state, such as whether its node exists
+// or is shut down, which a stale instance could get wrong.
+func (i *InstancesV2) getCurrentInstance(
+	ctx context.Context,
+	node *v1.Node,
+) (*oxide.Instance, string, error) {
+	return i.findInstance(ctx, node, false)
+}
+
+// findInstance implements [InstancesV2.getInstance], looking instances up by
+// name in the instance index when useIndex is set.
+func (i *InstancesV2) findInstance(
+	ctx context.Context,
+	node *v1.Node,
+	useIndex bool,
+) (*oxide.Instance, string, error) {
+	var (
+		params oxide.InstanceViewParams
+		byName bool
+		name   string
+	)
 	if node.Spec.ProviderID != "" {
-		instanceID, err := InstanceIDFromProviderID(node.Spec.ProviderID)
+		providerID, err := ParseProviderID(node.Spec.ProviderID)
 		if err != nil {
-			return nil, fmt.Errorf("failed parsing provider id %s: %w", node.Spec.ProviderID, err)
+			return nil, "", fmt.Errorf(
+				"failed parsing provider id %s: %w", node.Spec.ProviderID, err,
+			)
 		}
-		params = oxide.InstanceViewParams{Instance: oxide.NameOrId(instanceID)}
+		params = oxide.InstanceViewParams{Instance: oxide.NameOrId(providerID.InstanceID)}
 	} else {
+		name = i.nameTransform.instanceName(node.GetName())
 		params = oxide.InstanceViewParams{
 			Project:  oxide.NameOrId(i.project),
-			Instance: oxide.NameOrId(node.GetName()),
+			Instance: oxide.NameOrId(name),
 		}
+		byName = true
 	}
 
-	instance, err := i.client.InstanceView(ctx, params)
+	regions, err := i.regionsForNode(node, !byName)
 	if err != nil {
-		return nil, fmt.Errorf("failed viewing oxide instance: %w", err)
+		return nil, "", err
+	}
+
+	// Search the candidate regions in order, moving on to the next region only
+	// when the instance is not found in the current one.
+	for _, region := range regions {
+		client := i.clientForRegion(region)
+
+		if byName && useIndex {
+			instances, ok := i.index.lookup(ctx, client, i.project, region, name)
+			if ok {
+				instance, err := i.instanceInProject(
+					ctx, client, region, name, instances,
+				)
+				if err != nil {
+					return nil, "", err
+				}
+				return instance, region, nil
+			}
+		}
+
+		var instance *oxide.Instance
+		instance, err = client.InstanceView(ctx, params)
+		if err == nil && byName {
+			instance, err = i.instanceInProject(
+				ctx, client, region, name, []oxide.Instance{*instance},
+			)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		if err == nil {
+			if labeled := node.Labels[v1.LabelTopologyRegion]; labeled != "" && labeled != region {
+				klog.InfoS("found instance in a region other than the node's",
+					"node", klog.KObj(node), "region", region, "labeledRegion", labeled)
+			}
+			return instance, region, nil
+		}
+		if !errors.Is(err, oxide.ErrObjectNotFound) {
+			break
+		}
+	}
+
+	if errors.Is(err, oxide.ErrObjectNotFound) {
+		return nil, "", fmt.Errorf("%w: %w", ErrInstanceNotFound, err)
+	}
+	return nil, "", fmt.Errorf("failed viewing oxide instance: %w", err)
+}
+
+// regionsForNode returns the regions to look up the node's instance in. When
+// no regions are configured it returns the empty region, which selects the
+// default client. Otherwise, it returns the region named by the node's
+// topology.kubernetes.io/region label, or every configured region in sorted
+// order when the label is absent. A node labeled with a region that has no
+// configured endpoint is an error.
+//
+// When searchOthers is set, the other configured regions follow the labeled
+// one, so an instance that moved to another region is not mistaken for a
+// deleted one. It is only set for lookups by instance ID, since an instance
+// with the node's name in another region may be a different instance.
+func (i *InstancesV2) regionsForNode(node *v1.Node, searchOthers bool) ([]string, error) {
+	if len(i.regionClients) == 0 {
+		return []string{""}, nil
+	}
+
+	region := node.Labels[v1.LabelTopologyRegion]
+	if region == "" {
+		return slices.Sorted(maps.Keys(i.regionClients)), nil
 	}
 
-	return instance, nil
+	if _, ok := i.regionClients[region]; !ok {
+		return nil, fmt.Errorf(
+			"no oxide endpoint configured for region %q of node %s",
+			region, node.Name,
+		)
+	}
+
+	regions := []string{region}
+	if searchOthers {
+		for _, other := range slices.Sorted(maps.Keys(i.regionClients)) {
+			if other != region {
+				regions = append(regions, other)
+			}
+		}
+	}
+
+	return regions, nil
+}
+
+// clientForRegion returns the Oxide client for the given region, falling back
+// to the default client for the empty region.
+func (i *InstancesV2) clientForRegion(region string) oxideInstanceClient {
+	if client, ok := i.regionClients[region]; ok {
+		return client
+	}
+	return i.client
 }
@@ -5,12 +5,21 @@
 package provider
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/oxidecomputer/oxide.go/oxide"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 type mockOxideClient struct {
@@ -22,6 +31,28 @@ type mockOxideClient struct {
 
 	InstanceViewOutput *oxide.Instance
 	InstanceViewError  error
+
+	InstanceListAllPagesOutput []oxide.Instance
+	InstanceListAllPagesError  error
+
+	ProjectViewOutput *oxide.Project
+	ProjectViewError  error
+
+	DiskViewOutput *oxide.Disk
+	DiskViewError  error
+
+	InstanceDiskListAllPagesOutput []oxide.Disk
+	InstanceDiskListAllPagesError  error
+
+	CurrentUserViewOutput *oxide.CurrentUser
+	CurrentUserViewError  error
+
+	SledListAllPagesOutput []oxide.Sled
+	SledListAllPagesError  error
+
+	// SledInstanceListAllPagesOutput maps sled IDs to their instances.
+	SledInstanceListAllPagesOutput map[string][]oxide.SledInstance
+	SledInstanceListAllPagesError  error
 }
 
 var (
@@ -35,6 +66,12 @@ var (
 			ProviderID: "oxide://12345678-1234-1234-1234-123456789abc",
 		},
 	}
+	nodeOwnedByAWS = v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-3"},
+		Spec: v1.NodeSpec{
+			ProviderID: "aws:///us-east-1a/i-0123456789abcdef0",
+		},
+	}
 	nodeDoesNotExistInOxide = v1.Node{
 		ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
 		Spec: v1.NodeSpec{
@@ -53,6 +90,24 @@ var (
 		Id:       "12345678-1234-1234-1234-123456789abc",
 		RunState: oxide.InstanceStateStopped,
 	}
+
+	instanceStarting = oxide.Instance{
+		Name:     oxide.Name("node-1"),
+		Id:       "12345678-1234-1234-1234-123456789abc",
+		RunState: oxide.InstanceStateStarting,
+	}
+
+	nicsWithIPv4 = oxide.InstanceNetworkInterfaceResultsPage{
+		Items: []oxide.InstanceNetworkInterface{
+			{
+				IpStack: oxide.PrivateIpStack{
+					Value: &oxide.PrivateIpStackV4{
+						Value: oxide.PrivateIpv4Stack{Ip: "172.30.0.5"},
+					},
+				},
+			},
+		},
+	}
 )
 
 func TestInstanceExists(t *testing.T) {
@@ -103,6 +158,62 @@ func TestInstanceExists(t *testing.T) {
 			t.Fatal("expected instance to NOT exist via the Oxide API")
 		}
 	})
+
+	t.Run("OwnedByAnotherProvider", func(t *testing.T) {
+		// The Oxide API must not be called for a foreign provider ID.
+		instancesV2 := InstancesV2{
+			client:  &mockOxideClient{InstanceViewError: errBoom},
+			project: "test",
+		}
+		exists, err := instancesV2.InstanceExists(t.Context(), &nodeOwnedByAWS)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists {
+			t.Fatal("expected node owned by another provider to be reported as existing")
+		}
+	})
+}
+
+func TestInstanceLookupScope(t *testing.T) {
+	// Oxide scopes instances by project within the silo of the token, which
+	// replaced organizations. Instance IDs are unique, so they are looked up
+	// without a project.
+	tt := []struct {
+		name     string
+		node     *v1.Node
+		expected oxide.InstanceViewParams
+	}{
+		{
+			name:     "ByProviderID",
+			node:     &nodeWithProviderID,
+			expected: oxide.InstanceViewParams{Instance: oxide.NameOrId(instanceRunning.Id)},
+		},
+		{
+			name: "ByName",
+			node: &nodeWithoutProviderID,
+			expected: oxide.InstanceViewParams{
+				Project:  "test",
+				Instance: oxide.NameOrId(nodeWithoutProviderID.Name),
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &countingOxideClient{
+				oxideInstanceClient: &mockOxideClient{InstanceViewOutput: &instanceRunning},
+			}
+			instancesV2 := InstancesV2{client: client, project: "test"}
+
+			if _, err := instancesV2.InstanceExists(t.Context(), tc.node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(client.views) != 1 || client.views[0] != tc.expected {
+				t.Fatalf("instance views = %+v, want %+v", client.views, tc.expected)
+			}
+		})
+	}
 }
 
 func TestShutdown(t *testing.T) {
@@ -169,34 +280,1857 @@ func TestShutdown(t *testing.T) {
 			t.Fatal("expected instance to NOT exist via the Oxide API")
 		}
 	})
+
+	t.Run("OwnedByAnotherProvider", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client:  &mockOxideClient{InstanceViewError: errBoom},
+			project: "test",
+		}
+		shutdown, err := instancesV2.InstanceShutdown(t.Context(), &nodeOwnedByAWS)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if shutdown {
+			t.Fatal("expected node owned by another provider to NOT be shut down")
+		}
+	})
 }
 
-func (c *mockOxideClient) InstanceNetworkInterfaceList(
-	context.Context,
-	oxide.InstanceNetworkInterfaceListParams,
-) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
-	if c.InstanceNetworkInterfaceListError != nil {
-		return nil, c.InstanceNetworkInterfaceListError
+func TestShutdownInstanceStates(t *testing.T) {
+	instanceFailed := instanceRunning
+	instanceFailed.RunState = oxide.InstanceStateFailed
+	instanceDestroyed := instanceRunning
+	instanceDestroyed.RunState = oxide.InstanceStateDestroyed
+
+	tt := []struct {
+		name             string
+		shutdownStates   []oxide.InstanceState
+		instance         *oxide.Instance
+		expectedExists   bool
+		expectedShutdown bool
+	}{
+		{
+			name:             "deleted",
+			expectedExists:   false,
+			expectedShutdown: true,
+		},
+		{
+			name:             "stopped with keep",
+			shutdownStates:   DefaultShutdownInstanceStates,
+			instance:         &instanceStopped,
+			expectedExists:   true,
+			expectedShutdown: true,
+		},
+		{
+			name:             "stopped without keep",
+			shutdownStates:   []oxide.InstanceState{},
+			instance:         &instanceStopped,
+			expectedExists:   false,
+			expectedShutdown: false,
+		},
+		{
+			name:             "failed with keep",
+			shutdownStates:   []oxide.InstanceState{oxide.InstanceStateFailed},
+			instance:         &instanceFailed,
+			expectedExists:   true,
+			expectedShutdown: true,
+		},
+		{
+			name:             "failed without keep",
+			shutdownStates:   DefaultShutdownInstanceStates,
+			instance:         &instanceFailed,
+			expectedExists:   true,
+			expectedShutdown: false,
+		},
+		{
+			name: "destroyed with failed kept",
+			shutdownStates: []oxide.InstanceState{
+				oxide.InstanceStateStopped, oxide.InstanceStateFailed,
+			},
+			instance:         &instanceDestroyed,
+			expectedExists:   false,
+			expectedShutdown: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &mockOxideClient{InstanceViewOutput: tc.instance}
+			if tc.instance == nil {
+				client.InstanceViewError = oxide.ErrObjectNotFound
+			}
+			instancesV2 := InstancesV2{
+				client:         client,
+				project:        "test",
+				shutdownStates: tc.shutdownStates,
+			}
+
+			exists, err := instancesV2.InstanceExists(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if exists != tc.expectedExists {
+				t.Fatalf("exists = %t, want %t", exists, tc.expectedExists)
+			}
+
+			shutdown, err := instancesV2.InstanceShutdown(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if shutdown != tc.expectedShutdown {
+				t.Fatalf("shutdown = %t, want %t", shutdown, tc.expectedShutdown)
+			}
+		})
 	}
-	return c.InstanceNetworkInterfaceListOutput, nil
 }
 
-func (c *mockOxideClient) InstanceExternalIpList(
-	context.Context,
-	oxide.InstanceExternalIpListParams,
-) (*oxide.ExternalIpResultsPage, error) {
-	if c.InstanceExternalIpListError != nil {
-		return nil, c.InstanceExternalIpListError
+func TestInstanceMetadata(t *testing.T) {
+	t.Run("NotFound", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client:  &mockOxideClient{InstanceViewError: oxide.ErrObjectNotFound},
+			project: "test",
+		}
+		_, err := instancesV2.InstanceMetadata(t.Context(), &nodeDoesNotExistInOxide)
+		if !errors.Is(err, ErrInstanceNotFound) || !errors.Is(err, oxide.ErrObjectNotFound) {
+			t.Fatalf("err = %v, want instance not found", err)
+		}
+	})
+
+	t.Run("StartingWithoutIPs", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instanceStarting,
+				InstanceNetworkInterfaceListOutput: &oxide.InstanceNetworkInterfaceResultsPage{},
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			},
+			project: "test",
+		}
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err == nil {
+			t.Fatalf("expected error for starting instance without ips, got %+v", metadata)
+		}
+	})
+
+	t.Run("StartingWithIPs", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instanceStarting,
+				InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			},
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+		}
+		_, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("RunningWithoutNICs", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instanceRunning,
+				InstanceNetworkInterfaceListOutput: &oxide.InstanceNetworkInterfaceResultsPage{},
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			},
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+		}
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if !errors.Is(err, ErrNoNetworkInterfaces) {
+			t.Fatalf("err = %v, want no network interfaces, got %+v", err, metadata)
+		}
+	})
+
+	t.Run("StoppedWithoutNICs", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instanceStopped,
+				InstanceNetworkInterfaceListOutput: &oxide.InstanceNetworkInterfaceResultsPage{},
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			},
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+		}
+		_, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("RunningWithIPs", func(t *testing.T) {
+		instancesV2 := InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instanceRunning,
+				InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+			},
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+		}
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if metadata.ProviderID != nodeWithProviderID.Spec.ProviderID {
+			t.Fatalf("provider id = %q, want %q",
+				metadata.ProviderID, nodeWithProviderID.Spec.ProviderID,
+			)
+		}
+		if !hasIPAddress(metadata.NodeAddresses) {
+			t.Fatalf("addresses = %+v, want an internal ip", metadata.NodeAddresses)
+		}
+	})
+}
+
+func TestInstanceMetadataPartialFailure(t *testing.T) {
+	instance := instanceRunning
+	instance.BootDiskId = "disk-1"
+	instance.Ncpus = 4
+	instance.Memory = 16 * gibibyte
+
+	// The node was initialized before, so it has addresses to keep.
+	node := nodeWithProviderID.DeepCopy()
+	node.Status.Addresses = []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "172.30.0.9"},
+		{Type: v1.NodeExternalIP, Address: "203.0.113.9"},
+	}
+
+	newClient := func() *mockOxideClient {
+		return &mockOxideClient{
+			InstanceViewOutput:                 &instance,
+			InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+			InstanceExternalIpListOutput: &oxide.ExternalIpResultsPage{
+				Items: []oxide.ExternalIp{{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.20"}}},
+			},
+			DiskViewOutput:        &oxide.Disk{ImageId: "image-1"},
+			CurrentUserViewOutput: &oxide.CurrentUser{SiloName: "silo-1"},
+			SledListAllPagesOutput: []oxide.Sled{
+				{Id: "sled-1", RackId: "rack-1"},
+			},
+			SledInstanceListAllPagesOutput: map[string][]oxide.SledInstance{
+				"sled-1": {{Id: instance.Id}},
+			},
+		}
+	}
+
+	address := func(addressType v1.NodeAddressType, ip string) v1.NodeAddress {
+		return v1.NodeAddress{Type: addressType, Address: ip}
+	}
+	allLabels := map[string]string{
+		LabelProject: "test",
+		LabelImage:   "image-1",
+		LabelSilo:    "silo-1",
+		LabelRack:    "rack-1",
+	}
+	withoutLabel := func(key string) map[string]string {
+		labels := maps.Clone(allLabels)
+		delete(labels, key)
+		return labels
+	}
+
+	tt := []struct {
+		name          string
+		fail          func(*mockOxideClient)
+		node          *v1.Node
+		wantAddresses []v1.NodeAddress
+		wantLabels    map[string]string
+		wantError     bool
+	}{
+		{
+			name: "NetworkInterfaces",
+			fail: func(c *mockOxideClient) { c.InstanceNetworkInterfaceListError = errBoom },
+			wantAddresses: []v1.NodeAddress{
+				address(v1.NodeInternalIP, "172.30.0.9"),
+				address(v1.NodeExternalIP, "203.0.113.20"),
+			},
+			wantLabels: allLabels,
+		},
+		{
+			name:      "NetworkInterfacesOfNewNode",
+			fail:      func(c *mockOxideClient) { c.InstanceNetworkInterfaceListError = errBoom },
+			node:      &nodeWithoutProviderID,
+			wantError: true,
+		},
+		{
+			name: "ExternalIPs",
+			fail: func(c *mockOxideClient) { c.InstanceExternalIpListError = errBoom },
+			wantAddresses: []v1.NodeAddress{
+				address(v1.NodeInternalIP, "172.30.0.5"),
+				address(v1.NodeExternalIP, "203.0.113.9"),
+			},
+			wantLabels: allLabels,
+		},
+		{
+			name: "BootDisk",
+			fail: func(c *mockOxideClient) { c.DiskViewError = errBoom },
+			wantAddresses: []v1.NodeAddress{
+				address(v1.NodeInternalIP, "172.30.0.5"),
+				address(v1.NodeExternalIP, "203.0.113.20"),
+			},
+			wantLabels: withoutLabel(LabelImage),
+		},
+		{
+			name: "CurrentUser",
+			fail: func(c *mockOxideClient) { c.CurrentUserViewError = errBoom },
+			wantAddresses: []v1.NodeAddress{
+				address(v1.NodeInternalIP, "172.30.0.5"),
+				address(v1.NodeExternalIP, "203.0.113.20"),
+			},
+			wantLabels: withoutLabel(LabelSilo),
+		},
+		{
+			name: "Sleds",
+			fail: func(c *mockOxideClient) { c.SledListAllPagesError = errBoom },
+			wantAddresses: []v1.NodeAddress{
+				address(v1.NodeInternalIP, "172.30.0.5"),
+				address(v1.NodeExternalIP, "203.0.113.20"),
+			},
+			wantLabels: withoutLabel(LabelRack),
+		},
+		{
+			name: "InstanceDisks",
+			fail: func(c *mockOxideClient) { c.InstanceDiskListAllPagesError = errBoom },
+			wantAddresses: []v1.NodeAddress{
+				address(v1.NodeInternalIP, "172.30.0.5"),
+				address(v1.NodeExternalIP, "203.0.113.20"),
+			},
+			wantLabels: allLabels,
+		},
+	}
+
+	for _, tc := range tt {
+		for _, bestEffort := range []bool{false, true} {
+			name := tc.name + "/Strict"
+			if bestEffort {
+				name = tc.name + "/BestEffort"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				client := newClient()
+				tc.fail(client)
+				instancesV2 := InstancesV2{
+					client:    client,
+					project:   "test",
+					k8sClient: fake.NewSimpleClientset(),
+					nodeLabels: []string{
+						NodeLabelProject, NodeLabelImage, NodeLabelSilo, NodeLabelRack,
+					},
+					nodeAddressTypes: []v1.NodeAddressType{
+						v1.NodeInternalIP, v1.NodeExternalIP,
+					},
+					diskAnnotations:    true,
+					bestEffortMetadata: bestEffort,
+				}
+
+				metadata, err := instancesV2.InstanceMetadata(t.Context(), cmp.Or(tc.node, node))
+				if !bestEffort || tc.wantError {
+					if !errors.Is(err, errBoom) {
+						t.Fatalf("err = %v, want errBoom", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				providerID := ProviderID{InstanceID: instance.Id}.String()
+				if metadata.ProviderID != providerID {
+					t.Errorf("provider id = %q, want %q", metadata.ProviderID, providerID)
+				}
+				if metadata.InstanceType != "4-16" {
+					t.Errorf("instance type = %q, want %q", metadata.InstanceType, "4-16")
+				}
+				if !slices.Equal(metadata.NodeAddresses, tc.wantAddresses) {
+					t.Errorf("addresses = %v, want %v", metadata.NodeAddresses, tc.wantAddresses)
+				}
+				if !maps.Equal(metadata.AdditionalLabels, tc.wantLabels) {
+					t.Errorf("labels = %v, want %v", metadata.AdditionalLabels, tc.wantLabels)
+				}
+			})
+		}
 	}
-	return c.InstanceExternalIpListOutput, nil
 }
 
-func (c *mockOxideClient) InstanceView(
-	context.Context,
-	oxide.InstanceViewParams,
-) (*oxide.Instance, error) {
-	if c.InstanceViewError != nil {
-		return nil, c.InstanceViewError
+func TestInstanceAnnotations(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	instance := instanceRunning
+	instance.ProjectId = "87654321-4321-4321-4321-cba987654321"
+	instance.TimeCreated = &created
+	instance.BootDiskId = "disk-boot"
+
+	disks := []oxide.Disk{
+		{Id: "disk-data", Name: "node-1-data"},
+		{Id: "disk-boot", Name: "node-1-boot"},
+	}
+
+	newInstancesV2 := func(client *fake.Clientset) *InstancesV2 {
+		return &InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instance,
+				InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+				InstanceDiskListAllPagesOutput:     disks,
+				ProjectViewOutput:                  &oxide.Project{Id: instance.ProjectId},
+			},
+			project:         "test",
+			k8sClient:       client,
+			diskAnnotations: true,
+		}
+	}
+
+	t.Run("SetsAnnotations", func(t *testing.T) {
+		client := fake.NewSimpleClientset(nodeWithoutProviderID.DeepCopy())
+
+		_, err := newInstancesV2(client).InstanceMetadata(
+			t.Context(), &nodeWithoutProviderID,
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _ := client.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+		for key, want := range map[string]string{
+			AnnotationInstanceID:      "12345678-1234-1234-1234-123456789abc",
+			AnnotationInstanceCreated: "2026-01-02T03:04:05Z",
+			AnnotationProjectID:       "87654321-4321-4321-4321-cba987654321",
+			AnnotationBootDisk:        "node-1-boot",
+			AnnotationDiskCount:       "2",
+			AnnotationDisks:           "node-1-boot,node-1-data",
+		} {
+			if got.Annotations[key] != want {
+				t.Fatalf("annotation %s = %q, want %q", key, got.Annotations[key], want)
+			}
+		}
+	})
+
+	t.Run("BoundsDiskList", func(t *testing.T) {
+		client := fake.NewSimpleClientset(nodeWithoutProviderID.DeepCopy())
+		instancesV2 := newInstancesV2(client)
+
+		many := make([]oxide.Disk, 0, maxAnnotatedDisks+2)
+		for n := range maxAnnotatedDisks + 2 {
+			many = append(many, oxide.Disk{Name: oxide.Name(fmt.Sprintf("disk-%02d", n))})
+		}
+		instancesV2.client.(*mockOxideClient).InstanceDiskListAllPagesOutput = many
+
+		_, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithoutProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _ := client.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+		if count := got.Annotations[AnnotationDiskCount]; count != "14" {
+			t.Fatalf("disk count = %q, want %q", count, "14")
+		}
+		names := strings.Split(got.Annotations[AnnotationDisks], ",")
+		if len(names) != maxAnnotatedDisks || names[0] != "disk-00" {
+			t.Fatalf("disks = %v, want the first %d disks", names, maxAnnotatedDisks)
+		}
+	})
+
+	t.Run("DisksDisabled", func(t *testing.T) {
+		node := nodeWithProviderID.DeepCopy()
+		node.Annotations = map[string]string{
+			AnnotationBootDisk:  "node-1-boot",
+			AnnotationDiskCount: "2",
+			AnnotationDisks:     "node-1-boot,node-1-data",
+		}
+		client := fake.NewSimpleClientset(node)
+		instancesV2 := newInstancesV2(client)
+		instancesV2.diskAnnotations = false
+		instancesV2.client.(*mockOxideClient).InstanceDiskListAllPagesError = errUnexpectedOxideCall
+
+		if _, err := instancesV2.InstanceMetadata(t.Context(), node); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _ := client.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+		for _, key := range []string{AnnotationBootDisk, AnnotationDiskCount, AnnotationDisks} {
+			if value, ok := got.Annotations[key]; ok {
+				t.Fatalf("annotation %s = %q, want it removed", key, value)
+			}
+		}
+	})
+
+	t.Run("DiskListError", func(t *testing.T) {
+		instancesV2 := newInstancesV2(fake.NewSimpleClientset())
+		instancesV2.client.(*mockOxideClient).InstanceDiskListAllPagesError = errBoom
+
+		_, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want errBoom from disk list", err)
+		}
+	})
+
+	t.Run("EgressIPs", func(t *testing.T) {
+		externalIPs := &oxide.ExternalIpResultsPage{
+			Items: []oxide.ExternalIp{
+				{Value: &oxide.ExternalIpSnat{
+					Ip: "203.0.113.5", FirstPort: new(0), LastPort: new(16383),
+				}},
+				{Value: &oxide.ExternalIpFloating{Ip: "203.0.113.30"}},
+				{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.20"}},
+			},
+		}
+
+		tt := []struct {
+			name     string
+			enabled  bool
+			expected string
+		}{
+			{
+				name:     "Enabled",
+				enabled:  true,
+				expected: "ephemeral=203.0.113.20,snat=203.0.113.5:0-16383",
+			},
+			{
+				name:    "Disabled",
+				enabled: false,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				node := nodeWithoutProviderID.DeepCopy()
+				node.Annotations = map[string]string{AnnotationEgressIPs: "snat=198.51.100.1"}
+				client := fake.NewSimpleClientset(node)
+				instancesV2 := newInstancesV2(client)
+				instancesV2.client.(*mockOxideClient).InstanceExternalIpListOutput = externalIPs
+				instancesV2.egressIPsAnnotation = tc.enabled
+
+				metadata, err := instancesV2.InstanceMetadata(t.Context(), node)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				got, _ := client.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+				value, ok := got.Annotations[AnnotationEgressIPs]
+				if value != tc.expected || ok != (tc.expected != "") {
+					t.Fatalf("egress ips annotation = %q (set %t), want %q",
+						value, ok, tc.expected)
+				}
+
+				// The SNAT IP is still not reported as a node address.
+				for _, address := range metadata.NodeAddresses {
+					if address.Address == "203.0.113.5" {
+						t.Fatalf("addresses = %v, want no snat ip", metadata.NodeAddresses)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Sled", func(t *testing.T) {
+		sleds := []oxide.Sled{
+			{Id: "sled-1", Baseboard: oxide.Baseboard{Serial: "BRM42220001", Part: "913-0000019"}},
+			{Id: "sled-2", Baseboard: oxide.Baseboard{Serial: "BRM42220002", Part: "913-0000019"}},
+		}
+		current := map[string]string{
+			AnnotationSledID:     "sled-1",
+			AnnotationSledSerial: "BRM42220001",
+			AnnotationSledPart:   "913-0000019",
+		}
+
+		tt := []struct {
+			name     string
+			enabled  bool
+			state    oxide.InstanceState
+			sledID   string
+			expected map[string]string
+		}{
+			{
+				name:    "Moved",
+				enabled: true,
+				sledID:  "sled-2",
+				expected: map[string]string{
+					AnnotationSledID:     "sled-2",
+					AnnotationSledSerial: "BRM42220002",
+					AnnotationSledPart:   "913-0000019",
+				},
+			},
+			{
+				name:     "Migrating",
+				enabled:  true,
+				state:    oxide.InstanceStateMigrating,
+				sledID:   "sled-2",
+				expected: current,
+			},
+			{
+				name:     "NoSled",
+				enabled:  true,
+				expected: current,
+			},
+			{
+				name:     "Disabled",
+				sledID:   "sled-2",
+				expected: map[string]string{},
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				node := nodeWithoutProviderID.DeepCopy()
+				node.Annotations = maps.Clone(current)
+				client := fake.NewSimpleClientset(node)
+				instancesV2 := newInstancesV2(client)
+				instancesV2.sledAnnotations = tc.enabled
+
+				instance := instance
+				instance.RunState = cmp.Or(tc.state, instance.RunState)
+				oxideClient := instancesV2.client.(*mockOxideClient)
+				oxideClient.InstanceViewOutput = &instance
+				oxideClient.SledListAllPagesOutput = sleds
+				oxideClient.SledInstanceListAllPagesOutput = map[string][]oxide.SledInstance{
+					tc.sledID: {{Id: instance.Id}},
+				}
+
+				if _, err := instancesV2.InstanceMetadata(t.Context(), node); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				got, _ := client.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+				for _, key := range []string{
+					AnnotationSledID, AnnotationSledSerial, AnnotationSledPart,
+				} {
+					value, ok := got.Annotations[key]
+					if want, wantOK := tc.expected[key]; value != want || ok != wantOK {
+						t.Errorf("%s = %q (set %t), want %q", key, value, ok, want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("UnchangedIsNotPatched", func(t *testing.T) {
+		node := nodeWithProviderID.DeepCopy()
+		node.Annotations = map[string]string{
+			AnnotationInstanceID:      "12345678-1234-1234-1234-123456789abc",
+			AnnotationInstanceCreated: "2026-01-02T03:04:05Z",
+			AnnotationProjectID:       "87654321-4321-4321-4321-cba987654321",
+			AnnotationBootDisk:        "node-1-boot",
+			AnnotationDiskCount:       "2",
+			AnnotationDisks:           "node-1-boot,node-1-data",
+		}
+		client := fake.NewSimpleClientset(node)
+
+		_, err := newInstancesV2(client).InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if actions := client.Actions(); len(actions) != 0 {
+			t.Fatalf("actions = %v, want no kubernetes api calls", actions)
+		}
+	})
+}
+
+func TestInstanceAddressTypes(t *testing.T) {
+	instance := instanceRunning
+	instance.Hostname = "node-1"
+	externalIPs := oxide.ExternalIpResultsPage{
+		Items: []oxide.ExternalIp{{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.20"}}},
+	}
+
+	hostname := v1.NodeAddress{Type: v1.NodeHostName, Address: "node-1"}
+	internalIP := v1.NodeAddress{Type: v1.NodeInternalIP, Address: "172.30.0.5"}
+	externalIP := v1.NodeAddress{Type: v1.NodeExternalIP, Address: "203.0.113.20"}
+
+	tt := []struct {
+		name         string
+		addressTypes []v1.NodeAddressType
+		expected     []v1.NodeAddress
+	}{
+		{
+			name:         "defaults",
+			addressTypes: DefaultNodeAddressTypes,
+			expected:     []v1.NodeAddress{internalIP, externalIP, hostname},
+		},
+		{
+			name:         "unset",
+			addressTypes: nil,
+			expected:     []v1.NodeAddress{internalIP, externalIP, hostname},
+		},
+		{
+			name:         "without external ips",
+			addressTypes: []v1.NodeAddressType{v1.NodeHostName, v1.NodeInternalIP},
+			expected:     []v1.NodeAddress{internalIP, hostname},
+		},
+		{
+			name:         "internal ips only",
+			addressTypes: []v1.NodeAddressType{v1.NodeInternalIP},
+			expected:     []v1.NodeAddress{internalIP},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput:                 &instance,
+					InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+					InstanceExternalIpListOutput:       &externalIPs,
+				},
+				project:          "test",
+				k8sClient:        fake.NewSimpleClientset(),
+				nodeAddressTypes: tc.addressTypes,
+			}
+
+			metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(metadata.NodeAddresses, tc.expected) {
+				t.Fatalf("node addresses = %v, want %v", metadata.NodeAddresses, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstanceAddressOrder(t *testing.T) {
+	instance := instanceRunning
+	instance.Hostname = "node-1"
+
+	nic := func(name string, primary bool, ip string) oxide.InstanceNetworkInterface {
+		return oxide.InstanceNetworkInterface{
+			Name:    oxide.Name(name),
+			Primary: &primary,
+			IpStack: oxide.PrivateIpStack{
+				Value: &oxide.PrivateIpStackV4{Value: oxide.PrivateIpv4Stack{Ip: ip}},
+			},
+		}
+	}
+	nics := []oxide.InstanceNetworkInterface{
+		nic("net-b", false, "172.30.1.5"),
+		nic("net-c", true, "172.30.2.5"),
+		nic("net-a", false, "172.30.0.5"),
+	}
+	externalIPs := []oxide.ExternalIp{
+		{Value: &oxide.ExternalIpFloating{Ip: "203.0.113.30"}},
+		{Value: &oxide.ExternalIpSnat{Ip: "203.0.113.1"}},
+		{Value: &oxide.ExternalIpFloating{Ip: "203.0.113.10"}},
+		{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.20"}},
+	}
+
+	expected := []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "172.30.2.5"},
+		{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+		{Type: v1.NodeInternalIP, Address: "172.30.1.5"},
+		{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+		{Type: v1.NodeExternalIP, Address: "203.0.113.10"},
+		{Type: v1.NodeExternalIP, Address: "203.0.113.30"},
+		{Type: v1.NodeHostName, Address: "node-1"},
+	}
+
+	// The addresses are the same however the Oxide API orders its lists.
+	for _, reverse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reverse=%t", reverse), func(t *testing.T) {
+			nics, externalIPs := slices.Clone(nics), slices.Clone(externalIPs)
+			if reverse {
+				slices.Reverse(nics)
+				slices.Reverse(externalIPs)
+			}
+
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput: &instance,
+					InstanceNetworkInterfaceListOutput: &oxide.InstanceNetworkInterfaceResultsPage{
+						Items: nics,
+					},
+					InstanceExternalIpListOutput: &oxide.ExternalIpResultsPage{
+						Items: externalIPs,
+					},
+				},
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(),
+			}
+
+			metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(metadata.NodeAddresses, expected) {
+				t.Fatalf("node addresses = %v, want %v", metadata.NodeAddresses, expected)
+			}
+		})
+	}
+}
+
+func TestInstanceHostname(t *testing.T) {
+	hostname := func(address string) []v1.NodeAddress {
+		return []v1.NodeAddress{{Type: v1.NodeHostName, Address: address}}
+	}
+
+	tt := []struct {
+		name             string
+		hostname         string
+		invalidHostnames string
+		expected         []v1.NodeAddress
+	}{
+		{
+			name:     "valid",
+			hostname: "node-1.example.com",
+			expected: hostname("node-1.example.com"),
+		},
+		{
+			name:     "empty",
+			hostname: "",
+			expected: []v1.NodeAddress{},
+		},
+		{
+			name:     "invalid skipped by default",
+			hostname: "Node_1",
+			expected: []v1.NodeAddress{},
+		},
+		{
+			name:             "invalid skipped",
+			hostname:         "Node_1",
+			invalidHostnames: InvalidHostnamesSkip,
+			expected:         []v1.NodeAddress{},
+		},
+		{
+			name:             "invalid sanitized",
+			hostname:         "-Node_1..Example.com",
+			invalidHostnames: InvalidHostnamesSanitize,
+			expected:         hostname("node-1.example.com"),
+		},
+		{
+			name:             "unsanitizable skipped",
+			hostname:         "__",
+			invalidHostnames: InvalidHostnamesSanitize,
+			expected:         []v1.NodeAddress{},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			instance := instanceRunning
+			instance.Hostname = tc.hostname
+
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput:                 &instance,
+					InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+					InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+				},
+				project:          "test",
+				k8sClient:        fake.NewSimpleClientset(),
+				nodeAddressTypes: []v1.NodeAddressType{v1.NodeHostName},
+				invalidHostnames: tc.invalidHostnames,
+			}
+
+			metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(metadata.NodeAddresses, tc.expected) {
+				t.Fatalf("node addresses = %v, want %v", metadata.NodeAddresses, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstancePrimaryNICOnly(t *testing.T) {
+	primary, secondary := true, false
+	nics := oxide.InstanceNetworkInterfaceResultsPage{
+		Items: []oxide.InstanceNetworkInterface{
+			{
+				Name:    "isolated",
+				Primary: &secondary,
+				IpStack: oxide.PrivateIpStack{
+					Value: &oxide.PrivateIpStackV4{Value: oxide.PrivateIpv4Stack{Ip: "10.1.0.5"}},
+				},
+			},
+			{
+				Name:    "net0",
+				Primary: &primary,
+				IpStack: oxide.PrivateIpStack{
+					Value: &oxide.PrivateIpStackDualStack{Value: oxide.PrivateIpStackDualStackValue{
+						V4: oxide.PrivateIpv4Stack{Ip: "172.30.0.5"},
+						V6: oxide.PrivateIpv6Stack{Ip: "fd00::5"},
+					}},
+				},
+			},
+		},
+	}
+
+	internalIP := func(ip string) v1.NodeAddress {
+		return v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip}
+	}
+
+	tt := []struct {
+		name           string
+		primaryNICOnly bool
+		expected       []v1.NodeAddress
+	}{
+		{
+			name:           "all nics",
+			primaryNICOnly: false,
+			expected: []v1.NodeAddress{
+				internalIP("172.30.0.5"), internalIP("fd00::5"), internalIP("10.1.0.5"),
+			},
+		},
+		{
+			name:           "primary nic only",
+			primaryNICOnly: true,
+			expected:       []v1.NodeAddress{internalIP("172.30.0.5"), internalIP("fd00::5")},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput:                 &instanceRunning,
+					InstanceNetworkInterfaceListOutput: &nics,
+					InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+				},
+				project:          "test",
+				k8sClient:        fake.NewSimpleClientset(),
+				nodeAddressTypes: []v1.NodeAddressType{v1.NodeInternalIP},
+				primaryNICOnly:   tc.primaryNICOnly,
+			}
+
+			metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(metadata.NodeAddresses, tc.expected) {
+				t.Fatalf("node addresses = %v, want %v", metadata.NodeAddresses, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstanceSetProviderIDEvent(t *testing.T) {
+	tt := []struct {
+		name      string
+		node      *v1.Node
+		wantEvent string
+	}{
+		{
+			name: "InitialSync",
+			node: &nodeWithoutProviderID,
+			wantEvent: "Normal SetProviderID Setting provider ID " +
+				"oxide://12345678-1234-1234-1234-123456789abc for instance " +
+				"12345678-1234-1234-1234-123456789abc in project test",
+		},
+		{
+			name: "SubsequentSync",
+			node: &nodeWithProviderID,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput:                 &instanceRunning,
+					InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+					InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+				},
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(),
+				recorder:  recorder,
+			}
+
+			if _, err := instancesV2.InstanceMetadata(t.Context(), tc.node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if event != tc.wantEvent {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestInstanceExternalIPKinds(t *testing.T) {
+	externalIPs := oxide.ExternalIpResultsPage{
+		Items: []oxide.ExternalIp{
+			{Value: &oxide.ExternalIpSnat{Ip: "203.0.113.1"}},
+			{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.20"}},
+			{Value: &oxide.ExternalIpFloating{Ip: "203.0.113.30"}},
+			// An external IP of a kind this version of the SDK doesn't know.
+			{},
+		},
+	}
+
+	externalIP := func(ip string) v1.NodeAddress {
+		return v1.NodeAddress{Type: v1.NodeExternalIP, Address: ip}
+	}
+
+	tt := []struct {
+		name     string
+		kinds    []oxide.ExternalIpKind
+		expected []v1.NodeAddress
+	}{
+		{
+			name:     "defaults",
+			kinds:    nil,
+			expected: []v1.NodeAddress{externalIP("203.0.113.20"), externalIP("203.0.113.30")},
+		},
+		{
+			name:     "floating only",
+			kinds:    []oxide.ExternalIpKind{oxide.ExternalIpKindFloating},
+			expected: []v1.NodeAddress{externalIP("203.0.113.30")},
+		},
+		{
+			name:  "with snat",
+			kinds: append(slices.Clone(DefaultNodeExternalIPKinds), oxide.ExternalIpKindSnat),
+			expected: []v1.NodeAddress{
+				externalIP("203.0.113.20"), externalIP("203.0.113.30"), externalIP("203.0.113.1"),
+			},
+		},
+		{
+			name:     "none",
+			kinds:    []oxide.ExternalIpKind{},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput:                 &instanceRunning,
+					InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+					InstanceExternalIpListOutput:       &externalIPs,
+				},
+				project:          "test",
+				k8sClient:        fake.NewSimpleClientset(),
+				nodeAddressTypes: []v1.NodeAddressType{v1.NodeExternalIP},
+				externalIPKinds:  tc.kinds,
+			}
+
+			metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(metadata.NodeAddresses, tc.expected) {
+				t.Fatalf("node addresses = %v, want %v", metadata.NodeAddresses, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstanceType(t *testing.T) {
+	tt := []struct {
+		name     string
+		instance oxide.Instance
+		expected string
+	}{
+		{
+			name:     "whole gibibytes",
+			instance: oxide.Instance{Ncpus: 4, Memory: 16 * gibibyte},
+			expected: "4-16",
+		},
+		{
+			name:     "partial gibibytes round down",
+			instance: oxide.Instance{Ncpus: 1, Memory: 1536 * 1024 * 1024},
+			expected: "1-1",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := instanceType(&tc.instance); got != tc.expected {
+				t.Fatalf("instance type = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDedupNodeAddresses(t *testing.T) {
+	tt := []struct {
+		name      string
+		addresses []v1.NodeAddress
+		expected  []v1.NodeAddress
+	}{
+		{
+			name: "no duplicates",
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "node-1"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+			},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "node-1"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+			},
+		},
+		{
+			name: "duplicates keep first seen order",
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "node-1"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.6"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+			},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "node-1"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.6"},
+			},
+		},
+		{
+			name: "same address with different types",
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "203.0.113.20"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+			},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "203.0.113.20"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.20"},
+			},
+		},
+		{
+			name: "hostname identical to internal ip",
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "172.30.0.5"},
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+			},
+			expected: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			addresses := dedupNodeAddresses(tc.addresses)
+			if !slices.Equal(addresses, tc.expected) {
+				t.Fatalf("node addresses = %v, want %v", addresses, tc.expected)
+			}
+		})
+	}
+}
+
+func TestInstanceLabels(t *testing.T) {
+	instance := instanceRunning
+	instance.BootDiskId = "disk-1"
+	instance.CpuPlatform = oxide.InstanceCpuPlatformAmdMilan
+
+	nics := oxide.InstanceNetworkInterfaceResultsPage{
+		Items: []oxide.InstanceNetworkInterface{
+			{
+				Primary: new(true),
+				VpcId:   "vpc-1",
+				IpStack: nicsWithIPv4.Items[0].IpStack,
+			},
+		},
+	}
+
+	newInstancesV2 := func(nodeLabels ...string) *InstancesV2 {
+		return &InstancesV2{
+			client: &mockOxideClient{
+				InstanceViewOutput:                 &instance,
+				InstanceNetworkInterfaceListOutput: &nics,
+				InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+				DiskViewOutput:                     &oxide.Disk{ImageId: "image-1"},
+				CurrentUserViewOutput:              &oxide.CurrentUser{SiloName: "silo-1"},
+				SledListAllPagesOutput: []oxide.Sled{
+					{Id: "sled-1", RackId: "rack-1"},
+					{Id: "sled-2", RackId: "rack-2"},
+				},
+				SledInstanceListAllPagesOutput: map[string][]oxide.SledInstance{
+					"sled-2": {{Id: instance.Id}},
+				},
+			},
+			project:    "test",
+			k8sClient:  fake.NewSimpleClientset(),
+			nodeLabels: nodeLabels,
+		}
+	}
+
+	tt := []struct {
+		name       string
+		nodeLabels []string
+		expected   map[string]string
+	}{
+		{
+			name:       "defaults",
+			nodeLabels: DefaultNodeLabels,
+			expected:   map[string]string{LabelProject: "test"},
+		},
+		{
+			name:       "allowlisted only",
+			nodeLabels: []string{NodeLabelVPC, NodeLabelImage},
+			expected:   map[string]string{LabelVPC: "vpc-1", LabelImage: "image-1"},
+		},
+		{
+			name:       "silo and rack",
+			nodeLabels: []string{NodeLabelSilo, NodeLabelRack},
+			expected:   map[string]string{LabelSilo: "silo-1", LabelRack: "rack-2"},
+		},
+		{
+			name:       "zone",
+			nodeLabels: []string{NodeLabelZone},
+			expected:   map[string]string{LabelZone: "rack-2"},
+		},
+		{
+			name:       "rack and zone",
+			nodeLabels: []string{NodeLabelRack, NodeLabelZone},
+			expected:   map[string]string{LabelRack: "rack-2", LabelZone: "rack-2"},
+		},
+		{
+			name:       "cpu platform",
+			nodeLabels: []string{NodeLabelCPUPlatform},
+			expected:   map[string]string{LabelCPUPlatform: "amd_milan"},
+		},
+		{
+			name:       "disabled",
+			nodeLabels: []string{},
+			expected:   map[string]string{},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			metadata, err := newInstancesV2(tc.nodeLabels...).InstanceMetadata(
+				t.Context(), &nodeWithProviderID,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !maps.Equal(metadata.AdditionalLabels, tc.expected) {
+				t.Fatalf("labels = %v, want %v", metadata.AdditionalLabels, tc.expected)
+			}
+		})
+	}
+
+	t.Run("RackIsZone", func(t *testing.T) {
+		metadata, err := newInstancesV2(NodeLabelRack).InstanceMetadata(
+			t.Context(), &nodeWithProviderID,
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if metadata.Zone != "rack-2" {
+			t.Fatalf("zone = %q, want %q", metadata.Zone, "rack-2")
+		}
+	})
+
+	t.Run("RackIsRegion", func(t *testing.T) {
+		metadata, err := newInstancesV2(NodeLabelRegion, NodeLabelRack).InstanceMetadata(
+			t.Context(), &nodeWithProviderID,
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if metadata.Region != "rack-2" {
+			t.Fatalf("region = %q, want %q", metadata.Region, "rack-2")
+		}
+		want := map[string]string{LabelRegion: "rack-2", LabelRack: "rack-2"}
+		if !maps.Equal(metadata.AdditionalLabels, want) {
+			t.Fatalf("labels = %v, want %v", metadata.AdditionalLabels, want)
+		}
+	})
+
+	t.Run("ZoneMigration", func(t *testing.T) {
+		instancesV2 := newInstancesV2(NodeLabelZone)
+		client := instancesV2.client.(*mockOxideClient)
+
+		node := nodeWithProviderID.DeepCopy()
+		node.Labels = map[string]string{LabelZone: "rack-2", v1.LabelTopologyZone: "rack-2"}
+		k8sClient := fake.NewSimpleClientset(node)
+		instancesV2.k8sClient = k8sClient
+
+		// While the instance is migrating, the zone label is kept.
+		migrating := instance
+		migrating.RunState = oxide.InstanceStateMigrating
+		client.InstanceViewOutput = &migrating
+		client.SledInstanceListAllPagesOutput = nil
+
+		during, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if during.Zone != "rack-2" || during.AdditionalLabels[LabelZone] != "rack-2" {
+			t.Fatalf("zone = %q, labels = %v, want rack-2 during migration",
+				during.Zone, during.AdditionalLabels)
+		}
+
+		// The instance settles on a sled in another rack.
+		client.InstanceViewOutput = &instance
+		client.SledInstanceListAllPagesOutput = map[string][]oxide.SledInstance{
+			"sled-1": {{Id: instance.Id}},
+		}
+
+		after, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if after.Zone != "rack-1" || after.AdditionalLabels[LabelZone] != "rack-1" {
+			t.Fatalf("zone = %q, labels = %v, want rack-1", after.Zone, after.AdditionalLabels)
+		}
+
+		got, _ := k8sClient.CoreV1().Nodes().Get(t.Context(), node.Name, metav1.GetOptions{})
+		for _, key := range []string{LabelZone, v1.LabelTopologyZone} {
+			if got.Labels[key] != "rack-1" {
+				t.Fatalf("label %s = %q, want %q", key, got.Labels[key], "rack-1")
+			}
+		}
+	})
+
+	t.Run("RackWithoutFleetViewer", func(t *testing.T) {
+		instancesV2 := newInstancesV2(NodeLabelRack)
+		instancesV2.client.(*mockOxideClient).SledListAllPagesError = oxide.ErrHTTP403
+
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(metadata.AdditionalLabels) != 0 || metadata.Zone != "" {
+			t.Fatalf("labels = %v, zone = %q, want none", metadata.AdditionalLabels, metadata.Zone)
+		}
+	})
+
+	t.Run("AnyCPUPlatform", func(t *testing.T) {
+		instancesV2 := newInstancesV2(NodeLabelCPUPlatform)
+		anyPlatform := instance
+		anyPlatform.CpuPlatform = ""
+		instancesV2.client.(*mockOxideClient).InstanceViewOutput = &anyPlatform
+
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(metadata.AdditionalLabels) != 0 {
+			t.Fatalf("labels = %v, want none", metadata.AdditionalLabels)
+		}
+	})
+
+	t.Run("SledMigration", func(t *testing.T) {
+		instancesV2 := newInstancesV2(NodeLabelRack)
+		client := instancesV2.client.(*mockOxideClient)
+
+		node := nodeWithProviderID.DeepCopy()
+		node.Labels = map[string]string{
+			LabelRack:              "rack-2",
+			v1.LabelTopologyZone:   "rack-2",
+			v1.LabelTopologyRegion: "rack-2",
+		}
+		k8sClient := fake.NewSimpleClientset(node)
+		instancesV2.k8sClient = k8sClient
+
+		zoneLabels := func() map[string]string {
+			got, _ := k8sClient.CoreV1().Nodes().Get(t.Context(), node.Name, metav1.GetOptions{})
+			return map[string]string{
+				LabelRack:              got.Labels[LabelRack],
+				v1.LabelTopologyZone:   got.Labels[v1.LabelTopologyZone],
+				v1.LabelTopologyRegion: got.Labels[v1.LabelTopologyRegion],
+			}
+		}
+
+		before, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// While the instance is migrating, neither sled lists it.
+		migrating := instance
+		migrating.RunState = oxide.InstanceStateMigrating
+		client.InstanceViewOutput = &migrating
+		client.SledInstanceListAllPagesOutput = nil
+
+		during, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if during.Zone != "rack-2" || during.AdditionalLabels[LabelRack] != "rack-2" {
+			t.Fatalf("zone = %q, labels = %v, want rack-2 during migration",
+				during.Zone, during.AdditionalLabels)
+		}
+		if labels := zoneLabels(); !maps.Equal(labels, node.Labels) {
+			t.Fatalf("node labels = %v, want %v during migration", labels, node.Labels)
+		}
+
+		// The instance settles on a sled in another rack.
+		client.InstanceViewOutput = &instance
+		client.SledInstanceListAllPagesOutput = map[string][]oxide.SledInstance{
+			"sled-1": {{Id: instance.Id}},
+		}
+
+		after, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if after.Zone != "rack-1" || after.AdditionalLabels[LabelRack] != "rack-1" {
+			t.Fatalf("zone = %q, labels = %v, want rack-1", after.Zone, after.AdditionalLabels)
+		}
+		if !slices.Equal(after.NodeAddresses, before.NodeAddresses) {
+			t.Fatalf("addresses = %v, want %v", after.NodeAddresses, before.NodeAddresses)
+		}
+
+		want := map[string]string{
+			LabelRack:              "rack-1",
+			v1.LabelTopologyZone:   "rack-1",
+			v1.LabelTopologyRegion: "rack-1",
+		}
+		if labels := zoneLabels(); !maps.Equal(labels, want) {
+			t.Fatalf("node labels = %v, want %v", labels, want)
+		}
+	})
+
+	t.Run("RemappedKeys", func(t *testing.T) {
+		instancesV2 := newInstancesV2(NodeLabelProject, NodeLabelSilo, NodeLabelRack)
+		instancesV2.labelKeys = map[string]string{
+			NodeLabelProject: "example.com/project",
+			NodeLabelRack:    "example.com/rack",
+		}
+
+		node := nodeWithProviderID.DeepCopy()
+		node.Labels = map[string]string{"example.com/rack": "rack-1"}
+		k8sClient := fake.NewSimpleClientset(node)
+		instancesV2.k8sClient = k8sClient
+
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{
+			"example.com/project": "test",
+			LabelSilo:             "silo-1",
+			"example.com/rack":    "rack-2",
+		}
+		if !maps.Equal(metadata.AdditionalLabels, want) {
+			t.Fatalf("labels = %v, want %v", metadata.AdditionalLabels, want)
+		}
+		if metadata.Zone != "rack-2" {
+			t.Fatalf("zone = %q, want %q", metadata.Zone, "rack-2")
+		}
+
+		// The remapped rack label is kept up to date like the default one.
+		got, _ := k8sClient.CoreV1().Nodes().Get(t.Context(), node.Name, metav1.GetOptions{})
+		if rack := got.Labels["example.com/rack"]; rack != "rack-2" {
+			t.Fatalf("rack label = %q, want %q", rack, "rack-2")
+		}
+	})
+
+	t.Run("DiskViewError", func(t *testing.T) {
+		instancesV2 := newInstancesV2(NodeLabelImage)
+		instancesV2.client.(*mockOxideClient).DiskViewError = errBoom
+
+		_, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want errBoom from disk view", err)
+		}
+	})
+}
+
+func TestInstanceRegions(t *testing.T) {
+	// Two regions, each served by its own endpoint. The instance only exists
+	// in the west region.
+	newInstancesV2 := func() *InstancesV2 {
+		west := &mockOxideClient{
+			InstanceViewOutput:                 &instanceRunning,
+			InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+			InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+		}
+		return &InstancesV2{
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+			regionClients: map[string]oxideInstanceClient{
+				"east": &mockOxideClient{
+					InstanceViewError: oxide.ErrObjectNotFound,
+				},
+				"west": west,
+			},
+		}
+	}
+
+	t.Run("SearchesAllRegionsWithoutLabel", func(t *testing.T) {
+		metadata, err := newInstancesV2().InstanceMetadata(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if metadata.Region != "west" {
+			t.Fatalf("region = %q, want %q", metadata.Region, "west")
+		}
+	})
+
+	t.Run("UsesLabeledRegion", func(t *testing.T) {
+		// Looking up the east region first would fail.
+		instancesV2 := newInstancesV2()
+		instancesV2.regionClients["east"] = &mockOxideClient{InstanceViewError: errBoom}
+
+		node := nodeWithProviderID.DeepCopy()
+		node.Labels = map[string]string{v1.LabelTopologyRegion: "west"}
+
+		metadata, err := instancesV2.InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if metadata.Region != "west" {
+			t.Fatalf("region = %q, want %q", metadata.Region, "west")
+		}
+	})
+
+	t.Run("FoundInOtherRegion", func(t *testing.T) {
+		node := nodeWithProviderID.DeepCopy()
+		node.Labels = map[string]string{v1.LabelTopologyRegion: "east"}
+
+		exists, err := newInstancesV2().InstanceExists(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists {
+			t.Fatal("expected instance missing from the labeled region to exist in another")
+		}
+
+		metadata, err := newInstancesV2().InstanceMetadata(t.Context(), node)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if metadata.Region != "west" {
+			t.Fatalf("region = %q, want the region the instance was found in", metadata.Region)
+		}
+	})
+
+	t.Run("UnconfiguredRegion", func(t *testing.T) {
+		node := nodeWithProviderID.DeepCopy()
+		node.Labels = map[string]string{v1.LabelTopologyRegion: "north"}
+
+		exists, err := newInstancesV2().InstanceExists(t.Context(), node)
+		if err == nil {
+			t.Fatal("expected error for a region without a configured endpoint")
+		}
+		if exists {
+			t.Fatal("expected exists=false on error")
+		}
+	})
+
+	t.Run("NotFoundInAnyRegion", func(t *testing.T) {
+		instancesV2 := newInstancesV2()
+		instancesV2.regionClients["west"] = &mockOxideClient{
+			InstanceViewError: oxide.ErrObjectNotFound,
+		}
+
+		exists, err := instancesV2.InstanceExists(t.Context(), &nodeWithProviderID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Fatal("expected instance to NOT exist in any region")
+		}
+	})
+}
+
+func TestInstanceEndpointAnnotation(t *testing.T) {
+	westInstance := instanceRunning
+	eastInstance := instanceRunning
+	eastInstance.Id = instIDNew
+	newClient := func(instance *oxide.Instance) *mockOxideClient {
+		return &mockOxideClient{
+			InstanceViewOutput:                 instance,
+			InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+			InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+		}
+	}
+	newNode := func(region string, annotations map[string]string) *v1.Node {
+		node := nodeWithProviderID.DeepCopy()
+		node.Annotations = annotations
+		if region != "" {
+			node.Labels = map[string]string{v1.LabelTopologyRegion: region}
+		}
+		return node
+	}
+
+	endpoints := (&Config{
+		Host: "https://default.oxide.example",
+		Regions: map[string]RegionConfig{
+			"east": {Host: "https://east.oxide.example"},
+			"west": {Host: "https://west.oxide.example"},
+		},
+	}).Endpoints()
+
+	tests := []struct {
+		name      string
+		node      *v1.Node
+		regions   bool
+		endpoints map[string]string
+		want      string
+	}{
+		{
+			name:      "West",
+			node:      newNode("west", nil),
+			regions:   true,
+			endpoints: endpoints,
+			want:      "https://west.oxide.example",
+		},
+		{
+			name:      "East",
+			node:      newNode("east", nil),
+			regions:   true,
+			endpoints: endpoints,
+			want:      "https://east.oxide.example",
+		},
+		{
+			name:      "Default",
+			node:      newNode("", nil),
+			endpoints: endpoints,
+			want:      "https://default.oxide.example",
+		},
+		{
+			name: "Disabled",
+			node: newNode("", map[string]string{
+				AnnotationEndpoint: "https://default.oxide.example",
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sClient := fake.NewSimpleClientset(tt.node.DeepCopy())
+			instancesV2 := &InstancesV2{
+				client:    newClient(&westInstance),
+				project:   "test",
+				k8sClient: k8sClient,
+				endpoints: tt.endpoints,
+			}
+			if tt.regions {
+				instancesV2.regionClients = map[string]oxideInstanceClient{
+					"east": newClient(&eastInstance),
+					"west": newClient(&westInstance),
+				}
+			}
+
+			if _, err := instancesV2.InstanceMetadata(t.Context(), tt.node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := k8sClient.CoreV1().Nodes().Get(
+				t.Context(), tt.node.Name, metav1.GetOptions{},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			value, ok := got.Annotations[AnnotationEndpoint]
+			if tt.want == "" && ok {
+				t.Fatalf("endpoint annotation = %q, want none", value)
+			}
+			if value != tt.want {
+				t.Fatalf("endpoint annotation = %q, want %q", value, tt.want)
+			}
+		})
+	}
+}
+
+func (c *mockOxideClient) InstanceNetworkInterfaceList(
+	context.Context,
+	oxide.InstanceNetworkInterfaceListParams,
+) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+	if c.InstanceNetworkInterfaceListError != nil {
+		return nil, c.InstanceNetworkInterfaceListError
+	}
+	return c.InstanceNetworkInterfaceListOutput, nil
+}
+
+func (c *mockOxideClient) InstanceExternalIpList(
+	context.Context,
+	oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	if c.InstanceExternalIpListError != nil {
+		return nil, c.InstanceExternalIpListError
+	}
+	return c.InstanceExternalIpListOutput, nil
+}
+
+func (c *mockOxideClient) InstanceView(
+	context.Context,
+	oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	if c.InstanceViewError != nil {
+		return nil, c.InstanceViewError
+	}
+	return c.InstanceViewOutput, nil
+}
+
+func (c *mockOxideClient) InstanceListAllPages(
+	context.Context,
+	oxide.InstanceListParams,
+) ([]oxide.Instance, error) {
+	if c.InstanceListAllPagesError != nil {
+		return nil, c.InstanceListAllPagesError
+	}
+	return c.InstanceListAllPagesOutput, nil
+}
+
+func (c *mockOxideClient) ProjectView(
+	context.Context,
+	oxide.ProjectViewParams,
+) (*oxide.Project, error) {
+	if c.ProjectViewError != nil {
+		return nil, c.ProjectViewError
+	}
+	return c.ProjectViewOutput, nil
+}
+
+func (c *mockOxideClient) CurrentUserView(context.Context) (*oxide.CurrentUser, error) {
+	if c.CurrentUserViewError != nil {
+		return nil, c.CurrentUserViewError
+	}
+	return c.CurrentUserViewOutput, nil
+}
+
+func (c *mockOxideClient) SledListAllPages(
+	context.Context,
+	oxide.SledListParams,
+) ([]oxide.Sled, error) {
+	if c.SledListAllPagesError != nil {
+		return nil, c.SledListAllPagesError
+	}
+	return c.SledListAllPagesOutput, nil
+}
+
+func (c *mockOxideClient) SledInstanceListAllPages(
+	_ context.Context,
+	params oxide.SledInstanceListParams,
+) ([]oxide.SledInstance, error) {
+	if c.SledInstanceListAllPagesError != nil {
+		return nil, c.SledInstanceListAllPagesError
+	}
+	return c.SledInstanceListAllPagesOutput[params.SledId], nil
+}
+
+func (c *mockOxideClient) DiskView(
+	context.Context,
+	oxide.DiskViewParams,
+) (*oxide.Disk, error) {
+	if c.DiskViewError != nil {
+		return nil, c.DiskViewError
+	}
+	return c.DiskViewOutput, nil
+}
+
+func (c *mockOxideClient) InstanceDiskListAllPages(
+	context.Context,
+	oxide.InstanceDiskListParams,
+) ([]oxide.Disk, error) {
+	if c.InstanceDiskListAllPagesError != nil {
+		return nil, c.InstanceDiskListAllPagesError
+	}
+	return c.InstanceDiskListAllPagesOutput, nil
+}
+
+// countingOxideClient wraps an [oxideInstanceClient] and counts the API calls
+// made through it, recording the parameters of instance views.
+type countingOxideClient struct {
+	oxideInstanceClient
+	calls int
+	views []oxide.InstanceViewParams
+}
+
+func (c *countingOxideClient) InstanceNetworkInterfaceList(
+	ctx context.Context,
+	params oxide.InstanceNetworkInterfaceListParams,
+) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+	c.calls++
+	return c.oxideInstanceClient.InstanceNetworkInterfaceList(ctx, params)
+}
+
+func (c *countingOxideClient) InstanceExternalIpList(
+	ctx context.Context,
+	params oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	c.calls++
+	return c.oxideInstanceClient.InstanceExternalIpList(ctx, params)
+}
+
+func (c *countingOxideClient) InstanceView(
+	ctx context.Context,
+	params oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	c.calls++
+	c.views = append(c.views, params)
+	return c.oxideInstanceClient.InstanceView(ctx, params)
+}
+
+func (c *countingOxideClient) InstanceListAllPages(
+	ctx context.Context,
+	params oxide.InstanceListParams,
+) ([]oxide.Instance, error) {
+	c.calls++
+	return c.oxideInstanceClient.InstanceListAllPages(ctx, params)
+}
+
+func (c *countingOxideClient) DiskView(
+	ctx context.Context,
+	params oxide.DiskViewParams,
+) (*oxide.Disk, error) {
+	c.calls++
+	return c.oxideInstanceClient.DiskView(ctx, params)
+}
+
+// BenchmarkInstanceMetadata measures the allocations and Oxide API calls made
+// to build the metadata of a node whose instance has a varying number of
+// network interfaces and external IPs. Compare runs with benchstat:
+//
+//	go test -run '^$' -bench BenchmarkInstanceMetadata -count 10 ./internal/provider
+//
+// Baseline on an Intel Xeon, linux/amd64:
+//
+//	nics=1/external-ips=0     5761 ns/op  3 calls/op   643 B/op  13 allocs/op
+//	nics=1/external-ips=2     7227 ns/op  3 calls/op   771 B/op  14 allocs/op
+//	nics=4/external-ips=8    10189 ns/op  3 calls/op  1539 B/op  16 allocs/op
+//	nics=16/external-ips=32  19357 ns/op  3 calls/op  4995 B/op  18 allocs/op
+func BenchmarkInstanceMetadata(b *testing.B) {
+	instance := instanceRunning
+
+	node := nodeWithProviderID.DeepCopy()
+	node.Annotations = map[string]string{AnnotationInstanceID: instance.Id}
+
+	for _, size := range []struct{ nics, externalIPs int }{
+		{1, 0}, {1, 2}, {4, 8}, {16, 32},
+	} {
+		nics := oxide.InstanceNetworkInterfaceResultsPage{}
+		for range size.nics {
+			nics.Items = append(nics.Items, nicsWithIPv4.Items[0])
+		}
+
+		externalIPs := oxide.ExternalIpResultsPage{}
+		for range size.externalIPs {
+			externalIPs.Items = append(externalIPs.Items, oxide.ExternalIp{
+				Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.20"},
+			})
+		}
+
+		name := fmt.Sprintf("nics=%d/external-ips=%d", size.nics, size.externalIPs)
+		b.Run(name, func(b *testing.B) {
+			client := &countingOxideClient{
+				oxideInstanceClient: &mockOxideClient{
+					InstanceViewOutput:                 &instance,
+					InstanceNetworkInterfaceListOutput: &nics,
+					InstanceExternalIpListOutput:       &externalIPs,
+				},
+			}
+			instancesV2 := &InstancesV2{
+				client:    client,
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(),
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := instancesV2.InstanceMetadata(b.Context(), node); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+			b.ReportMetric(float64(client.calls)/float64(b.N), "calls/op")
+		})
 	}
-	return c.InstanceViewOutput, nil
 }
@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// internalIPFilter selects which network interface addresses are reported as
+// node internal IPs by the CIDRs they are in. A nil filter reports every
+// address.
+type internalIPFilter struct {
+	// include, when not empty, are the only CIDRs whose addresses are
+	// reported.
+	include []netip.Prefix
+
+	// exclude are CIDRs whose addresses are never reported.
+	exclude []netip.Prefix
+}
+
+// newInternalIPFilter returns a filter that reports the addresses in the
+// include CIDRs, or all addresses when there are none, except those in the
+// exclude CIDRs. It returns nil when there are no CIDRs to filter by.
+func newInternalIPFilter(include, exclude []string) (*internalIPFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	includePrefixes, err := parseCIDRs(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid internal ip include cidr: %w", err)
+	}
+	excludePrefixes, err := parseCIDRs(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid internal ip exclude cidr: %w", err)
+	}
+
+	return &internalIPFilter{include: includePrefixes, exclude: excludePrefixes}, nil
+}
+
+// allows reports whether the address is reported as a node internal IP.
+// Addresses that cannot be parsed are not filtered.
+func (f *internalIPFilter) allows(address string) bool {
+	if f == nil {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+
+	contains := func(prefix netip.Prefix) bool { return prefix.Contains(addr) }
+	if len(f.include) > 0 && !slices.ContainsFunc(f.include, contains) {
+		return false
+	}
+	return !slices.ContainsFunc(f.exclude, contains)
+}
+
+// parseCIDRs parses the CIDRs into prefixes, masking any host bits.
+func parseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInternalIPFilter(t *testing.T) {
+	primary, secondary := true, false
+	nics := oxide.InstanceNetworkInterfaceResultsPage{
+		Items: []oxide.InstanceNetworkInterface{
+			{
+				Name:    "management",
+				Primary: &secondary,
+				IpStack: oxide.PrivateIpStack{
+					Value: &oxide.PrivateIpStackV4{Value: oxide.PrivateIpv4Stack{Ip: "10.1.0.5"}},
+				},
+			},
+			{
+				Name:    "net0",
+				Primary: &primary,
+				IpStack: oxide.PrivateIpStack{
+					Value: &oxide.PrivateIpStackDualStack{Value: oxide.PrivateIpStackDualStackValue{
+						V4: oxide.PrivateIpv4Stack{Ip: "172.30.0.5"},
+						V6: oxide.PrivateIpv6Stack{Ip: "fd00::5"},
+					}},
+				},
+			},
+		},
+	}
+
+	internalIP := func(ip string) v1.NodeAddress {
+		return v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip}
+	}
+
+	tt := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []v1.NodeAddress
+	}{
+		{
+			name: "no filtering",
+			expected: []v1.NodeAddress{
+				internalIP("172.30.0.5"), internalIP("fd00::5"), internalIP("10.1.0.5"),
+			},
+		},
+		{
+			name:     "exclude",
+			exclude:  []string{"10.1.0.0/16"},
+			expected: []v1.NodeAddress{internalIP("172.30.0.5"), internalIP("fd00::5")},
+		},
+		{
+			name:     "include only",
+			include:  []string{"172.30.0.0/22", "fd00::/8"},
+			expected: []v1.NodeAddress{internalIP("172.30.0.5"), internalIP("fd00::5")},
+		},
+		{
+			name:     "include and exclude",
+			include:  []string{"0.0.0.0/0"},
+			exclude:  []string{"10.1.0.5/32"},
+			expected: []v1.NodeAddress{internalIP("172.30.0.5")},
+		},
+		{
+			name:     "host bits masked",
+			exclude:  []string{"172.30.0.1/24", "fd00::1/64"},
+			expected: []v1.NodeAddress{internalIP("10.1.0.5")},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := newInternalIPFilter(tc.include, tc.exclude)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			instancesV2 := InstancesV2{
+				client: &mockOxideClient{
+					InstanceViewOutput:                 &instanceRunning,
+					InstanceNetworkInterfaceListOutput: &nics,
+					InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+				},
+				project:          "test",
+				k8sClient:        fake.NewSimpleClientset(),
+				nodeAddressTypes: []v1.NodeAddressType{v1.NodeInternalIP},
+				internalIPFilter: filter,
+			}
+
+			metadata, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(metadata.NodeAddresses, tc.expected) {
+				t.Fatalf("node addresses = %v, want %v", metadata.NodeAddresses, tc.expected)
+			}
+		})
+	}
+}
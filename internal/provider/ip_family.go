@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// checkIPFamily returns an [ErrIPFamilyMismatch] error, and records a warning
+// event on the service, when an address allocated by the allocator or from
+// one of the fallback pools would have an IP version that is not one of the
+// service's IP families. Oxide would allocate it, but kube-proxy never
+// forwards traffic for it. Services without IP families are not checked, and
+// neither are allocators whose IP version cannot be resolved, which fail when
+// the address is allocated instead.
+func (l *LoadBalancer) checkIPFamily(
+	ctx context.Context,
+	service *v1.Service,
+	allocator oxide.AddressAllocator,
+	fallbackPools []string,
+) error {
+	if len(service.Spec.IPFamilies) == 0 {
+		return nil
+	}
+
+	allocators := []oxide.AddressAllocator{allocator}
+	for _, pool := range fallbackPools {
+		allocators = append(allocators, explicitPoolAllocator(pool))
+	}
+
+	for _, allocator := range allocators {
+		version, source, err := l.allocatorIPVersion(ctx, allocator)
+		if err != nil {
+			return err
+		}
+		if version == "" || slices.Contains(service.Spec.IPFamilies, ipFamily(version)) {
+			continue
+		}
+
+		families := make([]string, 0, len(service.Spec.IPFamilies))
+		for _, family := range service.Spec.IPFamilies {
+			families = append(families, string(family))
+		}
+		if l.recorder != nil {
+			l.recorder.Eventf(service, v1.EventTypeWarning, EventReasonIPFamilyMismatch,
+				"Not allocating a floating IP, %s has %s addresses but the service's "+
+					"IP families are %s", source, ipFamily(version), strings.Join(families, ", "),
+			)
+		}
+		return fmt.Errorf(
+			"%w: %s has %s addresses, service ip families are %s",
+			ErrIPFamilyMismatch, source, ipFamily(version), strings.Join(families, ", "),
+		)
+	}
+
+	return nil
+}
+
+// allocatorIPVersion returns the IP version of the addresses the allocator
+// allocates, along with a description of where they come from. The version
+// is empty when the silo's default IP pool cannot be resolved.
+func (l *LoadBalancer) allocatorIPVersion(
+	ctx context.Context,
+	allocator oxide.AddressAllocator,
+) (oxide.IpVersion, string, error) {
+	if explicit, ok := allocator.AsExplicit(); ok {
+		addr, err := netip.ParseAddr(explicit.Ip)
+		if err != nil {
+			return "", "", fmt.Errorf(
+				"invalid %s value %q: %w", AnnotationFloatingIP, explicit.Ip, err,
+			)
+		}
+		source := "address " + explicit.Ip
+		if addr.Is4() {
+			return oxide.IpVersionV4, source, nil
+		}
+		return oxide.IpVersionV6, source, nil
+	}
+
+	auto, ok := allocator.AsAuto()
+	if !ok {
+		return "", "", nil
+	}
+
+	if ps, ok := auto.PoolSelector.AsExplicit(); ok {
+		pool, err := l.client.IpPoolView(ctx, oxide.IpPoolViewParams{Pool: ps.Pool})
+		if err != nil {
+			return "", "", fmt.Errorf("failed viewing ip pool %s: %w", ps.Pool, err)
+		}
+		return pool.IpVersion, fmt.Sprintf("ip pool %s", ps.Pool), nil
+	}
+
+	var version oxide.IpVersion
+	if ps, ok := auto.PoolSelector.AsAuto(); ok {
+		version = ps.IpVersion
+	}
+	pool, err := l.siloDefaultPool(ctx, version)
+	if err != nil {
+		klog.V(2).InfoS("skipping ip family check of unresolved default ip pool", "err", err)
+		return "", "", nil
+	}
+	return pool.IpVersion, fmt.Sprintf("default ip pool %s", pool.Name), nil
+}
+
+// ipFamily returns the Kubernetes IP family of the Oxide IP version.
+func ipFamily(version oxide.IpVersion) v1.IPFamily {
+	if version == oxide.IpVersionV6 {
+		return v1.IPv6Protocol
+	}
+	return v1.IPv4Protocol
+}
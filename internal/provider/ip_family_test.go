@@ -0,0 +1,173 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+)
+
+func TestCheckIPFamily(t *testing.T) {
+	pools := map[string]oxide.SiloIpPool{
+		"public-v4": {Id: "pool-public-v4", Name: "public-v4", IpVersion: oxide.IpVersionV4},
+		"public-v6": {Id: "pool-public-v6", Name: "public-v6", IpVersion: oxide.IpVersionV6},
+	}
+	viewPool := func(
+		_ context.Context, params oxide.IpPoolViewParams,
+	) (*oxide.SiloIpPool, error) {
+		pool, ok := pools[string(params.Pool)]
+		if !ok {
+			return nil, oxide.ErrObjectNotFound
+		}
+		return &pool, nil
+	}
+
+	ipv4 := []v1.IPFamily{v1.IPv4Protocol}
+	ipv6 := []v1.IPFamily{v1.IPv6Protocol}
+	dualStack := []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+
+	tests := []struct {
+		name        string
+		families    []v1.IPFamily
+		annotations map[string]string
+		// defaults are the silo's default IP pools, both by default.
+		defaults []oxide.SiloIpPool
+		errorMsg string
+	}{
+		{
+			name:        "MatchingPool",
+			families:    ipv4,
+			annotations: map[string]string{AnnotationFloatingIPPool: "public-v4"},
+		},
+		{
+			name:        "MismatchingPool",
+			families:    ipv6,
+			annotations: map[string]string{AnnotationFloatingIPPool: "public-v4"},
+			errorMsg:    "ip pool public-v4 has IPv4 addresses, service ip families are IPv6",
+		},
+		{
+			name:        "DualStack",
+			families:    dualStack,
+			annotations: map[string]string{AnnotationFloatingIPPool: "public-v6"},
+		},
+		{
+			name:        "MismatchingFallbackPool",
+			families:    ipv4,
+			annotations: map[string]string{AnnotationFloatingIPPool: "public-v4, public-v6"},
+			errorMsg:    "ip pool public-v6 has IPv6 addresses",
+		},
+		{
+			name:        "MismatchingVersion",
+			families:    ipv4,
+			annotations: map[string]string{AnnotationFloatingIPVersion: "v6"},
+			errorMsg:    "default ip pool default-v6 has IPv6 addresses",
+		},
+		{
+			name:     "MatchingDefaultPool",
+			families: ipv4,
+			defaults: []oxide.SiloIpPool{defaultV4Pool},
+		},
+		{
+			name:        "MismatchingAddress",
+			families:    ipv4,
+			annotations: map[string]string{AnnotationFloatingIP: "2001:db8::5"},
+			errorMsg:    "address 2001:db8::5 has IPv6 addresses",
+		},
+		{
+			name:        "NoFamilies",
+			annotations: map[string]string{AnnotationFloatingIPPool: "missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := tt.defaults
+			if defaults == nil {
+				defaults = []oxide.SiloIpPool{defaultV4Pool, defaultV6Pool}
+			}
+			recorder := record.NewFakeRecorder(10)
+			lb := &LoadBalancer{
+				client: &fakeOxideLBClient{
+					IpPoolViewFn:         viewPool,
+					IpPoolListAllPagesFn: listIPPools(defaults...),
+				},
+				defaultPools: &defaultPoolCache{},
+				recorder:     recorder,
+			}
+			svc := newLBService(tt.annotations)
+			svc.Spec.IPFamilies = tt.families
+
+			allocator, err := addressAllocatorFromAnnotations(tt.annotations)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = lb.checkIPFamily(t.Context(), svc, allocator, fallbackIPPools(tt.annotations))
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(recorder.Events) != 0 {
+					t.Fatalf("unexpected event: %s", <-recorder.Events)
+				}
+				return
+			}
+			if !errors.Is(err, ErrIPFamilyMismatch) || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Fatalf("err = %v, want ip family mismatch containing %q", err, tt.errorMsg)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, EventReasonIPFamilyMismatch) {
+					t.Fatalf("event = %q, want reason %s", event, EventReasonIPFamilyMismatch)
+				}
+			default:
+				t.Fatal("expected an ip family mismatch event")
+			}
+		})
+	}
+}
+
+func TestEnsureLoadBalancerIPFamilyMismatch(t *testing.T) {
+	client := &fakeOxideLBClient{
+		FloatingIpViewFn: func(
+			context.Context, oxide.FloatingIpViewParams,
+		) (*oxide.FloatingIp, error) {
+			return nil, oxide.ErrObjectNotFound
+		},
+		IpPoolViewFn: func(
+			context.Context, oxide.IpPoolViewParams,
+		) (*oxide.SiloIpPool, error) {
+			return &oxide.SiloIpPool{Name: "public-v4", IpVersion: oxide.IpVersionV4}, nil
+		},
+	}
+	svc := newLBService(map[string]string{AnnotationFloatingIPPool: "public-v4"})
+	svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol}
+	lb := &LoadBalancer{
+		project:      "test",
+		k8sClient:    fake.NewSimpleClientset(svc),
+		client:       client,
+		defaultPools: &defaultPoolCache{},
+		locks:        &keyMutex{},
+		clock:        clock.RealClock{},
+		recorder:     record.NewFakeRecorder(10),
+	}
+
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+
+	// The floating IP is never created, which would fail the test with an
+	// unexpected call.
+	_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+	if !errors.Is(err, ErrIPFamilyMismatch) {
+		t.Fatalf("err = %v, want ip family mismatch", err)
+	}
+}
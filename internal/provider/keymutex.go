@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import "sync"
+
+// keyMutex is a set of mutexes keyed by string. Holders of the same key
+// serialize while holders of different keys proceed in parallel. Mutexes are
+// released once no holder or waiter references them, so the set does not grow
+// with every key ever locked. A nil keyMutex locks nothing.
+type keyMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the mutex for a single key along with the number of holders and
+// waiters referencing it.
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the mutex for key and returns a function that unlocks it.
+func (m *keyMutex) lock(key string) (unlock func()) {
+	if m == nil {
+		return func() {}
+	}
+
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Names of the Oxide-derived node labels that can be enabled via the
+// nodeLabels configuration.
+const (
+	// NodeLabelProject labels nodes with the name of their Oxide project.
+	NodeLabelProject = "project"
+
+	// NodeLabelVPC labels nodes with the ID of the VPC of their primary
+	// network interface.
+	NodeLabelVPC = "vpc"
+
+	// NodeLabelImage labels nodes with the ID of the image their boot disk was
+	// created from.
+	NodeLabelImage = "image"
+
+	// NodeLabelRegion labels nodes with the configured region their instance
+	// was found in, or with their rack when no regions are configured.
+	NodeLabelRegion = "region"
+
+	// NodeLabelSilo labels nodes with the name of the silo of their Oxide
+	// project.
+	NodeLabelSilo = "silo"
+
+	// NodeLabelRack labels nodes with the ID of the rack their instance runs
+	// on. Resolving the rack requires the fleet viewer role; without it, the
+	// label is omitted. The rack is also reported as the node's zone, and as
+	// its region when no regions are configured, since each rack is an Oxide
+	// region of its own.
+	NodeLabelRack = "rack"
+
+	// NodeLabelZone labels nodes with their zone, which is the rack their
+	// instance runs on, for label conventions that name failure domains zones.
+	// Like the rack label, it requires the fleet viewer role, and the rack is
+	// also reported as the node's zone and region.
+	NodeLabelZone = "zone"
+
+	// NodeLabelCPUPlatform labels nodes with the CPU platform their instance
+	// requires, such as amd_milan. Instances that require no particular CPU
+	// platform are not labeled. The label complements rather than replaces the
+	// kubelet's kubernetes.io/arch label, which it leaves alone.
+	NodeLabelCPUPlatform = "cpu-platform"
+)
+
+// Keys of the Oxide-derived node labels.
+const (
+	LabelProject = "oxide.computer/project"
+	LabelVPC     = "oxide.computer/vpc-id"
+	LabelImage   = "oxide.computer/image-id"
+	LabelRegion  = "oxide.computer/region"
+	LabelSilo    = "topology.oxide.computer/silo"
+	LabelRack    = "topology.oxide.computer/rack"
+	LabelZone    = "topology.oxide.computer/zone"
+
+	LabelCPUPlatform = "oxide.computer/cpu-platform"
+)
+
+// nodeLabelKeys maps each node label name to its label key.
+var nodeLabelKeys = map[string]string{
+	NodeLabelProject: LabelProject,
+	NodeLabelVPC:     LabelVPC,
+	NodeLabelImage:   LabelImage,
+	NodeLabelRegion:  LabelRegion,
+	NodeLabelSilo:    LabelSilo,
+	NodeLabelRack:    LabelRack,
+	NodeLabelZone:    LabelZone,
+
+	NodeLabelCPUPlatform: LabelCPUPlatform,
+}
+
+// DefaultNodeLabels are the node labels applied when the nodeLabels
+// configuration is unset. They are limited to values that are shared by many
+// nodes and rarely change to avoid label churn.
+var DefaultNodeLabels = []string{NodeLabelProject, NodeLabelRegion}
+
+// validNodeLabelKey returns an error when key, configured for the node label
+// name, is not a valid label key.
+func validNodeLabelKey(name, key string) error {
+	if err := validNodeLabel(name); err != nil {
+		return err
+	}
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf(
+			"invalid key %q for node label %q: %s", key, name, strings.Join(errs, "; "),
+		)
+	}
+	return nil
+}
+
+// validNodeLabel returns an error when name is not a known node label.
+func validNodeLabel(name string) error {
+	if _, ok := nodeLabelKeys[name]; !ok {
+		return fmt.Errorf(
+			"unknown node label %q, must be one of %v",
+			name, slices.Sorted(maps.Keys(nodeLabelKeys)),
+		)
+	}
+	return nil
+}
+
+// additionalLabels returns the allowlisted Oxide-derived labels for the
+// instance. Labels whose value is unknown, or could not be looked up when
+// metadata is built on a best-effort basis, are omitted, and the boot disk is
+// only fetched when the image label is allowlisted.
+func (i *InstancesV2) additionalLabels(
+	ctx context.Context,
+	client oxideInstanceClient,
+	instance *oxide.Instance,
+	nics []oxide.InstanceNetworkInterface,
+	region string,
+) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	// The rack and zone labels share the rack, which is looked up once.
+	var (
+		rack         string
+		rackResolved bool
+	)
+
+	for _, name := range i.nodeLabels {
+		var value string
+
+		switch name {
+		case NodeLabelProject:
+			value = i.project
+		case NodeLabelRegion:
+			value = region
+		case NodeLabelVPC:
+			for _, nic := range nics {
+				if nic.Primary != nil && *nic.Primary {
+					value = nic.VpcId
+				}
+			}
+		case NodeLabelImage:
+			if instance.BootDiskId == "" {
+				continue
+			}
+
+			disk, err := client.DiskView(ctx, oxide.DiskViewParams{
+				Disk: oxide.NameOrId(instance.BootDiskId),
+			})
+			if err != nil {
+				err = fmt.Errorf("failed viewing boot disk: %w", err)
+				if err := i.degradeMetadata(instance, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			value = disk.ImageId
+		case NodeLabelSilo:
+			user, err := client.CurrentUserView(ctx)
+			if err != nil {
+				err = fmt.Errorf("failed viewing current user: %w", err)
+				if err := i.degradeMetadata(instance, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			value = string(user.SiloName)
+		case NodeLabelRack, NodeLabelZone:
+			if !rackResolved {
+				rackResolved = true
+				sled, err := i.sleds.instanceSled(ctx, client, region, instance)
+				if err != nil {
+					if err := i.degradeMetadata(instance, err); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if sled != nil {
+					rack = sled.RackId
+				}
+			}
+			value = rack
+		case NodeLabelCPUPlatform:
+			value = string(instance.CpuPlatform)
+		}
+
+		if value != "" {
+			labels[i.nodeLabelKey(name)] = value
+		}
+	}
+
+	return labels, nil
+}
+
+// rackLabelKeys returns the keys of the allowlisted node labels whose value is
+// the rack, which are the rack and zone labels.
+func (i *InstancesV2) rackLabelKeys() []string {
+	var keys []string
+	for _, name := range []string{NodeLabelRack, NodeLabelZone} {
+		if slices.Contains(i.nodeLabels, name) {
+			keys = append(keys, i.nodeLabelKey(name))
+		}
+	}
+	return keys
+}
+
+// nodeLabelKey returns the key of the node label name, as configured or
+// from [nodeLabelKeys].
+func (i *InstancesV2) nodeLabelKey(name string) string {
+	if key, ok := i.labelKeys[name]; ok {
+		return key
+	}
+	return nodeLabelKeys[name]
+}
@@ -5,20 +5,34 @@
 package provider
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net/netip"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/oxidecomputer/oxide.go/oxide"
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	servicecontroller "k8s.io/cloud-provider/controllers/service"
 	servicehelpers "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 )
 
 const (
@@ -29,13 +43,150 @@ const (
 	AnnotationFloatingIP = "oxide.computer/floating-ip"
 
 	// AnnotationFloatingIPPool specifies the IP pool to automatically allocate a
-	// floating IP from.
+	// floating IP from. A comma-separated list of pools specifies fallback pools
+	// that are tried in order when the preceding pools are exhausted. On a
+	// namespace, it specifies the pool of the namespace's services that don't
+	// specify one, taking precedence over the configured pools.
 	AnnotationFloatingIPPool = "oxide.computer/floating-ip-pool"
 
 	// AnnotationFloatingIPVersion specifies the IP version (e.g., `v4` or `v6`) of
 	// the floating IP to allocate, using the default IP pool for the IP version.
 	// Cannot be used when [AnnotationFloatingIPPool] is set.
 	AnnotationFloatingIPVersion = "oxide.computer/floating-ip-version"
+
+	// AnnotationFloatingIPProject specifies the Oxide project to create the
+	// floating IP in, such as a shared networking project, instead of the
+	// project of the nodes. The floating IP is still attached to a node's
+	// instance, which fails with an error naming both projects when Oxide does
+	// not allow attaching floating IPs across projects. Services sharing a
+	// floating IP must specify the same project. Changing the project of a
+	// service leaves its floating IP in the previous project behind, and
+	// floating IPs outside the nodes' project are not cleaned up when their
+	// service is changed or removed while the cloud controller manager is down.
+	AnnotationFloatingIPProject = "oxide.computer/floating-ip-project"
+
+	// AnnotationBackingNode is set by the cloud controller manager to the name
+	// of the Kubernetes node the floating IP is currently attached to. It is
+	// informational only and removed when the load balancer is deleted.
+	AnnotationBackingNode = "oxide.computer/backing-node"
+
+	// AnnotationBackingInstance is set by the cloud controller manager to the
+	// ID of the Oxide instance the floating IP is currently attached to. It is
+	// informational only and removed when the load balancer is deleted.
+	AnnotationBackingInstance = "oxide.computer/backing-instance"
+
+	// AnnotationBackingIPPool is set by the cloud controller manager to the ID
+	// of the IP pool the floating IP was allocated from. It is informational
+	// only and removed when the load balancer is deleted.
+	AnnotationBackingIPPool = "oxide.computer/backing-ip-pool"
+
+	// AnnotationSharedIPKey specifies a key that services use to share a single
+	// floating IP. Services with the same key must not expose the same port and
+	// protocol. The floating IP is allocated using the annotations of the first
+	// service to reference the key and deleted once no service references it.
+	AnnotationSharedIPKey = "oxide.computer/shared-ip-key"
+
+	// AnnotationFloatingIPDescription specifies text appended to the
+	// description of the floating IP, after the ownership record the cloud
+	// controller manager relies on to recognize its floating IPs.
+	AnnotationFloatingIPDescription = "oxide.computer/floating-ip-description"
+
+	// AnnotationPinnedNode specifies the name of the Kubernetes node to attach
+	// the floating IP to instead of selecting one automatically. When the node
+	// is missing or ineligible, the load balancer fails to sync rather than
+	// falling back to another node.
+	AnnotationPinnedNode = "oxide.computer/pinned-node"
+
+	// AnnotationIngressNodeSelector specifies a label selector (e.g.,
+	// `oxide.computer/ingress=true`) for the nodes the floating IP may be
+	// attached to, overriding the configured ingress node selector. An empty
+	// value allows any node.
+	AnnotationIngressNodeSelector = "oxide.computer/ingress-node-selector"
+
+	// AnnotationPreferExternalIPNodes, when set to "true", attaches the
+	// service's floating IP to a node whose instance has an external IP of
+	// its own, reported as a node ExternalIP address, for services whose
+	// backends need the node's external connectivity. When no eligible node
+	// has one, a warning event is recorded and any eligible node is used.
+	AnnotationPreferExternalIPNodes = "oxide.computer/prefer-external-ip-nodes"
+
+	// AnnotationHostname specifies a DNS name that resolves to the floating IP
+	// to report in the load balancer status, for consumers such as
+	// external-dns. See [AnnotationHostnameMode].
+	AnnotationHostname = "oxide.computer/hostname"
+
+	// AnnotationHostnameMode specifies whether [AnnotationHostname] is reported
+	// alongside the floating IP ([HostnameModeBoth], the default) or instead
+	// of it ([HostnameModeHostnameOnly]).
+	AnnotationHostnameMode = "oxide.computer/hostname-mode"
+
+	// AnnotationIgnore, when set to "true", stops the cloud controller manager
+	// from managing the service's load balancer, such as while debugging it by
+	// hand. Its floating IP is left untouched, even when the service is
+	// deleted, and its load balancer status is kept. The load balancer is still
+	// reported by GetLoadBalancer, which only reads it. Management resumes once
+	// the annotation is removed.
+	AnnotationIgnore = "oxide.computer/ignore"
+
+	// AnnotationAttachMode specifies how the service's floating IP is attached,
+	// overriding the configured attach mode. Only [AttachModeSingleNode] is
+	// supported.
+	AnnotationAttachMode = "oxide.computer/attach-mode"
+
+	// AnnotationOperationTimeout specifies how long a reconcile of the
+	// service's load balancer may take, overriding the configured load
+	// balancer operation timeout, such as for services on congested racks
+	// whose floating IPs are slow to attach. A Go duration between
+	// [minOperationTimeout] and [maxOperationTimeout].
+	AnnotationOperationTimeout = "oxide.computer/operation-timeout"
+
+	// AnnotationAddressType specifies the kind of external IP the service's
+	// load balancer uses. One of [AddressTypeStatic], the default, or
+	// [AddressTypeEphemeral]. Changing the address type of an existing load
+	// balancer leaves its previous address behind.
+	AnnotationAddressType = "oxide.computer/address-type"
+
+	// AnnotationBackingEphemeralIP is set by the cloud controller manager to
+	// the ephemeral IP it attached to the backing instance of a service with
+	// [AddressTypeEphemeral]. Only ephemeral IPs recorded here are used for or
+	// detached from load balancers. It is removed when the load balancer is
+	// deleted.
+	AnnotationBackingEphemeralIP = "oxide.computer/backing-ephemeral-ip"
+)
+
+// Bounds of [AnnotationOperationTimeout] and
+// [Config.LoadBalancerOperationTimeout].
+const (
+	minOperationTimeout = 10 * time.Second
+	maxOperationTimeout = time.Hour
+)
+
+// Values of [AnnotationHostnameMode].
+const (
+	HostnameModeBoth         = "both"
+	HostnameModeHostnameOnly = "hostname-only"
+)
+
+// Values of [AnnotationAddressType].
+const (
+	// AddressTypeStatic creates a named floating IP for the service, which is
+	// deleted or returned to the spare floating IPs along with its load
+	// balancer.
+	AddressTypeStatic = "static"
+
+	// AddressTypeEphemeral attaches an ephemeral IP to the instance of the
+	// node backing the service, and detaches it when the load balancer is
+	// deleted or moves to another node. The address changes whenever the load
+	// balancer moves. Services backed by the same node share its ephemeral IP.
+	AddressTypeEphemeral = "ephemeral"
+)
+
+// Values of [AnnotationAttachMode] and [Config.FloatingIPAttachMode].
+const (
+	// AttachModeSingleNode attaches the floating IP to the instance of a
+	// single node, moving it to another node when that node becomes
+	// ineligible.
+	AttachModeSingleNode = "single-node"
 )
 
 var _ cloudprovider.LoadBalancer = (*LoadBalancer)(nil)
@@ -49,6 +200,9 @@ type oxideLoadBalancerClient interface {
 	FloatingIpCreate(
 		context.Context, oxide.FloatingIpCreateParams,
 	) (*oxide.FloatingIp, error)
+	FloatingIpUpdate(
+		context.Context, oxide.FloatingIpUpdateParams,
+	) (*oxide.FloatingIp, error)
 	FloatingIpDelete(
 		context.Context, oxide.FloatingIpDeleteParams,
 	) error
@@ -61,6 +215,21 @@ type oxideLoadBalancerClient interface {
 	IpPoolView(
 		context.Context, oxide.IpPoolViewParams,
 	) (*oxide.SiloIpPool, error)
+	IpPoolListAllPages(
+		context.Context, oxide.IpPoolListParams,
+	) ([]oxide.SiloIpPool, error)
+	FloatingIpListAllPages(
+		context.Context, oxide.FloatingIpListParams,
+	) ([]oxide.FloatingIp, error)
+	InstanceExternalIpList(
+		context.Context, oxide.InstanceExternalIpListParams,
+	) (*oxide.ExternalIpResultsPage, error)
+	InstanceEphemeralIpAttach(
+		context.Context, oxide.InstanceEphemeralIpAttachParams,
+	) (*oxide.ExternalIp, error)
+	InstanceEphemeralIpDetach(
+		context.Context, oxide.InstanceEphemeralIpDetachParams,
+	) error
 }
 
 // LoadBalancer implements [cloudprovider.LoadBalancer] by attaching a
@@ -69,73 +238,262 @@ type LoadBalancer struct {
 	client    oxideLoadBalancerClient
 	project   string
 	k8sClient kubernetes.Interface
+
+	// defaultPool is the IP pool to allocate floating IPs from for services
+	// without floating IP annotations. When empty, the silo's default IP pool
+	// is used.
+	defaultPool string
+
+	// namespacePools maps namespaces to the IP pool to allocate floating IPs
+	// from for their services, overriding defaultPool.
+	namespacePools map[string]string
+
+	// defaultPools caches the silo's default IP pools.
+	defaultPools *defaultPoolCache
+
+	// namespacePoolCache caches the floating IP pools annotated on namespaces.
+	namespacePoolCache *namespacePoolCache
+
+	// locks serializes operations on the same floating IP, keyed by load
+	// balancer name, so that concurrent ensures and updates for a service (or
+	// services sharing a floating IP) cannot interleave their detaches and
+	// attaches.
+	locks *keyMutex
+
+	// ingressNodeSelector is the label selector for the nodes floating IPs may
+	// be attached to. Any node may be used when empty.
+	ingressNodeSelector string
+
+	// nodeScoring, when set, selects the eligible node with the highest score
+	// to attach floating IPs to. See [LoadBalancer.nodeScores].
+	nodeScoring *NodeScoringConfig
+
+	// minNodes is the number of nodes that must be eligible to back a floating
+	// IP before it is first attached. See [LoadBalancer.waitForNodes].
+	minNodes int
+
+	// operationTimeout bounds each reconcile of a load balancer for services
+	// without [AnnotationOperationTimeout]. Reconciles are only bounded by the
+	// Oxide request timeout when zero.
+	operationTimeout time.Duration
+
+	// attachMode is the attach mode of services without
+	// [AnnotationAttachMode]. [AttachModeSingleNode] is used when empty.
+	attachMode string
+
+	// failoverGracePeriod is how long the node backing a floating IP may be
+	// down before the floating IP moves to another node. See
+	// [LoadBalancer.backingNodeInGracePeriod].
+	failoverGracePeriod time.Duration
+
+	// poolUtilization is notified when floating IPs are created or deleted.
+	// It may be nil.
+	poolUtilization *poolUtilization
+
+	// spares are the preallocated floating IPs assigned to services instead of
+	// creating floating IPs. Nothing is preallocated when nil.
+	spares *spareFloatingIPs
+
+	// clusterName is the name of the cluster the cloud controller manager
+	// runs in. [LoadBalancer.cleanUpFloatingIPs] only cleans up the floating
+	// IPs of its services, since clusters may share a project.
+	clusterName string
+
+	// cleanupDryRun logs the floating IPs that [LoadBalancer.cleanUpFloatingIPs]
+	// would delete instead of deleting them.
+	cleanupDryRun bool
+
+	// recorder records events on services. No events are recorded when nil.
+	recorder record.EventRecorder
+
+	// clock is the source of time for retries.
+	clock clock.Clock
+
+	// attachBackoff is the backoff between attempts to attach a floating IP
+	// after a transient failure, and between views confirming that a floating
+	// IP is detached before deleting it. Neither is retried when its Steps is
+	// zero.
+	attachBackoff wait.Backoff
+}
+
+const (
+	// EventReasonUnsupportedPorts is the reason of the warning event recorded
+	// on services exposing ports that floating IPs do not forward.
+	EventReasonUnsupportedPorts = "UnsupportedPorts"
+
+	// EventReasonNoIngressNodes is the reason of the warning event recorded on
+	// services whose floating IP is attached outside of the ingress nodes
+	// because none of them is eligible.
+	EventReasonNoIngressNodes = "NoIngressNodes"
+
+	// EventReasonNoExternalIPNodes is the reason of the warning event recorded
+	// on services with [AnnotationPreferExternalIPNodes] whose floating IP is
+	// attached to a node without an external IP because no eligible node has
+	// one.
+	EventReasonNoExternalIPNodes = "NoExternalIPNodes"
+
+	// EventReasonWaitingForNodes is the reason of the event recorded on
+	// services whose floating IP is not attached yet because too few nodes
+	// are eligible to back it.
+	EventReasonWaitingForNodes = "WaitingForNodes"
+
+	// EventReasonIPFamilyMismatch is the reason of the warning event recorded
+	// on services whose floating IP would be allocated with an IP version that
+	// is not one of their IP families.
+	EventReasonIPFamilyMismatch = "IPFamilyMismatch"
+)
+
+// unsupportedProtocols are the service port protocols that Oxide floating IPs
+// do not forward traffic for.
+var unsupportedProtocols = []v1.Protocol{v1.ProtocolSCTP}
+
+// loadBalancerState is the management state of the floating IP of a service,
+// as seen by [LoadBalancer.GetLoadBalancer].
+type loadBalancerState int
+
+const (
+	// loadBalancerAbsent means the service has no floating IP.
+	loadBalancerAbsent loadBalancerState = iota
+
+	// loadBalancerForeign means a floating IP with the service's load balancer
+	// name exists but was not created by the cloud controller manager, since
+	// it has no ownership record.
+	loadBalancerForeign
+
+	// loadBalancerDegraded means the service's floating IP exists but is not
+	// attached to the instance of a Kubernetes node.
+	loadBalancerDegraded
+
+	// loadBalancerReady means the service's floating IP exists and is attached
+	// to the instance of a Kubernetes node.
+	loadBalancerReady
+)
+
+// exists reports whether the state is of a floating IP managed by the cloud
+// controller manager.
+func (s loadBalancerState) exists() bool {
+	return s == loadBalancerDegraded || s == loadBalancerReady
+}
+
+// String implements [fmt.Stringer].
+func (s loadBalancerState) String() string {
+	switch s {
+	case loadBalancerAbsent:
+		return "absent"
+	case loadBalancerForeign:
+		return "foreign"
+	case loadBalancerDegraded:
+		return "degraded"
+	case loadBalancerReady:
+		return "ready"
+	default:
+		return fmt.Sprintf("loadBalancerState(%d)", int(s))
+	}
 }
 
 // GetLoadBalancer returns the status of the floating IP "load balancer" for
 // the given service. It fetches the floating IP from Oxide, checks whether
 // the floating IP is attached to an instance that's a valid Kubernetes node,
 // and returns the load balancer status with the floating IP address and the
-// instance's internal IP addresses.
+// instance's internal IP addresses. A floating IP that was not created by the
+// cloud controller manager is reported as not existing, so that the service
+// controller never deletes a floating IP that merely shares the name.
 func (l *LoadBalancer) GetLoadBalancer(
 	ctx context.Context,
 	clusterName string,
 	service *v1.Service,
 ) (*v1.LoadBalancerStatus, bool, error) {
+	status, state, err := l.getLoadBalancer(ctx, clusterName, service)
+	if err != nil {
+		return nil, false, err
+	}
+
+	klog.V(4).InfoS("got load balancer", "service", klog.KObj(service), "state", state)
+	if !state.exists() {
+		return nil, false, nil
+	}
+	return status, true, nil
+}
+
+// getLoadBalancer returns the status and management state of the service's
+// floating IP. The status is nil unless the floating IP exists.
+func (l *LoadBalancer) getLoadBalancer(
+	ctx context.Context,
+	clusterName string,
+	service *v1.Service,
+) (*v1.LoadBalancerStatus, loadBalancerState, error) {
+	if isEphemeral(service) {
+		return l.getEphemeralLoadBalancer(ctx, service)
+	}
+
 	floatingIPName := l.GetLoadBalancerName(ctx, clusterName, service)
 
 	floatingIP, err := l.client.FloatingIpView(
 		ctx, oxide.FloatingIpViewParams{
 			FloatingIp: oxide.NameOrId(floatingIPName),
-			Project:    oxide.NameOrId(l.project),
+			Project:    oxide.NameOrId(l.floatingIPProject(service)),
 		},
 	)
 	if err != nil {
 		if errors.Is(err, oxide.ErrObjectNotFound) {
-			return nil, false, nil
+			return nil, loadBalancerAbsent, nil
 		}
-		return nil, false, fmt.Errorf(
-			"failed viewing floating ip: %w", err,
-		)
+		return nil, loadBalancerAbsent, floatingIPViewError(floatingIPName, err)
+	}
+
+	if _, owned := parseFloatingIPOwner(floatingIP.Description); !owned {
+		return toLoadBalancerStatus(service, floatingIP, nil), loadBalancerForeign, nil
 	}
 
 	// This floating IP isn't attached to an instance so we skip adding the node's
 	// internal IP addresses to the load balancer status.
 	if floatingIP.InstanceId == "" {
-		return toLoadBalancerStatus(floatingIP, nil), true, nil
+		return toLoadBalancerStatus(service, floatingIP, nil), loadBalancerDegraded, nil
+	}
+
+	// Find the Kubernetes node the floating IP is attached to. When no Kubernetes
+	// node is found we assume the node was recently removed and the floating IP has
+	// not yet been attached to a new node. In this case we return a load balancer
+	// status containing just the floating IP and rely on the next reconcile of
+	// [EnsureLoadBalancer] or [UpdateLoadBalancer] to attach the floating IP to a
+	// new node.
+	node, err := l.nodeForInstance(ctx, floatingIP.InstanceId)
+	if err != nil {
+		return nil, loadBalancerAbsent, err
+	}
+	if node == nil {
+		return toLoadBalancerStatus(service, floatingIP, nil), loadBalancerDegraded, nil
 	}
 
-	// Fetch all the Kubernetes nodes.
+	return toLoadBalancerStatus(service, floatingIP, node), loadBalancerReady, nil
+}
+
+// nodeForInstance returns the Kubernetes node of the Oxide instance, or nil
+// when no node has the instance's provider ID.
+func (l *LoadBalancer) nodeForInstance(ctx context.Context, instanceID string) (*v1.Node, error) {
 	nodes, err := l.k8sClient.CoreV1().Nodes().List(
 		ctx, metav1.ListOptions{},
 	)
 	if err != nil {
-		return nil, false, fmt.Errorf(
+		return nil, fmt.Errorf(
 			"failed listing kubernetes nodes: %w", err,
 		)
 	}
 
-	// Find the Kubernetes node the floating IP is attached to. When no Kubernetes
-	// node is found we assume the node was recently removed and the floating IP has
-	// not yet been attached to a new node. In this case we return a load balancer
-	// status containing just the floating IP and rely on the next reconcile of
-	// [EnsureLoadBalancer] or [UpdateLoadBalancer] to attach the floating IP to a
-	// new node.
-	providerID := NewProviderID(floatingIP.InstanceId)
 	index := slices.IndexFunc(nodes.Items, func(node v1.Node) bool {
-		return node.Spec.ProviderID == providerID
+		providerID, err := ParseProviderID(node.Spec.ProviderID)
+		return err == nil && providerID.InstanceID == instanceID
 	})
 	if index == -1 {
-		return toLoadBalancerStatus(floatingIP, nil), true, nil
+		return nil, nil
 	}
-
-	return toLoadBalancerStatus(
-		floatingIP, &nodes.Items[index],
-	), true, nil
+	return &nodes.Items[index], nil
 }
 
 // GetLoadBalancerName returns a stable load balancer name derived from
 // the cluster name, namespace, and service name, truncated to at most 63
-// characters.
+// characters. Services sharing a floating IP via [AnnotationSharedIPKey] use a
+// name derived from the cluster name and the key instead.
 func (l *LoadBalancer) GetLoadBalancerName(
 	ctx context.Context,
 	clusterName string,
@@ -145,6 +503,10 @@ func (l *LoadBalancer) GetLoadBalancerName(
 		"%s-%s-%s", clusterName, service.Namespace, service.Name,
 	)
 
+	if key := service.Annotations[AnnotationSharedIPKey]; key != "" {
+		name = fmt.Sprintf("%s-shared-%s", clusterName, key)
+	}
+
 	if len(name) > 63 {
 		name = name[:63]
 	}
@@ -155,14 +517,30 @@ func (l *LoadBalancer) GetLoadBalancerName(
 }
 
 // EnsureLoadBalancer creates a floating IP if it does not exist, attaches it to
-// the first node in nodes order by name, and returns the load balancer status
-// with the floating IP address and node's internal IP addresses.
+// the first node in nodes order by name, records that node on the service's
+// backing annotations, and returns the load balancer status with the floating
+// IP address and node's internal IP addresses.
 func (l *LoadBalancer) EnsureLoadBalancer(
 	ctx context.Context,
 	clusterName string,
 	service *v1.Service,
 	nodes []*v1.Node,
-) (*v1.LoadBalancerStatus, error) {
+) (_ *v1.LoadBalancerStatus, err error) {
+	if isIgnored(service) {
+		klog.InfoS("ignoring load balancer ensure", "service", klog.KObj(service))
+		return service.Status.LoadBalancer.DeepCopy(), nil
+	}
+
+	defer observeLBReconcile(lbOperationEnsure, time.Now(), &err)
+	defer l.locks.lock(l.GetLoadBalancerName(ctx, clusterName, service))()
+
+	timeout, err := serviceOperationTimeout(service, l.operationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
 	if service.Spec.ExternalTrafficPolicy != v1.ServiceExternalTrafficPolicyCluster {
 		return nil, fmt.Errorf(
 			"unsupported external traffic policy %q, only %q is supported",
@@ -175,26 +553,89 @@ func (l *LoadBalancer) EnsureLoadBalancer(
 		return nil, errors.New("no nodes for service")
 	}
 
-	targetNode := selectTargetNode(nodes)
+	if err := l.checkPortProtocols(service); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := serviceHostname(service); err != nil {
+		return nil, err
+	}
+
+	if _, err := serviceAttachMode(service, l.attachMode); err != nil {
+		return nil, err
+	}
+
+	if err := checkAddressType(service); err != nil {
+		return nil, err
+	}
+
+	if err := l.waitForNodes(service, nodes); err != nil {
+		return nil, err
+	}
+
+	ingressNodes, err := l.ingressNodes(service, nodes)
+	if err != nil {
+		return nil, err
+	}
+	ingressNodes = l.externalIPNodes(service, ingressNodes)
+
+	scores, err := l.nodeScores(ctx, clusterName, service, ingressNodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed scoring nodes: %w", err)
+	}
 
-	instanceID, err := InstanceIDFromProviderID(targetNode.Spec.ProviderID)
+	targetNode, _ := l.backingNodeInGracePeriod(service, ingressNodes)
+	if targetNode == nil {
+		targetNode, err = selectTargetNode(service, ingressNodes, scores)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	providerID, err := ParseProviderID(targetNode.Spec.ProviderID)
 	if err != nil {
 		return nil, fmt.Errorf("failed fetching instance id from provider id: %w", err)
 	}
 
+	if isEphemeral(service) {
+		return l.ensureEphemeralLoadBalancer(ctx, service, targetNode, providerID.InstanceID)
+	}
+
 	floatingIPName := l.GetLoadBalancerName(ctx, clusterName, service)
 
-	allocator, err := addressAllocatorFromAnnotations(
-		service.Annotations,
-	)
+	annotations, err := l.annotationsWithDefaultPool(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	allocator, err := addressAllocatorFromAnnotations(annotations)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"failed parsing annotations: %w", err,
 		)
 	}
+	fallbackPools := fallbackIPPools(annotations)
+
+	description, err := floatingIPDescription(floatingIPOwner{
+		Cluster:   clusterName,
+		Namespace: service.Namespace,
+		Service:   service.Name,
+		UID:       service.UID,
+	}, service.Annotations[AnnotationFloatingIPDescription])
+	if err != nil {
+		return nil, err
+	}
+
+	sharing, err := l.servicesSharingIP(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSharedPorts(service, sharing); err != nil {
+		return nil, err
+	}
 
 	floatingIP, err := l.ensureLoadBalancer(
-		ctx, floatingIPName, allocator,
+		ctx, service, floatingIPName, allocator, fallbackPools, description, len(sharing) > 0,
 	)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -204,28 +645,311 @@ func (l *LoadBalancer) EnsureLoadBalancer(
 	}
 
 	floatingIP, err = l.attachFloatingIPToInstance(
-		ctx, floatingIP, instanceID,
+		ctx, floatingIP, providerID.InstanceID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"failed attaching floating ip %s to instance: %w",
-			floatingIPName, err,
+			floatingIPName, l.crossProjectAttachError(service, err),
 		)
 	}
 
-	return toLoadBalancerStatus(floatingIP, targetNode), nil
+	err = l.patchBackingAnnotations(
+		ctx, service, targetNode.Name, providerID.InstanceID, floatingIP.IpPoolId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return toLoadBalancerStatus(service, floatingIP, targetNode), nil
+}
+
+// isIgnored reports whether the service's load balancer is not managed
+// because of [AnnotationIgnore].
+func isIgnored(service *v1.Service) bool {
+	return service.Annotations[AnnotationIgnore] == "true"
 }
 
 // selectTargetNode returns the node that should back the floating IP. It
-// picks the first node ordered by name so that [EnsureLoadBalancer] and
-// [UpdateLoadBalancer] always converge on the same node for a given node set.
-// Callers must ensure nodes is non-empty.
-func selectTargetNode(nodes []*v1.Node) *v1.Node {
-	sortedNodes := slices.Clone(nodes)
-	slices.SortStableFunc(sortedNodes, func(a, b *v1.Node) int {
-		return strings.Compare(a.Name, b.Name)
+// picks the first node ordered by name that passes [isEligibleLBNode] so that
+// [EnsureLoadBalancer] and [UpdateLoadBalancer] always converge on the same
+// node for a given node set. Since ineligible nodes are skipped, cordoning or
+// draining the node backing a floating IP moves the floating IP to another
+// node on the next update. A service with [AnnotationPinnedNode] gets the
+// named node, or an error when that node is missing or ineligible.
+//
+// When scores is not nil, nodes are ordered by descending score first, and
+// nodes with equal scores prefer the node already backing the floating IP, so
+// that it only moves when another node scores strictly higher.
+func selectTargetNode(
+	service *v1.Service,
+	nodes []*v1.Node,
+	scores map[string]int,
+) (*v1.Node, error) {
+	if name, ok := service.Annotations[AnnotationPinnedNode]; ok {
+		i := slices.IndexFunc(nodes, func(node *v1.Node) bool {
+			return node.Name == name
+		})
+		if i == -1 {
+			return nil, fmt.Errorf("pinned node %q is not a load balancer node", name)
+		}
+		if !isEligibleLBNode(nodes[i]) {
+			return nil, fmt.Errorf(
+				"pinned node %q is excluded, cordoned, not ready, or shut down", name,
+			)
+		}
+		return nodes[i], nil
+	}
+
+	eligibleNodes := slices.DeleteFunc(slices.Clone(nodes), func(node *v1.Node) bool {
+		return !isEligibleLBNode(node)
+	})
+	if len(eligibleNodes) == 0 {
+		return nil, fmt.Errorf(
+			"no eligible nodes for service, all %d nodes are excluded, cordoned, "+
+				"not ready, or shut down", len(nodes),
+		)
+	}
+
+	// backing ranks the node already backing the floating IP first.
+	backing := func(node *v1.Node) int {
+		if node.Name == service.Annotations[AnnotationBackingNode] {
+			return 0
+		}
+		return 1
+	}
+	slices.SortStableFunc(eligibleNodes, func(a, b *v1.Node) int {
+		if scores == nil {
+			return strings.Compare(a.Name, b.Name)
+		}
+		return cmp.Or(
+			cmp.Compare(scores[b.Name], scores[a.Name]),
+			cmp.Compare(backing(a), backing(b)),
+			strings.Compare(a.Name, b.Name),
+		)
+	})
+	return eligibleNodes[0], nil
+}
+
+// backingNodeInGracePeriod returns the node backing the service's floating IP
+// and the rest of the failover grace period when the node is ineligible only
+// because it is not ready or shut down, and has been for less than the grace
+// period, such as while its instance reboots. Keeping the floating IP on the
+// node avoids moving it away and back during routine reboots. It returns nil
+// when the grace period is disabled or over, when the node is cordoned or
+// excluded, which moves the floating IP right away, and when the time the
+// node went down is unknown.
+func (l *LoadBalancer) backingNodeInGracePeriod(
+	service *v1.Service,
+	nodes []*v1.Node,
+) (*v1.Node, time.Duration) {
+	if l.failoverGracePeriod == 0 {
+		return nil, 0
+	}
+	if _, pinned := service.Annotations[AnnotationPinnedNode]; pinned {
+		return nil, 0
+	}
+
+	i := slices.IndexFunc(nodes, func(node *v1.Node) bool {
+		return node.Name == service.Annotations[AnnotationBackingNode]
+	})
+	if i == -1 {
+		return nil, 0
+	}
+	node := nodes[i]
+
+	if excludedFromLoadBalancers(node) || node.Spec.Unschedulable || isEligibleLBNode(node) {
+		return nil, 0
+	}
+
+	var downSince time.Time
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady && condition.Status != v1.ConditionTrue {
+			downSince = condition.LastTransitionTime.Time
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != cloudproviderapi.TaintNodeShutdown || taint.TimeAdded == nil {
+			continue
+		}
+		if downSince.IsZero() || taint.TimeAdded.Time.Before(downSince) {
+			downSince = taint.TimeAdded.Time
+		}
+	}
+	if downSince.IsZero() {
+		return nil, 0
+	}
+
+	remaining := l.failoverGracePeriod - l.clock.Since(downSince)
+	if remaining <= 0 {
+		return nil, 0
+	}
+
+	klog.InfoS("keeping floating ip on backing node during failover grace period",
+		"service", klog.KObj(service), "node", klog.KObj(node), "remaining", remaining)
+	return node, remaining
+}
+
+// waitForNodesRetryAfter is how long to wait before retrying a service whose
+// floating IP is waiting for nodes to become eligible.
+const waitForNodesRetryAfter = 30 * time.Second
+
+// waitForNodes returns a retriable error when the service's floating IP is not
+// attached to a node yet and fewer than the minimum number of nodes are
+// eligible to back it. A service without any eligible node is left to fail
+// [selectTargetNode] instead.
+func (l *LoadBalancer) waitForNodes(service *v1.Service, nodes []*v1.Node) error {
+	if service.Annotations[AnnotationBackingNode] != "" {
+		return nil
+	}
+
+	eligible := 0
+	for _, node := range nodes {
+		if isEligibleLBNode(node) {
+			eligible++
+		}
+	}
+	if eligible == 0 || eligible >= l.minNodes {
+		return nil
+	}
+
+	klog.InfoS("waiting for nodes to back floating ip", "service", klog.KObj(service),
+		"eligibleNodes", eligible, "minNodes", l.minNodes)
+	if l.recorder != nil {
+		l.recorder.Eventf(service, v1.EventTypeNormal, EventReasonWaitingForNodes,
+			"Waiting for %d of %d required nodes to be eligible to back the floating IP",
+			l.minNodes-eligible, l.minNodes)
+	}
+
+	return cloudproviderapi.NewRetryError(fmt.Sprintf(
+		"waiting for nodes, %d of %d required nodes are eligible", eligible, l.minNodes,
+	), waitForNodesRetryAfter)
+}
+
+// ingressNodes returns the nodes matching the ingress node selector of the
+// service, from [AnnotationIngressNodeSelector] or the configuration, so that
+// floating IPs are only attached to dedicated ingress nodes. When no eligible
+// node matches, it warns and returns all nodes so that the floating IP keeps
+// receiving traffic. Services with [AnnotationPinnedNode] are not restricted.
+func (l *LoadBalancer) ingressNodes(service *v1.Service, nodes []*v1.Node) ([]*v1.Node, error) {
+	if _, pinned := service.Annotations[AnnotationPinnedNode]; pinned {
+		return nodes, nil
+	}
+
+	selector := l.ingressNodeSelector
+	if value, ok := service.Annotations[AnnotationIngressNodeSelector]; ok {
+		selector = value
+	}
+	if selector == "" {
+		return nodes, nil
+	}
+
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ingress node selector %q: %w", selector, err)
+	}
+
+	ingressNodes := slices.DeleteFunc(slices.Clone(nodes), func(node *v1.Node) bool {
+		return !parsed.Matches(labels.Set(node.Labels))
+	})
+	if slices.ContainsFunc(ingressNodes, isEligibleLBNode) {
+		return ingressNodes, nil
+	}
+
+	klog.InfoS("no eligible ingress nodes, falling back to all nodes",
+		"service", klog.KObj(service), "selector", selector, "ingressNodes", len(ingressNodes))
+	if l.recorder != nil {
+		l.recorder.Eventf(service, v1.EventTypeWarning, EventReasonNoIngressNodes,
+			"No eligible nodes match ingress node selector %q, using any eligible node",
+			selector,
+		)
+	}
+
+	return nodes, nil
+}
+
+// externalIPNodes returns the nodes with a node ExternalIP address for
+// services with [AnnotationPreferExternalIPNodes], so that their floating IP
+// is attached to an instance with external connectivity of its own. When no
+// eligible node has an external IP, it warns and returns all nodes so that the
+// floating IP is still attached. Services with [AnnotationPinnedNode] are not
+// restricted.
+func (l *LoadBalancer) externalIPNodes(service *v1.Service, nodes []*v1.Node) []*v1.Node {
+	if service.Annotations[AnnotationPreferExternalIPNodes] != "true" {
+		return nodes
+	}
+	if _, pinned := service.Annotations[AnnotationPinnedNode]; pinned {
+		return nodes
+	}
+
+	externalIPNodes := slices.DeleteFunc(slices.Clone(nodes), func(node *v1.Node) bool {
+		return !slices.ContainsFunc(node.Status.Addresses, func(address v1.NodeAddress) bool {
+			return address.Type == v1.NodeExternalIP
+		})
+	})
+	if slices.ContainsFunc(externalIPNodes, isEligibleLBNode) {
+		return externalIPNodes
+	}
+
+	klog.InfoS("no eligible nodes with an external ip, falling back to all nodes",
+		"service", klog.KObj(service), "externalIPNodes", len(externalIPNodes))
+	if l.recorder != nil {
+		l.recorder.Eventf(service, v1.EventTypeWarning, EventReasonNoExternalIPNodes,
+			"No eligible nodes have an external IP, using any eligible node; "+
+				"the service's backends may have no external connectivity",
+		)
+	}
+
+	return nodes
+}
+
+// isEligibleLBNode reports whether the node can back a floating IP. A node is
+// eligible unless it is excluded from load balancers, see
+// [excludedFromLoadBalancers], is cordoned, is not ready, or is tainted as shut
+// down by the cloud node lifecycle controller, which reflects the run state of
+// its Oxide instance. Any node role is eligible since every node runs
+// kube-proxy.
+func isEligibleLBNode(node *v1.Node) bool {
+	if excludedFromLoadBalancers(node) {
+		return false
+	}
+
+	if node.Spec.Unschedulable {
+		return false
+	}
+
+	ready := slices.ContainsFunc(node.Status.Conditions, func(c v1.NodeCondition) bool {
+		return c.Type == v1.NodeReady && c.Status == v1.ConditionTrue
+	})
+	if !ready {
+		return false
+	}
+
+	return !slices.ContainsFunc(node.Spec.Taints, func(taint v1.Taint) bool {
+		return taint.Key == cloudproviderapi.TaintNodeShutdown
+	})
+}
+
+// excludedFromLoadBalancers reports whether the node is excluded from load
+// balancers the way the service controller excludes it: it is labeled with
+// node.kubernetes.io/exclude-from-external-load-balancers set to true or to a
+// value that is not a bool, is being deleted, or is tainted for deletion by
+// the cluster autoscaler. Excluding the same nodes keeps the floating IP on
+// the node the service controller would pick.
+func excludedFromLoadBalancers(node *v1.Node) bool {
+	if value, ok := node.Labels[v1.LabelNodeExcludeBalancers]; ok {
+		if excluded, err := strconv.ParseBool(value); err != nil || excluded {
+			return true
+		}
+	}
+
+	if node.DeletionTimestamp != nil {
+		return true
+	}
+
+	return slices.ContainsFunc(node.Spec.Taints, func(taint v1.Taint) bool {
+		return taint.Key == servicecontroller.ToBeDeletedTaint
 	})
-	return sortedNodes[0]
 }
 
 // UpdateLoadBalancer updates the backend nodes for an existing load balancer.
@@ -245,14 +969,46 @@ func (l *LoadBalancer) UpdateLoadBalancer(
 	clusterName string,
 	service *v1.Service,
 	nodes []*v1.Node,
-) error {
+) (err error) {
+	if isIgnored(service) {
+		klog.InfoS("ignoring load balancer update", "service", klog.KObj(service))
+		return nil
+	}
+
+	defer observeLBReconcile(lbOperationUpdate, time.Now(), &err)
+	defer l.locks.lock(l.GetLoadBalancerName(ctx, clusterName, service))()
+
+	timeout, err := serviceOperationTimeout(service, l.operationTimeout)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
 	if len(nodes) == 0 {
 		return errors.New("no nodes for service")
 	}
 
-	targetNode := selectTargetNode(nodes)
+	ingressNodes, err := l.ingressNodes(service, nodes)
+	if err != nil {
+		return err
+	}
+	ingressNodes = l.externalIPNodes(service, ingressNodes)
 
-	instanceID, err := InstanceIDFromProviderID(targetNode.Spec.ProviderID)
+	scores, err := l.nodeScores(ctx, clusterName, service, ingressNodes)
+	if err != nil {
+		return fmt.Errorf("failed scoring nodes: %w", err)
+	}
+
+	targetNode, graceRemaining := l.backingNodeInGracePeriod(service, ingressNodes)
+	if targetNode == nil {
+		targetNode, err = selectTargetNode(service, ingressNodes, scores)
+		if err != nil {
+			return err
+		}
+	}
+
+	providerID, err := ParseProviderID(targetNode.Spec.ProviderID)
 	if err != nil {
 		return fmt.Errorf(
 			"failed fetching instance id from provider id: %w", err,
@@ -261,28 +1017,66 @@ func (l *LoadBalancer) UpdateLoadBalancer(
 
 	floatingIPName := l.GetLoadBalancerName(ctx, clusterName, service)
 
+	var status *v1.LoadBalancerStatus
+	if isEphemeral(service) {
+		status, err = l.ensureEphemeralLoadBalancer(
+			ctx, service, targetNode, providerID.InstanceID,
+		)
+	} else {
+		status, err = l.updateFloatingIP(
+			ctx, service, floatingIPName, targetNode, providerID.InstanceID,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = l.patchServiceStatus(service, status)
+	if err != nil || graceRemaining == 0 {
+		return err
+	}
+
+	// Check the backing node again once the grace period is over, so that
+	// the load balancer moves if the node is still down.
+	return cloudproviderapi.NewRetryError(fmt.Sprintf(
+		"backing node %s is down, keeping load balancer %s on it for up to %s",
+		targetNode.Name, floatingIPName, graceRemaining,
+	), graceRemaining)
+}
+
+// updateFloatingIP attaches the service's existing floating IP to the
+// instance of the target node, records them on the service's backing
+// annotations, and returns the load balancer status.
+func (l *LoadBalancer) updateFloatingIP(
+	ctx context.Context,
+	service *v1.Service,
+	floatingIPName string,
+	targetNode *v1.Node,
+	instanceID string,
+) (*v1.LoadBalancerStatus, error) {
 	floatingIP, err := l.client.FloatingIpView(
 		ctx, oxide.FloatingIpViewParams{
 			FloatingIp: oxide.NameOrId(floatingIPName),
-			Project:    oxide.NameOrId(l.project),
+			Project:    oxide.NameOrId(l.floatingIPProject(service)),
 		},
 	)
 	if err != nil {
-		return fmt.Errorf(
-			"failed viewing floating ip %s: %w", floatingIPName, err,
-		)
+		return nil, floatingIPViewError(floatingIPName, err)
 	}
 
-	floatingIP, err = l.attachFloatingIPToInstance(
-		ctx, floatingIP, instanceID,
-	)
+	floatingIP, err = l.attachFloatingIPToInstance(ctx, floatingIP, instanceID)
 	if err != nil {
-		return err
+		return nil, l.crossProjectAttachError(service, err)
 	}
 
-	return l.patchServiceStatus(
-		service, toLoadBalancerStatus(floatingIP, targetNode),
+	err = l.patchBackingAnnotations(
+		ctx, service, targetNode.Name, instanceID, floatingIP.IpPoolId,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	return toLoadBalancerStatus(service, floatingIP, targetNode), nil
 }
 
 // patchServiceStatus patches the service's load balancer status when it differs
@@ -314,64 +1108,505 @@ func (l *LoadBalancer) patchServiceStatus(
 	return nil
 }
 
-// EnsureLoadBalancerDeleted detaches and deletes the floating IP.
+// patchBackingAnnotations records the Kubernetes node and Oxide instance
+// backing the floating IP, and the IP pool it was allocated from, as
+// annotations on the service. Empty values remove the respective annotation.
+// It is a no-op when the annotations are already up to date and treats the
+// service parameter as read-only.
+func (l *LoadBalancer) patchBackingAnnotations(
+	ctx context.Context,
+	service *v1.Service,
+	nodeName string,
+	instanceID string,
+	poolID string,
+) error {
+	return l.patchAnnotations(ctx, service, map[string]string{
+		AnnotationBackingNode:        nodeName,
+		AnnotationBackingInstance:    instanceID,
+		AnnotationBackingIPPool:      poolID,
+		AnnotationBackingEphemeralIP: "",
+	})
+}
+
+// patchAnnotations sets the annotations on the service, removing those with
+// empty values. It is a no-op when the annotations are already up to date and
+// treats the service parameter as read-only.
+func (l *LoadBalancer) patchAnnotations(
+	ctx context.Context,
+	service *v1.Service,
+	annotations map[string]string,
+) error {
+	patch, err := annotationsMergePatch(service.Annotations, annotations)
+	if err != nil || patch == nil {
+		return err
+	}
+
+	_, err = l.k8sClient.CoreV1().Services(service.Namespace).Patch(
+		ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf(
+			"failed patching annotations for service %s/%s: %w",
+			service.Namespace, service.Name, err,
+		)
+	}
+
+	return nil
+}
+
+// EnsureLoadBalancerDeleted detaches and deletes the floating IP, or returns it
+// to the spare floating IPs, and removes the backing annotations from the
+// service. A floating IP shared via
+// [AnnotationSharedIPKey] is kept as long as another service references it.
+// The ephemeral IP of a service with [AddressTypeEphemeral] is detached unless
+// another service uses it.
 func (l *LoadBalancer) EnsureLoadBalancerDeleted(
 	ctx context.Context,
 	clusterName string,
 	service *v1.Service,
-) error {
+) (err error) {
+	if isIgnored(service) {
+		klog.InfoS("ignoring load balancer deletion, leaving its floating ip behind",
+			"service", klog.KObj(service))
+		return nil
+	}
+
+	defer observeLBReconcile(lbOperationDelete, time.Now(), &err)
+	defer l.locks.lock(l.GetLoadBalancerName(ctx, clusterName, service))()
+
+	// An invalid timeout must not keep the floating IP from being deleted.
+	timeout, err := serviceOperationTimeout(service, l.operationTimeout)
+	if err != nil {
+		klog.InfoS("ignoring invalid operation timeout of deleted service",
+			"service", klog.KObj(service), "err", err)
+		timeout = l.operationTimeout
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	if isEphemeral(service) {
+		return l.deleteEphemeralLoadBalancer(ctx, service)
+	}
+
+	sharing, err := l.servicesSharingIP(ctx, service)
+	if err != nil {
+		return err
+	}
+
+	if len(sharing) > 0 {
+		return l.patchBackingAnnotations(ctx, service, "", "", "")
+	}
+
 	floatingIPName := l.GetLoadBalancerName(ctx, clusterName, service)
 
 	floatingIP, err := l.client.FloatingIpView(
 		ctx, oxide.FloatingIpViewParams{
 			FloatingIp: oxide.NameOrId(floatingIPName),
-			Project:    oxide.NameOrId(l.project),
+			Project:    oxide.NameOrId(l.floatingIPProject(service)),
 		},
 	)
 	if err != nil {
 		if errors.Is(err, oxide.ErrObjectNotFound) {
+			return l.patchBackingAnnotations(ctx, service, "", "", "")
+		}
+		return floatingIPViewError(floatingIPName, err)
+	}
+
+	err = l.releaseFloatingIP(ctx, l.floatingIPProject(service), floatingIP)
+	if err != nil {
+		return err
+	}
+
+	return l.patchBackingAnnotations(ctx, service, "", "", "")
+}
+
+// deleteFloatingIP detaches and deletes the floating IP, and verifies that it
+// is gone so that a floating IP is never left behind attached to an instance.
+// A floating IP that is already detached or deleted, for example by an
+// earlier attempt whose response was lost, is not an error.
+func (l *LoadBalancer) deleteFloatingIP(ctx context.Context, floatingIP *oxide.FloatingIp) error {
+	if floatingIP.InstanceId != "" {
+		_, err := l.client.FloatingIpDetach(
+			ctx, oxide.FloatingIpDetachParams{
+				FloatingIp: oxide.NameOrId(floatingIP.Id),
+			},
+		)
+		if err != nil && !errors.Is(err, oxide.ErrObjectNotFound) {
+			return fmt.Errorf(
+				"failed detaching floating ip %s: %w",
+				floatingIP.Name, err,
+			)
+		}
+
+		if err := l.waitForDetach(ctx, floatingIP); err != nil {
+			return err
+		}
+	}
+
+	err := l.client.FloatingIpDelete(
+		ctx, oxide.FloatingIpDeleteParams{
+			FloatingIp: oxide.NameOrId(floatingIP.Id),
+		},
+	)
+	if err != nil && !errors.Is(err, oxide.ErrObjectNotFound) {
+		return fmt.Errorf(
+			"failed deleting floating ip %s: %w", floatingIP.Name, err,
+		)
+	}
+	l.poolUtilization.changed()
+
+	_, err = l.client.FloatingIpView(ctx, oxide.FloatingIpViewParams{
+		FloatingIp: oxide.NameOrId(floatingIP.Id),
+	})
+	switch {
+	case errors.Is(err, oxide.ErrObjectNotFound):
+		return nil
+	case err != nil:
+		return floatingIPViewError(string(floatingIP.Name), err)
+	default:
+		return fmt.Errorf("floating ip %s still exists after deleting it", floatingIP.Name)
+	}
+}
+
+// waitForDetach views the floating IP until it is no longer attached to an
+// instance, backing off between views. Oxide may still report a floating IP
+// as attached right after detaching it, and deleting it then fails because
+// it is in use. A floating IP that is not found counts as detached.
+func (l *LoadBalancer) waitForDetach(ctx context.Context, floatingIP *oxide.FloatingIp) error {
+	backoff := l.attachBackoff
+	for {
+		viewed, err := l.client.FloatingIpView(ctx, oxide.FloatingIpViewParams{
+			FloatingIp: oxide.NameOrId(floatingIP.Id),
+		})
+		if err != nil {
+			if errors.Is(err, oxide.ErrObjectNotFound) {
+				return nil
+			}
+			return floatingIPViewError(string(floatingIP.Name), err)
+		}
+		if viewed.InstanceId == "" {
 			return nil
 		}
+		if backoff.Steps == 0 {
+			return fmt.Errorf(
+				"floating ip %s still attached to instance %s after detaching it",
+				floatingIP.Name, viewed.InstanceId,
+			)
+		}
+
+		klog.V(2).InfoS("waiting for floating ip to be detached",
+			"floatingIP", floatingIP.Name, "instanceID", viewed.InstanceId)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"failed waiting for floating ip %s to be detached: %w", floatingIP.Name, ctx.Err(),
+			)
+		case <-l.clock.After(backoff.Step()):
+		}
+	}
+}
+
+// serviceHostname returns the service's [AnnotationHostname] and whether it
+// replaces the floating IP in the load balancer status, or an error when
+// either annotation is invalid.
+func serviceHostname(service *v1.Service) (string, bool, error) {
+	hostname := service.Annotations[AnnotationHostname]
+	mode := service.Annotations[AnnotationHostnameMode]
+
+	switch mode {
+	case "", HostnameModeBoth, HostnameModeHostnameOnly:
+	default:
+		return "", false, fmt.Errorf(
+			"invalid %s value %q, must be %q or %q",
+			AnnotationHostnameMode, mode, HostnameModeBoth, HostnameModeHostnameOnly,
+		)
+	}
+
+	if hostname == "" {
+		if mode == HostnameModeHostnameOnly {
+			return "", false, fmt.Errorf(
+				"%s %q requires %s", AnnotationHostnameMode, mode, AnnotationHostname,
+			)
+		}
+		return "", false, nil
+	}
+
+	if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+		return "", false, fmt.Errorf(
+			"invalid %s value %q: %s",
+			AnnotationHostname, hostname, strings.Join(errs, ", "),
+		)
+	}
+
+	return hostname, mode == HostnameModeHostnameOnly, nil
+}
+
+// withTimeout returns ctx bounded by timeout, or only cancelable when timeout
+// is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// serviceOperationTimeout returns the service's [AnnotationOperationTimeout],
+// or defaultTimeout when unannotated, or an error when the annotation is not a
+// duration between [minOperationTimeout] and [maxOperationTimeout].
+func serviceOperationTimeout(
+	service *v1.Service,
+	defaultTimeout time.Duration,
+) (time.Duration, error) {
+	value, ok := service.Annotations[AnnotationOperationTimeout]
+	if !ok {
+		return defaultTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %w", AnnotationOperationTimeout, value, err)
+	}
+	if err := checkOperationTimeout(timeout); err != nil {
+		return 0, fmt.Errorf("invalid %s value %q: %w", AnnotationOperationTimeout, value, err)
+	}
+	return timeout, nil
+}
+
+// checkOperationTimeout returns an error when the operation timeout is out of
+// bounds.
+func checkOperationTimeout(timeout time.Duration) error {
+	if timeout < minOperationTimeout || timeout > maxOperationTimeout {
 		return fmt.Errorf(
-			"failed viewing floating ip %s: %w", floatingIPName, err,
+			"operation timeout must be between %s and %s", minOperationTimeout, maxOperationTimeout,
 		)
 	}
+	return nil
+}
+
+// serviceAttachMode returns the service's [AnnotationAttachMode], or
+// defaultMode when unannotated, or an error when the mode is unknown.
+func serviceAttachMode(service *v1.Service, defaultMode string) (string, error) {
+	mode, ok := service.Annotations[AnnotationAttachMode]
+	if !ok {
+		mode = cmp.Or(defaultMode, AttachModeSingleNode)
+	}
+	if err := checkAttachMode(mode); err != nil {
+		return "", fmt.Errorf("invalid %s value: %w", AnnotationAttachMode, err)
+	}
+	return mode, nil
+}
+
+// checkAttachMode returns an error when the attach mode is unknown.
+func checkAttachMode(mode string) error {
+	if mode != AttachModeSingleNode {
+		return fmt.Errorf("unknown attach mode %q, must be %q", mode, AttachModeSingleNode)
+	}
+	return nil
+}
+
+// servicesSharingIP returns the other load balancer services that share a
+// floating IP with the given service via [AnnotationSharedIPKey]. Services
+// being deleted are not counted.
+func (l *LoadBalancer) servicesSharingIP(
+	ctx context.Context,
+	service *v1.Service,
+) ([]v1.Service, error) {
+	key := service.Annotations[AnnotationSharedIPKey]
+	if key == "" {
+		return nil, nil
+	}
+
+	if err := checkSharedIPKey(key); err != nil {
+		return nil, err
+	}
+
+	services, err := l.k8sClient.CoreV1().Services(metav1.NamespaceAll).List(
+		ctx, metav1.ListOptions{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed listing kubernetes services: %w", err,
+		)
+	}
+
+	sharing := make([]v1.Service, 0)
+	for _, other := range services.Items {
+		if other.Namespace == service.Namespace && other.Name == service.Name {
+			continue
+		}
+
+		if other.Spec.Type != v1.ServiceTypeLoadBalancer ||
+			other.DeletionTimestamp != nil ||
+			other.Annotations[AnnotationSharedIPKey] != key {
+			continue
+		}
+
+		sharing = append(sharing, other)
+	}
+
+	return sharing, nil
+}
+
+// checkSharedIPKey returns an error when the [AnnotationSharedIPKey] value is
+// set but not a DNS label.
+func checkSharedIPKey(key string) error {
+	if key == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(key); len(errs) > 0 {
+		return fmt.Errorf(
+			"invalid %s value %q: %s",
+			AnnotationSharedIPKey, key, strings.Join(errs, ", "),
+		)
+	}
+	return nil
+}
+
+// checkPortProtocols checks the service's ports against the protocols floating
+// IPs forward. A service without any supported port is an error, which the
+// service controller reports as a warning event, rather than attaching a
+// floating IP that cannot carry its traffic. A service with only some
+// unsupported ports gets a floating IP for its supported ports and a warning
+// event about the others. Named target ports need no check since kube-proxy
+// resolves them on the node.
+func (l *LoadBalancer) checkPortProtocols(service *v1.Service) error {
+	unsupported, err := unsupportedPorts(service)
+	if err != nil || len(unsupported) == 0 {
+		return err
+	}
+
+	if l.recorder != nil {
+		l.recorder.Eventf(service, v1.EventTypeWarning, EventReasonUnsupportedPorts,
+			"Ports %s will not receive traffic, Oxide floating IPs only forward TCP and UDP",
+			strings.Join(unsupported, ", "),
+		)
+	}
+
+	return nil
+}
+
+// unsupportedPorts returns the service's ports whose protocol floating IPs do
+// not forward, or an error when none of its ports is supported.
+func unsupportedPorts(service *v1.Service) ([]string, error) {
+	unsupported := make([]string, 0)
+	for _, port := range service.Spec.Ports {
+		if slices.Contains(unsupportedProtocols, port.Protocol) {
+			unsupported = append(unsupported, formatServicePort(port))
+		}
+	}
+
+	if len(unsupported) > 0 && len(unsupported) == len(service.Spec.Ports) {
+		return nil, fmt.Errorf(
+			"unsupported ports %s, oxide floating ips only forward tcp and udp",
+			strings.Join(unsupported, ", "),
+		)
+	}
+
+	return unsupported, nil
+}
+
+// formatServicePort formats the port as port/protocol, prefixed by its name
+// when set.
+func formatServicePort(port v1.ServicePort) string {
+	formatted := fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+	if port.Name != "" {
+		formatted = port.Name + " (" + formatted + ")"
+	}
+	return formatted
+}
 
-	if floatingIP.InstanceId != "" {
-		_, err = l.client.FloatingIpDetach(
-			ctx, oxide.FloatingIpDetachParams{
-				FloatingIp: oxide.NameOrId(floatingIP.Id),
-			},
-		)
-		if err != nil {
+// checkSharedPorts returns an error when the service exposes a port and
+// protocol that is already exposed by one of the services sharing its floating
+// IP.
+func checkSharedPorts(service *v1.Service, sharing []v1.Service) error {
+	for _, other := range sharing {
+		if port, ok := conflictingPort(service, &other); ok {
 			return fmt.Errorf(
-				"failed detaching floating ip %s: %w",
-				floatingIPName, err,
+				"port %d/%s is already exposed on shared ip %q by service %s/%s",
+				port.Port, port.Protocol, service.Annotations[AnnotationSharedIPKey],
+				other.Namespace, other.Name,
 			)
 		}
 	}
 
-	err = l.client.FloatingIpDelete(
-		ctx, oxide.FloatingIpDeleteParams{
-			FloatingIp: oxide.NameOrId(floatingIP.Id),
-		},
-	)
-	if err != nil {
-		return fmt.Errorf(
-			"failed deleting floating ip %s: %w", floatingIPName, err,
-		)
+	return nil
+}
+
+// conflictingPort returns the first port of the service whose port and
+// protocol the other service also exposes.
+func conflictingPort(service, other *v1.Service) (v1.ServicePort, bool) {
+	for _, port := range service.Spec.Ports {
+		conflict := slices.ContainsFunc(other.Spec.Ports, func(p v1.ServicePort) bool {
+			return p.Port == port.Port && p.Protocol == port.Protocol
+		})
+		if conflict {
+			return port, true
+		}
 	}
+	return v1.ServicePort{}, false
+}
 
-	return nil
+// floatingIPAttachBackoff is the backoff between attempts to attach a floating
+// IP after a transient failure.
+var floatingIPAttachBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    3,
 }
 
 // attachFloatingIPToInstance attaches a floating IP to the given instance. If
 // the floating IP is already attached to the instance, this is a no-op. If
 // the floating IP is attached to a different instance, it is detached first.
+//
+// An attach right after a detach can observe the floating IP as still
+// attached, and a detach can observe it as already detached, while Oxide
+// settles. Such failures are retried with [LoadBalancer.attachBackoff],
+// re-viewing the floating IP before each retry so that only the steps that
+// have not taken effect yet are repeated.
 func (l *LoadBalancer) attachFloatingIPToInstance(
 	ctx context.Context,
 	floatingIP *oxide.FloatingIp,
 	instanceID string,
+) (*oxide.FloatingIp, error) {
+	backoff := l.attachBackoff
+	for {
+		attached, err := l.moveFloatingIP(ctx, floatingIP, instanceID)
+		if err == nil {
+			return attached, nil
+		}
+		if backoff.Steps == 0 || !isTransientAttachError(err) {
+			return nil, err
+		}
+
+		klog.V(2).InfoS("retrying floating ip attachment",
+			"floatingIP", floatingIP.Name, "instanceID", instanceID, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf(
+				"failed attaching floating ip %s: %w", floatingIP.Name, ctx.Err(),
+			)
+		case <-l.clock.After(backoff.Step()):
+		}
+
+		viewed, err := l.client.FloatingIpView(ctx, oxide.FloatingIpViewParams{
+			FloatingIp: oxide.NameOrId(floatingIP.Id),
+		})
+		if err != nil {
+			return nil, floatingIPViewError(string(floatingIP.Name), err)
+		}
+		floatingIP = viewed
+	}
+}
+
+// moveFloatingIP makes a single attempt at attaching a floating IP to the
+// given instance, detaching it from another instance first.
+func (l *LoadBalancer) moveFloatingIP(
+	ctx context.Context,
+	floatingIP *oxide.FloatingIp,
+	instanceID string,
 ) (*oxide.FloatingIp, error) {
 	if floatingIP.InstanceId == instanceID {
 		return floatingIP, nil
@@ -389,8 +1624,10 @@ func (l *LoadBalancer) attachFloatingIPToInstance(
 				floatingIP.Name, err,
 			)
 		}
+		lbReattachTotal.Inc()
 	}
 
+	// Instances are the only parent the Oxide API attaches floating IPs to.
 	floatingIP, err := l.client.FloatingIpAttach(
 		ctx, oxide.FloatingIpAttachParams{
 			FloatingIp: oxide.NameOrId(floatingIP.Id),
@@ -410,42 +1647,111 @@ func (l *LoadBalancer) attachFloatingIPToInstance(
 	return floatingIP, nil
 }
 
+// floatingIPProject returns the project the service's floating IP lives in,
+// which is [AnnotationFloatingIPProject] when set and the nodes' project
+// otherwise.
+func (l *LoadBalancer) floatingIPProject(service *v1.Service) string {
+	return cmp.Or(service.Annotations[AnnotationFloatingIPProject], l.project)
+}
+
+// crossProjectAttachError explains an attach rejected as invalid when the
+// service's floating IP lives in another project than the nodes, which Oxide
+// may not support. Other errors are returned unchanged.
+func (l *LoadBalancer) crossProjectAttachError(service *v1.Service, err error) error {
+	project := l.floatingIPProject(service)
+	if project == l.project || !errors.Is(err, oxide.ErrInvalidRequest) {
+		return err
+	}
+	return fmt.Errorf(
+		"oxide rejected attaching a floating ip in project %q to an instance in project %q, "+
+			"check that floating ips can be attached across projects or remove %s: %w",
+		project, l.project, AnnotationFloatingIPProject, err,
+	)
+}
+
+// isTransientAttachError reports whether a failed attach or detach may succeed
+// when retried, because Oxide rejected it based on an attachment that was
+// about to change.
+func isTransientAttachError(err error) bool {
+	return errors.Is(err, oxide.ErrInvalidRequest) || errors.Is(err, oxide.ErrConflict)
+}
+
 // ensureLoadBalancer returns the existing floating IP if it matches
 // the desired allocator, or deletes and recreates it if the
 // configuration has changed. Creates a new one if it does not
-// exist.
+// exist. A floating IP that is shared with other services is never
+// recreated since that would change their address too. A floating IP
+// allocated from one of the fallback pools matches the allocator. A floating
+// IP whose ownership record names a different UID than the service's was
+// created for a deleted service of the same name and is recreated. The
+// description of an existing floating IP tagged as owned by the cloud
+// controller manager is updated when it differs.
 func (l *LoadBalancer) ensureLoadBalancer(
 	ctx context.Context,
+	service *v1.Service,
 	name string,
 	allocator oxide.AddressAllocator,
+	fallbackPools []string,
+	description string,
+	shared bool,
 ) (*oxide.FloatingIp, error) {
+	project := l.floatingIPProject(service)
 	fip, err := l.client.FloatingIpView(
 		ctx, oxide.FloatingIpViewParams{
 			FloatingIp: oxide.NameOrId(name),
-			Project:    oxide.NameOrId(l.project),
+			Project:    oxide.NameOrId(project),
 		},
 	)
 	if err != nil {
 		if !errors.Is(err, oxide.ErrObjectNotFound) {
+			return nil, floatingIPViewError(name, err)
+		}
+
+		if err := l.checkIPFamily(ctx, service, allocator, fallbackPools); err != nil {
+			return nil, err
+		}
+
+		fip, assigned, err := l.assignSpareFloatingIP(ctx, project, name, allocator, description)
+		if err != nil || assigned {
+			return fip, err
+		}
+		return l.createFloatingIP(ctx, project, name, allocator, fallbackPools, description)
+	}
+
+	if shared {
+		return fip, nil
+	}
+
+	// Default pools only select the pool of new floating IPs, so that
+	// configuring or changing them never replaces the floating IPs of
+	// services without floating IP annotations.
+	var needsRecreate bool
+	if hasFloatingIPAnnotations(service) {
+		needsRecreate, err = l.floatingIPNeedsRecreate(
+			ctx, fip, allocator, fallbackPools,
+		)
+		if err != nil {
 			return nil, fmt.Errorf(
-				"failed viewing floating ip %s: %w", name, err,
+				"failed checking if floating ip %s needs recreate: %w",
+				name, err,
 			)
 		}
-		return l.createFloatingIP(ctx, name, allocator)
 	}
 
-	needsRecreate, err := l.floatingIPNeedsRecreate(
-		ctx, fip, allocator,
-	)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"failed checking if floating ip %s needs recreate: %w",
-			name, err,
-		)
+	// A floating IP left behind by a deleted service with the same name must
+	// not carry over to its successor.
+	if ownedByOtherService(fip.Description, service.UID) {
+		klog.InfoS("recreating floating ip owned by a previous service",
+			"service", klog.KObj(service), "floatingIP", name, "uid", service.UID)
+		needsRecreate = true
 	}
 
 	if !needsRecreate {
-		return fip, nil
+		return l.updateFloatingIPDescription(ctx, fip, description)
+	}
+
+	if err := l.checkIPFamily(ctx, service, allocator, fallbackPools); err != nil {
+		return nil, err
 	}
 
 	if fip.InstanceId != "" {
@@ -473,32 +1779,315 @@ func (l *LoadBalancer) ensureLoadBalancer(
 		)
 	}
 
-	return l.createFloatingIP(ctx, name, allocator)
+	return l.createFloatingIP(ctx, project, name, allocator, fallbackPools, description)
 }
 
-// createFloatingIP creates a new floating IP with the given name and allocator.
+// createFloatingIP creates a new floating IP with the given name and allocator
+// in project.
+// An allocator without an explicit pool is resolved to the silo's default IP
+// pool first. When the allocator's pool is exhausted, the floating IP is
+// allocated from the first fallback pool that is not exhausted.
+//
+// The silo's default IP pool is cached, so it may have been deleted or
+// replaced since it was resolved. When it is not found, the default pool is
+// resolved again and the floating IP created once more.
 func (l *LoadBalancer) createFloatingIP(
 	ctx context.Context,
+	project string,
+	name string,
+	allocator oxide.AddressAllocator,
+	fallbackPools []string,
+	description string,
+) (*oxide.FloatingIp, error) {
+	resolved, err := l.withDefaultPool(ctx, allocator)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed resolving ip pool for floating ip %s: %w", name, err,
+		)
+	}
+
+	fip, err := l.createFloatingIPFrom(ctx, project, name, resolved, fallbackPools, description)
+	if !errors.Is(err, oxide.ErrObjectNotFound) ||
+		allocatorPool(resolved) == allocatorPool(allocator) {
+		return fip, err
+	}
+
+	klog.InfoS("default ip pool not found, resolving it again",
+		"floatingIP", name, "pool", allocatorPool(resolved))
+	l.defaultPools.invalidate()
+
+	resolved, err = l.withDefaultPool(ctx, allocator)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed resolving ip pool for floating ip %s: %w", name, err,
+		)
+	}
+
+	return l.createFloatingIPFrom(ctx, project, name, resolved, fallbackPools, description)
+}
+
+// createFloatingIPFrom creates a new floating IP with the given name from the
+// allocator, falling back to the fallback pools in order while the preceding
+// pools are exhausted.
+func (l *LoadBalancer) createFloatingIPFrom(
+	ctx context.Context,
+	project string,
 	name string,
 	allocator oxide.AddressAllocator,
+	fallbackPools []string,
+	description string,
 ) (*oxide.FloatingIp, error) {
-	fip, err := l.client.FloatingIpCreate(
-		ctx, oxide.FloatingIpCreateParams{
-			Project: oxide.NameOrId(l.project),
-			Body: &oxide.FloatingIpCreate{
-				Name:             oxide.Name(name),
-				Description:      "Managed by oxide-cloud-controller-manager.",
-				AddressAllocator: allocator,
+	allocators := []oxide.AddressAllocator{allocator}
+	for _, pool := range fallbackPools {
+		allocators = append(allocators, explicitPoolAllocator(pool))
+	}
+
+	exhausted := make([]error, 0)
+	for _, allocator := range allocators {
+		fip, err := l.client.FloatingIpCreate(
+			ctx, oxide.FloatingIpCreateParams{
+				Project: oxide.NameOrId(project),
+				Body: &oxide.FloatingIpCreate{
+					Name:             oxide.Name(name),
+					Description:      description,
+					AddressAllocator: allocator,
+				},
 			},
-		},
+		)
+		if err == nil {
+			l.poolUtilization.changed()
+			return fip, nil
+		}
+
+		if !errors.Is(err, oxide.ErrInsufficientCapacity) {
+			return nil, fmt.Errorf(
+				"failed creating floating ip %s: %w", name, err,
+			)
+		}
+
+		exhausted = append(exhausted, fmt.Errorf(
+			"ip pool %s is exhausted: %w", allocatorPool(allocator), err,
+		))
+	}
+
+	return nil, fmt.Errorf(
+		"failed creating floating ip %s: %w: %w",
+		name, ErrPoolExhausted, errors.Join(exhausted...),
 	)
+}
+
+// floatingIPViewError wraps an error viewing the floating IP with the given
+// name, marking not found errors with [ErrFloatingIPNotFound].
+func floatingIPViewError(name string, err error) error {
+	if errors.Is(err, oxide.ErrObjectNotFound) {
+		return fmt.Errorf("%w: %s: %w", ErrFloatingIPNotFound, name, err)
+	}
+	return fmt.Errorf("failed viewing floating ip %s: %w", name, err)
+}
+
+// updateFloatingIPDescription updates the description of the floating IP when
+// it differs from description. Floating IPs without an ownership record were
+// not created by the cloud controller manager and are left as is.
+func (l *LoadBalancer) updateFloatingIPDescription(
+	ctx context.Context,
+	fip *oxide.FloatingIp,
+	description string,
+) (*oxide.FloatingIp, error) {
+	_, owned := parseFloatingIPOwner(fip.Description)
+	if !owned || fip.Description == description {
+		return fip, nil
+	}
+
+	updated, err := l.client.FloatingIpUpdate(ctx, oxide.FloatingIpUpdateParams{
+		FloatingIp: oxide.NameOrId(fip.Id),
+		Body:       &oxide.FloatingIpUpdate{Description: description},
+	})
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed updating description of floating ip %s: %w", fip.Name, err,
+		)
+	}
+
+	return updated, nil
+}
+
+// explicitPoolAllocator returns an allocator that automatically allocates an
+// address from the given pool.
+func explicitPoolAllocator(pool string) oxide.AddressAllocator {
+	return oxide.AddressAllocator{
+		Value: &oxide.AddressAllocatorAuto{
+			PoolSelector: oxide.PoolSelector{
+				Value: &oxide.PoolSelectorExplicit{
+					Pool: oxide.NameOrId(pool),
+				},
+			},
+		},
+	}
+}
+
+// allocatorPool returns the pool an automatic allocator allocates from, or the
+// empty string for other allocators.
+func allocatorPool(allocator oxide.AddressAllocator) string {
+	auto, ok := allocator.AsAuto()
+	if !ok {
+		return ""
+	}
+	ps, ok := auto.PoolSelector.AsExplicit()
+	if !ok {
+		return ""
+	}
+	return string(ps.Pool)
+}
+
+// withDefaultPool returns the allocator with the silo's default IP pool for
+// the requested IP version selected explicitly. Allocators that already select
+// a pool or an explicit IP address are returned as is.
+func (l *LoadBalancer) withDefaultPool(
+	ctx context.Context,
+	allocator oxide.AddressAllocator,
+) (oxide.AddressAllocator, error) {
+	auto, ok := allocator.AsAuto()
+	if !ok {
+		return allocator, nil
+	}
+
+	if _, ok := auto.PoolSelector.AsExplicit(); ok {
+		return allocator, nil
+	}
+
+	var version oxide.IpVersion
+	if ps, ok := auto.PoolSelector.AsAuto(); ok {
+		version = ps.IpVersion
+	}
+
+	pool, err := l.siloDefaultPool(ctx, version)
+	if err != nil {
+		return oxide.AddressAllocator{}, err
+	}
+
+	return explicitPoolAllocator(pool.Id), nil
+}
+
+// siloDefaultPool returns the silo's default IP pool for the IP version, or the
+// only default IP pool when version is empty. Resolved pools are cached in
+// defaultPools, when set, since the default pool rarely changes.
+func (l *LoadBalancer) siloDefaultPool(
+	ctx context.Context,
+	version oxide.IpVersion,
+) (*oxide.SiloIpPool, error) {
+	if pool, ok := l.defaultPools.get(version); ok {
+		return pool, nil
+	}
+
+	pools, err := l.client.IpPoolListAllPages(ctx, oxide.IpPoolListParams{})
 	if err != nil {
+		return nil, fmt.Errorf("failed listing ip pools: %w", err)
+	}
+
+	defaults := slices.DeleteFunc(pools, func(pool oxide.SiloIpPool) bool {
+		isDefault := pool.IsDefault != nil && *pool.IsDefault
+		return !isDefault || (version != "" && pool.IpVersion != version)
+	})
+
+	switch len(defaults) {
+	case 0:
+		if version != "" {
+			return nil, fmt.Errorf(
+				"silo has no default %s ip pool, set the %s annotation",
+				version, AnnotationFloatingIPPool,
+			)
+		}
+		return nil, fmt.Errorf(
+			"silo has no default ip pool, set the %s annotation",
+			AnnotationFloatingIPPool,
+		)
+	case 1:
+		klog.InfoS("resolved silo default ip pool",
+			"pool", defaults[0].Name, "id", defaults[0].Id, "ipVersion", version)
+		l.defaultPools.set(version, &defaults[0])
+		return &defaults[0], nil
+	default:
 		return nil, fmt.Errorf(
-			"failed creating floating ip %s: %w", name, err,
+			"silo has a default ip pool for both ip versions, set the %s annotation",
+			AnnotationFloatingIPVersion,
 		)
 	}
+}
+
+// resolveDefaultPool resolves the IP pool that floating IPs of services
+// without floating IP annotations are allocated from, so that the first
+// floating IPs don't wait on it and operators can see which pool is used. The
+// silo's default pools are cached for each IP version.
+func (l *LoadBalancer) resolveDefaultPool(ctx context.Context) error {
+	if l.defaultPool != "" {
+		klog.InfoS("using configured default ip pool", "pool", l.defaultPool)
+		return nil
+	}
+
+	if _, err := l.siloDefaultPool(ctx, ""); err == nil {
+		return nil
+	}
+
+	// A silo may have a default pool for each IP version instead.
+	versions := []oxide.IpVersion{oxide.IpVersionV4, oxide.IpVersionV6}
+	errs := make([]error, 0, len(versions))
+	for _, version := range versions {
+		if _, err := l.siloDefaultPool(ctx, version); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(versions) {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// defaultPoolCache caches the silo's default IP pool per IP version. A nil
+// cache caches nothing.
+type defaultPoolCache struct {
+	mu    sync.Mutex
+	pools map[oxide.IpVersion]*oxide.SiloIpPool
+}
+
+// get returns the cached default pool for the IP version.
+func (c *defaultPoolCache) get(version oxide.IpVersion) (*oxide.SiloIpPool, bool) {
+	if c == nil {
+		return nil, false
+	}
 
-	return fip, nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pool, ok := c.pools[version]
+	return pool, ok
+}
+
+// set caches the default pool for the IP version.
+func (c *defaultPoolCache) set(version oxide.IpVersion, pool *oxide.SiloIpPool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pools == nil {
+		c.pools = make(map[oxide.IpVersion]*oxide.SiloIpPool)
+	}
+	c.pools[version] = pool
+}
+
+// invalidate drops the cached default pools so they are resolved again.
+func (c *defaultPoolCache) invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.pools)
 }
 
 // floatingIPNeedsRecreate compares the existing floating IP
@@ -508,6 +2097,7 @@ func (l *LoadBalancer) floatingIPNeedsRecreate(
 	ctx context.Context,
 	fip *oxide.FloatingIp,
 	allocator oxide.AddressAllocator,
+	fallbackPools []string,
 ) (bool, error) {
 	if explicit, ok := allocator.AsExplicit(); ok {
 		return fip.Ip != explicit.Ip, nil
@@ -519,18 +2109,23 @@ func (l *LoadBalancer) floatingIPNeedsRecreate(
 	}
 
 	if ps, ok := auto.PoolSelector.AsExplicit(); ok {
-		pool, err := l.client.IpPoolView(
-			ctx, oxide.IpPoolViewParams{
-				Pool: ps.Pool,
-			},
-		)
-		if err != nil {
-			return false, fmt.Errorf(
-				"failed viewing ip pool %s: %w",
-				ps.Pool, err,
+		for _, name := range append([]string{string(ps.Pool)}, fallbackPools...) {
+			pool, err := l.client.IpPoolView(
+				ctx, oxide.IpPoolViewParams{
+					Pool: oxide.NameOrId(name),
+				},
 			)
+			if err != nil {
+				return false, fmt.Errorf(
+					"failed viewing ip pool %s: %w",
+					name, err,
+				)
+			}
+			if fip.IpPoolId == pool.Id {
+				return false, nil
+			}
 		}
-		return fip.IpPoolId != pool.Id, nil
+		return true, nil
 	}
 
 	if ps, ok := auto.PoolSelector.AsAuto(); ok {
@@ -550,6 +2145,57 @@ func (l *LoadBalancer) floatingIPNeedsRecreate(
 	return false, nil
 }
 
+// annotationsWithDefaultPool returns the service annotations with
+// [AnnotationFloatingIPPool] set to the default pool for the service's
+// namespace when the service has none of the floating IP annotations. The
+// default pool is, in order of precedence, the pool annotated on the
+// namespace, the pool configured for the namespace, or the cluster default
+// pool. Annotations on the service always take precedence over the defaults.
+func (l *LoadBalancer) annotationsWithDefaultPool(
+	ctx context.Context,
+	service *v1.Service,
+) (map[string]string, error) {
+	if hasFloatingIPAnnotations(service) {
+		return service.Annotations, nil
+	}
+
+	namespacePool, err := l.namespacePool(ctx, service.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := l.defaultPool
+	if configuredPool, ok := l.namespacePools[service.Namespace]; ok {
+		pool = configuredPool
+	}
+	if namespacePool != "" {
+		pool = namespacePool
+	}
+	if pool == "" {
+		return service.Annotations, nil
+	}
+
+	annotations := maps.Clone(service.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[AnnotationFloatingIPPool] = pool
+	return annotations, nil
+}
+
+// hasFloatingIPAnnotations reports whether the service selects the address or
+// pool of its floating IP with annotations rather than the default pools.
+func hasFloatingIPAnnotations(service *v1.Service) bool {
+	for _, key := range []string{
+		AnnotationFloatingIP, AnnotationFloatingIPPool, AnnotationFloatingIPVersion,
+	} {
+		if service.Annotations[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
 // addressAllocatorFromAnnotations builds an AddressAllocator from
 // the service annotations.
 func addressAllocatorFromAnnotations(
@@ -559,6 +2205,13 @@ func addressAllocatorFromAnnotations(
 	pool := annotations[AnnotationFloatingIPPool]
 	version := annotations[AnnotationFloatingIPVersion]
 
+	pools := splitIPPools(pool)
+	if pool != "" && len(pools) == 0 {
+		return oxide.AddressAllocator{}, fmt.Errorf(
+			"annotation %s does not name any ip pool", AnnotationFloatingIPPool,
+		)
+	}
+
 	if ip != "" && (pool != "" || version != "") {
 		return oxide.AddressAllocator{}, fmt.Errorf(
 			"annotation %s is mutually exclusive with %s and %s",
@@ -583,15 +2236,7 @@ func addressAllocatorFromAnnotations(
 	}
 
 	if pool != "" {
-		return oxide.AddressAllocator{
-			Value: &oxide.AddressAllocatorAuto{
-				PoolSelector: oxide.PoolSelector{
-					Value: &oxide.PoolSelectorExplicit{
-						Pool: oxide.NameOrId(pool),
-					},
-				},
-			},
-		}, nil
+		return explicitPoolAllocator(pools[0]), nil
 	}
 
 	if version != "" {
@@ -621,16 +2266,52 @@ func addressAllocatorFromAnnotations(
 	}, nil
 }
 
+// fallbackIPPools returns the pools after the first one in the
+// comma-separated [AnnotationFloatingIPPool] annotation.
+func fallbackIPPools(annotations map[string]string) []string {
+	pools := splitIPPools(annotations[AnnotationFloatingIPPool])
+	if len(pools) < 2 {
+		return nil
+	}
+	return pools[1:]
+}
+
+// splitIPPools splits a comma-separated list of IP pools, ignoring
+// surrounding whitespace and empty entries.
+func splitIPPools(pools string) []string {
+	split := make([]string, 0)
+	for pool := range strings.SplitSeq(pools, ",") {
+		if pool = strings.TrimSpace(pool); pool != "" {
+			split = append(split, pool)
+		}
+	}
+	return split
+}
+
 // toLoadBalancerStatus builds a LoadBalancerStatus from the floating IP and
-// optional node.
-func toLoadBalancerStatus(floatingIP *oxide.FloatingIp, node *v1.Node) *v1.LoadBalancerStatus {
+// optional node. The floating IP is reported alongside or replaced by the
+// service's [AnnotationHostname], which is omitted when invalid.
+func toLoadBalancerStatus(
+	service *v1.Service,
+	floatingIP *oxide.FloatingIp,
+	node *v1.Node,
+) *v1.LoadBalancerStatus {
+	hostname, hostnameOnly, err := serviceHostname(service)
+	if err != nil {
+		hostname, hostnameOnly = "", false
+	}
+
 	ingress := make([]v1.LoadBalancerIngress, 0)
-	if floatingIP != nil {
+	if floatingIP != nil && !hostnameOnly {
 		ingress = append(ingress, v1.LoadBalancerIngress{
 			IP:     floatingIP.Ip,
 			IPMode: new(v1.LoadBalancerIPModeProxy),
 		})
 	}
+	if floatingIP != nil && hostname != "" {
+		// Kubernetes only permits an IP mode for ingress points with an IP.
+		ingress = append(ingress, v1.LoadBalancerIngress{Hostname: hostname})
+	}
 
 	if node != nil {
 		for _, address := range node.Status.Addresses {
@@ -7,20 +7,32 @@ package provider
 import (
 	"context"
 	"errors"
+	"reflect"
+	goruntime "runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/oxidecomputer/oxide.go/oxide"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
 )
 
 // Test infrastructure: fakes and helpers shared across the tests below.
 
-// Instance IDs used by tests that exercise InstanceIDFromProviderID, which
+// Instance IDs used by tests that exercise ParseProviderID, which
 // requires valid UUIDs.
 const (
 	instID1   = "11111111-1111-1111-1111-111111111111"
@@ -32,6 +44,12 @@ const (
 // IP in these tests.
 const testFloatingIP = "203.0.113.10"
 
+// ownedDescription is the description of a floating IP the cloud controller
+// manager created for the service returned by [newLBService].
+var ownedDescription = floatingIPOwner{
+	Cluster: "cluster", Namespace: "ns", Service: "svc",
+}.record()
+
 // errUnexpectedOxideCall is returned by the fake Oxide client when a method is
 // invoked that the test did not configure. It makes unexpected API calls fail
 // loudly rather than silently succeeding.
@@ -52,6 +70,9 @@ type fakeOxideLBClient struct {
 	FloatingIpCreateFn func(
 		context.Context, oxide.FloatingIpCreateParams,
 	) (*oxide.FloatingIp, error)
+	FloatingIpUpdateFn func(
+		context.Context, oxide.FloatingIpUpdateParams,
+	) (*oxide.FloatingIp, error)
 	FloatingIpDeleteFn func(
 		context.Context, oxide.FloatingIpDeleteParams,
 	) error
@@ -64,6 +85,21 @@ type fakeOxideLBClient struct {
 	IpPoolViewFn func(
 		context.Context, oxide.IpPoolViewParams,
 	) (*oxide.SiloIpPool, error)
+	IpPoolListAllPagesFn func(
+		context.Context, oxide.IpPoolListParams,
+	) ([]oxide.SiloIpPool, error)
+	FloatingIpListAllPagesFn func(
+		context.Context, oxide.FloatingIpListParams,
+	) ([]oxide.FloatingIp, error)
+	InstanceExternalIpListFn func(
+		context.Context, oxide.InstanceExternalIpListParams,
+	) (*oxide.ExternalIpResultsPage, error)
+	InstanceEphemeralIpAttachFn func(
+		context.Context, oxide.InstanceEphemeralIpAttachParams,
+	) (*oxide.ExternalIp, error)
+	InstanceEphemeralIpDetachFn func(
+		context.Context, oxide.InstanceEphemeralIpDetachParams,
+	) error
 }
 
 func (f *fakeOxideLBClient) FloatingIpView(
@@ -84,6 +120,15 @@ func (f *fakeOxideLBClient) FloatingIpCreate(
 	return f.FloatingIpCreateFn(ctx, p)
 }
 
+func (f *fakeOxideLBClient) FloatingIpUpdate(
+	ctx context.Context, p oxide.FloatingIpUpdateParams,
+) (*oxide.FloatingIp, error) {
+	if f.FloatingIpUpdateFn == nil {
+		return nil, errUnexpectedOxideCall
+	}
+	return f.FloatingIpUpdateFn(ctx, p)
+}
+
 func (f *fakeOxideLBClient) FloatingIpDelete(
 	ctx context.Context, p oxide.FloatingIpDeleteParams,
 ) error {
@@ -120,6 +165,70 @@ func (f *fakeOxideLBClient) IpPoolView(
 	return f.IpPoolViewFn(ctx, p)
 }
 
+func (f *fakeOxideLBClient) IpPoolListAllPages(
+	ctx context.Context, p oxide.IpPoolListParams,
+) ([]oxide.SiloIpPool, error) {
+	if f.IpPoolListAllPagesFn == nil {
+		return nil, errUnexpectedOxideCall
+	}
+	return f.IpPoolListAllPagesFn(ctx, p)
+}
+
+func (f *fakeOxideLBClient) FloatingIpListAllPages(
+	ctx context.Context, p oxide.FloatingIpListParams,
+) ([]oxide.FloatingIp, error) {
+	if f.FloatingIpListAllPagesFn == nil {
+		return nil, errUnexpectedOxideCall
+	}
+	return f.FloatingIpListAllPagesFn(ctx, p)
+}
+
+func (f *fakeOxideLBClient) InstanceExternalIpList(
+	ctx context.Context, p oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	if f.InstanceExternalIpListFn == nil {
+		return nil, errUnexpectedOxideCall
+	}
+	return f.InstanceExternalIpListFn(ctx, p)
+}
+
+func (f *fakeOxideLBClient) InstanceEphemeralIpAttach(
+	ctx context.Context, p oxide.InstanceEphemeralIpAttachParams,
+) (*oxide.ExternalIp, error) {
+	if f.InstanceEphemeralIpAttachFn == nil {
+		return nil, errUnexpectedOxideCall
+	}
+	return f.InstanceEphemeralIpAttachFn(ctx, p)
+}
+
+func (f *fakeOxideLBClient) InstanceEphemeralIpDetach(
+	ctx context.Context, p oxide.InstanceEphemeralIpDetachParams,
+) error {
+	if f.InstanceEphemeralIpDetachFn == nil {
+		return errUnexpectedOxideCall
+	}
+	return f.InstanceEphemeralIpDetachFn(ctx, p)
+}
+
+// Default IP pools returned by [listIPPools].
+var (
+	defaultV4Pool = oxide.SiloIpPool{
+		Id: "pool-v4", Name: "default-v4", IpVersion: oxide.IpVersionV4, IsDefault: new(true),
+	}
+	defaultV6Pool = oxide.SiloIpPool{
+		Id: "pool-v6", Name: "default-v6", IpVersion: oxide.IpVersionV6, IsDefault: new(true),
+	}
+)
+
+// listIPPools returns an IpPoolListAllPagesFn that lists the given pools.
+func listIPPools(pools ...oxide.SiloIpPool) func(
+	context.Context, oxide.IpPoolListParams,
+) ([]oxide.SiloIpPool, error) {
+	return func(context.Context, oxide.IpPoolListParams) ([]oxide.SiloIpPool, error) {
+		return slices.Clone(pools), nil
+	}
+}
+
 // newLBService builds a LoadBalancer-type service named "ns/svc" with the
 // Cluster external traffic policy that EnsureLoadBalancer requires.
 func newLBService(annotations map[string]string) *v1.Service {
@@ -136,20 +245,32 @@ func newLBService(annotations map[string]string) *v1.Service {
 	}
 }
 
-// newLBNode builds a node whose provider ID maps to instanceID and that
+// newLBNode builds a ready node whose provider ID maps to instanceID and that
 // advertises internalIP as its internal address.
 func newLBNode(name, instanceID, internalIP string) *v1.Node {
 	return &v1.Node{
 		ObjectMeta: metav1.ObjectMeta{Name: name},
-		Spec:       v1.NodeSpec{ProviderID: NewProviderID(instanceID)},
+		Spec:       v1.NodeSpec{ProviderID: ProviderID{InstanceID: instanceID}.String()},
 		Status: v1.NodeStatus{
 			Addresses: []v1.NodeAddress{
 				{Type: v1.NodeInternalIP, Address: internalIP},
 			},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			},
 		},
 	}
 }
 
+// newSharedLBService builds a LoadBalancer-type service named "ns/name" that
+// shares the floating IP for key and exposes a single TCP port.
+func newSharedLBService(name, key string, port int32) *v1.Service {
+	svc := newLBService(map[string]string{AnnotationSharedIPKey: key})
+	svc.Name = name
+	svc.Spec.Ports = []v1.ServicePort{{Port: port, Protocol: v1.ProtocolTCP}}
+	return svc
+}
+
 // serviceWithIngressIP builds a service whose load balancer status advertises
 // the floating IP plus the given node internal IP.
 func serviceWithIngressIP(ip string) *v1.Service {
@@ -199,6 +320,26 @@ func assertProxyAndNodeIngress(
 	}
 }
 
+// assertBackingAnnotations asserts that the service's backing annotations name
+// the given node and instance. Empty values assert the annotation is absent.
+func assertBackingAnnotations(
+	t *testing.T, service *v1.Service, nodeName, instanceID string,
+) {
+	t.Helper()
+	for key, want := range map[string]string{
+		AnnotationBackingNode:     nodeName,
+		AnnotationBackingInstance: instanceID,
+	} {
+		got, ok := service.Annotations[key]
+		if want == "" && ok {
+			t.Fatalf("annotation %s = %q, want absent", key, got)
+		}
+		if got != want {
+			t.Fatalf("annotation %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
 // Exported method tests.
 
 func TestGetLoadBalancer(t *testing.T) {
@@ -257,7 +398,9 @@ func TestGetLoadBalancer(t *testing.T) {
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
-					return &oxide.FloatingIp{Ip: "203.0.113.10"}, nil
+					return &oxide.FloatingIp{
+						Ip: "203.0.113.10", Description: ownedDescription,
+					}, nil
 				},
 			},
 		}
@@ -284,7 +427,7 @@ func TestGetLoadBalancer(t *testing.T) {
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
 					return &oxide.FloatingIp{
-						Ip: "203.0.113.10", InstanceId: instID1,
+						Ip: "203.0.113.10", InstanceId: instID1, Description: ownedDescription,
 					}, nil
 				},
 			},
@@ -310,7 +453,7 @@ func TestGetLoadBalancer(t *testing.T) {
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
 					return &oxide.FloatingIp{
-						Ip: "203.0.113.10", InstanceId: "ghost",
+						Ip: "203.0.113.10", InstanceId: "ghost", Description: ownedDescription,
 					}, nil
 				},
 			},
@@ -330,6 +473,89 @@ func TestGetLoadBalancer(t *testing.T) {
 		}
 	})
 
+	t.Run("Ownership", func(t *testing.T) {
+		tt := []struct {
+			name       string
+			fip        *oxide.FloatingIp
+			wantState  loadBalancerState
+			wantExists bool
+		}{
+			{
+				name: "Owned",
+				fip: &oxide.FloatingIp{
+					Ip: testFloatingIP, InstanceId: instID1, Description: ownedDescription,
+				},
+				wantState:  loadBalancerReady,
+				wantExists: true,
+			},
+			{
+				name: "OwnedLegacy",
+				fip: &oxide.FloatingIp{
+					Ip: testFloatingIP, InstanceId: instID1, Description: legacyOwnershipTag,
+				},
+				wantState:  loadBalancerReady,
+				wantExists: true,
+			},
+			{
+				name: "OwnedDetached",
+				fip: &oxide.FloatingIp{
+					Ip: testFloatingIP, Description: ownedDescription,
+				},
+				wantState:  loadBalancerDegraded,
+				wantExists: true,
+			},
+			{
+				name: "ExternalSameName",
+				fip: &oxide.FloatingIp{
+					Ip: testFloatingIP, InstanceId: instID1, Description: "created by hand",
+				},
+				wantState: loadBalancerForeign,
+			},
+			{
+				name:      "Absent",
+				wantState: loadBalancerAbsent,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				lb := &LoadBalancer{
+					project: "test",
+					k8sClient: fake.NewSimpleClientset(
+						newLBNode("node-a", instID1, "10.0.0.5"),
+					),
+					client: &fakeOxideLBClient{
+						FloatingIpViewFn: func(
+							context.Context, oxide.FloatingIpViewParams,
+						) (*oxide.FloatingIp, error) {
+							if tc.fip == nil {
+								return nil, oxide.ErrObjectNotFound
+							}
+							return tc.fip, nil
+						},
+					},
+				}
+				svc := newLBService(nil)
+
+				_, state, err := lb.getLoadBalancer(t.Context(), "cluster", svc)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state != tc.wantState {
+					t.Fatalf("state = %s, want %s", state, tc.wantState)
+				}
+
+				status, exists, err := lb.GetLoadBalancer(t.Context(), "cluster", svc)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if exists != tc.wantExists || (status != nil) != tc.wantExists {
+					t.Fatalf("got (%v, %t), want exists=%t", status, exists, tc.wantExists)
+				}
+			})
+		}
+	})
+
 	t.Run("NodeListError", func(t *testing.T) {
 		client := fake.NewSimpleClientset()
 		client.PrependReactor("list", "nodes", func(
@@ -346,7 +572,7 @@ func TestGetLoadBalancer(t *testing.T) {
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
 					return &oxide.FloatingIp{
-						Ip: "203.0.113.10", InstanceId: instID1,
+						Ip: "203.0.113.10", InstanceId: instID1, Description: ownedDescription,
 					}, nil
 				},
 			},
@@ -399,6 +625,17 @@ func TestGetLoadBalancerName(t *testing.T) {
 			t.Fatalf("name = %q, want 63 a's", got)
 		}
 	})
+
+	t.Run("SharedIPKey", func(t *testing.T) {
+		lb := &LoadBalancer{}
+		got := lb.GetLoadBalancerName(
+			t.Context(), "cluster",
+			newSharedLBService("svc", "web", 80),
+		)
+		if got != "cluster-shared-web" {
+			t.Fatalf("name = %q, want %q", got, "cluster-shared-web")
+		}
+	})
 }
 
 func TestEnsureLoadBalancer(t *testing.T) {
@@ -428,17 +665,15 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	})
 
 	t.Run("InvalidProviderID", func(t *testing.T) {
-		bad := &v1.Node{
-			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
-			Spec:       v1.NodeSpec{ProviderID: "not-oxide"},
-		}
+		bad := newLBNode("node-a", instID1, "10.0.0.5")
+		bad.Spec.ProviderID = "not-oxide"
 		lb := &LoadBalancer{project: "test", client: &fakeOxideLBClient{}}
 		_, err := lb.EnsureLoadBalancer(
 			t.Context(), "cluster", newLBService(nil),
 			[]*v1.Node{bad},
 		)
-		if err == nil {
-			t.Fatal("expected error for invalid provider id")
+		if !errors.Is(err, ErrProviderIDInvalid) {
+			t.Fatalf("err = %v, want invalid provider id", err)
 		}
 	})
 
@@ -456,12 +691,321 @@ func TestEnsureLoadBalancer(t *testing.T) {
 		}
 	})
 
+	t.Run("PortProtocols", func(t *testing.T) {
+		tt := []struct {
+			name     string
+			ports    []v1.ServicePort
+			errorMsg string
+			event    string
+		}{
+			{
+				name:     "sctp only",
+				ports:    []v1.ServicePort{{Port: 5000, Protocol: v1.ProtocolSCTP}},
+				errorMsg: "unsupported ports 5000/SCTP",
+			},
+			{
+				name: "sctp alongside tcp",
+				ports: []v1.ServicePort{
+					{Name: "http", Port: 80, Protocol: v1.ProtocolTCP},
+					{Name: "signaling", Port: 5000, Protocol: v1.ProtocolSCTP},
+				},
+				event: "Warning UnsupportedPorts Ports signaling (5000/SCTP) will not " +
+					"receive traffic, Oxide floating IPs only forward TCP and UDP",
+			},
+			{
+				name: "named target ports",
+				ports: []v1.ServicePort{
+					{
+						Name:       "http",
+						Port:       80,
+						Protocol:   v1.ProtocolTCP,
+						TargetPort: intstr.FromString("http"),
+					},
+					{
+						Name:       "dns",
+						Port:       53,
+						Protocol:   v1.ProtocolUDP,
+						TargetPort: intstr.FromString("dns"),
+					},
+				},
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newLBService(nil)
+				svc.Spec.Ports = tc.ports
+				recorder := record.NewFakeRecorder(10)
+				lb := &LoadBalancer{
+					project:   "test",
+					k8sClient: fake.NewSimpleClientset(svc),
+					recorder:  recorder,
+					client: &fakeOxideLBClient{
+						FloatingIpViewFn: func(
+							context.Context, oxide.FloatingIpViewParams,
+						) (*oxide.FloatingIp, error) {
+							return &oxide.FloatingIp{
+								Id: "fip-1", Ip: testFloatingIP, InstanceId: instID1,
+							}, nil
+						},
+					},
+				}
+
+				_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+				if tc.errorMsg != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+						t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+					}
+				} else if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				close(recorder.Events)
+				var events []string
+				for event := range recorder.Events {
+					events = append(events, event)
+				}
+				if tc.event == "" && len(events) != 0 {
+					t.Fatalf("events = %q, want none", events)
+				}
+				if tc.event != "" && !slices.Equal(events, []string{tc.event}) {
+					t.Fatalf("events = %q, want %q", events, tc.event)
+				}
+			})
+		}
+	})
+
+	t.Run("MinNodes", func(t *testing.T) {
+		nodeB := newLBNode("node-b", instIDNew, "10.0.0.6")
+		cordoned := nodeB.DeepCopy()
+		cordoned.Spec.Unschedulable = true
+
+		tt := []struct {
+			name        string
+			nodes       []*v1.Node
+			backingNode string
+			wantWaiting bool
+		}{
+			{
+				name:        "BelowThreshold",
+				nodes:       []*v1.Node{node},
+				wantWaiting: true,
+			},
+			{
+				name:  "AtThreshold",
+				nodes: []*v1.Node{node, nodeB},
+			},
+			{
+				name:        "IneligibleNodesDoNotCount",
+				nodes:       []*v1.Node{node, cordoned},
+				wantWaiting: true,
+			},
+			{
+				name:        "AlreadyAttached",
+				nodes:       []*v1.Node{node},
+				backingNode: "node-a",
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newLBService(nil)
+				if tc.backingNode != "" {
+					svc.Annotations = map[string]string{AnnotationBackingNode: tc.backingNode}
+				}
+				client := newFakeFloatingIPs()
+				recorder := record.NewFakeRecorder(10)
+				lb := &LoadBalancer{
+					project:       "test",
+					k8sClient:     fake.NewSimpleClientset(svc),
+					client:        client,
+					defaultPools:  &defaultPoolCache{},
+					locks:         &keyMutex{},
+					clock:         clock.RealClock{},
+					attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+					minNodes:      2,
+					recorder:      recorder,
+				}
+
+				_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, tc.nodes)
+				if !tc.wantWaiting {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if len(client.list()) != 1 {
+						t.Fatal("expected floating ip to be created")
+					}
+					return
+				}
+
+				var retryErr *cloudproviderapi.RetryError
+				if !errors.As(err, &retryErr) {
+					t.Fatalf("err = %v, want a retry error", err)
+				}
+				if writes := client.takeWrites(); len(writes) != 0 {
+					t.Fatalf("unexpected floating ip writes %v", writes)
+				}
+				select {
+				case event := <-recorder.Events:
+					if !strings.Contains(event, EventReasonWaitingForNodes) {
+						t.Fatalf("event = %q, want reason %s", event, EventReasonWaitingForNodes)
+					}
+				default:
+					t.Fatal("expected an event")
+				}
+			})
+		}
+	})
+
+	t.Run("AttachMode", func(t *testing.T) {
+		tt := []struct {
+			name        string
+			defaultMode string
+			annotations map[string]string
+			errorMsg    string
+		}{
+			{
+				name: "DefaultsToSingleNode",
+			},
+			{
+				name:        "ConfiguredSingleNode",
+				defaultMode: AttachModeSingleNode,
+			},
+			{
+				name:        "AnnotatedSingleNode",
+				annotations: map[string]string{AnnotationAttachMode: AttachModeSingleNode},
+			},
+			{
+				name:        "AnnotatedUnknown",
+				annotations: map[string]string{AnnotationAttachMode: "anycast"},
+				errorMsg:    `unknown attach mode "anycast"`,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newLBService(tc.annotations)
+				client := newFakeFloatingIPs()
+				lb := &LoadBalancer{
+					project:       "test",
+					k8sClient:     fake.NewSimpleClientset(svc),
+					client:        client,
+					defaultPools:  &defaultPoolCache{},
+					locks:         &keyMutex{},
+					clock:         clock.RealClock{},
+					attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 3},
+					attachMode:    tc.defaultMode,
+				}
+
+				_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+				if tc.errorMsg != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+						t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+					}
+					if writes := client.takeWrites(); len(writes) != 0 {
+						t.Fatalf("unexpected floating ip writes %v", writes)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if fips := client.list(); len(fips) != 1 || fips[0].InstanceId != instID1 {
+					t.Fatalf("floating ips = %+v, want one attached to %s", fips, instID1)
+				}
+			})
+		}
+	})
+
+	t.Run("OperationTimeout", func(t *testing.T) {
+		tt := []struct {
+			name           string
+			defaultTimeout time.Duration
+			annotations    map[string]string
+			wantTimeout    time.Duration
+			errorMsg       string
+		}{
+			{
+				name: "Unbounded",
+			},
+			{
+				name:           "Configured",
+				defaultTimeout: 5 * time.Minute,
+				wantTimeout:    5 * time.Minute,
+			},
+			{
+				name:           "Annotated",
+				defaultTimeout: 5 * time.Minute,
+				annotations:    map[string]string{AnnotationOperationTimeout: "20m"},
+				wantTimeout:    20 * time.Minute,
+			},
+			{
+				name:        "Invalid",
+				annotations: map[string]string{AnnotationOperationTimeout: "soon"},
+				errorMsg:    `invalid oxide.computer/operation-timeout value "soon"`,
+			},
+			{
+				name:        "TooShort",
+				annotations: map[string]string{AnnotationOperationTimeout: "1s"},
+				errorMsg:    "operation timeout must be between 10s and 1h0m0s",
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newLBService(tc.annotations)
+
+				var deadline time.Time
+				var bounded bool
+				lb := &LoadBalancer{
+					project:   "test",
+					k8sClient: fake.NewSimpleClientset(svc),
+					client: &fakeOxideLBClient{
+						FloatingIpViewFn: func(
+							ctx context.Context, _ oxide.FloatingIpViewParams,
+						) (*oxide.FloatingIp, error) {
+							deadline, bounded = ctx.Deadline()
+							return nil, errBoom
+						},
+					},
+					locks:            &keyMutex{},
+					clock:            clock.RealClock{},
+					operationTimeout: tc.defaultTimeout,
+				}
+
+				start := time.Now()
+				_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+				end := time.Now()
+				if tc.errorMsg != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+						t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+					}
+					return
+				}
+				if !errors.Is(err, errBoom) {
+					t.Fatalf("err = %v, want errBoom", err)
+				}
+
+				if bounded != (tc.wantTimeout > 0) {
+					t.Fatalf("bounded = %t, want %t", bounded, tc.wantTimeout > 0)
+				}
+				// The timeout starts during the call.
+				if bounded && (deadline.Before(start.Add(tc.wantTimeout)) ||
+					deadline.After(end.Add(tc.wantTimeout))) {
+					t.Fatalf("timeout = %s, want %s", deadline.Sub(start), tc.wantTimeout)
+				}
+			})
+		}
+	})
+
 	t.Run("CreatesAndAttaches", func(t *testing.T) {
 		var attachedTo oxide.NameOrId
 		created := false
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool),
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
@@ -504,7 +1048,8 @@ func TestEnsureLoadBalancer(t *testing.T) {
 		// View returns a matching floating IP, so Create/Delete must not be
 		// called (their nil func fields would error if they were).
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
@@ -537,7 +1082,8 @@ func TestEnsureLoadBalancer(t *testing.T) {
 			AnnotationFloatingIP: "203.0.113.99",
 		})
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
@@ -583,7 +1129,8 @@ func TestEnsureLoadBalancer(t *testing.T) {
 			AnnotationFloatingIPPool: "external",
 		})
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
@@ -631,8 +1178,10 @@ func TestEnsureLoadBalancer(t *testing.T) {
 
 	t.Run("AttachError", func(t *testing.T) {
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool),
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
@@ -662,7 +1211,8 @@ func TestEnsureLoadBalancer(t *testing.T) {
 
 	t.Run("ViewError", func(t *testing.T) {
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
@@ -682,8 +1232,10 @@ func TestEnsureLoadBalancer(t *testing.T) {
 
 	t.Run("CreateError", func(t *testing.T) {
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool),
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
@@ -803,8 +1355,10 @@ func TestEnsureLoadBalancer(t *testing.T) {
 			AnnotationFloatingIPVersion: "v6",
 		})
 		lb := &LoadBalancer{
-			project: "test",
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool, defaultV6Pool),
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
@@ -841,6 +1395,84 @@ func TestEnsureLoadBalancer(t *testing.T) {
 			t.Fatal("expected recreate on ip version change")
 		}
 	})
+
+	t.Run("DefaultPoolKeepsExistingFloatingIP", func(t *testing.T) {
+		// The floating IP was allocated before the default pool was
+		// configured, so it is in another pool, which must not replace it.
+		svc := newLBService(nil)
+		lb := &LoadBalancer{
+			project:     "test",
+			k8sClient:   fake.NewSimpleClientset(svc),
+			defaultPool: "cluster-pool",
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", IpPoolId: "other-pool",
+					}, nil
+				},
+				FloatingIpDeleteFn: func(
+					context.Context, oxide.FloatingIpDeleteParams,
+				) error {
+					t.Fatal("floating ip was recreated in the default pool")
+					return nil
+				},
+				FloatingIpAttachFn: func(
+					context.Context, oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", InstanceId: instID1,
+					}, nil
+				},
+			},
+		}
+
+		status, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{node},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ip := status.Ingress[0].IP; ip != "203.0.113.10" {
+			t.Fatalf("ingress ip = %q, want the existing floating ip", ip)
+		}
+	})
+
+	t.Run("SetsBackingAnnotations", func(t *testing.T) {
+		svc := newLBService(nil)
+		client := fake.NewSimpleClientset(svc)
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: client,
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{Id: "fip-1", Ip: "203.0.113.10"}, nil
+				},
+				FloatingIpAttachFn: func(
+					context.Context, oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", InstanceId: instID1,
+					}, nil
+				},
+			},
+		}
+
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", svc, []*v1.Node{node},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _ := client.CoreV1().Services("ns").Get(
+			t.Context(), "svc", metav1.GetOptions{},
+		)
+		assertBackingAnnotations(t, got, "node-a", instID1)
+	})
 }
 
 func TestUpdateLoadBalancer(t *testing.T) {
@@ -855,17 +1487,15 @@ func TestUpdateLoadBalancer(t *testing.T) {
 	})
 
 	t.Run("InvalidProviderID", func(t *testing.T) {
-		bad := &v1.Node{
-			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
-			Spec:       v1.NodeSpec{ProviderID: "not-oxide"},
-		}
+		bad := newLBNode("node-a", instID1, "10.0.0.5")
+		bad.Spec.ProviderID = "not-oxide"
 		lb := &LoadBalancer{project: "test", client: &fakeOxideLBClient{}}
 		err := lb.UpdateLoadBalancer(
 			t.Context(), "cluster", newLBService(nil),
 			[]*v1.Node{bad},
 		)
-		if err == nil {
-			t.Fatal("expected error for invalid provider id")
+		if !errors.Is(err, ErrProviderIDInvalid) {
+			t.Fatalf("err = %v, want invalid provider id", err)
 		}
 	})
 
@@ -889,6 +1519,26 @@ func TestUpdateLoadBalancer(t *testing.T) {
 		}
 	})
 
+	t.Run("FloatingIPNotFound", func(t *testing.T) {
+		lb := &LoadBalancer{
+			project: "test",
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return nil, oxide.ErrObjectNotFound
+				},
+			},
+		}
+		err := lb.UpdateLoadBalancer(
+			t.Context(), "cluster", newLBService(nil),
+			[]*v1.Node{newLBNode("node-a", instID1, "10.0.0.5")},
+		)
+		if !errors.Is(err, ErrFloatingIPNotFound) || !errors.Is(err, oxide.ErrObjectNotFound) {
+			t.Fatalf("err = %v, want floating ip not found", err)
+		}
+	})
+
 	t.Run("AlreadyAttachedPatchesStatus", func(t *testing.T) {
 		svc := newLBService(nil)
 		client := fake.NewSimpleClientset(svc)
@@ -921,6 +1571,127 @@ func TestUpdateLoadBalancer(t *testing.T) {
 		assertProxyAndNodeIngress(t, got.Status.LoadBalancer.Ingress, "10.0.0.5")
 	})
 
+	t.Run("FailoverGracePeriod", func(t *testing.T) {
+		nodeB := newLBNode("node-b", instIDNew, "10.0.0.20")
+
+		tt := []struct {
+			name        string
+			gracePeriod time.Duration
+			downFor     time.Duration
+			cordoned    bool
+			// shutdown taints the node as shut down instead of marking it not
+			// ready.
+			shutdown  bool
+			wantMoved bool
+			wantRetry time.Duration
+		}{
+			{
+				name:        "ShortReboot",
+				gracePeriod: 2 * time.Minute,
+				downFor:     30 * time.Second,
+				wantRetry:   90 * time.Second,
+			},
+			{
+				name:        "ShortShutdown",
+				gracePeriod: 2 * time.Minute,
+				downFor:     30 * time.Second,
+				shutdown:    true,
+				wantRetry:   90 * time.Second,
+			},
+			{
+				name:        "SustainedOutage",
+				gracePeriod: 2 * time.Minute,
+				downFor:     5 * time.Minute,
+				wantMoved:   true,
+			},
+			{
+				name:        "Cordoned",
+				gracePeriod: 2 * time.Minute,
+				downFor:     30 * time.Second,
+				cordoned:    true,
+				wantMoved:   true,
+			},
+			{
+				name:      "Disabled",
+				downFor:   30 * time.Second,
+				wantMoved: true,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				fakeClock := clocktesting.NewFakeClock(time.Now())
+				svc := newLBService(nil)
+				k8sClient := fake.NewSimpleClientset(svc)
+				client := newFakeFloatingIPs()
+				lb := &LoadBalancer{
+					project:             "test",
+					k8sClient:           k8sClient,
+					client:              client,
+					defaultPools:        &defaultPoolCache{},
+					locks:               &keyMutex{},
+					clock:               fakeClock,
+					attachBackoff:       wait.Backoff{Duration: time.Microsecond, Steps: 3},
+					failoverGracePeriod: tc.gracePeriod,
+				}
+
+				nodeA := newLBNode("node-a", instIDOld, "10.0.0.10")
+				_, err := lb.EnsureLoadBalancer(
+					t.Context(), "cluster", svc, []*v1.Node{nodeA, nodeB},
+				)
+				if err != nil {
+					t.Fatalf("unexpected error ensuring load balancer: %v", err)
+				}
+				client.takeWrites()
+
+				// Node A goes down.
+				downSince := metav1.NewTime(fakeClock.Now().Add(-tc.downFor))
+				if tc.shutdown {
+					nodeA.Spec.Taints = []v1.Taint{{
+						Key:       cloudproviderapi.TaintNodeShutdown,
+						Effect:    v1.TaintEffectNoSchedule,
+						TimeAdded: &downSince,
+					}}
+				} else {
+					nodeA.Status.Conditions = []v1.NodeCondition{{
+						Type:               v1.NodeReady,
+						Status:             v1.ConditionUnknown,
+						LastTransitionTime: downSince,
+					}}
+				}
+				nodeA.Spec.Unschedulable = tc.cordoned
+
+				current, _ := k8sClient.CoreV1().Services("ns").Get(
+					t.Context(), "svc", metav1.GetOptions{},
+				)
+				err = lb.UpdateLoadBalancer(
+					t.Context(), "cluster", current, []*v1.Node{nodeA, nodeB},
+				)
+
+				var retryErr *cloudproviderapi.RetryError
+				switch {
+				case tc.wantRetry == 0 && err != nil:
+					t.Fatalf("unexpected error: %v", err)
+				case tc.wantRetry != 0 && !errors.As(err, &retryErr):
+					t.Fatalf("err = %v, want a retry error", err)
+				case tc.wantRetry != 0 && retryErr.RetryAfter() != tc.wantRetry:
+					t.Fatalf("retry after = %s, want %s", retryErr.RetryAfter(), tc.wantRetry)
+				}
+
+				var want []string
+				if tc.wantMoved {
+					want = []string{
+						"detach cluster-ns-svc " + instIDOld,
+						"attach cluster-ns-svc " + instIDNew,
+					}
+				}
+				if got := client.takeWrites(); !slices.Equal(got, want) {
+					t.Fatalf("writes = %q, want %q", got, want)
+				}
+			})
+		}
+	})
+
 	t.Run("FailoverDetachesAndReattaches", func(t *testing.T) {
 		svc := newLBService(nil)
 		svc.Status.LoadBalancer = v1.LoadBalancerStatus{
@@ -991,8 +1762,53 @@ func TestUpdateLoadBalancer(t *testing.T) {
 		}
 	})
 
-	t.Run("DetachError", func(t *testing.T) {
-		lb := &LoadBalancer{
+	t.Run("CordonedNodeMovesFloatingIP", func(t *testing.T) {
+		// The floating IP is on node-a, which sorts first but is cordoned.
+		cordoned := newLBNode("node-a", instIDOld, "10.0.0.10")
+		cordoned.Spec.Unschedulable = true
+
+		var attachedTo oxide.NameOrId
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", InstanceId: instIDOld,
+					}, nil
+				},
+				FloatingIpDetachFn: func(
+					context.Context, oxide.FloatingIpDetachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{Id: "fip-1", Ip: "203.0.113.10"}, nil
+				},
+				FloatingIpAttachFn: func(
+					_ context.Context, p oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					attachedTo = p.Body.Parent
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", InstanceId: instIDNew,
+					}, nil
+				},
+			},
+		}
+
+		err := lb.UpdateLoadBalancer(
+			t.Context(), "cluster", newLBService(nil),
+			[]*v1.Node{cordoned, newLBNode("node-b", instIDNew, "10.0.0.20")},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(attachedTo) != instIDNew {
+			t.Fatalf("attached to %q, want %q", attachedTo, instIDNew)
+		}
+	})
+
+	t.Run("DetachError", func(t *testing.T) {
+		lb := &LoadBalancer{
 			project:   "test",
 			k8sClient: fake.NewSimpleClientset(),
 			client: &fakeOxideLBClient{
@@ -1046,6 +1862,52 @@ func TestUpdateLoadBalancer(t *testing.T) {
 			t.Fatalf("expected nil error for missing service, got: %v", err)
 		}
 	})
+
+	t.Run("ReattachUpdatesBackingAnnotations", func(t *testing.T) {
+		svc := newLBService(map[string]string{
+			AnnotationBackingNode:     "node-a",
+			AnnotationBackingInstance: instIDOld,
+		})
+		client := fake.NewSimpleClientset(svc)
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: client,
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", InstanceId: instIDOld,
+					}, nil
+				},
+				FloatingIpDetachFn: func(
+					context.Context, oxide.FloatingIpDetachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{Id: "fip-1", Ip: "203.0.113.10"}, nil
+				},
+				FloatingIpAttachFn: func(
+					context.Context, oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: "203.0.113.10", InstanceId: instIDNew,
+					}, nil
+				},
+			},
+		}
+
+		err := lb.UpdateLoadBalancer(
+			t.Context(), "cluster", svc,
+			[]*v1.Node{newLBNode("node-b", instIDNew, "10.0.0.20")},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, _ := client.CoreV1().Services("ns").Get(
+			t.Context(), "svc", metav1.GetOptions{},
+		)
+		assertBackingAnnotations(t, got, "node-b", instIDNew)
+	})
 }
 
 func TestEnsureLoadBalancerDeleted(t *testing.T) {
@@ -1098,6 +1960,12 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
+					if deleted {
+						return nil, oxide.ErrObjectNotFound
+					}
+					if detached {
+						return &oxide.FloatingIp{Id: "fip-1"}, nil
+					}
 					return &oxide.FloatingIp{Id: "fip-1", InstanceId: instID1}, nil
 				},
 				FloatingIpDetachFn: func(
@@ -1128,6 +1996,90 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 		}
 	})
 
+	t.Run("WaitsForDetach", func(t *testing.T) {
+		// The floating IP is still reported as attached for a few views after
+		// detaching it, and deleting it while attached fails.
+		const attachedViews = 2
+		var detached, deleted bool
+		views := 0
+		lb := &LoadBalancer{
+			project: "test",
+			clock:   clock.RealClock{},
+			attachBackoff: wait.Backoff{
+				Duration: time.Microsecond, Steps: attachedViews,
+			},
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					if deleted {
+						return nil, oxide.ErrObjectNotFound
+					}
+					if detached {
+						views++
+						if views > attachedViews {
+							return &oxide.FloatingIp{Id: "fip-1"}, nil
+						}
+					}
+					return &oxide.FloatingIp{Id: "fip-1", InstanceId: instID1}, nil
+				},
+				FloatingIpDetachFn: func(
+					context.Context, oxide.FloatingIpDetachParams,
+				) (*oxide.FloatingIp, error) {
+					detached = true
+					return &oxide.FloatingIp{Id: "fip-1"}, nil
+				},
+				FloatingIpDeleteFn: func(
+					context.Context, oxide.FloatingIpDeleteParams,
+				) error {
+					if views <= attachedViews {
+						return oxide.ErrInvalidRequest
+					}
+					deleted = true
+					return nil
+				},
+			},
+		}
+
+		err := lb.EnsureLoadBalancerDeleted(
+			t.Context(), "cluster", newLBService(nil),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !deleted {
+			t.Fatal("expected floating ip to be deleted")
+		}
+	})
+
+	t.Run("StillAttachedAfterDetach", func(t *testing.T) {
+		// Delete func is nil: if it is called, the test fails.
+		lb := &LoadBalancer{
+			project:       "test",
+			clock:         clock.RealClock{},
+			attachBackoff: wait.Backoff{Duration: time.Microsecond, Steps: 2},
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{Id: "fip-1", InstanceId: instID1}, nil
+				},
+				FloatingIpDetachFn: func(
+					context.Context, oxide.FloatingIpDetachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{Id: "fip-1"}, nil
+				},
+			},
+		}
+
+		err := lb.EnsureLoadBalancerDeleted(
+			t.Context(), "cluster", newLBService(nil),
+		)
+		if err == nil || !strings.Contains(err.Error(), "still attached") {
+			t.Fatalf("err = %v, want floating ip still attached", err)
+		}
+	})
+
 	t.Run("NotAttachedDeletesOnly", func(t *testing.T) {
 		// Detach func is nil: if it is called, the test fails.
 		deleted := false
@@ -1137,6 +2089,9 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 				FloatingIpViewFn: func(
 					context.Context, oxide.FloatingIpViewParams,
 				) (*oxide.FloatingIp, error) {
+					if deleted {
+						return nil, oxide.ErrObjectNotFound
+					}
 					return &oxide.FloatingIp{Id: "fip-1"}, nil
 				},
 				FloatingIpDeleteFn: func(
@@ -1208,105 +2163,1431 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 			t.Fatalf("err = %v, want errBoom from delete", err)
 		}
 	})
-}
-
-// Internal method tests.
-
-func TestSelectTargetNode(t *testing.T) {
-	nodes := []*v1.Node{
-		{ObjectMeta: metav1.ObjectMeta{Name: "node-c"}},
-		{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
-		{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
-	}
-
-	target := selectTargetNode(nodes)
-	if target.Name != "node-a" {
-		t.Fatalf("target node = %q, want %q", target.Name, "node-a")
-	}
-
-	// The input slice must not be reordered.
-	if nodes[0].Name != "node-c" {
-		t.Fatalf("input slice was mutated, first node = %q, want %q",
-			nodes[0].Name, "node-c",
-		)
-	}
-}
-
-func TestPatchServiceStatus(t *testing.T) {
-	newStatus := &v1.LoadBalancerStatus{
-		Ingress: []v1.LoadBalancerIngress{
-			{IP: "203.0.113.10", IPMode: new(v1.LoadBalancerIPModeProxy)},
-			{IP: "10.0.0.20"},
-		},
-	}
 
-	t.Run("UpdatesStaleNodeIP", func(t *testing.T) {
-		// The service status still advertises the destroyed node's IP.
-		service := serviceWithIngressIP("10.0.0.10")
-		client := fake.NewSimpleClientset(service)
-		lb := &LoadBalancer{k8sClient: client}
-
-		if err := lb.patchServiceStatus(service, newStatus); err != nil {
-			t.Fatalf("unexpected error: %v", err)
+	t.Run("StillExistsAfterDelete", func(t *testing.T) {
+		lb := &LoadBalancer{
+			project: "test",
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{Id: "fip-1"}, nil
+				},
+				FloatingIpDeleteFn: func(
+					context.Context, oxide.FloatingIpDeleteParams,
+				) error {
+					return nil
+				},
+			},
 		}
 
-		// The persisted status must reflect the new node's internal IP.
-		got, err := client.CoreV1().Services("default").Get(
-			t.Context(), "svc", metav1.GetOptions{},
+		err := lb.EnsureLoadBalancerDeleted(
+			t.Context(), "cluster", newLBService(nil),
 		)
-		if err != nil {
-			t.Fatalf("failed getting service: %v", err)
-		}
-		ingress := got.Status.LoadBalancer.Ingress
-		if len(ingress) != 2 {
-			t.Fatalf("ingress len = %d, want 2", len(ingress))
-		}
-		if ingress[1].IP != "10.0.0.20" {
-			t.Fatalf("node ingress ip = %q, want %q",
-				ingress[1].IP, "10.0.0.20",
-			)
-		}
-
-		// The read-only service parameter must not have been mutated.
-		if service.Status.LoadBalancer.Ingress[1].IP != "10.0.0.10" {
-			t.Fatalf(
-				"service parameter was mutated, node ingress ip = %q, want %q",
-				service.Status.LoadBalancer.Ingress[1].IP, "10.0.0.10",
-			)
+		if err == nil {
+			t.Fatal("expected error when floating ip still exists after delete")
 		}
 	})
 
-	t.Run("NoOpWhenUnchanged", func(t *testing.T) {
-		service := serviceWithIngressIP("10.0.0.20")
-		client := fake.NewSimpleClientset(service)
-		lb := &LoadBalancer{k8sClient: client}
+	t.Run("RemovesBackingAnnotations", func(t *testing.T) {
+		svc := newLBService(map[string]string{
+			AnnotationBackingNode:     "node-a",
+			AnnotationBackingInstance: instID1,
+		})
+		client := fake.NewSimpleClientset(svc)
+		deleted := false
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: client,
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					if deleted {
+						return nil, oxide.ErrObjectNotFound
+					}
+					return &oxide.FloatingIp{Id: "fip-1"}, nil
+				},
+				FloatingIpDeleteFn: func(
+					context.Context, oxide.FloatingIpDeleteParams,
+				) error {
+					deleted = true
+					return nil
+				},
+			},
+		}
 
-		if err := lb.patchServiceStatus(service, newStatus); err != nil {
+		err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc)
+		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
-		for _, action := range client.Actions() {
-			if action.Matches("patch", "services") {
-				t.Fatal("expected no patch when status is unchanged")
-			}
-		}
+		got, _ := client.CoreV1().Services("ns").Get(
+			t.Context(), "svc", metav1.GetOptions{},
+		)
+		assertBackingAnnotations(t, got, "", "")
 	})
+}
 
-	t.Run("ToleratesMissingService", func(t *testing.T) {
-		service := serviceWithIngressIP("10.0.0.10")
-		// The service is absent from the cluster (deleted concurrently).
-		client := fake.NewSimpleClientset()
-		lb := &LoadBalancer{k8sClient: client}
+func TestSharedFloatingIP(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
 
-		if err := lb.patchServiceStatus(service, newStatus); err != nil {
-			t.Fatalf("expected nil error for missing service, got: %v", err)
+	// newSharedLB returns a load balancer backed by a single in-memory floating
+	// IP so the tests can observe it being created once and deleted once.
+	newSharedLB := func(
+		services ...*v1.Service,
+	) (*LoadBalancer, *fake.Clientset, func() *oxide.FloatingIp) {
+		var fip *oxide.FloatingIp
+		objects := make([]runtime.Object, 0, len(services))
+		for _, svc := range services {
+			objects = append(objects, svc)
 		}
-	})
+		client := fake.NewSimpleClientset(objects...)
 
-	t.Run("PropagatesPatchError", func(t *testing.T) {
-		// The service exists, so the patch is attempted, but the API returns a
-		// non-NotFound error which patchServiceStatus must propagate.
-		service := serviceWithIngressIP("10.0.0.10")
+		return &LoadBalancer{
+			project:   "test",
+			k8sClient: client,
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool),
+				FloatingIpViewFn: func(
+					_ context.Context, p oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					if fip == nil || string(p.FloatingIp) != string(fip.Name) {
+						return nil, oxide.ErrObjectNotFound
+					}
+					return fip, nil
+				},
+				FloatingIpCreateFn: func(
+					_ context.Context, p oxide.FloatingIpCreateParams,
+				) (*oxide.FloatingIp, error) {
+					if fip != nil {
+						t.Fatal("shared floating ip created twice")
+					}
+					fip = &oxide.FloatingIp{
+						Id: "fip-1", Name: p.Body.Name, Ip: testFloatingIP,
+					}
+					return fip, nil
+				},
+				FloatingIpAttachFn: func(
+					context.Context, oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					fip.InstanceId = instID1
+					return fip, nil
+				},
+				FloatingIpDetachFn: func(
+					context.Context, oxide.FloatingIpDetachParams,
+				) (*oxide.FloatingIp, error) {
+					fip.InstanceId = ""
+					return fip, nil
+				},
+				FloatingIpDeleteFn: func(
+					context.Context, oxide.FloatingIpDeleteParams,
+				) error {
+					fip = nil
+					return nil
+				},
+			},
+		}, client, func() *oxide.FloatingIp { return fip }
+	}
+
+	t.Run("TwoServicesShare", func(t *testing.T) {
+		web := newSharedLBService("web", "frontend", 80)
+		tls := newSharedLBService("tls", "frontend", 443)
+		lb, _, _ := newSharedLB(web, tls)
+
+		for _, svc := range []*v1.Service{web, tls} {
+			status, err := lb.EnsureLoadBalancer(
+				t.Context(), "cluster", svc, []*v1.Node{node},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", svc.Name, err)
+			}
+			assertProxyAndNodeIngress(t, status.Ingress, "10.0.0.5")
+		}
+	})
+
+	t.Run("PortConflict", func(t *testing.T) {
+		web := newSharedLBService("web", "frontend", 80)
+		other := newSharedLBService("other", "frontend", 80)
+		lb, _, _ := newSharedLB(web, other)
+
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", other, []*v1.Node{node},
+		)
+		if err == nil || !strings.Contains(err.Error(), "already exposed") {
+			t.Fatalf("err = %v, want port conflict", err)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		web := newSharedLBService("web", "Not_Valid", 80)
+		lb, _, _ := newSharedLB(web)
+
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", web, []*v1.Node{node},
+		)
+		if err == nil || !strings.Contains(err.Error(), AnnotationSharedIPKey) {
+			t.Fatalf("err = %v, want invalid key error", err)
+		}
+	})
+
+	t.Run("LastServiceDeletes", func(t *testing.T) {
+		web := newSharedLBService("web", "frontend", 80)
+		tls := newSharedLBService("tls", "frontend", 443)
+		lb, client, current := newSharedLB(web, tls)
+
+		for _, svc := range []*v1.Service{web, tls} {
+			_, err := lb.EnsureLoadBalancer(
+				t.Context(), "cluster", svc, []*v1.Node{node},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", svc.Name, err)
+			}
+		}
+
+		// The first service leaving must keep the floating IP for the second.
+		err := client.CoreV1().Services("ns").Delete(
+			t.Context(), "web", metav1.DeleteOptions{},
+		)
+		if err != nil {
+			t.Fatalf("failed deleting service: %v", err)
+		}
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", web); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if current() == nil {
+			t.Fatal("shared floating ip deleted while still referenced")
+		}
+
+		// The last service leaving deletes it.
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", tls); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if current() != nil {
+			t.Fatal("expected shared floating ip to be deleted")
+		}
+	})
+}
+
+// Internal method tests.
+
+func TestIPPoolFallback(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+
+	tt := []struct {
+		name      string
+		exhausted []string
+		attempts  []string
+		pool      string
+		errorMsg  string
+	}{
+		{
+			name:     "first pool succeeds",
+			attempts: []string{"primary"},
+			pool:     "primary",
+		},
+		{
+			name:      "fallback on exhaustion",
+			exhausted: []string{"primary"},
+			attempts:  []string{"primary", "secondary"},
+			pool:      "secondary",
+		},
+		{
+			name:      "all exhausted",
+			exhausted: []string{"primary", "secondary", "tertiary"},
+			attempts:  []string{"primary", "secondary", "tertiary"},
+			errorMsg: "all ip pools are exhausted: " +
+				"ip pool primary is exhausted: InsufficientCapacity\n" +
+				"ip pool secondary is exhausted: InsufficientCapacity\n" +
+				"ip pool tertiary is exhausted: InsufficientCapacity",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newLBService(map[string]string{
+				AnnotationFloatingIPPool: "primary, secondary,tertiary",
+			})
+			client := fake.NewSimpleClientset(svc)
+
+			var attempts []string
+			lb := &LoadBalancer{
+				project:   "test",
+				k8sClient: client,
+				client: &fakeOxideLBClient{
+					FloatingIpViewFn: func(
+						context.Context, oxide.FloatingIpViewParams,
+					) (*oxide.FloatingIp, error) {
+						return nil, oxide.ErrObjectNotFound
+					},
+					FloatingIpCreateFn: func(
+						_ context.Context, p oxide.FloatingIpCreateParams,
+					) (*oxide.FloatingIp, error) {
+						pool := allocatorPool(p.Body.AddressAllocator)
+						attempts = append(attempts, pool)
+						if slices.Contains(tc.exhausted, pool) {
+							return nil, oxide.ErrInsufficientCapacity
+						}
+						return &oxide.FloatingIp{
+							Id: "fip-1", Ip: testFloatingIP, IpPoolId: "id-" + pool,
+						}, nil
+					},
+					FloatingIpAttachFn: func(
+						context.Context, oxide.FloatingIpAttachParams,
+					) (*oxide.FloatingIp, error) {
+						return &oxide.FloatingIp{
+							Id:         "fip-1",
+							Ip:         testFloatingIP,
+							IpPoolId:   "id-" + attempts[len(attempts)-1],
+							InstanceId: instID1,
+						}, nil
+					},
+				},
+			}
+
+			_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+			if !slices.Equal(attempts, tc.attempts) {
+				t.Fatalf("attempted pools = %v, want %v", attempts, tc.attempts)
+			}
+			if tc.errorMsg != "" {
+				if !errors.Is(err, ErrPoolExhausted) {
+					t.Fatalf("err = %v, want %v", err, ErrPoolExhausted)
+				}
+				if !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, _ := client.CoreV1().Services("ns").Get(t.Context(), "svc", metav1.GetOptions{})
+			if pool := got.Annotations[AnnotationBackingIPPool]; pool != "id-"+tc.pool {
+				t.Fatalf("annotation %s = %q, want %q",
+					AnnotationBackingIPPool, pool, "id-"+tc.pool,
+				)
+			}
+		})
+	}
+
+	t.Run("ExistingInFallbackPoolIsKept", func(t *testing.T) {
+		svc := newLBService(map[string]string{
+			AnnotationFloatingIPPool: "primary,secondary",
+		})
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(svc),
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id:         "fip-1",
+						Ip:         testFloatingIP,
+						IpPoolId:   "id-secondary",
+						InstanceId: instID1,
+					}, nil
+				},
+				IpPoolViewFn: func(
+					_ context.Context, p oxide.IpPoolViewParams,
+				) (*oxide.SiloIpPool, error) {
+					return &oxide.SiloIpPool{Id: "id-" + string(p.Pool)}, nil
+				},
+			},
+		}
+
+		_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("OtherErrorsDoNotFallBack", func(t *testing.T) {
+		svc := newLBService(map[string]string{
+			AnnotationFloatingIPPool: "primary,secondary",
+		})
+		lb := &LoadBalancer{
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(svc),
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return nil, oxide.ErrObjectNotFound
+				},
+				FloatingIpCreateFn: func(
+					_ context.Context, p oxide.FloatingIpCreateParams,
+				) (*oxide.FloatingIp, error) {
+					if pool := allocatorPool(p.Body.AddressAllocator); pool != "primary" {
+						t.Fatalf("fell back to pool %q", pool)
+					}
+					return nil, errBoom
+				},
+			},
+		}
+
+		_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want %v", err, errBoom)
+		}
+	})
+}
+
+func TestFloatingIPDescriptionUpdate(t *testing.T) {
+	owned := floatingIPOwner{Cluster: "cluster", Namespace: "ns", Service: "svc"}.record()
+	svc := newLBService(map[string]string{AnnotationFloatingIPDescription: "Storefront."})
+
+	tt := []struct {
+		name        string
+		description string
+		updated     bool
+	}{
+		{
+			name:        "stale",
+			description: legacyOwnershipTag,
+			updated:     true,
+		},
+		{
+			name:        "current",
+			description: owned + " Storefront.",
+		},
+		{
+			name:        "not owned",
+			description: "Created by hand.",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var updated string
+			lb := &LoadBalancer{
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(svc),
+				client: &fakeOxideLBClient{
+					FloatingIpViewFn: func(
+						context.Context, oxide.FloatingIpViewParams,
+					) (*oxide.FloatingIp, error) {
+						return &oxide.FloatingIp{
+							Id:          "fip-1",
+							Ip:          testFloatingIP,
+							InstanceId:  instID1,
+							Description: tc.description,
+						}, nil
+					},
+					FloatingIpUpdateFn: func(
+						_ context.Context, p oxide.FloatingIpUpdateParams,
+					) (*oxide.FloatingIp, error) {
+						updated = p.Body.Description
+						return &oxide.FloatingIp{
+							Id:          "fip-1",
+							Ip:          testFloatingIP,
+							InstanceId:  instID1,
+							Description: p.Body.Description,
+						}, nil
+					},
+				},
+			}
+
+			_, err := lb.EnsureLoadBalancer(
+				t.Context(), "cluster", svc,
+				[]*v1.Node{newLBNode("node-a", instID1, "10.0.0.5")},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := ""
+			if tc.updated {
+				want = owned + " Storefront."
+			}
+			if updated != want {
+				t.Fatalf("updated description = %q, want %q", updated, want)
+			}
+		})
+	}
+}
+
+func TestFloatingIPProject(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+
+	tt := []struct {
+		name string
+		// project is the value of the floating ip project annotation.
+		project string
+		// crossProjectAttach is whether Oxide allows attaching floating ips
+		// to instances in another project.
+		crossProjectAttach bool
+		wantProject        string
+		errorMsg           string
+	}{
+		{
+			name:        "nodes project by default",
+			wantProject: "test",
+		},
+		{
+			name:               "other project",
+			project:            "networking",
+			crossProjectAttach: true,
+			wantProject:        "networking",
+		},
+		{
+			name:        "same project as nodes",
+			project:     "test",
+			wantProject: "test",
+		},
+		{
+			name:        "cross-project attach unsupported",
+			project:     "networking",
+			wantProject: "networking",
+			errorMsg: `oxide rejected attaching a floating ip in project "networking" ` +
+				`to an instance in project "test"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if tc.project != "" {
+				annotations[AnnotationFloatingIPProject] = tc.project
+			}
+			svc := newLBService(annotations)
+
+			var fip *oxide.FloatingIp
+			projects := make([]string, 0)
+			lb := &LoadBalancer{
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(svc),
+				client: &fakeOxideLBClient{
+					IpPoolListAllPagesFn: listIPPools(defaultV4Pool),
+					FloatingIpViewFn: func(
+						_ context.Context, p oxide.FloatingIpViewParams,
+					) (*oxide.FloatingIp, error) {
+						projects = append(projects, string(p.Project))
+						if fip == nil || string(p.Project) != tc.wantProject {
+							return nil, oxide.ErrObjectNotFound
+						}
+						return fip, nil
+					},
+					FloatingIpCreateFn: func(
+						_ context.Context, p oxide.FloatingIpCreateParams,
+					) (*oxide.FloatingIp, error) {
+						projects = append(projects, string(p.Project))
+						fip = &oxide.FloatingIp{
+							Id:          "fip-1",
+							Name:        p.Body.Name,
+							Ip:          testFloatingIP,
+							Description: p.Body.Description,
+						}
+						return fip, nil
+					},
+					FloatingIpAttachFn: func(
+						_ context.Context, p oxide.FloatingIpAttachParams,
+					) (*oxide.FloatingIp, error) {
+						if tc.wantProject != "test" && !tc.crossProjectAttach {
+							return nil, oxide.ErrInvalidRequest
+						}
+						fip.InstanceId = string(p.Body.Parent)
+						return fip, nil
+					},
+				},
+			}
+
+			_, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+			if tc.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Fatalf("error = %v, want it to contain %q", err, tc.errorMsg)
+				}
+				if !errors.Is(err, oxide.ErrInvalidRequest) {
+					t.Fatalf("error = %v, want it to wrap the oxide error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fip.InstanceId != instID1 {
+				t.Fatalf("floating ip attached to %q, want %q", fip.InstanceId, instID1)
+			}
+
+			// The floating ip is found in its project afterwards.
+			_, exists, err := lb.GetLoadBalancer(t.Context(), "cluster", svc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !exists {
+				t.Fatal("expected the load balancer to exist")
+			}
+
+			for _, project := range projects {
+				if project != tc.wantProject {
+					t.Fatalf("floating ip projects = %v, want only %q", projects, tc.wantProject)
+				}
+			}
+		})
+	}
+}
+
+func TestFloatingIPServiceUID(t *testing.T) {
+	owner := func(uid types.UID) string {
+		return floatingIPOwner{
+			Cluster: "cluster", Namespace: "ns", Service: "svc", UID: uid,
+		}.record()
+	}
+
+	tt := []struct {
+		name        string
+		description string
+		recreated   bool
+	}{
+		{
+			name:        "same uid",
+			description: owner("uid-1"),
+		},
+		{
+			name:        "without uid",
+			description: owner(""),
+		},
+		{
+			name:        "different uid",
+			description: owner("uid-0"),
+			recreated:   true,
+		},
+		{
+			name:        "not owned",
+			description: "Created by hand.",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newLBService(map[string]string{AnnotationFloatingIP: testFloatingIP})
+			svc.UID = "uid-1"
+
+			fip := &oxide.FloatingIp{
+				Id:          "fip-1",
+				Name:        "cluster-ns-svc",
+				Ip:          testFloatingIP,
+				InstanceId:  instID1,
+				Description: tc.description,
+			}
+			var deleted bool
+			lb := &LoadBalancer{
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(svc),
+				client: &fakeOxideLBClient{
+					FloatingIpViewFn: func(
+						context.Context, oxide.FloatingIpViewParams,
+					) (*oxide.FloatingIp, error) {
+						return fip, nil
+					},
+					FloatingIpUpdateFn: func(
+						_ context.Context, p oxide.FloatingIpUpdateParams,
+					) (*oxide.FloatingIp, error) {
+						fip.Description = p.Body.Description
+						return fip, nil
+					},
+					FloatingIpDetachFn: func(
+						context.Context, oxide.FloatingIpDetachParams,
+					) (*oxide.FloatingIp, error) {
+						fip.InstanceId = ""
+						return fip, nil
+					},
+					FloatingIpDeleteFn: func(
+						context.Context, oxide.FloatingIpDeleteParams,
+					) error {
+						deleted = true
+						return nil
+					},
+					FloatingIpCreateFn: func(
+						_ context.Context, p oxide.FloatingIpCreateParams,
+					) (*oxide.FloatingIp, error) {
+						fip = &oxide.FloatingIp{
+							Id:          "fip-2",
+							Name:        p.Body.Name,
+							Ip:          testFloatingIP,
+							Description: p.Body.Description,
+						}
+						return fip, nil
+					},
+					FloatingIpAttachFn: func(
+						_ context.Context, p oxide.FloatingIpAttachParams,
+					) (*oxide.FloatingIp, error) {
+						fip.InstanceId = string(p.Body.Parent)
+						return fip, nil
+					},
+				},
+			}
+
+			_, err := lb.EnsureLoadBalancer(
+				t.Context(), "cluster", svc,
+				[]*v1.Node{newLBNode("node-a", instID1, "10.0.0.5")},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if deleted != tc.recreated {
+				t.Fatalf("floating ip recreated = %t, want %t", deleted, tc.recreated)
+			}
+			if tc.recreated && fip.Id != "fip-2" {
+				t.Fatalf("floating ip = %s, want the recreated floating ip", fip.Id)
+			}
+			if _, owned := parseFloatingIPOwner(tc.description); owned {
+				if want := owner(svc.UID); fip.Description != want {
+					t.Fatalf("description = %q, want %q", fip.Description, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAttachFloatingIPRetry(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+
+	// newLB returns a load balancer whose floating IP starts attached to
+	// instIDOld. Oxide settles every detach and attach, but the first attempts
+	// to attach are answered with attachErrs.
+	newLB := func(attachErrs ...error) (*LoadBalancer, *oxide.FloatingIp, *int) {
+		fip := &oxide.FloatingIp{Id: "fip-1", Name: "cluster-ns-svc", InstanceId: instIDOld}
+		var attaches int
+		return &LoadBalancer{
+			project:       "test",
+			clock:         clock.RealClock{},
+			attachBackoff: backoff,
+			client: &fakeOxideLBClient{
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					viewed := *fip
+					return &viewed, nil
+				},
+				FloatingIpDetachFn: func(
+					context.Context, oxide.FloatingIpDetachParams,
+				) (*oxide.FloatingIp, error) {
+					if fip.InstanceId == "" {
+						return nil, oxide.ErrInvalidRequest
+					}
+					fip.InstanceId = ""
+					return fip, nil
+				},
+				FloatingIpAttachFn: func(
+					_ context.Context, p oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					attaches++
+					if attaches <= len(attachErrs) {
+						return nil, attachErrs[attaches-1]
+					}
+					fip.InstanceId = string(p.Body.Parent)
+					return fip, nil
+				},
+			},
+		}, fip, &attaches
+	}
+
+	t.Run("TransientAttachFailure", func(t *testing.T) {
+		lb, fip, attaches := newLB(oxide.ErrInvalidRequest, oxide.ErrConflict)
+		attached, err := lb.attachFloatingIPToInstance(t.Context(), fip, instIDNew)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if attached.InstanceId != instIDNew {
+			t.Fatalf("floating ip attached to %q, want %q", attached.InstanceId, instIDNew)
+		}
+		if *attaches != 3 {
+			t.Fatalf("attaches = %d, want 3", *attaches)
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		lb, fip, attaches := newLB(slices.Repeat([]error{oxide.ErrInvalidRequest}, 10)...)
+		_, err := lb.attachFloatingIPToInstance(t.Context(), fip, instIDNew)
+		if !errors.Is(err, oxide.ErrInvalidRequest) {
+			t.Fatalf("err = %v, want invalid request", err)
+		}
+		if want := backoff.Steps + 1; *attaches != want {
+			t.Fatalf("attaches = %d, want %d", *attaches, want)
+		}
+	})
+
+	t.Run("NotTransient", func(t *testing.T) {
+		lb, fip, attaches := newLB(errBoom)
+		_, err := lb.attachFloatingIPToInstance(t.Context(), fip, instIDNew)
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want errBoom", err)
+		}
+		if *attaches != 1 {
+			t.Fatalf("attaches = %d, want 1", *attaches)
+		}
+	})
+
+	t.Run("WithoutBackoff", func(t *testing.T) {
+		lb, fip, attaches := newLB(oxide.ErrInvalidRequest)
+		lb.attachBackoff = wait.Backoff{}
+		_, err := lb.attachFloatingIPToInstance(t.Context(), fip, instIDNew)
+		if !errors.Is(err, oxide.ErrInvalidRequest) {
+			t.Fatalf("err = %v, want invalid request", err)
+		}
+		if *attaches != 1 {
+			t.Fatalf("attaches = %d, want 1", *attaches)
+		}
+	})
+}
+
+func TestIgnoredLoadBalancer(t *testing.T) {
+	svc := newLBService(map[string]string{AnnotationIgnore: "true"})
+	svc.Status.LoadBalancer = v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{{IP: testFloatingIP}},
+	}
+	k8sClient := fake.NewSimpleClientset(svc)
+	nodes := []*v1.Node{newLBNode("node-a", instID1, "10.0.0.5")}
+
+	fip := &oxide.FloatingIp{
+		Id:          "fip-1",
+		Name:        "cluster-ns-svc",
+		Ip:          testFloatingIP,
+		InstanceId:  instIDOld,
+		Description: ownedDescription,
+	}
+	client := &fakeOxideLBClient{
+		FloatingIpViewFn: func(
+			context.Context, oxide.FloatingIpViewParams,
+		) (*oxide.FloatingIp, error) {
+			return fip, nil
+		},
+	}
+	lb := &LoadBalancer{project: "test", k8sClient: k8sClient, client: client}
+
+	t.Run("Ignored", func(t *testing.T) {
+		status, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, nodes)
+		if err != nil {
+			t.Fatalf("unexpected ensure error: %v", err)
+		}
+		if !reflect.DeepEqual(*status, svc.Status.LoadBalancer) {
+			t.Fatalf("status = %+v, want %+v", *status, svc.Status.LoadBalancer)
+		}
+
+		if err := lb.UpdateLoadBalancer(t.Context(), "cluster", svc, nodes); err != nil {
+			t.Fatalf("unexpected update error: %v", err)
+		}
+		if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc); err != nil {
+			t.Fatalf("unexpected delete error: %v", err)
+		}
+
+		// The state is still reported.
+		_, exists, err := lb.GetLoadBalancer(t.Context(), "cluster", svc)
+		if err != nil || !exists {
+			t.Fatalf("exists = %t, err = %v, want existing load balancer", exists, err)
+		}
+
+		for _, action := range k8sClient.Actions() {
+			if !slices.Contains([]string{"get", "list", "watch"}, action.GetVerb()) {
+				t.Fatalf("kubernetes action = %v, want no mutations", action)
+			}
+		}
+		if fip.InstanceId != instIDOld {
+			t.Fatalf("floating ip attached to %q, want it untouched", fip.InstanceId)
+		}
+	})
+
+	t.Run("Resumed", func(t *testing.T) {
+		client.FloatingIpDetachFn = func(
+			context.Context, oxide.FloatingIpDetachParams,
+		) (*oxide.FloatingIp, error) {
+			fip.InstanceId = ""
+			return fip, nil
+		}
+		client.FloatingIpAttachFn = func(
+			_ context.Context, p oxide.FloatingIpAttachParams,
+		) (*oxide.FloatingIp, error) {
+			fip.InstanceId = string(p.Body.Parent)
+			return fip, nil
+		}
+		client.IpPoolListAllPagesFn = listIPPools(
+			oxide.SiloIpPool{Id: "pool-1", IsDefault: new(true)},
+		)
+
+		resumed := svc.DeepCopy()
+		delete(resumed.Annotations, AnnotationIgnore)
+		if err := lb.UpdateLoadBalancer(t.Context(), "cluster", resumed, nodes); err != nil {
+			t.Fatalf("unexpected update error: %v", err)
+		}
+		if fip.InstanceId != instID1 {
+			t.Fatalf("floating ip attached to %q, want %q", fip.InstanceId, instID1)
+		}
+	})
+}
+
+// TestConcurrentLoadBalancerOperations drives overlapping ensures and updates
+// for the same service that move the floating IP between two instances. The
+// fake Oxide client rejects detaching an unattached floating IP and attaching
+// an attached one, as Oxide does, so interleaved operations fail.
+func TestConcurrentLoadBalancerOperations(t *testing.T) {
+	svc := newLBService(nil)
+	client := fake.NewSimpleClientset(svc)
+
+	var (
+		mu         sync.Mutex
+		attachedTo string
+	)
+	lb := &LoadBalancer{
+		project:   "test",
+		k8sClient: client,
+		locks:     &keyMutex{},
+		client: &fakeOxideLBClient{
+			FloatingIpViewFn: func(
+				context.Context, oxide.FloatingIpViewParams,
+			) (*oxide.FloatingIp, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return &oxide.FloatingIp{
+					Id: "fip-1", Ip: testFloatingIP, InstanceId: attachedTo,
+				}, nil
+			},
+			FloatingIpDetachFn: func(
+				context.Context, oxide.FloatingIpDetachParams,
+			) (*oxide.FloatingIp, error) {
+				goruntime.Gosched()
+				mu.Lock()
+				defer mu.Unlock()
+				if attachedTo == "" {
+					return nil, errors.New("floating ip is not attached")
+				}
+				attachedTo = ""
+				return &oxide.FloatingIp{Id: "fip-1", Ip: testFloatingIP}, nil
+			},
+			FloatingIpAttachFn: func(
+				_ context.Context, p oxide.FloatingIpAttachParams,
+			) (*oxide.FloatingIp, error) {
+				goruntime.Gosched()
+				mu.Lock()
+				defer mu.Unlock()
+				if attachedTo != "" {
+					return nil, errors.New("floating ip is already attached")
+				}
+				attachedTo = string(p.Body.Parent)
+				return &oxide.FloatingIp{
+					Id: "fip-1", Ip: testFloatingIP, InstanceId: attachedTo,
+				}, nil
+			},
+		},
+	}
+
+	nodeSets := [][]*v1.Node{
+		{newLBNode("node-a", instIDOld, "10.0.0.10")},
+		{newLBNode("node-b", instIDNew, "10.0.0.20")},
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		nodes := nodeSets[i%len(nodeSets)]
+		wg.Go(func() {
+			if _, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, nodes); err != nil {
+				t.Errorf("ensure: unexpected error: %v", err)
+			}
+		})
+		wg.Go(func() {
+			if err := lb.UpdateLoadBalancer(t.Context(), "cluster", svc, nodes); err != nil {
+				t.Errorf("update: unexpected error: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestSelectTargetNode(t *testing.T) {
+	t.Run("FirstEligibleByName", func(t *testing.T) {
+		cordoned := newLBNode("node-a", instID1, "10.0.0.5")
+		cordoned.Spec.Unschedulable = true
+		nodes := []*v1.Node{
+			newLBNode("node-c", instID1, "10.0.0.7"),
+			cordoned,
+			newLBNode("node-b", instID1, "10.0.0.6"),
+		}
+
+		target, err := selectTargetNode(newLBService(nil), nodes, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if target.Name != "node-b" {
+			t.Fatalf("target node = %q, want %q", target.Name, "node-b")
+		}
+
+		// The input slice must not be reordered.
+		if nodes[0].Name != "node-c" {
+			t.Fatalf("input slice was mutated, first node = %q, want %q",
+				nodes[0].Name, "node-c",
+			)
+		}
+	})
+
+	t.Run("NoEligibleNodes", func(t *testing.T) {
+		cordoned := newLBNode("node-a", instID1, "10.0.0.5")
+		cordoned.Spec.Unschedulable = true
+		excluded := newLBNode("node-b", instID1, "10.0.0.6")
+		excluded.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "true"}
+
+		_, err := selectTargetNode(newLBService(nil), []*v1.Node{cordoned, excluded}, nil)
+		if err == nil || !strings.Contains(err.Error(), "no eligible nodes") {
+			t.Fatalf("err = %v, want no eligible nodes error", err)
+		}
+	})
+
+	t.Run("Pinned", func(t *testing.T) {
+		cordoned := newLBNode("node-b", instID1, "10.0.0.6")
+		cordoned.Spec.Unschedulable = true
+		nodes := []*v1.Node{
+			newLBNode("node-a", instID1, "10.0.0.5"),
+			cordoned,
+			newLBNode("node-c", instID1, "10.0.0.7"),
+		}
+
+		tt := []struct {
+			name     string
+			pinned   string
+			expected string
+			errorMsg string
+		}{
+			{
+				name:     "eligible",
+				pinned:   "node-c",
+				expected: "node-c",
+			},
+			{
+				name:     "ineligible",
+				pinned:   "node-b",
+				errorMsg: `pinned node "node-b" is excluded, cordoned, not ready, or shut down`,
+			},
+			{
+				name:     "nonexistent",
+				pinned:   "node-d",
+				errorMsg: `pinned node "node-d" is not a load balancer node`,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newLBService(map[string]string{AnnotationPinnedNode: tc.pinned})
+
+				target, err := selectTargetNode(svc, nodes, nil)
+				if tc.errorMsg != "" {
+					if err == nil || err.Error() != tc.errorMsg {
+						t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if target.Name != tc.expected {
+					t.Fatalf("target node = %q, want %q", target.Name, tc.expected)
+				}
+			})
+		}
+	})
+}
+
+func TestIngressNodes(t *testing.T) {
+	ingress := func(node *v1.Node) *v1.Node {
+		node.Labels = map[string]string{"oxide.computer/ingress": "true"}
+		return node
+	}
+	cordoned := ingress(newLBNode("node-d", instID1, "10.0.0.8"))
+	cordoned.Spec.Unschedulable = true
+
+	tt := []struct {
+		name        string
+		selector    string
+		annotations map[string]string
+		nodes       []*v1.Node
+		expected    string
+		event       bool
+		errorMsg    string
+	}{
+		{
+			name:     "ingress nodes",
+			selector: "oxide.computer/ingress=true",
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				ingress(newLBNode("node-c", instID1, "10.0.0.7")),
+				ingress(newLBNode("node-b", instID1, "10.0.0.6")),
+			},
+			expected: "node-b",
+		},
+		{
+			name:     "no ingress nodes",
+			selector: "oxide.computer/ingress=true",
+			nodes: []*v1.Node{
+				newLBNode("node-b", instID1, "10.0.0.6"),
+				newLBNode("node-a", instID1, "10.0.0.5"),
+			},
+			expected: "node-a",
+			event:    true,
+		},
+		{
+			name:     "no eligible ingress nodes",
+			selector: "oxide.computer/ingress=true",
+			nodes:    []*v1.Node{newLBNode("node-b", instID1, "10.0.0.6"), cordoned},
+			expected: "node-b",
+			event:    true,
+		},
+		{
+			name: "annotation",
+			annotations: map[string]string{
+				AnnotationIngressNodeSelector: "oxide.computer/ingress",
+			},
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				ingress(newLBNode("node-b", instID1, "10.0.0.6")),
+			},
+			expected: "node-b",
+		},
+		{
+			name:        "annotation disables selector",
+			selector:    "oxide.computer/ingress=true",
+			annotations: map[string]string{AnnotationIngressNodeSelector: ""},
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				ingress(newLBNode("node-b", instID1, "10.0.0.6")),
+			},
+			expected: "node-a",
+		},
+		{
+			name:        "pinned",
+			selector:    "oxide.computer/ingress=true",
+			annotations: map[string]string{AnnotationPinnedNode: "node-a"},
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				ingress(newLBNode("node-b", instID1, "10.0.0.6")),
+			},
+			expected: "node-a",
+		},
+		{
+			name:        "invalid selector",
+			annotations: map[string]string{AnnotationIngressNodeSelector: "in valid"},
+			nodes:       []*v1.Node{newLBNode("node-a", instID1, "10.0.0.5")},
+			errorMsg:    `invalid ingress node selector "in valid"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			lb := &LoadBalancer{ingressNodeSelector: tc.selector, recorder: recorder}
+			svc := newLBService(tc.annotations)
+
+			nodes, err := lb.ingressNodes(svc, tc.nodes)
+			if tc.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			target, err := selectTargetNode(svc, nodes, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target.Name != tc.expected {
+				t.Fatalf("target node = %q, want %q", target.Name, tc.expected)
+			}
+
+			if event := len(recorder.Events) > 0; event != tc.event {
+				t.Fatalf("event recorded = %t, want %t", event, tc.event)
+			}
+			if tc.event {
+				if event := <-recorder.Events; !strings.Contains(event, EventReasonNoIngressNodes) {
+					t.Fatalf("event = %q, want reason %s", event, EventReasonNoIngressNodes)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalIPNodes(t *testing.T) {
+	withExternalIP := func(node *v1.Node, ip string) *v1.Node {
+		node.Status.Addresses = append(node.Status.Addresses,
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: ip})
+		return node
+	}
+	prefer := map[string]string{AnnotationPreferExternalIPNodes: "true"}
+	cordoned := withExternalIP(newLBNode("node-a", instID1, "10.0.0.5"), "203.0.113.5")
+	cordoned.Spec.Unschedulable = true
+
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		nodes       []*v1.Node
+		expected    string
+		event       bool
+	}{
+		{
+			name:        "mixed",
+			annotations: prefer,
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				withExternalIP(newLBNode("node-c", instID1, "10.0.0.7"), "203.0.113.7"),
+				withExternalIP(newLBNode("node-b", instID1, "10.0.0.6"), "203.0.113.6"),
+			},
+			expected: "node-b",
+		},
+		{
+			name:        "none",
+			annotations: prefer,
+			nodes: []*v1.Node{
+				newLBNode("node-b", instID1, "10.0.0.6"),
+				newLBNode("node-a", instID1, "10.0.0.5"),
+			},
+			expected: "node-a",
+			event:    true,
+		},
+		{
+			name:        "none eligible",
+			annotations: prefer,
+			nodes:       []*v1.Node{newLBNode("node-b", instID1, "10.0.0.6"), cordoned},
+			expected:    "node-b",
+			event:       true,
+		},
+		{
+			name: "not preferred",
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				withExternalIP(newLBNode("node-b", instID1, "10.0.0.6"), "203.0.113.6"),
+			},
+			expected: "node-a",
+		},
+		{
+			name: "pinned",
+			annotations: map[string]string{
+				AnnotationPreferExternalIPNodes: "true",
+				AnnotationPinnedNode:            "node-a",
+			},
+			nodes: []*v1.Node{
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				withExternalIP(newLBNode("node-b", instID1, "10.0.0.6"), "203.0.113.6"),
+			},
+			expected: "node-a",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			lb := &LoadBalancer{recorder: recorder}
+			svc := newLBService(tc.annotations)
+
+			target, err := selectTargetNode(svc, lb.externalIPNodes(svc, tc.nodes), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target.Name != tc.expected {
+				t.Fatalf("target node = %q, want %q", target.Name, tc.expected)
+			}
+
+			if event := len(recorder.Events) > 0; event != tc.event {
+				t.Fatalf("event recorded = %t, want %t", event, tc.event)
+			}
+			if tc.event {
+				event := <-recorder.Events
+				if !strings.Contains(event, EventReasonNoExternalIPNodes) {
+					t.Fatalf("event = %q, want reason %s", event, EventReasonNoExternalIPNodes)
+				}
+			}
+		})
+	}
+}
+
+func TestIsEligibleLBNode(t *testing.T) {
+	tt := []struct {
+		name     string
+		modify   func(node *v1.Node)
+		expected bool
+	}{
+		{
+			name:     "ready",
+			modify:   func(*v1.Node) {},
+			expected: true,
+		},
+		{
+			name: "control plane",
+			modify: func(node *v1.Node) {
+				node.Labels = map[string]string{"node-role.kubernetes.io/control-plane": ""}
+			},
+			expected: true,
+		},
+		{
+			name: "excluded with empty value",
+			modify: func(node *v1.Node) {
+				node.Labels = map[string]string{v1.LabelNodeExcludeBalancers: ""}
+			},
+			expected: false,
+		},
+		{
+			name: "excluded with true value",
+			modify: func(node *v1.Node) {
+				node.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "true"}
+			},
+			expected: false,
+		},
+		{
+			name: "included with false value",
+			modify: func(node *v1.Node) {
+				node.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "false"}
+			},
+			expected: true,
+		},
+		{
+			name: "being deleted",
+			modify: func(node *v1.Node) {
+				node.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			},
+			expected: false,
+		},
+		{
+			name: "tainted for deletion by the cluster autoscaler",
+			modify: func(node *v1.Node) {
+				node.Spec.Taints = []v1.Taint{{
+					Key:    "ToBeDeletedByClusterAutoscaler",
+					Effect: v1.TaintEffectNoSchedule,
+				}}
+			},
+			expected: false,
+		},
+		{
+			name:     "cordoned",
+			modify:   func(node *v1.Node) { node.Spec.Unschedulable = true },
+			expected: false,
+		},
+		{
+			name: "not ready",
+			modify: func(node *v1.Node) {
+				node.Status.Conditions[0].Status = v1.ConditionFalse
+			},
+			expected: false,
+		},
+		{
+			name: "readiness unknown",
+			modify: func(node *v1.Node) {
+				node.Status.Conditions[0].Status = v1.ConditionUnknown
+			},
+			expected: false,
+		},
+		{
+			name:     "no ready condition",
+			modify:   func(node *v1.Node) { node.Status.Conditions = nil },
+			expected: false,
+		},
+		{
+			name: "shut down",
+			modify: func(node *v1.Node) {
+				node.Spec.Taints = []v1.Taint{{
+					Key:    "node.cloudprovider.kubernetes.io/shutdown",
+					Effect: v1.TaintEffectNoSchedule,
+				}}
+			},
+			expected: false,
+		},
+		{
+			name: "unrelated taint",
+			modify: func(node *v1.Node) {
+				node.Spec.Taints = []v1.Taint{{
+					Key:    "dedicated",
+					Effect: v1.TaintEffectNoSchedule,
+				}}
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			node := newLBNode("node-a", instID1, "10.0.0.5")
+			tc.modify(node)
+			if got := isEligibleLBNode(node); got != tc.expected {
+				t.Fatalf("isEligibleLBNode() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPatchServiceStatus(t *testing.T) {
+	newStatus := &v1.LoadBalancerStatus{
+		Ingress: []v1.LoadBalancerIngress{
+			{IP: "203.0.113.10", IPMode: new(v1.LoadBalancerIPModeProxy)},
+			{IP: "10.0.0.20"},
+		},
+	}
+
+	t.Run("UpdatesStaleNodeIP", func(t *testing.T) {
+		// The service status still advertises the destroyed node's IP.
+		service := serviceWithIngressIP("10.0.0.10")
+		client := fake.NewSimpleClientset(service)
+		lb := &LoadBalancer{k8sClient: client}
+
+		if err := lb.patchServiceStatus(service, newStatus); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The persisted status must reflect the new node's internal IP.
+		got, err := client.CoreV1().Services("default").Get(
+			t.Context(), "svc", metav1.GetOptions{},
+		)
+		if err != nil {
+			t.Fatalf("failed getting service: %v", err)
+		}
+		ingress := got.Status.LoadBalancer.Ingress
+		if len(ingress) != 2 {
+			t.Fatalf("ingress len = %d, want 2", len(ingress))
+		}
+		if ingress[1].IP != "10.0.0.20" {
+			t.Fatalf("node ingress ip = %q, want %q",
+				ingress[1].IP, "10.0.0.20",
+			)
+		}
+
+		// The read-only service parameter must not have been mutated.
+		if service.Status.LoadBalancer.Ingress[1].IP != "10.0.0.10" {
+			t.Fatalf(
+				"service parameter was mutated, node ingress ip = %q, want %q",
+				service.Status.LoadBalancer.Ingress[1].IP, "10.0.0.10",
+			)
+		}
+	})
+
+	t.Run("NoOpWhenUnchanged", func(t *testing.T) {
+		service := serviceWithIngressIP("10.0.0.20")
+		client := fake.NewSimpleClientset(service)
+		lb := &LoadBalancer{k8sClient: client}
+
+		if err := lb.patchServiceStatus(service, newStatus); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, action := range client.Actions() {
+			if action.Matches("patch", "services") {
+				t.Fatal("expected no patch when status is unchanged")
+			}
+		}
+	})
+
+	t.Run("ToleratesMissingService", func(t *testing.T) {
+		service := serviceWithIngressIP("10.0.0.10")
+		// The service is absent from the cluster (deleted concurrently).
+		client := fake.NewSimpleClientset()
+		lb := &LoadBalancer{k8sClient: client}
+
+		if err := lb.patchServiceStatus(service, newStatus); err != nil {
+			t.Fatalf("expected nil error for missing service, got: %v", err)
+		}
+	})
+
+	t.Run("PropagatesPatchError", func(t *testing.T) {
+		// The service exists, so the patch is attempted, but the API returns a
+		// non-NotFound error which patchServiceStatus must propagate.
+		service := serviceWithIngressIP("10.0.0.10")
 		client := fake.NewSimpleClientset(service)
 		client.PrependReactor("patch", "services", func(
 			k8stesting.Action,
@@ -1324,6 +3605,7 @@ func TestPatchServiceStatus(t *testing.T) {
 func TestToLoadBalancerStatus(t *testing.T) {
 	t.Run("WithInternalIPs", func(t *testing.T) {
 		status := toLoadBalancerStatus(
+			newLBService(nil),
 			&oxide.FloatingIp{
 				Ip: "203.0.113.10",
 			},
@@ -1372,6 +3654,7 @@ func TestToLoadBalancerStatus(t *testing.T) {
 
 	t.Run("NoInternalIPs", func(t *testing.T) {
 		status := toLoadBalancerStatus(
+			newLBService(nil),
 			&oxide.FloatingIp{
 				Ip: "203.0.113.10",
 			},
@@ -1387,6 +3670,558 @@ func TestToLoadBalancerStatus(t *testing.T) {
 	})
 }
 
+func TestLoadBalancerHostname(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+	proxy := new(v1.LoadBalancerIPModeProxy)
+
+	tt := []struct {
+		name        string
+		annotations map[string]string
+		expected    []v1.LoadBalancerIngress
+		errorMsg    string
+	}{
+		{
+			name: "ip only",
+			expected: []v1.LoadBalancerIngress{
+				{IP: testFloatingIP, IPMode: proxy},
+				{IP: "10.0.0.5"},
+			},
+		},
+		{
+			name:        "both",
+			annotations: map[string]string{AnnotationHostname: "shop.example.com"},
+			expected: []v1.LoadBalancerIngress{
+				{IP: testFloatingIP, IPMode: proxy},
+				{Hostname: "shop.example.com"},
+				{IP: "10.0.0.5"},
+			},
+		},
+		{
+			name: "hostname only",
+			annotations: map[string]string{
+				AnnotationHostname:     "shop.example.com",
+				AnnotationHostnameMode: HostnameModeHostnameOnly,
+			},
+			expected: []v1.LoadBalancerIngress{
+				{Hostname: "shop.example.com"},
+				{IP: "10.0.0.5"},
+			},
+		},
+		{
+			name:        "invalid hostname",
+			annotations: map[string]string{AnnotationHostname: "Shop_Example"},
+			errorMsg:    `invalid oxide.computer/hostname value "Shop_Example"`,
+		},
+		{
+			name:        "invalid mode",
+			annotations: map[string]string{AnnotationHostnameMode: "instead"},
+			errorMsg:    `invalid oxide.computer/hostname-mode value "instead"`,
+		},
+		{
+			name: "hostname only without hostname",
+			annotations: map[string]string{
+				AnnotationHostnameMode: HostnameModeHostnameOnly,
+			},
+			errorMsg: "requires oxide.computer/hostname",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newLBService(tc.annotations)
+			lb := &LoadBalancer{
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(svc, node),
+				client: &fakeOxideLBClient{
+					FloatingIpViewFn: func(
+						context.Context, oxide.FloatingIpViewParams,
+					) (*oxide.FloatingIp, error) {
+						return &oxide.FloatingIp{
+							Id:          "fip-1",
+							Ip:          testFloatingIP,
+							InstanceId:  instID1,
+							Description: ownedDescription,
+						}, nil
+					},
+				},
+			}
+
+			status, err := lb.EnsureLoadBalancer(t.Context(), "cluster", svc, []*v1.Node{node})
+			if tc.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Fatalf("err = %v, want %q", err, tc.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(status.Ingress, tc.expected) {
+				t.Fatalf("ensure ingress = %+v, want %+v", status.Ingress, tc.expected)
+			}
+
+			status, _, err = lb.GetLoadBalancer(t.Context(), "cluster", svc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(status.Ingress, tc.expected) {
+				t.Fatalf("get ingress = %+v, want %+v", status.Ingress, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAnnotationsWithDefaultPool(t *testing.T) {
+	// namespace returns a namespace annotated with the floating IP pool, if
+	// any.
+	namespace := func(name, pool string) *v1.Namespace {
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if pool != "" {
+			ns.Annotations = map[string]string{AnnotationFloatingIPPool: pool}
+		}
+		return ns
+	}
+
+	lb := &LoadBalancer{
+		k8sClient: fake.NewSimpleClientset(
+			namespace("prod", ""),
+			namespace("tenant", "tenant-pool"),
+			namespace("team", "team-pool"),
+		),
+		defaultPool: "cluster-pool",
+		namespacePools: map[string]string{
+			"prod": "public", "dev": "internal", "tenant": "configured",
+		},
+	}
+
+	tt := []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "annotation takes precedence",
+			namespace:   "prod",
+			annotations: map[string]string{AnnotationFloatingIPPool: "annotated"},
+			expected:    "annotated",
+		},
+		{
+			name:        "annotation takes precedence over namespace annotation",
+			namespace:   "tenant",
+			annotations: map[string]string{AnnotationFloatingIPPool: "annotated"},
+			expected:    "annotated",
+		},
+		{
+			name:      "namespace annotation takes precedence over configuration",
+			namespace: "tenant",
+			expected:  "tenant-pool",
+		},
+		{
+			name:      "namespace annotation only",
+			namespace: "team",
+			expected:  "team-pool",
+		},
+		{
+			name:      "namespace default",
+			namespace: "prod",
+			expected:  "public",
+		},
+		{
+			name:      "namespace default for missing namespace",
+			namespace: "dev",
+			expected:  "internal",
+		},
+		{
+			name:      "cluster default",
+			namespace: "staging",
+			expected:  "cluster-pool",
+		},
+		{
+			name:        "explicit ip is not given a pool",
+			namespace:   "tenant",
+			annotations: map[string]string{AnnotationFloatingIP: "203.0.113.10"},
+			expected:    "",
+		},
+		{
+			name:        "ip version is not given a pool",
+			namespace:   "dev",
+			annotations: map[string]string{AnnotationFloatingIPVersion: "v6"},
+			expected:    "",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newLBService(tc.annotations)
+			svc.Namespace = tc.namespace
+
+			annotations, err := lb.annotationsWithDefaultPool(t.Context(), svc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := annotations[AnnotationFloatingIPPool]; got != tc.expected {
+				t.Fatalf("pool = %q, want %q", got, tc.expected)
+			}
+
+			// The service's annotations must not be modified.
+			if tc.annotations == nil && svc.Annotations != nil {
+				t.Fatalf("service annotations were modified: %v", svc.Annotations)
+			}
+		})
+	}
+
+	t.Run("NoDefaults", func(t *testing.T) {
+		lb := &LoadBalancer{k8sClient: fake.NewSimpleClientset()}
+		got, err := lb.annotationsWithDefaultPool(t.Context(), newLBService(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := got[AnnotationFloatingIPPool]; ok {
+			t.Fatalf("annotations = %v, want no pool", got)
+		}
+	})
+
+	t.Run("NamespaceLookupFails", func(t *testing.T) {
+		k8sClient := fake.NewSimpleClientset()
+		k8sClient.PrependReactor("get", "namespaces",
+			func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errBoom
+			},
+		)
+		lb := &LoadBalancer{k8sClient: k8sClient, defaultPool: "cluster-pool"}
+
+		// The cluster default must not be used in place of a pool the
+		// namespace may enforce.
+		_, err := lb.annotationsWithDefaultPool(t.Context(), newLBService(nil))
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want %v", err, errBoom)
+		}
+	})
+
+	t.Run("NamespaceCached", func(t *testing.T) {
+		k8sClient := fake.NewSimpleClientset(namespace("ns", "first"))
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		lb := &LoadBalancer{
+			k8sClient:          k8sClient,
+			clock:              fakeClock,
+			namespacePoolCache: &namespacePoolCache{},
+		}
+
+		pool := func(t *testing.T) string {
+			t.Helper()
+			annotations, err := lb.annotationsWithDefaultPool(t.Context(), newLBService(nil))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return annotations[AnnotationFloatingIPPool]
+		}
+
+		if got := pool(t); got != "first" {
+			t.Fatalf("pool = %q, want %q", got, "first")
+		}
+
+		_, err := k8sClient.CoreV1().Namespaces().Update(
+			t.Context(), namespace("ns", "second"), metav1.UpdateOptions{},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := pool(t); got != "first" {
+			t.Fatalf("pool = %q, want the cached %q", got, "first")
+		}
+
+		fakeClock.Step(namespacePoolTTL)
+		if got := pool(t); got != "second" {
+			t.Fatalf("pool = %q, want %q once the cache expired", got, "second")
+		}
+	})
+}
+
+func TestWithDefaultPool(t *testing.T) {
+	renamed := oxide.SiloIpPool{
+		Id: "pool-renamed", Name: "public", IpVersion: oxide.IpVersionV4, IsDefault: new(true),
+	}
+	nonDefault := oxide.SiloIpPool{
+		Id: "pool-other", Name: "other", IpVersion: oxide.IpVersionV4, IsDefault: new(false),
+	}
+
+	// selectedPool returns the pool explicitly selected by the allocator.
+	selectedPool := func(t *testing.T, allocator oxide.AddressAllocator) oxide.NameOrId {
+		t.Helper()
+		auto, ok := allocator.AsAuto()
+		if !ok {
+			t.Fatalf("allocator = %+v, want auto", allocator)
+		}
+		ps, ok := auto.PoolSelector.AsExplicit()
+		if !ok {
+			t.Fatalf("pool selector = %+v, want explicit", auto.PoolSelector)
+		}
+		return ps.Pool
+	}
+
+	t.Run("RenamedDefaultPool", func(t *testing.T) {
+		lb := &LoadBalancer{
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(nonDefault, renamed),
+			},
+		}
+
+		allocator, err := lb.withDefaultPool(
+			t.Context(), oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pool := selectedPool(t, allocator); pool != "pool-renamed" {
+			t.Fatalf("pool = %q, want %q", pool, "pool-renamed")
+		}
+	})
+
+	t.Run("DefaultPoolForIPVersion", func(t *testing.T) {
+		lb := &LoadBalancer{
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool, defaultV6Pool),
+			},
+		}
+
+		allocator, err := lb.withDefaultPool(t.Context(), oxide.AddressAllocator{
+			Value: &oxide.AddressAllocatorAuto{
+				PoolSelector: oxide.PoolSelector{
+					Value: &oxide.PoolSelectorAuto{IpVersion: oxide.IpVersionV6},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pool := selectedPool(t, allocator); pool != "pool-v6" {
+			t.Fatalf("pool = %q, want %q", pool, "pool-v6")
+		}
+	})
+
+	t.Run("ExplicitPoolUnchanged", func(t *testing.T) {
+		// The list func is nil: if it is called, the test fails.
+		lb := &LoadBalancer{client: &fakeOxideLBClient{}}
+
+		allocator, err := addressAllocatorFromAnnotations(
+			map[string]string{AnnotationFloatingIPPool: "annotated"},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		allocator, err = lb.withDefaultPool(t.Context(), allocator)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pool := selectedPool(t, allocator); pool != "annotated" {
+			t.Fatalf("pool = %q, want %q", pool, "annotated")
+		}
+	})
+
+	t.Run("NoDefaultPool", func(t *testing.T) {
+		lb := &LoadBalancer{
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(nonDefault),
+			},
+		}
+
+		_, err := lb.withDefaultPool(
+			t.Context(), oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}},
+		)
+		if err == nil || !strings.Contains(err.Error(), "no default ip pool") {
+			t.Fatalf("err = %v, want no default ip pool error", err)
+		}
+	})
+
+	t.Run("DefaultPoolForBothVersions", func(t *testing.T) {
+		lb := &LoadBalancer{
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: listIPPools(defaultV4Pool, defaultV6Pool),
+			},
+		}
+
+		_, err := lb.withDefaultPool(
+			t.Context(), oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}},
+		)
+		if err == nil || !strings.Contains(err.Error(), AnnotationFloatingIPVersion) {
+			t.Fatalf("err = %v, want error naming %s", err, AnnotationFloatingIPVersion)
+		}
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		lists := 0
+		lb := &LoadBalancer{
+			defaultPools: &defaultPoolCache{},
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: func(
+					ctx context.Context, p oxide.IpPoolListParams,
+				) ([]oxide.SiloIpPool, error) {
+					lists++
+					return listIPPools(renamed)(ctx, p)
+				},
+			},
+		}
+
+		for range 2 {
+			_, err := lb.withDefaultPool(
+				t.Context(), oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if lists != 1 {
+			t.Fatalf("ip pools listed %d times, want 1", lists)
+		}
+	})
+}
+
+func TestDefaultPoolResolution(t *testing.T) {
+	node := newLBNode("node-a", instID1, "10.0.0.5")
+
+	// newLB returns a load balancer listing the pools returned by pools and
+	// creating floating ips from existing pools, recording the pools used.
+	newLB := func(
+		pools func() []oxide.SiloIpPool,
+	) (lb *LoadBalancer, lists *int, created *[]string) {
+		lists, created = new(0), new([]string{})
+		lb = &LoadBalancer{
+			project:      "test",
+			k8sClient:    fake.NewSimpleClientset(newLBService(nil)),
+			defaultPools: &defaultPoolCache{},
+			client: &fakeOxideLBClient{
+				IpPoolListAllPagesFn: func(
+					context.Context, oxide.IpPoolListParams,
+				) ([]oxide.SiloIpPool, error) {
+					*lists++
+					return pools(), nil
+				},
+				FloatingIpViewFn: func(
+					context.Context, oxide.FloatingIpViewParams,
+				) (*oxide.FloatingIp, error) {
+					return nil, oxide.ErrObjectNotFound
+				},
+				FloatingIpCreateFn: func(
+					_ context.Context, p oxide.FloatingIpCreateParams,
+				) (*oxide.FloatingIp, error) {
+					pool := allocatorPool(p.Body.AddressAllocator)
+					exists := slices.ContainsFunc(pools(), func(p oxide.SiloIpPool) bool {
+						return p.Id == pool
+					})
+					if !exists {
+						return nil, oxide.ErrObjectNotFound
+					}
+					*created = append(*created, pool)
+					return &oxide.FloatingIp{
+						Id: "fip-1", Name: p.Body.Name, Ip: testFloatingIP, IpPoolId: pool,
+					}, nil
+				},
+				FloatingIpAttachFn: func(
+					_ context.Context, p oxide.FloatingIpAttachParams,
+				) (*oxide.FloatingIp, error) {
+					return &oxide.FloatingIp{
+						Id: "fip-1", Ip: testFloatingIP, InstanceId: string(p.Body.Parent),
+					}, nil
+				},
+			},
+		}
+		return lb, lists, created
+	}
+
+	t.Run("ResolvedOnce", func(t *testing.T) {
+		lb, lists, created := newLB(func() []oxide.SiloIpPool {
+			return []oxide.SiloIpPool{defaultV4Pool}
+		})
+
+		if err := lb.resolveDefaultPool(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for range 2 {
+			_, err := lb.EnsureLoadBalancer(
+				t.Context(), "cluster", newLBService(nil), []*v1.Node{node},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		if *lists != 1 {
+			t.Fatalf("ip pools listed %d times, want 1", *lists)
+		}
+		if want := []string{"pool-v4", "pool-v4"}; !slices.Equal(*created, want) {
+			t.Fatalf("floating ips created from %v, want %v", *created, want)
+		}
+	})
+
+	t.Run("ResolvedPerVersion", func(t *testing.T) {
+		lb, lists, _ := newLB(func() []oxide.SiloIpPool {
+			return []oxide.SiloIpPool{defaultV4Pool, defaultV6Pool}
+		})
+
+		if err := lb.resolveDefaultPool(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		listed := *lists
+
+		for _, version := range []oxide.IpVersion{oxide.IpVersionV4, oxide.IpVersionV6} {
+			if _, ok := lb.defaultPools.get(version); !ok {
+				t.Fatalf("default %s pool not resolved", version)
+			}
+		}
+		if _, err := lb.siloDefaultPool(t.Context(), oxide.IpVersionV6); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *lists != listed {
+			t.Fatalf("ip pools listed again after resolving them")
+		}
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		lb, lists, _ := newLB(func() []oxide.SiloIpPool { return nil })
+		lb.defaultPool = "public"
+
+		if err := lb.resolveDefaultPool(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *lists != 0 {
+			t.Fatalf("ip pools listed %d times, want 0", *lists)
+		}
+	})
+
+	t.Run("ReresolvedWhenMissing", func(t *testing.T) {
+		replaced := oxide.SiloIpPool{
+			Id: "pool-new", Name: "default-new", IpVersion: oxide.IpVersionV4,
+			IsDefault: new(true),
+		}
+		current := defaultV4Pool
+		lb, lists, created := newLB(func() []oxide.SiloIpPool {
+			return []oxide.SiloIpPool{current}
+		})
+
+		if err := lb.resolveDefaultPool(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The default pool is replaced after it was resolved.
+		current = replaced
+
+		_, err := lb.EnsureLoadBalancer(
+			t.Context(), "cluster", newLBService(nil), []*v1.Node{node},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if *lists != 2 {
+			t.Fatalf("ip pools listed %d times, want 2", *lists)
+		}
+		if want := []string{"pool-new"}; !slices.Equal(*created, want) {
+			t.Fatalf("floating ips created from %v, want %v", *created, want)
+		}
+	})
+}
+
 func TestAddressAllocatorFromAnnotations(t *testing.T) {
 	t.Run("NoAnnotations", func(t *testing.T) {
 		alloc, err := addressAllocatorFromAnnotations(nil)
@@ -1444,6 +4279,34 @@ func TestAddressAllocatorFromAnnotations(t *testing.T) {
 		}
 	})
 
+	t.Run("FallbackPools", func(t *testing.T) {
+		annotations := map[string]string{
+			AnnotationFloatingIPPool: " external , overflow,,backup ",
+		}
+		alloc, err := addressAllocatorFromAnnotations(annotations)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pool := allocatorPool(alloc); pool != "external" {
+			t.Fatalf("pool = %q, want %q", pool, "external")
+		}
+		fallback := fallbackIPPools(annotations)
+		if !slices.Equal(fallback, []string{"overflow", "backup"}) {
+			t.Fatalf("fallback pools = %v, want [overflow backup]", fallback)
+		}
+	})
+
+	t.Run("NoPoolInList", func(t *testing.T) {
+		_, err := addressAllocatorFromAnnotations(
+			map[string]string{
+				AnnotationFloatingIPPool: " , ",
+			},
+		)
+		if err == nil {
+			t.Fatal("expected error for pool list without pools")
+		}
+	})
+
 	t.Run("IPVersion", func(t *testing.T) {
 		alloc, err := addressAllocatorFromAnnotations(
 			map[string]string{
@@ -0,0 +1,17 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+// TestMain fails the package tests when a goroutine outlives them, which
+// catches contexts and timers that are never cancelled.
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	"github.com/oxidecomputer/oxide-cloud-controller-manager/internal/version"
+)
+
+// metricsNamespace prefixes every metric exported by the cloud provider.
+const metricsNamespace = "oxide_ccm"
+
+// Load balancer operations used as the operation label value.
+const (
+	lbOperationEnsure = "ensure"
+	lbOperationUpdate = "update"
+	lbOperationDelete = "delete"
+)
+
+// Reasons the Oxide API rejected a request used as the reason label value.
+const (
+	authFailureUnauthorized = "unauthorized"
+	authFailureForbidden    = "forbidden"
+)
+
+var (
+	buildInfo = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Name:           "build_info",
+			Help:           "Build information of the cloud controller manager, always 1.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"version", "git_commit", "oxide_sdk_version", "go_version"},
+	)
+
+	lbReconcileDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "lb",
+			Name:           "reconcile_duration_seconds",
+			Help:           "Duration of load balancer reconciles by operation.",
+			Buckets:        metrics.ExponentialBuckets(0.05, 2, 12),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation"},
+	)
+
+	lbReattachTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "lb",
+			Name:           "reattach_total",
+			Help:           "Number of times a floating IP was moved to a different instance.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	lbErrorsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "lb",
+			Name:           "errors_total",
+			Help:           "Number of failed load balancer reconciles by operation.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation"},
+	)
+
+	oxideAuthFailuresTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "oxide_api",
+			Name:           "auth_failures_total",
+			Help:           "Number of Oxide API requests rejected as unauthorized or forbidden.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+
+	oxideCircuitBreakerState = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "oxide_api",
+			Name:           "circuit_breaker_state",
+			Help:           "Oxide API circuit breaker state: 0 closed, 1 half-open, 2 open.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"host"},
+	)
+
+	nodeDriftTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "node",
+			Name:           "drift_total",
+			Help:           "Number of times node labels or addresses differed from the instance.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"kind"},
+	)
+
+	nodesManaged = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "node",
+			Name:           "managed",
+			Help:           "Number of nodes resolved to their Oxide instance.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	nodesUnmanaged = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "node",
+			Name:           "unmanaged",
+			Help:           "Number of nodes not resolved to an Oxide instance by reason.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+
+	floatingIPPoolTotal = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "floating_ip_pool",
+			Name:           "total",
+			Help:           "Number of addresses in a floating IP pool.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"pool"},
+	)
+
+	floatingIPPoolAvailable = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      "floating_ip_pool",
+			Name:           "available",
+			Help:           "Number of addresses left in a floating IP pool.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"pool"},
+	)
+)
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the cloud provider metrics with the legacy
+// registry served on the cloud controller manager's /metrics endpoint.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(
+			buildInfo,
+			lbReconcileDuration,
+			lbReattachTotal,
+			lbErrorsTotal,
+			oxideAuthFailuresTotal,
+			oxideCircuitBreakerState,
+			nodeDriftTotal,
+			nodesManaged,
+			nodesUnmanaged,
+			floatingIPPoolTotal,
+			floatingIPPoolAvailable,
+		)
+
+		info := version.Get()
+		buildInfo.WithLabelValues(
+			info.Version, info.GitCommit, info.OxideSDKVersion, info.GoVersion,
+		).Set(1)
+	})
+}
+
+// observeLBReconcile records the duration and outcome of a load balancer
+// reconcile that started at start. It is meant to be deferred with a pointer
+// to the reconcile's error result.
+func observeLBReconcile(operation string, start time.Time, err *error) {
+	lbReconcileDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		lbErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,152 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/oxidecomputer/oxide-cloud-controller-manager/internal/version"
+)
+
+func TestBuildInfoMetric(t *testing.T) {
+	registerMetrics()
+
+	info := version.Get()
+	want := fmt.Sprintf(`
+# HELP oxide_ccm_build_info [ALPHA] Build information of the cloud controller manager, always 1.
+# TYPE oxide_ccm_build_info gauge
+oxide_ccm_build_info{git_commit=%q,go_version=%q,oxide_sdk_version=%q,version=%q} 1
+`, info.GitCommit, info.GoVersion, info.OxideSDKVersion, info.Version)
+
+	err := testutil.GatherAndCompare(
+		legacyregistry.DefaultGatherer, strings.NewReader(want), "oxide_ccm_build_info",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadBalancerMetrics(t *testing.T) {
+	// The metrics are global, so assert on deltas from their current values.
+	counts := func() map[string]uint64 {
+		t.Helper()
+		result := make(map[string]uint64)
+		for _, operation := range []string{
+			lbOperationEnsure, lbOperationUpdate, lbOperationDelete,
+		} {
+			count, err := testutil.GetHistogramMetricCount(
+				lbReconcileDuration.WithLabelValues(operation),
+			)
+			if err != nil {
+				t.Fatalf("failed reading histogram: %v", err)
+			}
+			result[operation] = count
+		}
+		return result
+	}
+	counter := func(metric metrics.CounterMetric) float64 {
+		t.Helper()
+		value, err := testutil.GetCounterMetricValue(metric)
+		if err != nil {
+			t.Fatalf("failed reading counter: %v", err)
+		}
+		return value
+	}
+
+	durationsBefore := counts()
+	reattachBefore := counter(lbReattachTotal)
+	updateErrorsBefore := counter(lbErrorsTotal.WithLabelValues(lbOperationUpdate))
+
+	fip := &oxide.FloatingIp{Id: "fip-1", Name: "cluster-ns-svc", Ip: testFloatingIP}
+	lb := &LoadBalancer{
+		project:   "test",
+		k8sClient: fake.NewSimpleClientset(),
+		client: &fakeOxideLBClient{
+			FloatingIpViewFn: func(
+				context.Context, oxide.FloatingIpViewParams,
+			) (*oxide.FloatingIp, error) {
+				if fip == nil {
+					return nil, oxide.ErrObjectNotFound
+				}
+				return fip, nil
+			},
+			FloatingIpAttachFn: func(
+				_ context.Context, p oxide.FloatingIpAttachParams,
+			) (*oxide.FloatingIp, error) {
+				fip.InstanceId = string(p.Body.Parent)
+				return fip, nil
+			},
+			FloatingIpDetachFn: func(
+				context.Context, oxide.FloatingIpDetachParams,
+			) (*oxide.FloatingIp, error) {
+				fip.InstanceId = ""
+				return fip, nil
+			},
+			FloatingIpDeleteFn: func(
+				context.Context, oxide.FloatingIpDeleteParams,
+			) error {
+				fip = nil
+				return nil
+			},
+		},
+	}
+	svc := newLBService(nil)
+
+	_, err := lb.EnsureLoadBalancer(
+		t.Context(), "cluster", svc,
+		[]*v1.Node{newLBNode("node-a", instIDOld, "10.0.0.10")},
+	)
+	if err != nil {
+		t.Fatalf("unexpected ensure error: %v", err)
+	}
+
+	// The backing node was replaced, so the floating IP moves.
+	err = lb.UpdateLoadBalancer(
+		t.Context(), "cluster", svc,
+		[]*v1.Node{newLBNode("node-b", instIDNew, "10.0.0.20")},
+	)
+	if err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+
+	// An update without nodes fails.
+	if err := lb.UpdateLoadBalancer(t.Context(), "cluster", svc, nil); err == nil {
+		t.Fatal("expected update error without nodes")
+	}
+
+	if err := lb.EnsureLoadBalancerDeleted(t.Context(), "cluster", svc); err != nil {
+		t.Fatalf("unexpected delete error: %v", err)
+	}
+
+	durationsAfter := counts()
+	for operation, want := range map[string]uint64{
+		lbOperationEnsure: 1,
+		lbOperationUpdate: 2,
+		lbOperationDelete: 1,
+	} {
+		if got := durationsAfter[operation] - durationsBefore[operation]; got != want {
+			t.Fatalf("%s reconcile observations = %d, want %d", operation, got, want)
+		}
+	}
+
+	if got := counter(lbReattachTotal) - reattachBefore; got != 1 {
+		t.Fatalf("reattach total delta = %v, want 1", got)
+	}
+
+	updateErrors := counter(lbErrorsTotal.WithLabelValues(lbOperationUpdate))
+	if got := updateErrors - updateErrorsBefore; got != 1 {
+		t.Fatalf("update errors delta = %v, want 1", got)
+	}
+}
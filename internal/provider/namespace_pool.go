@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespacePoolTTL is how long the floating IP pool of a namespace is cached
+// before the namespace is read again, so that changing it takes effect without
+// a restart.
+const namespacePoolTTL = time.Minute
+
+// namespacePoolCache caches the floating IP pool annotated on namespaces. A
+// nil cache caches nothing.
+type namespacePoolCache struct {
+	mu      sync.Mutex
+	entries map[string]namespacePoolEntry
+}
+
+// namespacePoolEntry is the cached floating IP pool of a namespace, which is
+// empty when the namespace has none.
+type namespacePoolEntry struct {
+	pool    string
+	expires time.Time
+}
+
+// get returns the cached pool of the namespace, unless it expired by now.
+func (c *namespacePoolCache) get(namespace string, now time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[namespace]
+	if !ok || !now.Before(entry.expires) {
+		return "", false
+	}
+	return entry.pool, true
+}
+
+// set caches the pool of the namespace until expires.
+func (c *namespacePoolCache) set(namespace, pool string, expires time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]namespacePoolEntry)
+	}
+	c.entries[namespace] = namespacePoolEntry{pool: pool, expires: expires}
+}
+
+// namespacePool returns the floating IP pool the namespace is annotated with
+// via [AnnotationFloatingIPPool], or an empty string when it has none or does
+// not exist.
+func (l *LoadBalancer) namespacePool(ctx context.Context, namespace string) (string, error) {
+	if l.namespacePoolCache != nil {
+		if pool, ok := l.namespacePoolCache.get(namespace, l.clock.Now()); ok {
+			return pool, nil
+		}
+	}
+
+	var pool string
+	ns, err := l.k8sClient.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return "", fmt.Errorf("failed getting namespace %s: %w", namespace, err)
+	default:
+		pool = ns.Annotations[AnnotationFloatingIPPool]
+	}
+
+	if l.namespacePoolCache != nil {
+		l.namespacePoolCache.set(namespace, pool, l.clock.Now().Add(namespacePoolTTL))
+	}
+	return pool, nil
+}
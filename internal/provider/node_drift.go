@@ -0,0 +1,224 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// EventReasonNodeDrift is the reason of the warning event recorded on nodes
+// whose labels or addresses differ from those derived from their instance.
+const EventReasonNodeDrift = "NodeDrift"
+
+// Kinds of node drift used as the kind label value.
+const (
+	nodeDriftLabels    = "labels"
+	nodeDriftAddresses = "addresses"
+)
+
+// instanceMetadataGetter returns the metadata of a node's instance. It is
+// implemented by [InstancesV2] and exists so it can be mocked in tests.
+type instanceMetadataGetter interface {
+	InstanceMetadata(context.Context, *v1.Node) (*cloudprovider.InstanceMetadata, error)
+}
+
+// nodeDriftDetector compares the labels and addresses of nodes to those
+// derived from their instances, which drift apart when a node is edited by
+// hand or an instance changes after its node was initialized. The cloud node
+// controller only applies labels when initializing a node, so drifted labels
+// otherwise stay wrong for the lifetime of the node.
+type nodeDriftDetector struct {
+	instances instanceMetadataGetter
+	k8sClient kubernetes.Interface
+
+	// recorder records events on nodes. No events are recorded when nil.
+	recorder record.EventRecorder
+
+	// correct updates drifted nodes to match their instances rather than only
+	// reporting them.
+	correct bool
+}
+
+// nodeDrift is how a node differs from its instance.
+type nodeDrift struct {
+	// labels are the labels whose values differ, with their expected values.
+	labels map[string]string
+
+	// addresses are the expected addresses when they differ, or nil.
+	addresses []v1.NodeAddress
+}
+
+// detect checks every node with an Oxide provider ID for drift. Nodes whose
+// instance metadata cannot be determined are skipped until the next run.
+func (d *nodeDriftDetector) detect(ctx context.Context) error {
+	nodes, err := d.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed listing kubernetes nodes: %w", err)
+	}
+
+	for _, node := range nodes.Items {
+		if _, err := ParseProviderID(node.Spec.ProviderID); err != nil {
+			continue
+		}
+
+		metadata, err := d.instances.InstanceMetadata(ctx, &node)
+		if err != nil {
+			klog.V(2).InfoS("skipping drift detection for node",
+				"node", klog.KObj(&node), "err", err)
+			continue
+		}
+
+		drift := detectNodeDrift(&node, metadata)
+		if len(drift.labels) == 0 && drift.addresses == nil {
+			continue
+		}
+
+		d.report(&node, drift)
+
+		if d.correct {
+			if err := d.correctNode(ctx, &node, drift); err != nil {
+				klog.ErrorS(err, "failed correcting node drift", "node", klog.KObj(&node))
+			}
+		}
+	}
+
+	return nil
+}
+
+// detectNodeDrift compares the node to the metadata of its instance. Labels
+// the node has that the metadata does not set are not drift, since they may
+// be set by anyone. Addresses are compared regardless of their order.
+func detectNodeDrift(node *v1.Node, metadata *cloudprovider.InstanceMetadata) nodeDrift {
+	expected := maps.Clone(metadata.AdditionalLabels)
+	if expected == nil {
+		expected = make(map[string]string)
+	}
+	if metadata.Region != "" {
+		expected[v1.LabelTopologyRegion] = metadata.Region
+	}
+	if metadata.Zone != "" {
+		expected[v1.LabelTopologyZone] = metadata.Zone
+	}
+
+	drift := nodeDrift{labels: make(map[string]string)}
+	for key, value := range expected {
+		if value != "" && node.Labels[key] != value {
+			drift.labels[key] = value
+		}
+	}
+
+	if !sameNodeAddresses(node.Status.Addresses, metadata.NodeAddresses) {
+		drift.addresses = metadata.NodeAddresses
+	}
+
+	return drift
+}
+
+// sameNodeAddresses reports whether a and b hold the same addresses in any
+// order.
+func sameNodeAddresses(a, b []v1.NodeAddress) bool {
+	compare := func(x, y v1.NodeAddress) int {
+		return strings.Compare(string(x.Type)+"/"+x.Address, string(y.Type)+"/"+y.Address)
+	}
+	return slices.Equal(
+		slices.SortedFunc(slices.Values(a), compare),
+		slices.SortedFunc(slices.Values(b), compare),
+	)
+}
+
+// report logs, counts, and records an event for the node's drift.
+func (d *nodeDriftDetector) report(node *v1.Node, drift nodeDrift) {
+	details := make([]string, 0, 2)
+
+	if len(drift.labels) > 0 {
+		nodeDriftTotal.WithLabelValues(nodeDriftLabels).Inc()
+
+		labels := make([]string, 0, len(drift.labels))
+		for _, key := range slices.Sorted(maps.Keys(drift.labels)) {
+			labels = append(labels, fmt.Sprintf("%s=%q (have %q)",
+				key, drift.labels[key], node.Labels[key]))
+		}
+		details = append(details, "labels "+strings.Join(labels, ", "))
+	}
+
+	if drift.addresses != nil {
+		nodeDriftTotal.WithLabelValues(nodeDriftAddresses).Inc()
+
+		details = append(details, fmt.Sprintf("addresses %s (have %s)",
+			formatNodeAddresses(drift.addresses), formatNodeAddresses(node.Status.Addresses)))
+	}
+
+	message := "Node differs from its Oxide instance: " + strings.Join(details, "; ")
+	klog.InfoS("detected node drift", "node", klog.KObj(node), "drift", details)
+
+	if d.recorder != nil {
+		d.recorder.Event(node, v1.EventTypeWarning, EventReasonNodeDrift, message)
+	}
+}
+
+// formatNodeAddresses formats addresses as type=address pairs.
+func formatNodeAddresses(addresses []v1.NodeAddress) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, string(address.Type)+"="+address.Address)
+	}
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+// correctNode updates the node's drifted labels and addresses to match its
+// instance.
+func (d *nodeDriftDetector) correctNode(
+	ctx context.Context,
+	node *v1.Node,
+	drift nodeDrift,
+) error {
+	if drift.addresses != nil {
+		// A JSON merge patch replaces the list of addresses as a whole.
+		patch, err := json.Marshal(map[string]any{
+			"status": map[string]any{"addresses": drift.addresses},
+		})
+		if err != nil {
+			return fmt.Errorf("failed marshaling addresses patch: %w", err)
+		}
+
+		_, err = d.k8sClient.CoreV1().Nodes().Patch(
+			ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status",
+		)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed patching addresses for node %s: %w", node.Name, err)
+		}
+	}
+
+	if len(drift.labels) > 0 {
+		patch, err := labelsMergePatch(node.Labels, drift.labels)
+		if err != nil {
+			return err
+		}
+
+		_, err = d.k8sClient.CoreV1().Nodes().Patch(
+			ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{},
+		)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed patching labels for node %s: %w", node.Name, err)
+		}
+	}
+
+	klog.InfoS("corrected node drift", "node", klog.KObj(node))
+	return nil
+}
@@ -0,0 +1,206 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// fakeInstanceMetadata returns the same instance metadata for every node.
+type fakeInstanceMetadata struct {
+	metadata *cloudprovider.InstanceMetadata
+}
+
+func (f *fakeInstanceMetadata) InstanceMetadata(
+	context.Context, *v1.Node,
+) (*cloudprovider.InstanceMetadata, error) {
+	return f.metadata, nil
+}
+
+func TestNodeDriftDetector(t *testing.T) {
+	registerMetrics()
+
+	drifts := func(t *testing.T, kind string) float64 {
+		t.Helper()
+		value, err := testutil.GetCounterMetricValue(nodeDriftTotal.WithLabelValues(kind))
+		if err != nil {
+			t.Fatalf("failed reading counter: %v", err)
+		}
+		return value
+	}
+
+	addresses := []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "172.30.0.5"},
+		{Type: v1.NodeHostName, Address: "node-1"},
+	}
+	metadata := &cloudprovider.InstanceMetadata{
+		ProviderID:    ProviderID{InstanceID: instID1}.String(),
+		NodeAddresses: addresses,
+		Zone:          "rack-1",
+		AdditionalLabels: map[string]string{
+			"oxide.computer/project": "test",
+			"oxide.computer/rack":    "rack-1",
+		},
+	}
+
+	// syncedNode returns a node matching the metadata, with a label of its
+	// own.
+	syncedNode := func() *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node-1",
+				Labels: map[string]string{
+					"oxide.computer/project": "test",
+					"oxide.computer/rack":    "rack-1",
+					v1.LabelTopologyZone:     "rack-1",
+					"example.com/team":       "platform",
+				},
+			},
+			Spec: v1.NodeSpec{ProviderID: ProviderID{InstanceID: instID1}.String()},
+			Status: v1.NodeStatus{
+				// The order of the addresses doesn't matter.
+				Addresses: []v1.NodeAddress{addresses[1], addresses[0]},
+			},
+		}
+	}
+
+	tt := []struct {
+		name           string
+		modify         func(*v1.Node)
+		correct        bool
+		wantEvent      string
+		wantLabels     int
+		wantAddresses  int
+		wantNodeLabel  string
+		wantNodeAddrIP string
+	}{
+		{
+			name:           "NoDrift",
+			wantNodeLabel:  "test",
+			wantNodeAddrIP: "172.30.0.5",
+		},
+		{
+			name: "LabelEditedByHand",
+			modify: func(n *v1.Node) {
+				n.Labels["oxide.computer/project"] = "edited"
+			},
+			wantEvent: `Warning NodeDrift Node differs from its Oxide instance: ` +
+				`labels oxide.computer/project="test" (have "edited")`,
+			wantLabels:     1,
+			wantNodeLabel:  "edited",
+			wantNodeAddrIP: "172.30.0.5",
+		},
+		{
+			name: "AddressChanged",
+			modify: func(n *v1.Node) {
+				n.Status.Addresses[1].Address = "172.30.0.9"
+			},
+			wantEvent: "Warning NodeDrift Node differs from its Oxide instance: " +
+				"addresses [InternalIP=172.30.0.5 Hostname=node-1] " +
+				"(have [Hostname=node-1 InternalIP=172.30.0.9])",
+			wantAddresses:  1,
+			wantNodeLabel:  "test",
+			wantNodeAddrIP: "172.30.0.9",
+		},
+		{
+			name: "Corrected",
+			modify: func(n *v1.Node) {
+				delete(n.Labels, "oxide.computer/project")
+				n.Status.Addresses[1].Address = "172.30.0.9"
+			},
+			correct:        true,
+			wantEvent:      "Warning NodeDrift",
+			wantLabels:     1,
+			wantAddresses:  1,
+			wantNodeLabel:  "test",
+			wantNodeAddrIP: "172.30.0.5",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			node := syncedNode()
+			if tc.modify != nil {
+				tc.modify(node)
+			}
+
+			// A node of another cloud provider is left alone.
+			foreign := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "foreign"},
+				Spec:       v1.NodeSpec{ProviderID: "aws:///us-east-1a/i-0123"},
+			}
+
+			k8sClient := fake.NewSimpleClientset(node, foreign)
+			recorder := record.NewFakeRecorder(10)
+			detector := &nodeDriftDetector{
+				instances: &fakeInstanceMetadata{metadata: metadata},
+				k8sClient: k8sClient,
+				recorder:  recorder,
+				correct:   tc.correct,
+			}
+
+			labelsBefore := drifts(t, nodeDriftLabels)
+			addressesBefore := drifts(t, nodeDriftAddresses)
+
+			if err := detector.detect(t.Context()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := drifts(t, nodeDriftLabels) - labelsBefore; got != float64(tc.wantLabels) {
+				t.Errorf("label drifts = %v, want %d", got, tc.wantLabels)
+			}
+			got := drifts(t, nodeDriftAddresses) - addressesBefore
+			if got != float64(tc.wantAddresses) {
+				t.Errorf("address drifts = %v, want %d", got, tc.wantAddresses)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tc.wantEvent == "" || !strings.HasPrefix(event, tc.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tc.wantEvent)
+				}
+			default:
+				if tc.wantEvent != "" {
+					t.Errorf("no event, want %q", tc.wantEvent)
+				}
+			}
+
+			updated, err := k8sClient.CoreV1().Nodes().Get(
+				t.Context(), "node-1", metav1.GetOptions{},
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := updated.Labels["oxide.computer/project"]; got != tc.wantNodeLabel {
+				t.Errorf("project label = %q, want %q", got, tc.wantNodeLabel)
+			}
+			if updated.Labels["example.com/team"] != "platform" {
+				t.Errorf("labels = %v, want the node's own label kept", updated.Labels)
+			}
+			if !hasNodeAddress(updated.Status.Addresses, tc.wantNodeAddrIP) {
+				t.Errorf("addresses = %v, want %s", updated.Status.Addresses, tc.wantNodeAddrIP)
+			}
+		})
+	}
+}
+
+// hasNodeAddress reports whether addresses include the internal IP ip.
+func hasNodeAddress(addresses []v1.NodeAddress, ip string) bool {
+	for _, address := range addresses {
+		if address.Type == v1.NodeInternalIP && address.Address == ip {
+			return true
+		}
+	}
+	return false
+}
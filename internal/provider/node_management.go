@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// Reasons a node is not managed used as the reason label value.
+const (
+	nodeUnmanagedForeignProviderID = "foreign_provider_id"
+	nodeUnmanagedInvalidProviderID = "invalid_provider_id"
+	nodeUnmanagedNotFound          = "instance_not_found"
+	nodeUnmanagedProjectMismatch   = "project_mismatch"
+	nodeUnmanagedAmbiguousName     = "ambiguous_instance_name"
+)
+
+// nodeUnmanagedReasons are every reason a node is not managed, so that the
+// gauges of reasons no node has anymore drop to zero.
+var nodeUnmanagedReasons = []string{
+	nodeUnmanagedForeignProviderID,
+	nodeUnmanagedInvalidProviderID,
+	nodeUnmanagedNotFound,
+	nodeUnmanagedProjectMismatch,
+	nodeUnmanagedAmbiguousName,
+}
+
+// nodeManagementTTL is how long a node is counted after it was last synced.
+// The cloud node lifecycle controller syncs every node every few seconds, so
+// only deleted nodes go unsynced for this long.
+const nodeManagementTTL = 10 * time.Minute
+
+// nodeManagement tracks which nodes were resolved to their Oxide instance and
+// why the others were not, and exports them as the managed and unmanaged node
+// gauges, so that nodes provisioned incorrectly stand out. A nil tracker
+// tracks nothing.
+type nodeManagement struct {
+	clock clock.PassiveClock
+
+	mu    sync.Mutex
+	nodes map[string]nodeManagementEntry
+}
+
+// nodeManagementEntry is the outcome of the last sync of a node.
+type nodeManagementEntry struct {
+	// reason is why the node is not managed, or empty when it is.
+	reason string
+	synced time.Time
+}
+
+// newNodeManagement returns a tracker with no nodes.
+func newNodeManagement(clock clock.PassiveClock) *nodeManagement {
+	return &nodeManagement{clock: clock, nodes: make(map[string]nodeManagementEntry)}
+}
+
+// record records the outcome of looking up the node's instance, where err is
+// the error of the lookup, and updates the gauges. Errors that say nothing
+// about the node, such as the Oxide API being unreachable, leave the node as
+// it was.
+func (m *nodeManagement) record(nodeName string, err error) {
+	if m == nil || nodeName == "" {
+		return
+	}
+
+	var reason string
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrForeignProviderID):
+		reason = nodeUnmanagedForeignProviderID
+	case errors.Is(err, ErrProviderIDInvalid):
+		reason = nodeUnmanagedInvalidProviderID
+	case errors.Is(err, ErrInstanceNotFound):
+		reason = nodeUnmanagedNotFound
+	case errors.Is(err, ErrInstanceProjectMismatch):
+		reason = nodeUnmanagedProjectMismatch
+	case errors.Is(err, ErrAmbiguousInstanceName):
+		reason = nodeUnmanagedAmbiguousName
+	default:
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	m.nodes[nodeName] = nodeManagementEntry{reason: reason, synced: now}
+
+	managed := 0
+	unmanaged := make(map[string]int, len(nodeUnmanagedReasons))
+	for name, entry := range m.nodes {
+		if now.Sub(entry.synced) > nodeManagementTTL {
+			delete(m.nodes, name)
+			continue
+		}
+		if entry.reason == "" {
+			managed++
+		} else {
+			unmanaged[entry.reason]++
+		}
+	}
+
+	nodesManaged.Set(float64(managed))
+	for _, reason := range nodeUnmanagedReasons {
+		nodesUnmanaged.WithLabelValues(reason).Set(float64(unmanaged[reason]))
+	}
+}
@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestNodeManagement(t *testing.T) {
+	registerMetrics()
+
+	gauges := func(t *testing.T) map[string]float64 {
+		t.Helper()
+		result := make(map[string]float64)
+		managed, err := testutil.GetGaugeMetricValue(nodesManaged)
+		if err != nil {
+			t.Fatalf("failed reading gauge: %v", err)
+		}
+		result["managed"] = managed
+		for _, reason := range nodeUnmanagedReasons {
+			value, err := testutil.GetGaugeMetricValue(nodesUnmanaged.WithLabelValues(reason))
+			if err != nil {
+				t.Fatalf("failed reading gauge: %v", err)
+			}
+			result[reason] = value
+		}
+		return result
+	}
+	assertGauges := func(t *testing.T, want map[string]float64) {
+		t.Helper()
+		got := gauges(t)
+		for _, reason := range nodeUnmanagedReasons {
+			if _, ok := want[reason]; !ok {
+				want[reason] = 0
+			}
+		}
+		if !maps.Equal(got, want) {
+			t.Fatalf("gauges = %v, want %v", got, want)
+		}
+	}
+
+	node := func(name, providerID string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.NodeSpec{ProviderID: providerID},
+		}
+	}
+
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	management := newNodeManagement(fakeClock)
+	instance := instanceRunning
+	i := &InstancesV2{
+		client: &recreatedInstanceClient{
+			mockOxideClient: &mockOxideClient{},
+			recreated:       &instance,
+		},
+		project:        "test",
+		nodeManagement: management,
+	}
+
+	for _, node := range []*v1.Node{
+		node("node-1", ProviderID{InstanceID: instanceRunning.Id}.String()),
+		node("node-2", ProviderID{InstanceID: instIDOld}.String()),
+		node("node-3", ""),
+		node("aws-node", "aws:///us-east-1a/i-0123456789abcdef0"),
+		node("broken-node", "oxide://not-a-uuid"),
+	} {
+		// Nodes with a malformed provider ID fail, the others do not.
+		_, err := i.InstanceShutdown(t.Context(), node)
+		if (err != nil) != (node.Name == "broken-node") {
+			t.Fatalf("unexpected error for node %s: %v", node.Name, err)
+		}
+	}
+
+	assertGauges(t, map[string]float64{
+		"managed":                      1,
+		nodeUnmanagedNotFound:          2,
+		nodeUnmanagedForeignProviderID: 1,
+		nodeUnmanagedInvalidProviderID: 1,
+	})
+
+	// A failure to reach the Oxide API leaves the node as it was.
+	unreachable := &InstancesV2{
+		client:         &mockOxideClient{InstanceViewError: errBoom},
+		project:        "test",
+		nodeManagement: management,
+	}
+	if _, err := unreachable.InstanceShutdown(
+		t.Context(), node("node-1", ProviderID{InstanceID: instanceRunning.Id}.String()),
+	); err == nil {
+		t.Fatal("expected an error")
+	}
+	assertGauges(t, map[string]float64{
+		"managed":                      1,
+		nodeUnmanagedNotFound:          2,
+		nodeUnmanagedForeignProviderID: 1,
+		nodeUnmanagedInvalidProviderID: 1,
+	})
+
+	// Nodes that are no longer synced, such as deleted nodes, are dropped.
+	fakeClock.Step(nodeManagementTTL / 2)
+	if _, err := i.InstanceExists(
+		t.Context(), node("node-1", ProviderID{InstanceID: instanceRunning.Id}.String()),
+	); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fakeClock.Step(nodeManagementTTL/2 + time.Second)
+	if _, err := i.InstanceMetadata(t.Context(), node("node-3", "")); err == nil {
+		t.Fatal("expected an error")
+	}
+	assertGauges(t, map[string]float64{"managed": 1, nodeUnmanagedNotFound: 1})
+}
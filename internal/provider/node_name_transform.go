@@ -0,0 +1,64 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nodeNameTransform derives the name of a node's Oxide instance from the node
+// name, for provisioners that name nodes differently from their instances. A
+// nil transform uses node names as instance names.
+type nodeNameTransform struct {
+	// trimPrefix is removed from the start of node names.
+	trimPrefix string
+
+	// trimSuffix is removed from the end of node names.
+	trimSuffix string
+
+	// regex, when set, is matched against node names after trimming, and its
+	// first capture group is the instance name.
+	regex *regexp.Regexp
+}
+
+// newNodeNameTransform compiles the configured transform. It returns nil when
+// none is configured.
+func newNodeNameTransform(config *NodeNameTransformConfig) (*nodeNameTransform, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	t := &nodeNameTransform{trimPrefix: config.TrimPrefix, trimSuffix: config.TrimSuffix}
+	if config.Regex != "" {
+		regex, err := regexp.Compile(config.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node name transform regex: %w", err)
+		}
+		if regex.NumSubexp() == 0 {
+			return nil, errors.New("node name transform regex must have a capture group")
+		}
+		t.regex = regex
+	}
+	return t, nil
+}
+
+// instanceName returns the name of the instance of the node with the name.
+// Node names the regex does not match are trimmed only.
+func (t *nodeNameTransform) instanceName(nodeName string) string {
+	if t == nil {
+		return nodeName
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(nodeName, t.trimPrefix), t.trimSuffix)
+	if t.regex != nil {
+		if match := t.regex.FindStringSubmatch(name); match != nil && match[1] != "" {
+			name = match[1]
+		}
+	}
+	return name
+}
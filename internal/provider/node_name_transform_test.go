@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeNameTransform(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     *NodeNameTransformConfig
+		nodeName   string
+		wantFound  bool
+		wantLookup string
+	}{
+		{
+			name:       "NoTransform",
+			nodeName:   "node-1",
+			wantFound:  true,
+			wantLookup: "node-1",
+		},
+		{
+			name:       "TrimPrefix",
+			config:     &NodeNameTransformConfig{TrimPrefix: "k8s-"},
+			nodeName:   "k8s-node-1",
+			wantFound:  true,
+			wantLookup: "node-1",
+		},
+		{
+			name:       "TrimPrefixAndSuffix",
+			config:     &NodeNameTransformConfig{TrimPrefix: "k8s-", TrimSuffix: ".cluster"},
+			nodeName:   "k8s-node-1.cluster",
+			wantFound:  true,
+			wantLookup: "node-1",
+		},
+		{
+			name:       "PrefixMissing",
+			config:     &NodeNameTransformConfig{TrimPrefix: "k8s-"},
+			nodeName:   "node-1",
+			wantFound:  true,
+			wantLookup: "node-1",
+		},
+		{
+			name:       "Regex",
+			config:     &NodeNameTransformConfig{Regex: `^[a-z]+-pool-(.+)-[0-9a-f]{5}$`},
+			nodeName:   "prod-pool-node-1-8c2f1",
+			wantFound:  true,
+			wantLookup: "node-1",
+		},
+		{
+			name:       "RegexNotMatching",
+			config:     &NodeNameTransformConfig{Regex: `^[a-z]+-pool-(.+)-[0-9a-f]{5}$`},
+			nodeName:   "k8s-node-1",
+			wantLookup: "k8s-node-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := newNodeNameTransform(tt.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := transform.instanceName(tt.nodeName); got != tt.wantLookup {
+				t.Fatalf("instance name = %q, want %q", got, tt.wantLookup)
+			}
+
+			recreated := instanceRunning
+			i := &InstancesV2{
+				client: &recreatedInstanceClient{
+					mockOxideClient: &mockOxideClient{},
+					recreated:       &recreated,
+				},
+				project:       "test",
+				nameTransform: transform,
+			}
+
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.nodeName}}
+			instance, _, err := i.getInstance(t.Context(), node)
+			if !tt.wantFound {
+				if !errors.Is(err, ErrInstanceNotFound) {
+					t.Fatalf("err = %v, want %v", err, ErrInstanceNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if instance.Id != instanceRunning.Id {
+				t.Fatalf("instance = %s, want %s", instance.Id, instanceRunning.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeScores returns the score of each eligible node for the floating IP of
+// the service, keyed by node name, as configured by [NodeScoringConfig]. It
+// returns nil when node scoring is disabled or the service is pinned to a
+// node, in which case [selectTargetNode] orders nodes by name only.
+func (l *LoadBalancer) nodeScores(
+	ctx context.Context,
+	clusterName string,
+	service *v1.Service,
+	nodes []*v1.Node,
+) (map[string]int, error) {
+	if l.nodeScoring == nil {
+		return nil, nil
+	}
+	if _, pinned := service.Annotations[AnnotationPinnedNode]; pinned {
+		return nil, nil
+	}
+
+	scores := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		if isEligibleLBNode(node) {
+			scores[node.Name] = 0
+		}
+	}
+
+	if weight := l.nodeScoring.FloatingIPWeight; weight > 0 {
+		counts, err := l.floatingIPCounts(ctx, clusterName, service)
+		if err != nil {
+			return nil, err
+		}
+		for name := range scores {
+			scores[name] -= weight * counts[name]
+		}
+	}
+
+	if weight := l.nodeScoring.PodWeight; weight > 0 {
+		pods, err := l.k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(
+			ctx, metav1.ListOptions{},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing pods: %w", err)
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+				continue
+			}
+			if _, ok := scores[pod.Spec.NodeName]; ok {
+				scores[pod.Spec.NodeName] -= weight
+			}
+		}
+	}
+
+	return scores, nil
+}
+
+// floatingIPCounts returns the number of floating IPs attached to each node,
+// keyed by node name, according to the backing node annotation of services.
+// The floating IP of the service itself is not counted, so that it does not
+// move away from the node it is attached to, and services sharing a floating
+// IP count once.
+func (l *LoadBalancer) floatingIPCounts(
+	ctx context.Context,
+	clusterName string,
+	service *v1.Service,
+) (map[string]int, error) {
+	services, err := l.k8sClient.CoreV1().Services(metav1.NamespaceAll).List(
+		ctx, metav1.ListOptions{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing services: %w", err)
+	}
+
+	own := l.GetLoadBalancerName(ctx, clusterName, service)
+	backingNodes := make(map[string]string)
+	for i := range services.Items {
+		svc := &services.Items[i]
+		node := svc.Annotations[AnnotationBackingNode]
+		if svc.Spec.Type != v1.ServiceTypeLoadBalancer || node == "" {
+			continue
+		}
+		if name := l.GetLoadBalancerName(ctx, clusterName, svc); name != own {
+			backingNodes[name] = node
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, node := range backingNodes {
+		counts[node]++
+	}
+	return counts, nil
+}
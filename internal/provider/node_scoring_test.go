@@ -0,0 +1,197 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeScoring(t *testing.T) {
+	// backedService returns a service whose floating IP is attached to node.
+	backedService := func(name, node string, annotations map[string]string) *v1.Service {
+		svc := newLBService(annotations)
+		svc.Name = name
+		if svc.Annotations == nil {
+			svc.Annotations = map[string]string{}
+		}
+		svc.Annotations[AnnotationBackingNode] = node
+		return svc
+	}
+
+	// podsOn returns count pods scheduled on node in phase.
+	podsOn := func(node string, count int, phase v1.PodPhase) []runtime.Object {
+		pods := make([]runtime.Object, 0, count)
+		for i := range count {
+			pods = append(pods, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns",
+					Name:      fmt.Sprintf("%s-%s-%d", node, phase, i),
+				},
+				Spec:   v1.PodSpec{NodeName: node},
+				Status: v1.PodStatus{Phase: phase},
+			})
+		}
+		return pods
+	}
+
+	tt := []struct {
+		name     string
+		scoring  *NodeScoringConfig
+		service  *v1.Service
+		objects  []runtime.Object
+		cordon   string
+		expected string
+	}{
+		{
+			name:    "Disabled",
+			service: newLBService(nil),
+			objects: []runtime.Object{
+				backedService("other", "node-a", nil),
+			},
+			expected: "node-a",
+		},
+		{
+			name:    "FewestFloatingIPs",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: newLBService(nil),
+			objects: []runtime.Object{
+				backedService("one", "node-a", nil),
+				backedService("two", "node-a", nil),
+				backedService("three", "node-b", nil),
+			},
+			expected: "node-c",
+		},
+		{
+			name:    "TieByName",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: newLBService(nil),
+			objects: []runtime.Object{
+				backedService("one", "node-a", nil),
+				backedService("two", "node-b", nil),
+				backedService("three", "node-c", nil),
+			},
+			expected: "node-a",
+		},
+		{
+			name:    "TieKeepsBackingNode",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: backedService("svc", "node-b", nil),
+			objects: []runtime.Object{
+				backedService("one", "node-a", nil),
+				backedService("two", "node-c", nil),
+			},
+			expected: "node-b",
+		},
+		{
+			name:    "OwnFloatingIPNotCounted",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: backedService("svc", "node-c", nil),
+			objects: []runtime.Object{
+				backedService("svc", "node-c", nil),
+				backedService("one", "node-a", nil),
+				backedService("two", "node-b", nil),
+			},
+			expected: "node-c",
+		},
+		{
+			name:    "SharedFloatingIPCountsOnce",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: newLBService(nil),
+			objects: []runtime.Object{
+				backedService("one", "node-a", nil),
+				backedService("two", "node-a", nil),
+				backedService("three", "node-b", map[string]string{AnnotationSharedIPKey: "web"}),
+				backedService("four", "node-b", map[string]string{AnnotationSharedIPKey: "web"}),
+				backedService("five", "node-c", nil),
+				backedService("six", "node-c", nil),
+			},
+			expected: "node-b",
+		},
+		{
+			name:    "FewestRunningPods",
+			scoring: &NodeScoringConfig{PodWeight: 1},
+			service: newLBService(nil),
+			objects: slices.Concat(
+				podsOn("node-a", 3, v1.PodRunning),
+				podsOn("node-b", 1, v1.PodRunning),
+				podsOn("node-b", 3, v1.PodSucceeded),
+				podsOn("node-c", 2, v1.PodPending),
+			),
+			expected: "node-b",
+		},
+		{
+			name:    "CombinedWeights",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 10, PodWeight: 1},
+			service: newLBService(nil),
+			objects: slices.Concat(
+				[]runtime.Object{
+					backedService("one", "node-a", nil),
+					backedService("two", "node-b", nil),
+				},
+				podsOn("node-b", 2, v1.PodRunning),
+				podsOn("node-c", 15, v1.PodRunning),
+			),
+			expected: "node-a",
+		},
+		{
+			name:    "IneligibleNodeNotSelected",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: newLBService(nil),
+			objects: []runtime.Object{
+				backedService("one", "node-b", nil),
+				backedService("two", "node-b", nil),
+				backedService("three", "node-c", nil),
+			},
+			cordon:   "node-a",
+			expected: "node-c",
+		},
+		{
+			name:    "PinnedIgnoresScores",
+			scoring: &NodeScoringConfig{FloatingIPWeight: 1},
+			service: newLBService(map[string]string{AnnotationPinnedNode: "node-a"}),
+			objects: []runtime.Object{
+				backedService("one", "node-a", nil),
+			},
+			expected: "node-a",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes := []*v1.Node{
+				newLBNode("node-c", instID1, "10.0.0.7"),
+				newLBNode("node-a", instID1, "10.0.0.5"),
+				newLBNode("node-b", instID1, "10.0.0.6"),
+			}
+			for _, node := range nodes {
+				node.Spec.Unschedulable = node.Name == tc.cordon
+			}
+
+			lb := &LoadBalancer{
+				k8sClient:   fake.NewSimpleClientset(tc.objects...),
+				nodeScoring: tc.scoring,
+			}
+
+			scores, err := lb.nodeScores(t.Context(), "cluster", tc.service, nodes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			target, err := selectTargetNode(tc.service, nodes, scores)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target.Name != tc.expected {
+				t.Fatalf("target node = %q, want %q (scores %v)", target.Name, tc.expected, scores)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+// limitedInstanceClient wraps an [oxideInstanceClient] so that at most as many
+// calls as slots has room for are in flight. The slots are shared by the
+// clients of all regions so the limit applies across all node syncs.
+//
+// A slot is held for a single call only, including any retries the HTTP client
+// makes with a reloaded token, and not while waiting between retries such as
+// the re-checks of [notFoundRecheck], so a node backing off does not hold up
+// other nodes.
+type limitedInstanceClient struct {
+	client oxideInstanceClient
+
+	// slots holds a token for each in-flight call.
+	slots chan struct{}
+}
+
+var _ oxideInstanceClient = (*limitedInstanceClient)(nil)
+
+// newLimitedInstanceClient returns client limited to the calls slots has
+// room for. It returns client as is when slots is nil.
+func newLimitedInstanceClient(
+	client oxideInstanceClient,
+	slots chan struct{},
+) oxideInstanceClient {
+	if slots == nil {
+		return client
+	}
+	return &limitedInstanceClient{client: client, slots: slots}
+}
+
+// limited calls call once a slot is free.
+func limited[T any](ctx context.Context, slots chan struct{}, call func() (T, error)) (T, error) {
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, fmt.Errorf("failed waiting to call oxide api: %w", ctx.Err())
+	case slots <- struct{}{}:
+	}
+	defer func() { <-slots }()
+
+	return call()
+}
+
+func (c *limitedInstanceClient) InstanceNetworkInterfaceList(
+	ctx context.Context,
+	params oxide.InstanceNetworkInterfaceListParams,
+) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+	return limited(ctx, c.slots, func() (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+		return c.client.InstanceNetworkInterfaceList(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) InstanceExternalIpList(
+	ctx context.Context,
+	params oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	return limited(ctx, c.slots, func() (*oxide.ExternalIpResultsPage, error) {
+		return c.client.InstanceExternalIpList(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) InstanceView(
+	ctx context.Context,
+	params oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	return limited(ctx, c.slots, func() (*oxide.Instance, error) {
+		return c.client.InstanceView(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) InstanceListAllPages(
+	ctx context.Context,
+	params oxide.InstanceListParams,
+) ([]oxide.Instance, error) {
+	return limited(ctx, c.slots, func() ([]oxide.Instance, error) {
+		return c.client.InstanceListAllPages(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) DiskView(
+	ctx context.Context,
+	params oxide.DiskViewParams,
+) (*oxide.Disk, error) {
+	return limited(ctx, c.slots, func() (*oxide.Disk, error) {
+		return c.client.DiskView(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) InstanceDiskListAllPages(
+	ctx context.Context,
+	params oxide.InstanceDiskListParams,
+) ([]oxide.Disk, error) {
+	return limited(ctx, c.slots, func() ([]oxide.Disk, error) {
+		return c.client.InstanceDiskListAllPages(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) ProjectView(
+	ctx context.Context,
+	params oxide.ProjectViewParams,
+) (*oxide.Project, error) {
+	return limited(ctx, c.slots, func() (*oxide.Project, error) {
+		return c.client.ProjectView(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) CurrentUserView(ctx context.Context) (*oxide.CurrentUser, error) {
+	return limited(ctx, c.slots, func() (*oxide.CurrentUser, error) {
+		return c.client.CurrentUserView(ctx)
+	})
+}
+
+func (c *limitedInstanceClient) SledListAllPages(
+	ctx context.Context,
+	params oxide.SledListParams,
+) ([]oxide.Sled, error) {
+	return limited(ctx, c.slots, func() ([]oxide.Sled, error) {
+		return c.client.SledListAllPages(ctx, params)
+	})
+}
+
+func (c *limitedInstanceClient) SledInstanceListAllPages(
+	ctx context.Context,
+	params oxide.SledInstanceListParams,
+) ([]oxide.SledInstance, error) {
+	return limited(ctx, c.slots, func() ([]oxide.SledInstance, error) {
+		return c.client.SledInstanceListAllPages(ctx, params)
+	})
+}
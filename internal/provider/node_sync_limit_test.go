@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// inFlightOxideClient wraps an [oxideInstanceClient] and records the highest
+// number of concurrent calls to the instance, network interface, and external
+// IP APIs made through it.
+type inFlightOxideClient struct {
+	oxideInstanceClient
+
+	inFlight, maxInFlight, calls atomic.Int64
+}
+
+// track records a call for its duration.
+func (c *inFlightOxideClient) track() func() {
+	n := c.inFlight.Add(1)
+	for {
+		highest := c.maxInFlight.Load()
+		if n <= highest || c.maxInFlight.CompareAndSwap(highest, n) {
+			break
+		}
+	}
+	c.calls.Add(1)
+	time.Sleep(time.Millisecond)
+	return func() { c.inFlight.Add(-1) }
+}
+
+func (c *inFlightOxideClient) InstanceView(
+	ctx context.Context,
+	params oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	defer c.track()()
+	return c.oxideInstanceClient.InstanceView(ctx, params)
+}
+
+func (c *inFlightOxideClient) InstanceNetworkInterfaceList(
+	ctx context.Context,
+	params oxide.InstanceNetworkInterfaceListParams,
+) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+	defer c.track()()
+	return c.oxideInstanceClient.InstanceNetworkInterfaceList(ctx, params)
+}
+
+func (c *inFlightOxideClient) InstanceExternalIpList(
+	ctx context.Context,
+	params oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	defer c.track()()
+	return c.oxideInstanceClient.InstanceExternalIpList(ctx, params)
+}
+
+func TestNodeSyncConcurrency(t *testing.T) {
+	t.Run("BoundsInFlightCalls", func(t *testing.T) {
+		const concurrency = 3
+		slots := make(chan struct{}, concurrency)
+
+		west := &inFlightOxideClient{oxideInstanceClient: &mockOxideClient{
+			InstanceViewOutput:                 &instanceRunning,
+			InstanceNetworkInterfaceListOutput: &nicsWithIPv4,
+			InstanceExternalIpListOutput:       &oxide.ExternalIpResultsPage{},
+		}}
+		east := &inFlightOxideClient{oxideInstanceClient: &mockOxideClient{
+			InstanceViewError: oxide.ErrObjectNotFound,
+		}}
+		instancesV2 := &InstancesV2{
+			project:   "test",
+			k8sClient: fake.NewSimpleClientset(),
+			regionClients: map[string]oxideInstanceClient{
+				"west": newLimitedInstanceClient(west, slots),
+				"east": newLimitedInstanceClient(east, slots),
+			},
+		}
+
+		// Many nodes register at once, each searching both regions.
+		const nodes = 50
+		var wg sync.WaitGroup
+		for range nodes {
+			wg.Go(func() {
+				_, err := instancesV2.InstanceMetadata(t.Context(), &nodeWithProviderID)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+		wg.Wait()
+
+		// The limit is shared between the regions.
+		if n := max(west.maxInFlight.Load(), east.maxInFlight.Load()); n > concurrency {
+			t.Fatalf("max in-flight calls = %d, want at most %d", n, concurrency)
+		}
+		if n := west.maxInFlight.Load() + east.maxInFlight.Load(); n < 2 {
+			t.Fatalf("max in-flight calls = %d, want calls to run concurrently", n)
+		}
+		if west.calls.Load() < nodes || east.calls.Load() < nodes {
+			t.Fatalf("calls = %d west, %d east, want at least %d each",
+				west.calls.Load(), east.calls.Load(), nodes)
+		}
+	})
+
+	t.Run("CanceledWhileWaiting", func(t *testing.T) {
+		slots := make(chan struct{}, 1)
+		slots <- struct{}{}
+		client := newLimitedInstanceClient(
+			&mockOxideClient{InstanceViewOutput: &instanceRunning}, slots,
+		)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := client.InstanceView(ctx, oxide.InstanceViewParams{})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context canceled", err)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		mock := &mockOxideClient{}
+		if client := newLimitedInstanceClient(mock, nil); client != mock {
+			t.Fatalf("client = %T, want the unwrapped client", client)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// maxConcurrentNotFoundRechecks bounds the instance lookups re-checking
+// not-found instances across all nodes.
+const maxConcurrentNotFoundRechecks = 4
+
+// notFoundRecheckBackoff is the backoff between re-checks of a not-found
+// instance. It is jittered so that nodes that went missing together, such as
+// during an Oxide API incident, are not re-checked in lockstep, and bounded to
+// finish well within the timeout of [InstancesV2.InstanceExists].
+var notFoundRecheckBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    3,
+}
+
+// notFoundRecheck confirms that an instance is gone before its node is
+// reported as not existing, since the node lifecycle controller deletes the
+// node right away. A not-found result from a single lookup may be transient,
+// for example while the Oxide API recovers from an incident. A nil
+// notFoundRecheck confirms every not-found result without re-checking.
+type notFoundRecheck struct {
+	clock   clock.Clock
+	backoff wait.Backoff
+
+	// slots holds a token for each in-flight re-check.
+	slots chan struct{}
+}
+
+// newNotFoundRecheck returns a notFoundRecheck that re-checks with backoff
+// and runs at most concurrency re-checks at a time.
+func newNotFoundRecheck(
+	clock clock.Clock,
+	backoff wait.Backoff,
+	concurrency int,
+) *notFoundRecheck {
+	return &notFoundRecheck{
+		clock:   clock,
+		backoff: backoff,
+		slots:   make(chan struct{}, concurrency),
+	}
+}
+
+// confirm calls lookup after each backoff step until it finds the instance or
+// the backoff is exhausted, and reports whether the instance is still not
+// found. It returns an error when lookup fails with any other error or ctx
+// is done, so that the node is kept until the next check.
+func (r *notFoundRecheck) confirm(
+	ctx context.Context,
+	lookup func(context.Context) error,
+) (bool, error) {
+	if r == nil {
+		return true, nil
+	}
+
+	backoff := r.backoff
+	for backoff.Steps > 0 {
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("failed re-checking not found instance: %w", ctx.Err())
+		case <-r.clock.After(backoff.Step()):
+		}
+
+		err := r.lookup(ctx, lookup)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, ErrInstanceNotFound) {
+			return false, fmt.Errorf("failed re-checking not found instance: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// lookup calls lookup once a re-check slot is free.
+func (r *notFoundRecheck) lookup(ctx context.Context, lookup func(context.Context) error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r.slots <- struct{}{}:
+	}
+	defer func() { <-r.slots }()
+
+	return lookup(ctx)
+}
@@ -0,0 +1,200 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestNotFoundRecheck(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 2, Jitter: 0.5, Steps: 3}
+
+	t.Run("FoundOnRecheck", func(t *testing.T) {
+		var calls int
+		gone, err := newNotFoundRecheck(clock.RealClock{}, backoff, 1).confirm(t.Context(),
+			func(context.Context) error {
+				calls++
+				if calls < 2 {
+					return ErrInstanceNotFound
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gone {
+			t.Fatal("expected instance found on recheck to not be gone")
+		}
+		if calls != 2 {
+			t.Fatalf("calls = %d, want 2", calls)
+		}
+	})
+
+	t.Run("StillNotFound", func(t *testing.T) {
+		var calls int
+		gone, err := newNotFoundRecheck(clock.RealClock{}, backoff, 1).confirm(t.Context(),
+			func(context.Context) error {
+				calls++
+				return ErrInstanceNotFound
+			},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !gone {
+			t.Fatal("expected instance to be gone")
+		}
+		if calls != backoff.Steps {
+			t.Fatalf("calls = %d, want %d", calls, backoff.Steps)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		_, err := newNotFoundRecheck(clock.RealClock{}, backoff, 1).confirm(t.Context(),
+			func(context.Context) error { return errBoom },
+		)
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want errBoom", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := newNotFoundRecheck(clock.RealClock{}, backoff, 1).confirm(ctx,
+			func(context.Context) error { return ErrInstanceNotFound },
+		)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context canceled", err)
+		}
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		backoff := wait.Backoff{Duration: time.Second, Factor: 2, Steps: 3}
+		recheck := newNotFoundRecheck(fakeClock, backoff, 1)
+
+		var calls atomic.Int64
+		done := make(chan bool)
+		go func() {
+			gone, _ := recheck.confirm(t.Context(), func(context.Context) error {
+				calls.Add(1)
+				return ErrInstanceNotFound
+			})
+			done <- gone
+		}()
+
+		// Each re-check waits for its step of the backoff, and no longer.
+		for n, step := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+			for !fakeClock.HasWaiters() {
+				time.Sleep(time.Millisecond)
+			}
+			fakeClock.Step(step - time.Nanosecond)
+			if got := calls.Load(); got != int64(n) {
+				t.Fatalf("calls = %d before step %d elapsed, want %d", got, n, n)
+			}
+			fakeClock.Step(time.Nanosecond)
+		}
+
+		if gone := <-done; !gone {
+			t.Fatal("expected instance to be gone")
+		}
+		if got := calls.Load(); got != 3 {
+			t.Fatalf("calls = %d, want 3", got)
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		var recheck *notFoundRecheck
+		gone, err := recheck.confirm(t.Context(), func(context.Context) error {
+			t.Fatal("unexpected lookup")
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !gone {
+			t.Fatal("expected instance to be gone")
+		}
+	})
+
+	t.Run("BoundsConcurrency", func(t *testing.T) {
+		const concurrency = 3
+		recheck := newNotFoundRecheck(clock.RealClock{}, backoff, concurrency)
+
+		var inFlight, maxInFlight, calls atomic.Int64
+		lookup := func(context.Context) error {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				highest := maxInFlight.Load()
+				if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+					break
+				}
+			}
+			calls.Add(1)
+			time.Sleep(time.Millisecond)
+			return ErrInstanceNotFound
+		}
+
+		// Many nodes become unresolvable at once.
+		const nodes = 50
+		var wg sync.WaitGroup
+		for range nodes {
+			wg.Go(func() {
+				gone, err := recheck.confirm(t.Context(), lookup)
+				if err != nil || !gone {
+					t.Errorf("gone = %t, err = %v, want gone", gone, err)
+				}
+			})
+		}
+		wg.Wait()
+
+		if n := maxInFlight.Load(); n > concurrency {
+			t.Fatalf("max in-flight rechecks = %d, want at most %d", n, concurrency)
+		}
+		if n := calls.Load(); n != nodes*int64(backoff.Steps) {
+			t.Fatalf("calls = %d, want %d", n, nodes*backoff.Steps)
+		}
+	})
+}
+
+func TestInstanceExistsRecheck(t *testing.T) {
+	client := &countingOxideClient{
+		oxideInstanceClient: &mockOxideClient{InstanceViewError: oxide.ErrObjectNotFound},
+	}
+	instancesV2 := InstancesV2{
+		client:  client,
+		project: "test",
+		recheck: newNotFoundRecheck(
+			clock.RealClock{},
+			wait.Backoff{Duration: time.Millisecond, Steps: 2},
+			maxConcurrentNotFoundRechecks,
+		),
+	}
+
+	exists, err := instancesV2.InstanceExists(t.Context(), &nodeDoesNotExistInOxide)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists {
+		t.Fatal("expected instance to NOT exist after rechecks")
+	}
+	if client.calls != 3 {
+		t.Fatalf("calls = %d, want 3", client.calls)
+	}
+}
@@ -0,0 +1,139 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ownershipRecordPrefix starts the ownership record of floating IPs created by
+// the cloud controller manager, followed by the record version.
+const ownershipRecordPrefix = "[oxide-ccm/"
+
+// ownershipRecordVersion is the version of the ownership record written by
+// [floatingIPOwner.record].
+const ownershipRecordVersion = "v1"
+
+// legacyOwnershipTag started the description of floating IPs created before
+// the ownership record was introduced.
+const legacyOwnershipTag = "Managed by oxide-cloud-controller-manager."
+
+// maxDescriptionLength is the maximum length of an Oxide resource
+// description.
+const maxDescriptionLength = 512
+
+// floatingIPOwner identifies the service a floating IP was created for. It is
+// recorded at the start of the floating IP's description so that the cloud
+// controller manager recognizes its floating IPs without parsing free text.
+type floatingIPOwner struct {
+	Cluster   string
+	Namespace string
+	Service   string
+
+	// UID is the UID of the service, which tells a service apart from a
+	// service recreated with the same name. It is empty in records written
+	// before it was introduced.
+	UID types.UID
+}
+
+// record returns the ownership record, such as
+// [oxide-ccm/v1 cluster=kubernetes namespace=default service=web uid=...].
+// Values are query escaped so they cannot contain separators.
+func (o floatingIPOwner) record() string {
+	record := fmt.Sprintf("%s%s cluster=%s namespace=%s service=%s",
+		ownershipRecordPrefix, ownershipRecordVersion,
+		url.QueryEscape(o.Cluster), url.QueryEscape(o.Namespace), url.QueryEscape(o.Service),
+	)
+	if o.UID != "" {
+		record += " uid=" + url.QueryEscape(string(o.UID))
+	}
+	return record + "]"
+}
+
+// service returns the namespaced name of the owner's service.
+func (o floatingIPOwner) service() types.NamespacedName {
+	return types.NamespacedName{Namespace: o.Namespace, Name: o.Service}
+}
+
+// ownedByOtherService reports whether the floating IP's ownership record names
+// a service UID other than uid, meaning that it was created for a previous
+// service with the same name. Records without a UID match any service.
+func ownedByOtherService(description string, uid types.UID) bool {
+	owner, owned := parseFloatingIPOwner(description)
+	return owned && owner.UID != "" && uid != "" && owner.UID != uid
+}
+
+// parseFloatingIPOwner returns the owner recorded at the start of a floating
+// IP's description and whether the floating IP is owned by the cloud
+// controller manager. Floating IPs created before the ownership record was
+// introduced are owned with an unknown, zero owner.
+func parseFloatingIPOwner(description string) (floatingIPOwner, bool) {
+	if strings.HasPrefix(description, legacyOwnershipTag) {
+		return floatingIPOwner{}, true
+	}
+
+	rest, ok := strings.CutPrefix(description, ownershipRecordPrefix)
+	if !ok {
+		return floatingIPOwner{}, false
+	}
+	record, _, ok := strings.Cut(rest, "]")
+	if !ok {
+		return floatingIPOwner{}, false
+	}
+
+	fields := strings.Fields(record)
+	if len(fields) == 0 || fields[0] != ownershipRecordVersion {
+		return floatingIPOwner{}, false
+	}
+
+	values := make(map[string]string, len(fields)-1)
+	for _, field := range fields[1:] {
+		key, escaped, ok := strings.Cut(field, "=")
+		if !ok {
+			return floatingIPOwner{}, false
+		}
+		value, err := url.QueryUnescape(escaped)
+		if err != nil {
+			return floatingIPOwner{}, false
+		}
+		values[key] = value
+	}
+
+	owner := floatingIPOwner{
+		Cluster:   values["cluster"],
+		Namespace: values["namespace"],
+		Service:   values["service"],
+		UID:       types.UID(values["uid"]),
+	}
+	if owner.Cluster == "" || owner.Namespace == "" || owner.Service == "" {
+		return floatingIPOwner{}, false
+	}
+
+	return owner, true
+}
+
+// floatingIPDescription returns the description for a floating IP: the
+// owner's record followed by text, typically the value of
+// [AnnotationFloatingIPDescription], when set.
+func floatingIPDescription(owner floatingIPOwner, text string) (string, error) {
+	description := owner.record()
+	if text = strings.TrimSpace(text); text != "" {
+		description += " " + text
+	}
+
+	if len(description) > maxDescriptionLength {
+		return "", fmt.Errorf(
+			"annotation %s is too long, the floating ip description must be at most %d "+
+				"characters including the %d character ownership record",
+			AnnotationFloatingIPDescription, maxDescriptionLength, len(owner.record())+1,
+		)
+	}
+
+	return description, nil
+}
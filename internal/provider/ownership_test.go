@@ -0,0 +1,125 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFloatingIPOwner(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, owner := range []floatingIPOwner{
+			{Cluster: "kubernetes", Namespace: "default", Service: "web"},
+			{Cluster: "prod east]", Namespace: "ns", Service: "svc=1"},
+			{Cluster: "kubernetes", Namespace: "default", Service: "web", UID: "uid 1"},
+		} {
+			description, err := floatingIPDescription(owner, "Storefront.")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			parsed, ok := parseFloatingIPOwner(description)
+			if !ok {
+				t.Fatalf("description %q is not owned", description)
+			}
+			if parsed != owner {
+				t.Fatalf("owner = %+v, want %+v", parsed, owner)
+			}
+		}
+	})
+
+	t.Run("Record", func(t *testing.T) {
+		owner := floatingIPOwner{Cluster: "kubernetes", Namespace: "default", Service: "web"}
+		want := "[oxide-ccm/v1 cluster=kubernetes namespace=default service=web]"
+		if record := owner.record(); record != want {
+			t.Fatalf("record = %q, want %q", record, want)
+		}
+
+		owner.UID = "0a3c7f0e-5d4b-4c6a-9f1e-2b8d7c6e5a41"
+		want = "[oxide-ccm/v1 cluster=kubernetes namespace=default service=web " +
+			"uid=0a3c7f0e-5d4b-4c6a-9f1e-2b8d7c6e5a41]"
+		if record := owner.record(); record != want {
+			t.Fatalf("record = %q, want %q", record, want)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		owner, ok := parseFloatingIPOwner(legacyOwnershipTag + " Storefront.")
+		if !ok {
+			t.Fatal("legacy description is not owned")
+		}
+		if owner != (floatingIPOwner{}) {
+			t.Fatalf("owner = %+v, want unknown owner", owner)
+		}
+	})
+
+	t.Run("NotOwned", func(t *testing.T) {
+		for _, description := range []string{
+			"",
+			"Created by hand.",
+			"Storefront. [oxide-ccm/v1 cluster=k namespace=ns service=svc]",
+			"[oxide-ccm/v2 cluster=k namespace=ns service=svc]",
+			"[oxide-ccm/v1 cluster=k namespace=ns service=svc",
+			"[oxide-ccm/v1 cluster=k namespace=ns]",
+			"[oxide-ccm/v1 cluster=k namespace=ns service]",
+			"[oxide-ccm/v1 cluster=%zz namespace=ns service=svc]",
+		} {
+			if owner, ok := parseFloatingIPOwner(description); ok {
+				t.Fatalf("description %q is owned by %+v, want not owned", description, owner)
+			}
+		}
+	})
+}
+
+func TestFloatingIPDescription(t *testing.T) {
+	owner := floatingIPOwner{Cluster: "cluster", Namespace: "ns", Service: "svc"}
+	record := owner.record()
+
+	tt := []struct {
+		name        string
+		text        string
+		expected    string
+		errorSubstr string
+	}{
+		{
+			name:     "default",
+			expected: record,
+		},
+		{
+			name:     "custom",
+			text:     " Ingress for the storefront. ",
+			expected: record + " Ingress for the storefront.",
+		},
+		{
+			name:     "longest",
+			text:     strings.Repeat("a", maxDescriptionLength-len(record)-1),
+			expected: record + " " + strings.Repeat("a", maxDescriptionLength-len(record)-1),
+		},
+		{
+			name:        "over length",
+			text:        strings.Repeat("a", maxDescriptionLength-len(record)),
+			errorSubstr: "annotation oxide.computer/floating-ip-description is too long",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			description, err := floatingIPDescription(owner, tc.text)
+			if tc.errorSubstr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorSubstr) {
+					t.Fatalf("err = %v, want %q", err, tc.errorSubstr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if description != tc.expected {
+				t.Fatalf("description = %q, want %q", description, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/klog/v2"
+
+	"github.com/oxidecomputer/oxide-cloud-controller-manager/internal/version"
+)
+
+// oxideVersionsTimeout bounds viewing the Oxide system version at startup.
+const oxideVersionsTimeout = 30 * time.Second
+
+// oxideSystemClient is the subset of the Oxide API used to view the system
+// version. It exists so the Oxide client can be mocked in tests.
+type oxideSystemClient interface {
+	SystemUpdateStatus(context.Context) (*oxide.UpdateStatus, error)
+}
+
+// logOxideVersions logs the Oxide system releases the rack's components are
+// running, and the release an update is moving them to, alongside the Oxide Go
+// SDK version the cloud controller manager was built with, to help diagnose
+// errors caused by an SDK that doesn't match the system. Viewing the system
+// version requires the fleet viewer role; without it, only the SDK version is
+// logged.
+func logOxideVersions(ctx context.Context, client oxideSystemClient) {
+	ctx, cancel := context.WithTimeout(ctx, oxideVersionsTimeout)
+	defer cancel()
+
+	sdkVersion := version.Get().OxideSDKVersion
+
+	status, err := client.SystemUpdateStatus(ctx)
+	if err != nil {
+		if !errors.Is(err, oxide.ErrHTTP403) {
+			klog.ErrorS(err, "failed viewing oxide system version", "sdkVersion", sdkVersion)
+			return
+		}
+		klog.InfoS("not permitted to view oxide system version", "sdkVersion", sdkVersion)
+		return
+	}
+
+	targetVersion := ""
+	if status.TargetRelease != nil {
+		targetVersion = status.TargetRelease.Version
+	}
+	klog.InfoS("oxide versions",
+		"systemVersions", runningSystemVersions(status),
+		"targetSystemVersion", targetVersion,
+		"sdkVersion", sdkVersion)
+}
+
+// runningSystemVersions returns the system releases the rack's components are
+// running in order, which are several while an update is in progress.
+func runningSystemVersions(status *oxide.UpdateStatus) []string {
+	return slices.Sorted(maps.Keys(status.ComponentsByReleaseVersion))
+}
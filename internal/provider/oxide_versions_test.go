@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+func TestRunningSystemVersions(t *testing.T) {
+	tt := []struct {
+		name       string
+		components map[string]*int
+		want       []string
+	}{
+		{
+			name:       "updated",
+			components: map[string]*int{"18.0.0": new(30)},
+			want:       []string{"18.0.0"},
+		},
+		{
+			name: "updating",
+			components: map[string]*int{
+				"18.0.0": new(12), "17.1.0": new(18), "install dataset": new(1),
+			},
+			want: []string{"17.1.0", "18.0.0", "install dataset"},
+		},
+		{
+			name: "unknown",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := runningSystemVersions(&oxide.UpdateStatus{
+				ComponentsByReleaseVersion: tc.components,
+				// The target is where an update is going, not what is running.
+				TargetRelease: &oxide.TargetRelease{Version: "19.0.0"},
+			})
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("versions = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
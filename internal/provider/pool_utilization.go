@@ -0,0 +1,145 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// poolUtilizationInterval is the interval at which the utilization of the
+// floating IP pools is updated when no floating IP is created or deleted.
+const poolUtilizationInterval = 5 * time.Minute
+
+// oxidePoolUtilizationClient is the subset of the Oxide API used by
+// [poolUtilization]. It exists so the Oxide client can be mocked in tests.
+type oxidePoolUtilizationClient interface {
+	IpPoolListAllPages(context.Context, oxide.IpPoolListParams) ([]oxide.SiloIpPool, error)
+	SystemIpPoolUtilizationView(
+		context.Context, oxide.SystemIpPoolUtilizationViewParams,
+	) (*oxide.IpPoolUtilization, error)
+}
+
+// poolUtilization exports the number of addresses and available addresses of
+// the floating IP pools as metrics, so that operators can alert before a pool
+// is exhausted. It is started by [Oxide.Initialize], which only runs while the
+// cloud controller manager is the leader.
+type poolUtilization struct {
+	client oxidePoolUtilizationClient
+	clock  clock.WithTicker
+
+	// pools are the configured floating IP pools. The silo's default IP pools
+	// are used when empty.
+	pools []string
+
+	// updates requests an update ahead of the interval.
+	updates chan struct{}
+}
+
+// newPoolUtilization returns a pool utilization exporter for the pools.
+func newPoolUtilization(
+	client oxidePoolUtilizationClient,
+	clock clock.WithTicker,
+	pools []string,
+) *poolUtilization {
+	return &poolUtilization{
+		client:  client,
+		clock:   clock,
+		pools:   pools,
+		updates: make(chan struct{}, 1),
+	}
+}
+
+// changed requests an update of the pool utilization after a floating IP was
+// created or deleted. Requests made while an update is pending are coalesced.
+// It is a no-op on a nil pool utilization.
+func (p *poolUtilization) changed() {
+	if p == nil {
+		return
+	}
+	select {
+	case p.updates <- struct{}{}:
+	default:
+	}
+}
+
+// run updates the pool utilization at [poolUtilizationInterval] and whenever
+// a change is requested, until ctx is done.
+func (p *poolUtilization) run(ctx context.Context) {
+	ticker := p.clock.NewTicker(poolUtilizationInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.update(ctx); err != nil {
+			klog.ErrorS(err, "failed updating floating ip pool utilization")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		case <-p.updates:
+		}
+	}
+}
+
+// update sets the pool utilization metrics of every pool. Viewing the
+// utilization of a pool requires fleet viewer permissions, so pools the token
+// cannot view are skipped.
+func (p *poolUtilization) update(ctx context.Context) error {
+	pools, err := p.poolNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, pool := range pools {
+		utilization, err := p.client.SystemIpPoolUtilizationView(
+			ctx, oxide.SystemIpPoolUtilizationViewParams{Pool: oxide.NameOrId(pool)},
+		)
+		if errors.Is(err, oxide.ErrHTTP403) {
+			klog.V(2).InfoS("not permitted to view ip pool utilization", "pool", pool)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"failed viewing utilization of ip pool %s: %w", pool, err,
+			))
+			continue
+		}
+
+		floatingIPPoolTotal.WithLabelValues(pool).Set(utilization.Capacity)
+		floatingIPPoolAvailable.WithLabelValues(pool).Set(utilization.Remaining)
+	}
+
+	return errors.Join(errs...)
+}
+
+// poolNames returns the configured pools, or the names of the silo's default
+// IP pools when none are configured.
+func (p *poolUtilization) poolNames(ctx context.Context) ([]string, error) {
+	if len(p.pools) > 0 {
+		return p.pools, nil
+	}
+
+	pools, err := p.client.IpPoolListAllPages(ctx, oxide.IpPoolListParams{})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing ip pools: %w", err)
+	}
+
+	var names []string
+	for _, pool := range pools {
+		if pool.IsDefault != nil && *pool.IsDefault {
+			names = append(names, string(pool.Name))
+		}
+	}
+	return names, nil
+}
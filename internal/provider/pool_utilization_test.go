@@ -0,0 +1,181 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// fakePoolUtilizationClient serves the utilization of IP pools by name. Pools
+// without a utilization are forbidden. Viewing any utilization fails with err
+// when set.
+type fakePoolUtilizationClient struct {
+	mu           sync.Mutex
+	pools        []oxide.SiloIpPool
+	utilizations map[string]oxide.IpPoolUtilization
+	err          error
+	views        int
+}
+
+func (f *fakePoolUtilizationClient) IpPoolListAllPages(
+	context.Context, oxide.IpPoolListParams,
+) ([]oxide.SiloIpPool, error) {
+	return f.pools, nil
+}
+
+func (f *fakePoolUtilizationClient) SystemIpPoolUtilizationView(
+	_ context.Context, params oxide.SystemIpPoolUtilizationViewParams,
+) (*oxide.IpPoolUtilization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.views++
+	if f.err != nil {
+		return nil, f.err
+	}
+	utilization, ok := f.utilizations[string(params.Pool)]
+	if !ok {
+		return nil, oxide.ErrHTTP403
+	}
+	return &utilization, nil
+}
+
+func (f *fakePoolUtilizationClient) viewCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.views
+}
+
+func TestPoolUtilization(t *testing.T) {
+	registerMetrics()
+
+	gauges := func(t *testing.T, pool string) (float64, float64) {
+		t.Helper()
+		total, err := testutil.GetGaugeMetricValue(floatingIPPoolTotal.WithLabelValues(pool))
+		if err != nil {
+			t.Fatalf("failed reading gauge: %v", err)
+		}
+		available, err := testutil.GetGaugeMetricValue(
+			floatingIPPoolAvailable.WithLabelValues(pool),
+		)
+		if err != nil {
+			t.Fatalf("failed reading gauge: %v", err)
+		}
+		return total, available
+	}
+
+	tests := []struct {
+		name      string
+		pools     []string
+		silo      []oxide.SiloIpPool
+		wantPool  string
+		wantTotal float64
+		wantAvail float64
+	}{
+		{
+			name:      "ConfiguredPools",
+			pools:     []string{"utilization-public", "utilization-forbidden"},
+			wantPool:  "utilization-public",
+			wantTotal: 256,
+			wantAvail: 200,
+		},
+		{
+			name: "DefaultPool",
+			silo: []oxide.SiloIpPool{
+				{Name: "utilization-other", IsDefault: new(false)},
+				{Name: "utilization-default", IsDefault: new(true)},
+			},
+			wantPool:  "utilization-default",
+			wantTotal: 16,
+			wantAvail: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePoolUtilizationClient{
+				pools: tt.silo,
+				utilizations: map[string]oxide.IpPoolUtilization{
+					"utilization-public":  {Capacity: 256, Remaining: 200},
+					"utilization-default": {Capacity: 16, Remaining: 1},
+					"utilization-other":   {Capacity: 8, Remaining: 8},
+				},
+			}
+			p := newPoolUtilization(
+				client, clocktesting.NewFakeClock(time.Now()), tt.pools,
+			)
+
+			if err := p.update(t.Context()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			total, available := gauges(t, tt.wantPool)
+			if total != tt.wantTotal || available != tt.wantAvail {
+				t.Fatalf("total, available = %v, %v, want %v, %v",
+					total, available, tt.wantTotal, tt.wantAvail)
+			}
+			if total, _ := gauges(t, "utilization-other"); total != 0 {
+				t.Fatalf("unmonitored pool total = %v, want 0", total)
+			}
+		})
+	}
+
+	t.Run("ViewError", func(t *testing.T) {
+		p := newPoolUtilization(
+			&fakePoolUtilizationClient{err: errBoom},
+			clocktesting.NewFakeClock(time.Now()),
+			[]string{"utilization-broken"},
+		)
+
+		if err := p.update(t.Context()); !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want errBoom", err)
+		}
+	})
+
+	t.Run("UpdatesOnChange", func(t *testing.T) {
+		client := &fakePoolUtilizationClient{
+			utilizations: map[string]oxide.IpPoolUtilization{
+				"utilization-changed": {Capacity: 4, Remaining: 4},
+			},
+		}
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		p := newPoolUtilization(client, fakeClock, []string{"utilization-changed"})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		go p.run(ctx)
+
+		waitForViews := func(want int) {
+			t.Helper()
+			deadline := time.Now().Add(10 * time.Second)
+			for client.viewCount() < want {
+				if time.Now().After(deadline) {
+					t.Fatalf("views = %d, want %d", client.viewCount(), want)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		// The utilization is updated right away, on a change, and at the
+		// interval.
+		waitForViews(1)
+		p.changed()
+		waitForViews(2)
+		for !fakeClock.HasWaiters() {
+			time.Sleep(time.Millisecond)
+		}
+		fakeClock.Step(poolUtilizationInterval)
+		waitForViews(3)
+	})
+}
@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+// Preflight modes for [Config.Preflight].
+const (
+	// PreflightRead checks that the token can perform the read operations the
+	// cloud controller manager needs.
+	PreflightRead = "read"
+
+	// PreflightWrite additionally creates and deletes a throwaway floating IP,
+	// attaching it to and detaching it from [Config.PreflightInstance] when
+	// set.
+	PreflightWrite = "write"
+)
+
+// preflightCleanupTimeout bounds deleting the throwaway floating IP created by
+// the write preflight checks.
+const preflightCleanupTimeout = 30 * time.Second
+
+// oxidePreflightClient is the subset of the Oxide API exercised by the preflight
+// checks. It exists so the Oxide client can be mocked in tests.
+type oxidePreflightClient interface {
+	InstanceList(context.Context, oxide.InstanceListParams) (*oxide.InstanceResultsPage, error)
+	InstanceView(context.Context, oxide.InstanceViewParams) (*oxide.Instance, error)
+	InstanceNetworkInterfaceList(
+		context.Context,
+		oxide.InstanceNetworkInterfaceListParams,
+	) (*oxide.InstanceNetworkInterfaceResultsPage, error)
+	InstanceExternalIpList(
+		context.Context,
+		oxide.InstanceExternalIpListParams,
+	) (*oxide.ExternalIpResultsPage, error)
+	FloatingIpList(
+		context.Context, oxide.FloatingIpListParams,
+	) (*oxide.FloatingIpResultsPage, error)
+	IpPoolList(context.Context, oxide.IpPoolListParams) (*oxide.SiloIpPoolResultsPage, error)
+	FloatingIpCreate(context.Context, oxide.FloatingIpCreateParams) (*oxide.FloatingIp, error)
+	FloatingIpAttach(context.Context, oxide.FloatingIpAttachParams) (*oxide.FloatingIp, error)
+	FloatingIpDetach(context.Context, oxide.FloatingIpDetachParams) (*oxide.FloatingIp, error)
+	FloatingIpDelete(context.Context, oxide.FloatingIpDeleteParams) error
+}
+
+// preflight checks that the token can perform every Oxide operation the cloud
+// controller manager needs in the project, returning an error naming each
+// operation that failed. The read operations on instances are checked against
+// an arbitrary instance of the project and skipped when it has none. When
+// write is set, a throwaway floating IP is created from pool, or the silo's
+// default pool when empty, and deleted. It is only attached to and detached
+// from attachInstance, when set, since the instance is left with the floating
+// IP when detaching it fails, so it must be one the operator chose for it
+// rather than a node serving traffic. The floating IP is deleted even when a
+// check fails.
+func preflight(
+	ctx context.Context,
+	client oxidePreflightClient,
+	project string,
+	write bool,
+	pool string,
+	attachInstance string,
+) error {
+	var errs []error
+	check := func(operation string, err error) bool {
+		if err != nil {
+			errs = append(errs, preflightError(operation, err))
+		}
+		return err == nil
+	}
+
+	var instanceID string
+	instances, err := client.InstanceList(ctx, oxide.InstanceListParams{
+		Project: oxide.NameOrId(project),
+		Limit:   new(1),
+	})
+	if check("list instances", err) && len(instances.Items) > 0 {
+		instanceID = instances.Items[0].Id
+	}
+
+	if instanceID != "" {
+		_, err = client.InstanceView(ctx, oxide.InstanceViewParams{
+			Instance: oxide.NameOrId(instanceID),
+		})
+		check("view instance", err)
+
+		_, err = client.InstanceNetworkInterfaceList(ctx, oxide.InstanceNetworkInterfaceListParams{
+			Instance: oxide.NameOrId(instanceID),
+			Limit:    new(1),
+		})
+		check("list instance network interfaces", err)
+
+		_, err = client.InstanceExternalIpList(ctx, oxide.InstanceExternalIpListParams{
+			Instance: oxide.NameOrId(instanceID),
+		})
+		check("list instance external ips", err)
+	}
+
+	_, err = client.FloatingIpList(ctx, oxide.FloatingIpListParams{
+		Project: oxide.NameOrId(project),
+		Limit:   new(1),
+	})
+	check("list floating ips", err)
+
+	_, err = client.IpPoolList(ctx, oxide.IpPoolListParams{Limit: new(1)})
+	check("list ip pools", err)
+
+	if write {
+		errs = append(errs, preflightFloatingIP(ctx, client, project, attachInstance, pool)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// preflightFloatingIP creates a throwaway floating IP, attaches it to and
+// detaches it from the instance, when set, and deletes it, returning an error
+// for each operation that failed.
+func preflightFloatingIP(
+	ctx context.Context,
+	client oxidePreflightClient,
+	project string,
+	instance string,
+	pool string,
+) (errs []error) {
+	allocator := oxide.AddressAllocator{Value: &oxide.AddressAllocatorAuto{}}
+	if pool != "" {
+		allocator = explicitPoolAllocator(pool)
+	}
+
+	fip, err := client.FloatingIpCreate(ctx, oxide.FloatingIpCreateParams{
+		Project: oxide.NameOrId(project),
+		Body: &oxide.FloatingIpCreate{
+			Name:             oxide.Name("oxide-ccm-preflight-" + uuid.NewString()[:8]),
+			Description:      "Preflight check of oxide-cloud-controller-manager.",
+			AddressAllocator: allocator,
+		},
+	})
+	if err != nil {
+		return []error{preflightError("create floating ip", err)}
+	}
+
+	attached := false
+	defer func() {
+		// Clean up even when the preflight was canceled.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), preflightCleanupTimeout)
+		defer cancel()
+
+		if attached {
+			_, err := client.FloatingIpDetach(ctx, oxide.FloatingIpDetachParams{
+				FloatingIp: oxide.NameOrId(fip.Id),
+			})
+			if err != nil {
+				errs = append(errs, preflightError("detach floating ip", err))
+			}
+		}
+
+		err := client.FloatingIpDelete(ctx, oxide.FloatingIpDeleteParams{
+			FloatingIp: oxide.NameOrId(fip.Id),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"%w, delete floating ip %s manually",
+				preflightError("delete floating ip", err), fip.Name,
+			))
+		}
+	}()
+
+	if instance == "" {
+		return nil
+	}
+
+	_, err = client.FloatingIpAttach(ctx, oxide.FloatingIpAttachParams{
+		FloatingIp: oxide.NameOrId(fip.Id),
+		Body: &oxide.FloatingIpAttach{
+			Kind:   oxide.FloatingIpParentKindInstance,
+			Parent: oxide.NameOrId(instance),
+		},
+	})
+	if err != nil {
+		return []error{preflightError("attach floating ip", err)}
+	}
+	attached = true
+
+	return nil
+}
+
+// preflightError describes a failed preflight operation, calling out a missing
+// permission when the Oxide API denied it.
+func preflightError(operation string, err error) error {
+	if errors.Is(err, oxide.ErrHTTP403) {
+		return fmt.Errorf("%s: permission denied: %w", operation, err)
+	}
+	return fmt.Errorf("%s: %w", operation, err)
+}
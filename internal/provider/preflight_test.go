@@ -0,0 +1,226 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+// fakePreflightClient is a fake [oxidePreflightClient] that records the
+// operations called and denies those listed in denied with a 403.
+type fakePreflightClient struct {
+	denied    []string
+	instances []oxide.Instance
+	calls     []string
+}
+
+func (f *fakePreflightClient) call(operation string) error {
+	f.calls = append(f.calls, operation)
+	if slices.Contains(f.denied, operation) {
+		return oxide.ErrHTTP403
+	}
+	return nil
+}
+
+func (f *fakePreflightClient) InstanceList(
+	context.Context, oxide.InstanceListParams,
+) (*oxide.InstanceResultsPage, error) {
+	if err := f.call("InstanceList"); err != nil {
+		return nil, err
+	}
+	return &oxide.InstanceResultsPage{Items: f.instances}, nil
+}
+
+func (f *fakePreflightClient) InstanceView(
+	context.Context, oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	return &oxide.Instance{}, f.call("InstanceView")
+}
+
+func (f *fakePreflightClient) InstanceNetworkInterfaceList(
+	context.Context, oxide.InstanceNetworkInterfaceListParams,
+) (*oxide.InstanceNetworkInterfaceResultsPage, error) {
+	return &oxide.InstanceNetworkInterfaceResultsPage{}, f.call("InstanceNetworkInterfaceList")
+}
+
+func (f *fakePreflightClient) InstanceExternalIpList(
+	context.Context, oxide.InstanceExternalIpListParams,
+) (*oxide.ExternalIpResultsPage, error) {
+	return &oxide.ExternalIpResultsPage{}, f.call("InstanceExternalIpList")
+}
+
+func (f *fakePreflightClient) FloatingIpList(
+	context.Context, oxide.FloatingIpListParams,
+) (*oxide.FloatingIpResultsPage, error) {
+	return &oxide.FloatingIpResultsPage{}, f.call("FloatingIpList")
+}
+
+func (f *fakePreflightClient) IpPoolList(
+	context.Context, oxide.IpPoolListParams,
+) (*oxide.SiloIpPoolResultsPage, error) {
+	return &oxide.SiloIpPoolResultsPage{}, f.call("IpPoolList")
+}
+
+func (f *fakePreflightClient) FloatingIpCreate(
+	context.Context, oxide.FloatingIpCreateParams,
+) (*oxide.FloatingIp, error) {
+	if err := f.call("FloatingIpCreate"); err != nil {
+		return nil, err
+	}
+	return &oxide.FloatingIp{Id: "fip-1", Name: "oxide-ccm-preflight"}, nil
+}
+
+func (f *fakePreflightClient) FloatingIpAttach(
+	context.Context, oxide.FloatingIpAttachParams,
+) (*oxide.FloatingIp, error) {
+	return &oxide.FloatingIp{Id: "fip-1"}, f.call("FloatingIpAttach")
+}
+
+func (f *fakePreflightClient) FloatingIpDetach(
+	context.Context, oxide.FloatingIpDetachParams,
+) (*oxide.FloatingIp, error) {
+	return &oxide.FloatingIp{Id: "fip-1"}, f.call("FloatingIpDetach")
+}
+
+func (f *fakePreflightClient) FloatingIpDelete(
+	context.Context, oxide.FloatingIpDeleteParams,
+) error {
+	return f.call("FloatingIpDelete")
+}
+
+func TestPreflight(t *testing.T) {
+	instances := []oxide.Instance{{Id: instID1}}
+	readCalls := []string{
+		"InstanceList",
+		"InstanceView",
+		"InstanceNetworkInterfaceList",
+		"InstanceExternalIpList",
+		"FloatingIpList",
+		"IpPoolList",
+	}
+	writeCalls := []string{"FloatingIpCreate", "FloatingIpDelete"}
+	attachCalls := []string{
+		"FloatingIpCreate",
+		"FloatingIpAttach",
+		"FloatingIpDetach",
+		"FloatingIpDelete",
+	}
+
+	tt := []struct {
+		name      string
+		write     bool
+		attach    string
+		denied    []string
+		instances []oxide.Instance
+		calls     []string
+		errors    []string
+	}{
+		{
+			name:      "read allowed",
+			instances: instances,
+			calls:     readCalls,
+		},
+		{
+			name:      "write allowed",
+			write:     true,
+			instances: instances,
+			calls:     append(slices.Clone(readCalls), writeCalls...),
+		},
+		{
+			name:      "write attaches to the named instance",
+			write:     true,
+			attach:    "canary",
+			instances: instances,
+			calls:     append(slices.Clone(readCalls), attachCalls...),
+		},
+		{
+			name:  "no instances",
+			write: true,
+			calls: []string{
+				"InstanceList",
+				"FloatingIpList",
+				"IpPoolList",
+				"FloatingIpCreate",
+				"FloatingIpDelete",
+			},
+		},
+		{
+			name:      "read denied",
+			denied:    []string{"InstanceExternalIpList", "IpPoolList"},
+			instances: instances,
+			calls:     readCalls,
+			errors: []string{
+				"list instance external ips: permission denied",
+				"list ip pools: permission denied",
+			},
+		},
+		{
+			name:      "create denied",
+			write:     true,
+			denied:    []string{"FloatingIpCreate"},
+			instances: instances,
+			calls:     append(slices.Clone(readCalls), "FloatingIpCreate"),
+			errors:    []string{"create floating ip: permission denied"},
+		},
+		{
+			name:      "attach denied still deletes",
+			write:     true,
+			attach:    "canary",
+			denied:    []string{"FloatingIpAttach"},
+			instances: instances,
+			calls: append(
+				slices.Clone(readCalls), "FloatingIpCreate", "FloatingIpAttach", "FloatingIpDelete",
+			),
+			errors: []string{"attach floating ip: permission denied"},
+		},
+		{
+			name:      "delete denied",
+			write:     true,
+			attach:    "canary",
+			denied:    []string{"FloatingIpDelete"},
+			instances: instances,
+			calls:     append(slices.Clone(readCalls), attachCalls...),
+			errors: []string{
+				"delete floating ip: permission denied: HTTP 403, " +
+					"delete floating ip oxide-ccm-preflight manually",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakePreflightClient{denied: tc.denied, instances: tc.instances}
+
+			err := preflight(t.Context(), client, "test", tc.write, "", tc.attach)
+			if !slices.Equal(client.calls, tc.calls) {
+				t.Fatalf("calls = %v, want %v", client.calls, tc.calls)
+			}
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, oxide.ErrHTTP403) {
+				t.Fatalf("err = %v, want %v", err, oxide.ErrHTTP403)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tc.errors) {
+				t.Fatalf("errors = %q, want %q", lines, tc.errors)
+			}
+			for i, want := range tc.errors {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Fatalf("error = %q, want prefix %q", lines[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/klog/v2"
+)
+
+// oxideProjectDiscoveryClient is the subset of the Oxide API used to discover
+// the project of the instance the cloud controller manager runs on. It exists
+// so the Oxide client can be mocked in tests.
+type oxideProjectDiscoveryClient interface {
+	CurrentUserView(context.Context) (*oxide.CurrentUser, error)
+	ProjectListAllPages(context.Context, oxide.ProjectListParams) ([]oxide.Project, error)
+	InstanceListAllPages(context.Context, oxide.InstanceListParams) ([]oxide.Instance, error)
+}
+
+// resolveProject returns the configured project, or, when none is configured,
+// discovers it as the project of the instance the cloud controller manager
+// runs on. Oxide has no instance metadata service, so the instance is found
+// by its hostname among the instances of the projects the token can view.
+// The Oxide API endpoint cannot be discovered this way and is always required.
+func resolveProject(
+	ctx context.Context,
+	client oxideProjectDiscoveryClient,
+	configured string,
+	hostname string,
+) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+
+	project, err := discoverProject(ctx, client, hostname)
+	if err != nil {
+		return "", fmt.Errorf(
+			"oxide project is required, set the %s environment variable or project "+
+				"in the config: failed discovering project: %w",
+			EnvProject, err,
+		)
+	}
+	return project, nil
+}
+
+// discoverProject returns the name of the only project with an instance whose
+// hostname or name is hostname.
+func discoverProject(
+	ctx context.Context,
+	client oxideProjectDiscoveryClient,
+	hostname string,
+) (string, error) {
+	if hostname == "" {
+		return "", errors.New("hostname is empty")
+	}
+
+	projects, err := client.ProjectListAllPages(ctx, oxide.ProjectListParams{})
+	if err != nil {
+		return "", fmt.Errorf("failed listing projects: %w", err)
+	}
+
+	var matches []string
+	for _, project := range projects {
+		instances, err := client.InstanceListAllPages(ctx, oxide.InstanceListParams{
+			Project: oxide.NameOrId(project.Id),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed listing instances in project %s: %w", project.Name, err)
+		}
+
+		if slices.ContainsFunc(instances, func(instance oxide.Instance) bool {
+			return instance.Hostname == hostname || string(instance.Name) == hostname
+		}) {
+			matches = append(matches, string(project.Name))
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no instance with hostname %q found", hostname)
+	case 1:
+	default:
+		return "", fmt.Errorf(
+			"instances with hostname %q found in multiple projects: %v", hostname, matches,
+		)
+	}
+
+	// The silo is logged to help tell apart projects of the same name across
+	// silos. Viewing it is best effort.
+	var silo string
+	if user, err := client.CurrentUserView(ctx); err == nil {
+		silo = string(user.SiloName)
+	}
+	klog.InfoS("discovered oxide project from instance",
+		"project", matches[0], "hostname", hostname, "silo", silo)
+
+	return matches[0], nil
+}
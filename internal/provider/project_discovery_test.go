@@ -0,0 +1,150 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+// fakeProjectDiscoveryClient serves projects and the instances in each
+// project, keyed by project ID.
+type fakeProjectDiscoveryClient struct {
+	projects     []oxide.Project
+	projectsErr  error
+	instances    map[string][]oxide.Instance
+	instancesErr error
+	calls        int
+}
+
+func (c *fakeProjectDiscoveryClient) CurrentUserView(context.Context) (*oxide.CurrentUser, error) {
+	return &oxide.CurrentUser{SiloName: "silo"}, nil
+}
+
+func (c *fakeProjectDiscoveryClient) ProjectListAllPages(
+	context.Context, oxide.ProjectListParams,
+) ([]oxide.Project, error) {
+	c.calls++
+	return c.projects, c.projectsErr
+}
+
+func (c *fakeProjectDiscoveryClient) InstanceListAllPages(
+	_ context.Context, params oxide.InstanceListParams,
+) ([]oxide.Instance, error) {
+	c.calls++
+	return c.instances[string(params.Project)], c.instancesErr
+}
+
+func TestResolveProject(t *testing.T) {
+	projects := []oxide.Project{
+		{Id: "project-1", Name: "k8s"},
+		{Id: "project-2", Name: "other"},
+	}
+	instances := map[string][]oxide.Instance{
+		"project-1": {{Name: "node-1", Hostname: "node-1.k8s"}, {Name: "node-2"}},
+		"project-2": {{Name: "db"}},
+	}
+
+	tt := []struct {
+		name       string
+		configured string
+		hostname   string
+		client     *fakeProjectDiscoveryClient
+		want       string
+		errorMsg   string
+	}{
+		{
+			name:       "configured",
+			configured: "example",
+			hostname:   "node-1.k8s",
+			client:     &fakeProjectDiscoveryClient{projectsErr: errBoom},
+			want:       "example",
+		},
+		{
+			name:     "discovered by hostname",
+			hostname: "node-1.k8s",
+			client:   &fakeProjectDiscoveryClient{projects: projects, instances: instances},
+			want:     "k8s",
+		},
+		{
+			name:     "discovered by name",
+			hostname: "node-2",
+			client:   &fakeProjectDiscoveryClient{projects: projects, instances: instances},
+			want:     "k8s",
+		},
+		{
+			name:     "no instance",
+			hostname: "laptop",
+			client:   &fakeProjectDiscoveryClient{projects: projects, instances: instances},
+			errorMsg: `no instance with hostname "laptop" found`,
+		},
+		{
+			name:     "multiple projects",
+			hostname: "node-2",
+			client: &fakeProjectDiscoveryClient{
+				projects: projects,
+				instances: map[string][]oxide.Instance{
+					"project-1": {{Name: "node-2"}},
+					"project-2": {{Hostname: "node-2"}},
+				},
+			},
+			errorMsg: "found in multiple projects: [k8s other]",
+		},
+		{
+			name:     "no hostname",
+			client:   &fakeProjectDiscoveryClient{projects: projects, instances: instances},
+			errorMsg: "hostname is empty",
+		},
+		{
+			name:     "list projects error",
+			hostname: "node-1",
+			client:   &fakeProjectDiscoveryClient{projectsErr: errBoom},
+			errorMsg: "failed listing projects",
+		},
+		{
+			name:     "list instances error",
+			hostname: "node-1",
+			client:   &fakeProjectDiscoveryClient{projects: projects, instancesErr: errBoom},
+			errorMsg: "failed listing instances in project k8s",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			project, err := resolveProject(t.Context(), tc.client, tc.configured, tc.hostname)
+			if tc.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Fatalf("err = %v, want error containing %q", err, tc.errorMsg)
+				}
+				if !strings.Contains(err.Error(), EnvProject) {
+					t.Fatalf("err = %v, want it to name %s", err, EnvProject)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if project != tc.want {
+				t.Fatalf("project = %q, want %q", project, tc.want)
+			}
+			if tc.configured != "" && tc.client.calls != 0 {
+				t.Fatalf("calls = %d, want none for a configured project", tc.client.calls)
+			}
+		})
+	}
+
+	t.Run("WrapsError", func(t *testing.T) {
+		_, err := resolveProject(t.Context(),
+			&fakeProjectDiscoveryClient{projectsErr: errBoom}, "", "node-1",
+		)
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("err = %v, want errBoom", err)
+		}
+	})
+}
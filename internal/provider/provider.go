@@ -5,31 +5,54 @@
 package provider
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"slices"
+	"sync"
 
-	"github.com/google/uuid"
 	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 )
 
-// init registers the Oxide cloud provider as a valid external cloud provider
-// for Kubernetes.
-func init() {
-	cloudprovider.RegisterCloudProvider(
-		Name,
-		func(config io.Reader) (cloudprovider.Interface, error) {
-			return &Oxide{}, nil
-		},
-	)
+var registerOnce sync.Once
+
+// Register registers the Oxide cloud provider under [Name] as a valid external
+// cloud provider for Kubernetes, along with its metrics. The provider is
+// registered explicitly rather than from an init function so that importing
+// the package has no side effects, and only once since registering a name
+// twice is fatal. It must be called before the cloud controller manager
+// initializes its cloud provider.
+func Register() {
+	registerOnce.Do(func() {
+		registerMetrics()
+
+		cloudprovider.RegisterCloudProvider(
+			Name,
+			func(config io.Reader) (cloudprovider.Interface, error) {
+				cfg, err := LoadConfig(config)
+				if err != nil {
+					return nil, err
+				}
+				return &Oxide{config: cfg, clock: clock.RealClock{}}, nil
+			},
+		)
+	})
 }
 
-// Name is the name of this cloud provider.
+// Name is the name of this cloud provider. It is also the scheme of the
+// provider IDs it sets on nodes.
 const Name = "oxide"
 
 var _ cloudprovider.Interface = (*Oxide)(nil)
@@ -37,10 +60,77 @@ var _ cloudprovider.Interface = (*Oxide)(nil)
 // Oxide is the Oxide cloud provider. It implements [cloudprovider.Interface] to
 // provide Oxide specific functionality.
 type Oxide struct {
+	config *Config
+
+	// clock is the source of time for timeouts, retries, and caches, so that
+	// tests can control it.
+	clock clock.WithTicker
+
+	// clusterName is the name of the cluster the load balancers are named
+	// after, as passed to the service controller.
+	clusterName string
+
 	client  *oxide.Client
 	project string
 
+	// regionClients maps each configured region to the Oxide client for its
+	// endpoint.
+	regionClients map[string]*oxide.Client
+
+	// defaultPools caches the silo's default IP pools across load balancers.
+	defaultPools defaultPoolCache
+
+	// namespacePools caches the floating IP pools annotated on namespaces
+	// across load balancers.
+	namespacePools namespacePoolCache
+
+	// internalIPFilter selects the addresses reported as node internal IPs. It
+	// is nil when no CIDRs are configured.
+	internalIPFilter *internalIPFilter
+
+	// nodeNameTransform derives instance names from node names. It is nil
+	// when none is configured.
+	nodeNameTransform *nodeNameTransform
+
+	// instanceIndex, when enabled, indexes instances by name. It is nil when
+	// disabled.
+	instanceIndex *instanceIndex
+
+	// instanceMetadataCache, when enabled, caches the metadata of nodes. It is
+	// nil when disabled.
+	instanceMetadataCache *instanceMetadataCache
+
+	// projectIDs caches the ID of the configured project per region.
+	projectIDs projectIDCache
+
+	// instanceSleds caches the sled each instance runs on.
+	instanceSleds *instanceSledCache
+
+	// nodeManagement tracks which nodes are resolved to their instance.
+	nodeManagement *nodeManagement
+
+	// notFoundRecheck confirms not-found instances across nodes.
+	notFoundRecheck *notFoundRecheck
+
+	// nodeSyncSlots limits the Oxide API calls in flight across node syncs to
+	// [Config.NodeSyncConcurrency].
+	nodeSyncSlots chan struct{}
+
+	// spares are the preallocated floating IPs shared across load balancers.
+	// It is nil when preallocation is disabled.
+	spares *spareFloatingIPs
+
+	// poolUtilization exports the utilization of the floating IP pools.
+	poolUtilization *poolUtilization
+
+	// lbLocks serializes load balancer operations per service across load
+	// balancers.
+	lbLocks keyMutex
+
 	k8sClient kubernetes.Interface
+
+	// recorder records events on Kubernetes objects.
+	recorder record.EventRecorder
 }
 
 // Initialize creates the Oxide and Kubernetes clients and spawns any additional
@@ -55,18 +145,196 @@ func (o *Oxide) Initialize(
 	}
 	o.k8sClient = kubernetesClient
 
-	oxideClient, err := oxide.NewClient()
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kubernetesClient.CoreV1().Events(""),
+	})
+	o.recorder = broadcaster.NewRecorder(
+		scheme.Scheme, v1.EventSource{Component: "oxide-cloud-controller-manager"},
+	)
+	go func() {
+		<-stop
+		broadcaster.Shutdown()
+	}()
+
+	transport, err := newBasePathTransport(o.config.HTTPTransport.Transport(), o.config.BaseURL)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	transport = newCircuitBreakerTransport(transport, o.clock, o.config.CircuitBreaker)
+
+	// The HTTP client reloads the token when the Oxide API rejects it. It is
+	// shared by all Oxide clients since they use the same token.
+	httpClient, err := newReauthHTTPClient(transport, o.clock, o.config.LoadToken)
+	if err != nil {
+		klog.Fatalf("failed to load oxide token: %v", err)
+	}
+	clientOptions := append(o.config.ClientOptions(), oxide.WithHTTPClient(httpClient))
+
+	oxideClient, err := oxide.NewClient(clientOptions...)
 	if err != nil {
 		klog.Fatalf("failed to create oxide client: %v", err)
 	}
 	o.client = oxideClient
 
-	o.project = os.Getenv("OXIDE_PROJECT")
-	if o.project == "" {
-		klog.Fatalf("OXIDE_PROJECT environment variable is required")
+	// The cloud controller manager runs on the host network, so its hostname
+	// is that of the instance it runs on.
+	hostname, _ := os.Hostname()
+	err = o.retryStartupValidation(func(ctx context.Context) error {
+		project, err := resolveProject(ctx, o.client, o.config.Project, hostname)
+		if err != nil {
+			return err
+		}
+		o.project = project
+		return validateProject(ctx, o.client, project)
+	})
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	o.regionClients = make(map[string]*oxide.Client, len(o.config.Regions))
+	for _, name := range o.config.RegionNames() {
+		regionClient, err := oxide.NewClient(append(
+			slices.Clone(clientOptions),
+			oxide.WithHost(o.config.Regions[name].Host),
+		)...)
+		if err != nil {
+			klog.Fatalf("failed to create oxide client for region %q: %v", name, err)
+		}
+		o.regionClients[name] = regionClient
 	}
 
-	klog.InfoS("initialized cloud provider", "type", "oxide", "project", o.project)
+	o.internalIPFilter, err = newInternalIPFilter(
+		o.config.InternalIPIncludeCIDRs, o.config.InternalIPExcludeCIDRs,
+	)
+	if err != nil {
+		klog.Fatalf("failed to create internal ip filter: %v", err)
+	}
+
+	o.nodeNameTransform, err = newNodeNameTransform(o.config.NodeNameTransform)
+	if err != nil {
+		klog.Fatalf("failed to create node name transform: %v", err)
+	}
+
+	o.instanceSleds = newInstanceSledCache(o.clock)
+	o.nodeManagement = newNodeManagement(o.clock)
+
+	if interval := o.config.InstanceIndexInterval; interval != nil {
+		o.instanceIndex = newInstanceIndex(o.clock, interval.Duration)
+	}
+
+	if ttl := o.config.InstanceMetadataCacheTTL; ttl != nil {
+		o.instanceMetadataCache = newInstanceMetadataCache(o.clock, ttl.Duration)
+	}
+
+	o.notFoundRecheck = newNotFoundRecheck(
+		o.clock, notFoundRecheckBackoff, maxConcurrentNotFoundRechecks,
+	)
+
+	o.nodeSyncSlots = make(chan struct{}, o.config.NodeSyncConcurrency)
+
+	go wait.UntilWithContext(wait.ContextForChannel(stop), func(ctx context.Context) {
+		if err := o.loadBalancer().cleanUpFloatingIPs(ctx); err != nil {
+			klog.ErrorS(err, "failed cleaning up floating ips")
+		}
+	}, floatingIPCleanupInterval)
+
+	o.poolUtilization = newPoolUtilization(o.client, o.clock, o.config.FloatingIPPools())
+	go o.poolUtilization.run(wait.ContextForChannel(stop))
+
+	o.spares = newSpareFloatingIPs(o.clusterName, o.config)
+	if o.spares != nil {
+		go wait.UntilWithContext(wait.ContextForChannel(stop), func(ctx context.Context) {
+			if err := o.loadBalancer().reconcileSpareFloatingIPs(ctx); err != nil {
+				klog.ErrorS(err, "failed reconciling spare floating ips")
+			}
+		}, spareFloatingIPInterval)
+	}
+
+	if interval := o.config.NodeDriftInterval; interval != nil {
+		instances, _ := o.InstancesV2()
+		detector := &nodeDriftDetector{
+			instances: instances,
+			k8sClient: o.k8sClient,
+			recorder:  o.recorder,
+			correct:   o.config.NodeDriftCorrect,
+		}
+		go wait.UntilWithContext(wait.ContextForChannel(stop), func(ctx context.Context) {
+			if err := detector.detect(ctx); err != nil {
+				klog.ErrorS(err, "failed detecting node drift")
+			}
+		}, interval.Duration)
+	}
+
+	if debounce := o.config.ServiceReconcileDebounce; debounce != nil {
+		if err := startServiceReconciler(
+			wait.ContextForChannel(stop),
+			informers.NewSharedInformerFactory(o.k8sClient, 0),
+			o.loadBalancer(),
+			o.clusterName,
+			o.clock,
+			debounce.Duration,
+		); err != nil {
+			klog.Fatalf("failed to start service reconciler: %v", err)
+		}
+	}
+
+	if admin := o.config.Admin; admin != nil {
+		go serveAdmin(wait.ContextForChannel(stop), admin, &adminReconcileHandler{
+			updater:     o.loadBalancer(),
+			k8sClient:   o.k8sClient,
+			clusterName: o.clusterName,
+			recorder:    o.recorder,
+		})
+	}
+
+	if webhook := o.config.Webhook; webhook != nil {
+		go serveServiceWebhook(wait.ContextForChannel(stop), webhook, &serviceWebhook{
+			lb:          o.loadBalancer(),
+			clusterName: o.clusterName,
+		})
+	}
+
+	logOxideVersions(context.Background(), o.client)
+
+	if err := o.retryStartupValidation(func(ctx context.Context) error {
+		return validateFloatingIPPools(ctx, o.client, o.config.FloatingIPPools())
+	}); err != nil {
+		klog.Fatalf("invalid floating ip pool configuration: %v", err)
+	}
+
+	if err := o.loadBalancer().resolveDefaultPool(context.Background()); err != nil {
+		klog.ErrorS(err, "failed resolving default ip pool, "+
+			"resolving it again when a floating ip is created")
+	}
+
+	if o.config.Preflight != "" {
+		var pool string
+		if pools := splitIPPools(o.config.FloatingIPPool); len(pools) > 0 {
+			pool = pools[0]
+		}
+		if err := preflight(
+			context.Background(), o.client, o.project, o.config.Preflight == PreflightWrite, pool,
+			o.config.PreflightInstance,
+		); err != nil {
+			klog.Fatalf("preflight checks failed:\n%v", err)
+		}
+		klog.InfoS("preflight checks passed", "preflight", o.config.Preflight)
+	}
+
+	klog.InfoS(
+		"initialized cloud provider",
+		"type", "oxide",
+		"project", o.project,
+		"regions", o.config.RegionNames(),
+	)
+}
+
+// SetClusterName sets the name of the cluster, which load balancers are named
+// after. It must be called with the cluster name the cloud controller manager
+// was started with before [Oxide.Initialize].
+func (o *Oxide) SetClusterName(name string) {
+	o.clusterName = name
 }
 
 // ProviderName returns the name of this cloud provider.
@@ -94,32 +362,106 @@ func (o *Oxide) Clusters() (cloudprovider.Clusters, bool) {
 	return nil, false
 }
 
-// Instances is purposefully unimplemented. Use [Oxide.InstancesV2].
+// Instances returns an implementation of the legacy [cloudprovider.Instances]
+// interface when [Config.LegacyInstances] is set, for older components that
+// still query it. It is unimplemented otherwise. Use [Oxide.InstancesV2].
 func (o *Oxide) Instances() (cloudprovider.Instances, bool) {
-	return nil, false
+	if !o.config.LegacyInstances {
+		return nil, false
+	}
+	instancesV2, _ := o.InstancesV2()
+	return newInstances(instancesV2.(*InstancesV2)), true
 }
 
 // InstancesV2 returns an implementation of [cloudprovider.InstancesV2]
 // that provides functionality to initialize Kubernetes nodes, provide their
 // metadata, and determine whether they exists to facilitate cleanup.
 func (o *Oxide) InstancesV2() (cloudprovider.InstancesV2, bool) {
+	regionClients := make(map[string]oxideInstanceClient, len(o.regionClients))
+	for name, client := range o.regionClients {
+		regionClients[name] = newLimitedInstanceClient(client, o.nodeSyncSlots)
+	}
+
+	var endpoints map[string]string
+	if o.config.EndpointAnnotation {
+		endpoints = o.config.Endpoints()
+	}
+
 	return &InstancesV2{
-		client:  o.client,
-		project: o.project,
+		client:              newLimitedInstanceClient(o.client, o.nodeSyncSlots),
+		project:             o.project,
+		k8sClient:           o.k8sClient,
+		regionClients:       regionClients,
+		nodeLabels:          o.config.NodeLabels,
+		labelKeys:           o.config.NodeLabelKeys,
+		nodeAddressTypes:    o.config.NodeAddressTypes,
+		invalidHostnames:    o.config.InvalidHostnames,
+		primaryNICOnly:      o.config.InternalIPsFromPrimaryNICOnly,
+		internalIPFilter:    o.internalIPFilter,
+		externalIPKinds:     o.config.NodeExternalIPKinds,
+		egressIPsAnnotation: o.config.EgressIPsAnnotation,
+		diskAnnotations:     o.config.DiskAnnotations,
+		sledAnnotations:     o.config.SledAnnotations,
+		endpoints:           endpoints,
+		bestEffortMetadata:  o.config.InstanceMetadataPolicy == InstanceMetadataBestEffort,
+		shutdownStates:      o.config.ShutdownInstanceStates,
+		recheck:             o.notFoundRecheck,
+		index:               o.instanceIndex,
+		nameTransform:       o.nodeNameTransform,
+		projectIDs:          &o.projectIDs,
+		sleds:               o.instanceSleds,
+		metadataCache:       o.instanceMetadataCache,
+		nodeManagement:      o.nodeManagement,
+		followRecreated:     o.config.FollowRecreatedInstances,
+		recorder:            o.recorder,
 	}, true
 }
 
+// LoadBalancer returns an implementation of [cloudprovider.LoadBalancer] that
+// attaches a floating IP to a node for each service of type LoadBalancer.
 func (o *Oxide) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
-	return &LoadBalancer{
-		client:    o.client,
-		project:   o.project,
-		k8sClient: o.k8sClient,
-	}, true
+	return o.loadBalancer(), true
+}
+
+func (o *Oxide) loadBalancer() *LoadBalancer {
+	lb := &LoadBalancer{
+		client:         o.client,
+		project:        o.project,
+		k8sClient:      o.k8sClient,
+		clusterName:    o.clusterName,
+		defaultPool:    o.config.FloatingIPPool,
+		namespacePools: o.config.NamespaceFloatingIPPools,
+		defaultPools:   &o.defaultPools,
+		locks:          &o.lbLocks,
+		recorder:       o.recorder,
+		clock:          o.clock,
+		attachBackoff:  floatingIPAttachBackoff,
+
+		namespacePoolCache:  &o.namespacePools,
+		ingressNodeSelector: o.config.IngressNodeSelector,
+		nodeScoring:         o.config.NodeScoring,
+		minNodes:            o.config.MinLoadBalancerNodes,
+		attachMode:          o.config.FloatingIPAttachMode,
+		cleanupDryRun:       o.config.FloatingIPCleanup == FloatingIPCleanupDryRun,
+		spares:              o.spares,
+		poolUtilization:     o.poolUtilization,
+	}
+	if timeout := o.config.LoadBalancerOperationTimeout; timeout != nil {
+		lb.operationTimeout = timeout.Duration
+	}
+	if period := o.config.FloatingIPFailoverGracePeriod; period != nil {
+		lb.failoverGracePeriod = period.Duration
+	}
+	return lb
 }
 
 // Routes is purposefully unimplemented. It is expected that the Kubernetes
 // cluster uses a third-party CNI instead of this controller. This may be
-// implemented in the future.
+// implemented in the future. Since the cloud controller manager creates no VPC
+// routes, it also doesn't garbage collect routes left behind by removed
+// nodes; those belong to whatever created them. A route implementation should
+// name its routes after their nodes so that the route controller, which
+// deletes the routes of nodes that no longer exist, can clean them up.
 func (o *Oxide) Routes() (cloudprovider.Routes, bool) {
 	return nil, false
 }
@@ -130,26 +472,34 @@ func (o *Oxide) Zones() (cloudprovider.Zones, bool) {
 	return nil, false
 }
 
-// InstanceIDFromProviderID extracts the Oxide instance ID from a provider ID.
-func InstanceIDFromProviderID(providerID string) (string, error) {
-	if providerID == "" {
-		return "", errors.New("provider id is empty")
+// retryStartupValidation retries validate while the Oxide API is unreachable
+// for up to [Config.StartupValidationTimeout].
+func (o *Oxide) retryStartupValidation(validate func(context.Context) error) error {
+	timeout := DefaultStartupValidationTimeout
+	if o.config.StartupValidationTimeout != nil {
+		timeout = o.config.StartupValidationTimeout.Duration
 	}
-
-	if !strings.HasPrefix(providerID, "oxide://") {
-		return "", errors.New("provider id does not have 'oxide://' prefix")
-	}
-
-	instanceID := strings.TrimPrefix(providerID, "oxide://")
-
-	if _, err := uuid.Parse(instanceID); err != nil {
-		return "", fmt.Errorf("provider id contains invalid uuid: %w", err)
-	}
-
-	return instanceID, nil
+	return retryStartupValidation(
+		context.Background(), o.clock, startupValidationBackoff, timeout, validate,
+	)
 }
 
-// NewProviderID formats an Oxide instance ID as a provider ID.
-func NewProviderID(instanceID string) string {
-	return fmt.Sprintf("oxide://%s", instanceID)
+// validateFloatingIPPools checks that every configured floating IP pool exists
+// and is linked to the silo so misconfiguration fails at startup rather than
+// when a service is reconciled.
+func validateFloatingIPPools(
+	ctx context.Context,
+	client oxideLoadBalancerClient,
+	pools []string,
+) error {
+	errs := make([]error, 0)
+	for _, pool := range pools {
+		_, err := client.IpPoolView(ctx, oxide.IpPoolViewParams{
+			Pool: oxide.NameOrId(pool),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed viewing ip pool %s: %w", pool, err))
+		}
+	}
+	return errors.Join(errs...)
 }
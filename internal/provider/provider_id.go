@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ProviderID is a parsed Oxide provider ID. Two formats are supported:
+//
+//   - oxide://<uuid>, the original format, which only has the instance ID.
+//   - oxide://<silo>/<project>/<uuid>, which also has the silo and project of
+//     the instance so nodes of several silos or projects can be told apart.
+//
+// Both formats must keep parsing, since provider IDs are immutable once set on
+// a node.
+type ProviderID struct {
+	// Silo and Project are the silo and project of the instance. They are
+	// either both set or both empty.
+	Silo    string
+	Project string
+
+	// InstanceID is the ID of the Oxide instance.
+	InstanceID string
+}
+
+// ParseProviderID parses an Oxide provider ID in any supported format. A
+// provider ID with a scheme other than oxide:// returns an error wrapping
+// [ErrForeignProviderID] so callers can tell it apart from a malformed Oxide
+// provider ID, which returns an error wrapping [ErrProviderIDInvalid].
+func ParseProviderID(providerID string) (ProviderID, error) {
+	if providerID == "" {
+		return ProviderID{}, fmt.Errorf("%w: provider id is empty", ErrProviderIDInvalid)
+	}
+
+	if scheme, _, ok := strings.Cut(providerID, "://"); ok && scheme != Name {
+		return ProviderID{}, fmt.Errorf(
+			"%w: unexpected scheme %q", ErrForeignProviderID, scheme,
+		)
+	}
+
+	rest, ok := strings.CutPrefix(providerID, "oxide://")
+	if !ok {
+		return ProviderID{}, fmt.Errorf(
+			"%w: provider id does not have 'oxide://' prefix", ErrProviderIDInvalid,
+		)
+	}
+
+	var parsed ProviderID
+	switch segments := strings.Split(rest, "/"); len(segments) {
+	case 1:
+		parsed.InstanceID = segments[0]
+	case 3:
+		if segments[0] == "" || segments[1] == "" {
+			return ProviderID{}, fmt.Errorf(
+				"%w: provider id has an empty silo or project", ErrProviderIDInvalid,
+			)
+		}
+		parsed = ProviderID{Silo: segments[0], Project: segments[1], InstanceID: segments[2]}
+	default:
+		return ProviderID{}, fmt.Errorf(
+			"%w: provider id has %d path segments, want 1 or 3",
+			ErrProviderIDInvalid, len(segments),
+		)
+	}
+
+	if _, err := uuid.Parse(parsed.InstanceID); err != nil {
+		return ProviderID{}, fmt.Errorf(
+			"%w: provider id contains invalid uuid: %w", ErrProviderIDInvalid, err,
+		)
+	}
+
+	return parsed, nil
+}
+
+// String formats the provider ID, including the silo and project only when
+// both are set.
+func (p ProviderID) String() string {
+	if p.Silo == "" || p.Project == "" {
+		return fmt.Sprintf("oxide://%s", p.InstanceID)
+	}
+	return fmt.Sprintf("oxide://%s/%s/%s", p.Silo, p.Project, p.InstanceID)
+}
@@ -0,0 +1,239 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseProviderID(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		tt := []struct {
+			name       string
+			providerID string
+			expected   ProviderID
+		}{
+			{
+				name:       "instance only",
+				providerID: "oxide://12345678-1234-1234-1234-123456789abc",
+				expected:   ProviderID{InstanceID: "12345678-1234-1234-1234-123456789abc"},
+			},
+			{
+				name:       "instance only with uppercase UUID",
+				providerID: "oxide://12345678-1234-1234-1234-123456789ABC",
+				expected:   ProviderID{InstanceID: "12345678-1234-1234-1234-123456789ABC"},
+			},
+			{
+				name:       "silo and project",
+				providerID: "oxide://silo/project/12345678-1234-1234-1234-123456789abc",
+				expected: ProviderID{
+					Silo:       "silo",
+					Project:    "project",
+					InstanceID: "12345678-1234-1234-1234-123456789abc",
+				},
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				result, err := ParseProviderID(tc.providerID)
+				if err != nil {
+					t.Fatalf("ParseProviderID(%s) returned error %v, want nil error",
+						tc.providerID, err)
+				}
+				if result != tc.expected {
+					t.Errorf("ParseProviderID(%s) = %+v, want %+v",
+						tc.providerID, result, tc.expected)
+				}
+
+				// Formatting the parsed provider ID must round trip.
+				if got := result.String(); got != tc.providerID {
+					t.Errorf("String() = %s, want %s", got, tc.providerID)
+				}
+			})
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		tt := []struct {
+			name       string
+			providerID string
+			errorMsg   string
+		}{
+			{
+				name:       "empty provider ID",
+				providerID: "",
+				errorMsg:   "provider id is empty",
+			},
+			{
+				name:       "provider ID without oxide:// prefix",
+				providerID: "12345678-1234-1234-1234-123456789abc",
+				errorMsg:   "provider id does not have 'oxide://' prefix",
+			},
+			{
+				name:       "provider ID with malformed oxide scheme",
+				providerID: "oxide:/12345678-1234-1234-1234-123456789abc",
+				errorMsg:   "provider id does not have 'oxide://' prefix",
+			},
+			{
+				name:       "provider ID with invalid UUID",
+				providerID: "oxide://not-a-valid-uuid",
+				errorMsg:   "provider id contains invalid uuid",
+			},
+			{
+				name:       "provider ID with empty UUID",
+				providerID: "oxide://",
+				errorMsg:   "provider id contains invalid uuid",
+			},
+			{
+				name:       "provider ID with partial UUID",
+				providerID: "oxide://12345678-1234",
+				errorMsg:   "provider id contains invalid uuid",
+			},
+			{
+				name:       "silo and project with invalid UUID",
+				providerID: "oxide://silo/project/not-a-valid-uuid",
+				errorMsg:   "provider id contains invalid uuid",
+			},
+			{
+				name:       "silo and project with empty UUID",
+				providerID: "oxide://silo/project/",
+				errorMsg:   "provider id contains invalid uuid",
+			},
+			{
+				name:       "empty silo",
+				providerID: "oxide:///project/12345678-1234-1234-1234-123456789abc",
+				errorMsg:   "provider id has an empty silo or project",
+			},
+			{
+				name:       "empty project",
+				providerID: "oxide://silo//12345678-1234-1234-1234-123456789abc",
+				errorMsg:   "provider id has an empty silo or project",
+			},
+			{
+				name:       "project without silo",
+				providerID: "oxide://project/12345678-1234-1234-1234-123456789abc",
+				errorMsg:   "provider id has 2 path segments, want 1 or 3",
+			},
+			{
+				name:       "trailing slash",
+				providerID: "oxide://12345678-1234-1234-1234-123456789abc/",
+				errorMsg:   "provider id has 2 path segments, want 1 or 3",
+			},
+			{
+				name:       "too many segments",
+				providerID: "oxide://a/silo/project/12345678-1234-1234-1234-123456789abc",
+				errorMsg:   "provider id has 4 path segments, want 1 or 3",
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := ParseProviderID(tc.providerID)
+				if !errors.Is(err, ErrProviderIDInvalid) {
+					t.Fatalf("ParseProviderID(%s) returned error %v, want %v",
+						tc.providerID, err, ErrProviderIDInvalid)
+				}
+				if !strings.Contains(err.Error(), tc.errorMsg) {
+					t.Errorf("ParseProviderID(%s) returned error %v, want %s",
+						tc.providerID, err, tc.errorMsg)
+				}
+			})
+		}
+	})
+
+	t.Run("Foreign", func(t *testing.T) {
+		tt := []struct {
+			name       string
+			providerID string
+			foreign    bool
+		}{
+			{
+				name:       "aws",
+				providerID: "aws:///us-east-1a/i-0123456789abcdef0",
+				foreign:    true,
+			},
+			{
+				name:       "gce",
+				providerID: "gce://project/us-central1-a/instance-1",
+				foreign:    true,
+			},
+			{
+				name:       "malformed oxide",
+				providerID: "oxide://not-a-valid-uuid",
+				foreign:    false,
+			},
+			{
+				name:       "oxide without scheme separator",
+				providerID: "oxide:12345678-1234-1234-1234-123456789abc",
+				foreign:    false,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := ParseProviderID(tc.providerID)
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if got := errors.Is(err, ErrForeignProviderID); got != tc.foreign {
+					t.Fatalf("errors.Is(%v, ErrForeignProviderID) = %v, want %v",
+						err, got, tc.foreign)
+				}
+			})
+		}
+	})
+}
+
+func TestProviderIDString(t *testing.T) {
+	tests := []struct {
+		name       string
+		providerID ProviderID
+		expected   string
+	}{
+		{
+			name:       "valid instance ID",
+			providerID: ProviderID{InstanceID: "12345678-1234-1234-1234-123456789abc"},
+			expected:   "oxide://12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name:       "empty instance ID",
+			providerID: ProviderID{},
+			expected:   "oxide://",
+		},
+		{
+			name:       "instance ID with uppercase UUID",
+			providerID: ProviderID{InstanceID: "12345678-1234-1234-1234-123456789ABC"},
+			expected:   "oxide://12345678-1234-1234-1234-123456789ABC",
+		},
+		{
+			name: "silo and project",
+			providerID: ProviderID{
+				Silo:       "silo",
+				Project:    "project",
+				InstanceID: "12345678-1234-1234-1234-123456789abc",
+			},
+			expected: "oxide://silo/project/12345678-1234-1234-1234-123456789abc",
+		},
+		{
+			name: "project without silo",
+			providerID: ProviderID{
+				Project:    "project",
+				InstanceID: "12345678-1234-1234-1234-123456789abc",
+			},
+			expected: "oxide://12345678-1234-1234-1234-123456789abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.providerID.String(); result != tt.expected {
+				t.Errorf("String() = %s, want %s", result, tt.expected)
+			}
+		})
+	}
+}
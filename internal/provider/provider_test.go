@@ -5,142 +5,63 @@
 package provider
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"testing"
-)
-
-func TestInstanceIDFromProviderID(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		tt := []struct {
-			name       string
-			providerID string
-			expected   string
-		}{
-			{
-				name:       "valid provider ID with UUID",
-				providerID: "oxide://12345678-1234-1234-1234-123456789abc",
-				expected:   "12345678-1234-1234-1234-123456789abc",
-			},
-			{
-				name:       "valid provider ID with uppercase UUID",
-				providerID: "oxide://12345678-1234-1234-1234-123456789ABC",
-				expected:   "12345678-1234-1234-1234-123456789ABC",
-			},
-		}
 
-		for _, tc := range tt {
-			t.Run(tc.name, func(t *testing.T) {
-				result, err := InstanceIDFromProviderID(tc.providerID)
-				if err != nil {
-					t.Errorf(
-						"TestInstanceIDFromProviderID(%s) returned non-nil error %v, want nil error",
-						tc.providerID,
-						err,
-					)
-				}
+	"github.com/oxidecomputer/oxide.go/oxide"
+	cloudprovider "k8s.io/cloud-provider"
+)
 
-				if result != tc.expected {
-					t.Errorf(
-						"TestInstanceIDFromProviderID(%s) returned %s, want %s",
-						tc.providerID,
-						result,
-						tc.expected,
-					)
-				}
-			})
-		}
-	})
+func TestRegister(t *testing.T) {
+	t.Setenv("OXIDE_HOST", "https://oxide.sys.example.com")
+	t.Setenv("OXIDE_TOKEN", "token")
+	t.Setenv("OXIDE_PROJECT", "project")
 
-	t.Run("Error", func(t *testing.T) {
-		tt := []struct {
-			name       string
-			providerID string
-			errorMsg   string
-		}{
-			{
-				name:       "empty provider ID",
-				providerID: "",
-				errorMsg:   "provider id is empty",
-			},
-			{
-				name:       "provider ID without oxide:// prefix",
-				providerID: "12345678-1234-1234-1234-123456789abc",
-				errorMsg:   "provider id does not have 'oxide://' prefix",
-			},
-			{
-				name:       "provider ID with invalid UUID",
-				providerID: "oxide://not-a-valid-uuid",
-				errorMsg:   "provider id contains invalid uuid",
-			},
-			{
-				name:       "provider ID with empty UUID",
-				providerID: "oxide://",
-				errorMsg:   "provider id contains invalid uuid",
-			},
-			{
-				name:       "provider ID with partial UUID",
-				providerID: "oxide://12345678-1234",
-				errorMsg:   "provider id contains invalid uuid",
-			},
-			{
-				name:       "provider ID with wrong prefix",
-				providerID: "aws://12345678-1234-1234-1234-123456789abc",
-				errorMsg:   "provider id does not have 'oxide://' prefix",
-			},
-		}
+	// Registering again must not register the name twice, which is fatal.
+	Register()
+	Register()
 
-		for _, tc := range tt {
-			t.Run(tc.name, func(t *testing.T) {
-				_, err := InstanceIDFromProviderID(tc.providerID)
-				if err == nil {
-					t.Errorf(
-						"TestInstanceIDFromProviderID(%s) returned nil error, want non-nil error",
-						tc.providerID,
-					)
-				}
+	if !cloudprovider.IsCloudProvider(Name) {
+		t.Fatalf("cloud provider %q is not registered", Name)
+	}
 
-				if !strings.Contains(err.Error(), tc.errorMsg) {
-					t.Errorf(
-						"TestInstanceIDFromProviderID(%s) returned error %v, want %s",
-						tc.providerID,
-						err.Error(),
-						tc.errorMsg,
-					)
-				}
-			})
-		}
-	})
+	cloud, err := cloudprovider.GetCloudProvider(Name, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := cloud.(*Oxide); !ok {
+		t.Fatalf("cloud provider = %T, want *Oxide", cloud)
+	}
 }
 
-func TestNewProviderID(t *testing.T) {
-	tests := []struct {
-		name       string
-		instanceID string
-		expected   string
-	}{
-		{
-			name:       "valid instance ID",
-			instanceID: "12345678-1234-1234-1234-123456789abc",
-			expected:   "oxide://12345678-1234-1234-1234-123456789abc",
-		},
-		{
-			name:       "empty instance ID",
-			instanceID: "",
-			expected:   "oxide://",
-		},
-		{
-			name:       "instance ID with uppercase UUID",
-			instanceID: "12345678-1234-1234-1234-123456789ABC",
-			expected:   "oxide://12345678-1234-1234-1234-123456789ABC",
+func TestValidateFloatingIPPools(t *testing.T) {
+	client := &fakeOxideLBClient{
+		IpPoolViewFn: func(
+			_ context.Context, p oxide.IpPoolViewParams,
+		) (*oxide.SiloIpPool, error) {
+			if p.Pool == "missing" {
+				return nil, oxide.ErrObjectNotFound
+			}
+			return &oxide.SiloIpPool{Name: oxide.Name(p.Pool)}, nil
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := NewProviderID(tt.instanceID)
-			if result != tt.expected {
-				t.Errorf("NewProviderID(%s) = %s, want %s", tt.instanceID, result, tt.expected)
-			}
-		})
-	}
+	t.Run("AllExist", func(t *testing.T) {
+		err := validateFloatingIPPools(t.Context(), client, []string{"internal", "public"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		err := validateFloatingIPPools(t.Context(), client, []string{"public", "missing"})
+		if !errors.Is(err, oxide.ErrObjectNotFound) {
+			t.Fatalf("err = %v, want ErrObjectNotFound", err)
+		}
+		if !strings.Contains(err.Error(), "missing") {
+			t.Fatalf("err = %v, want it to name the missing pool", err)
+		}
+	})
 }
@@ -0,0 +1,180 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// oxideHTTPTimeout is the timeout for Oxide API requests, matching the default
+// of the Oxide client.
+const oxideHTTPTimeout = 10 * time.Minute
+
+// tokenReloadCooldown is how long after a token reload that did not yield a new
+// token the token is not reloaded again, so that a rejected token does not
+// reload it on every request while still picking up a token rotated later.
+const tokenReloadCooldown = 30 * time.Second
+
+// reauthTransport is an [http.RoundTripper] that authenticates Oxide API
+// requests with the current token. When the Oxide API rejects the token with a
+// 401, it reloads the token and retries the request once, so a token that is
+// rotated out of band is picked up without restarting.
+type reauthTransport struct {
+	base      http.RoundTripper
+	clock     clock.PassiveClock
+	loadToken func() (string, error)
+
+	mu    sync.Mutex
+	token string
+
+	// failedReload is when reloading the token last failed or yielded the
+	// rejected token, or zero when the last reload succeeded.
+	failedReload time.Time
+}
+
+// newReauthHTTPClient returns an HTTP client for the Oxide client that sends
+// requests over base and authenticates with the token returned by loadToken,
+// reloading it when the Oxide API rejects it.
+func newReauthHTTPClient(
+	base http.RoundTripper,
+	clock clock.PassiveClock,
+	loadToken func() (string, error),
+) (*http.Client, error) {
+	token, err := loadToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout: oxideHTTPTimeout,
+		Transport: &reauthTransport{
+			base:      base,
+			clock:     clock,
+			loadToken: loadToken,
+			token:     token,
+		},
+	}, nil
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTripWithReload(req)
+	if err == nil {
+		observeAuthFailure(req, resp.StatusCode)
+	}
+	return resp, err
+}
+
+// roundTripWithReload sends the request, reloading the token and retrying once
+// when the Oxide API rejects it with a 401. A 403 means the token is valid but
+// lacks permission for the request, which reloading it won't fix.
+func (t *reauthTransport) roundTripWithReload(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	token := t.token
+	t.mu.Unlock()
+
+	resp, err := t.roundTrip(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The request can only be retried when its body can be read again.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	newToken, ok := t.reload(token)
+	if !ok {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	_ = resp.Body.Close()
+
+	return t.roundTrip(retry, newToken)
+}
+
+// roundTrip sends the request authenticated with token. An empty token leaves
+// the Oxide client's own authentication (e.g., from OXIDE_PROFILE) in place.
+func (t *reauthTransport) roundTrip(req *http.Request, token string) (*http.Response, error) {
+	if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// observeAuthFailure logs and counts a request the Oxide API rejected with a
+// 401 or a 403, which call for different fixes: a 401 means the token is
+// invalid or expired, while a 403 means the token's user lacks a role that the
+// operation needs.
+func observeAuthFailure(req *http.Request, status int) {
+	operation := req.Method + " " + req.URL.Path
+
+	switch status {
+	case http.StatusUnauthorized:
+		oxideAuthFailuresTotal.WithLabelValues(authFailureUnauthorized).Inc()
+		klog.ErrorS(nil, "oxide api rejected the token, check that it is valid and not expired",
+			"operation", operation)
+	case http.StatusForbidden:
+		oxideAuthFailuresTotal.WithLabelValues(authFailureForbidden).Inc()
+		klog.ErrorS(nil, "oxide api denied the operation, grant the token's user a role "+
+			"that permits it", "operation", operation)
+	}
+}
+
+// reload reloads the token after rejected was rejected by the Oxide API. It
+// returns the token to retry with and whether the request should be retried.
+// After a reload that yields no new token, the token is not reloaded again for
+// [tokenReloadCooldown].
+func (t *reauthTransport) reload(rejected string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Another request already reloaded the token.
+	if t.token != rejected {
+		return t.token, true
+	}
+
+	now := t.clock.Now()
+	if !t.failedReload.IsZero() && now.Sub(t.failedReload) < tokenReloadCooldown {
+		return "", false
+	}
+
+	token, err := t.loadToken()
+	if err != nil {
+		t.failedReload = now
+		klog.ErrorS(err, "oxide api rejected the token and reloading it failed",
+			"retryAfter", tokenReloadCooldown)
+		return "", false
+	}
+
+	if token == rejected {
+		t.failedReload = now
+		klog.ErrorS(nil, "oxide api rejected the token and the reloaded token is unchanged, "+
+			"update the token to recover", "retryAfter", tokenReloadCooldown)
+		return "", false
+	}
+
+	klog.InfoS("oxide api rejected the token, retrying with the reloaded token")
+	t.token = token
+	t.failedReload = time.Time{}
+
+	return token, true
+}
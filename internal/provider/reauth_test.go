@@ -0,0 +1,221 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// roundTripperFunc adapts a function to an [http.RoundTripper].
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// acceptToken returns a round tripper that accepts requests authenticated with
+// valid and rejects all others with a 401, recording each request body.
+func acceptToken(valid *string, bodies *[]string) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			body, _ := io.ReadAll(req.Body)
+			*bodies = append(*bodies, string(body))
+		}
+
+		status := http.StatusOK
+		if req.Header.Get("Authorization") != "Bearer "+*valid {
+			status = http.StatusUnauthorized
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	})
+}
+
+func TestReauthTransport(t *testing.T) {
+	newRequest := func(t *testing.T) *http.Request {
+		req, err := http.NewRequestWithContext(
+			t.Context(), http.MethodPost, "https://oxide.example.com/v1/floating-ips",
+			strings.NewReader(`{"name":"fip"}`),
+		)
+		if err != nil {
+			t.Fatalf("failed creating request: %v", err)
+		}
+		return req
+	}
+
+	t.Run("RetriesWithReloadedToken", func(t *testing.T) {
+		valid := "new-token"
+		bodies := make([]string, 0)
+		transport := &reauthTransport{
+			base:      acceptToken(&valid, &bodies),
+			clock:     clocktesting.NewFakeClock(time.Now()),
+			loadToken: func() (string, error) { return "new-token", nil },
+			token:     "old-token",
+		}
+
+		resp, err := transport.RoundTrip(newRequest(t))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if len(bodies) != 2 || bodies[1] != `{"name":"fip"}` {
+			t.Fatalf("request bodies = %q, want the body sent twice", bodies)
+		}
+	})
+
+	t.Run("UnchangedTokenIsNotRetried", func(t *testing.T) {
+		valid := "new-token"
+		bodies := make([]string, 0)
+		transport := &reauthTransport{
+			base:      acceptToken(&valid, &bodies),
+			clock:     clocktesting.NewFakeClock(time.Now()),
+			loadToken: func() (string, error) { return "old-token", nil },
+			token:     "old-token",
+		}
+
+		resp, err := transport.RoundTrip(newRequest(t))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+		if len(bodies) != 1 {
+			t.Fatalf("sent %d requests, want 1", len(bodies))
+		}
+	})
+
+	t.Run("ReloadsCoolDown", func(t *testing.T) {
+		// The token is rotated only after several requests were rejected.
+		valid := "new-token"
+		current := "old-token"
+		bodies := make([]string, 0)
+		loads := 0
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		transport := &reauthTransport{
+			base:  acceptToken(&valid, &bodies),
+			clock: fakeClock,
+			loadToken: func() (string, error) {
+				loads++
+				return current, nil
+			},
+			token: "old-token",
+		}
+
+		for range 5 {
+			if _, err := transport.RoundTrip(newRequest(t)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if loads != 1 {
+			t.Fatalf("token loaded %d times, want 1 within the cooldown", loads)
+		}
+
+		// The rotated token is picked up once the cooldown passed.
+		current = "new-token"
+		fakeClock.Step(tokenReloadCooldown)
+		resp, err := transport.RoundTrip(newRequest(t))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %v, err = %v, want success", resp.StatusCode, err)
+		}
+		if loads != 2 || transport.token != "new-token" {
+			t.Fatalf("loads = %d, token = %q, want the rotated token", loads, transport.token)
+		}
+	})
+
+	t.Run("AuthFailures", func(t *testing.T) {
+		registerMetrics()
+
+		failures := func(t *testing.T, reason string) float64 {
+			t.Helper()
+			value, err := testutil.GetCounterMetricValue(
+				oxideAuthFailuresTotal.WithLabelValues(reason),
+			)
+			if err != nil {
+				t.Fatalf("failed reading counter: %v", err)
+			}
+			return value
+		}
+
+		tt := []struct {
+			name    string
+			status  int
+			reason  string
+			reloads int
+		}{
+			{
+				// The token is reloaded and the request retried once.
+				name:    "Unauthorized",
+				status:  http.StatusUnauthorized,
+				reason:  authFailureUnauthorized,
+				reloads: 1,
+			},
+			{
+				// A token lacking permission is not reloaded.
+				name:    "Forbidden",
+				status:  http.StatusForbidden,
+				reason:  authFailureForbidden,
+				reloads: 0,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				other := authFailureForbidden
+				if tc.reason == authFailureForbidden {
+					other = authFailureUnauthorized
+				}
+				before, otherBefore := failures(t, tc.reason), failures(t, other)
+
+				loads := 0
+				transport := &reauthTransport{
+					base: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: tc.status,
+							Body:       io.NopCloser(strings.NewReader("")),
+						}, nil
+					}),
+					clock: clocktesting.NewFakeClock(time.Now()),
+					loadToken: func() (string, error) {
+						loads++
+						return "new-token", nil
+					},
+					token: "old-token",
+				}
+
+				resp, err := transport.RoundTrip(newRequest(t))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if resp.StatusCode != tc.status {
+					t.Fatalf("status = %d, want %d", resp.StatusCode, tc.status)
+				}
+				if loads != tc.reloads {
+					t.Fatalf("token loaded %d times, want %d", loads, tc.reloads)
+				}
+
+				// Only the final response is counted, not the rejected attempt
+				// that was retried with a reloaded token.
+				if got := failures(t, tc.reason) - before; got != 1 {
+					t.Fatalf("%s failures = %v, want 1", tc.reason, got)
+				}
+				if got := failures(t, other) - otherBefore; got != 0 {
+					t.Fatalf("%s failures = %v, want 0", other, got)
+				}
+			})
+		}
+	})
+}
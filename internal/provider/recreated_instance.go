@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// EventReasonReplacedProviderID is the reason of the event recorded on a node
+// when its provider ID is replaced with that of an instance recreated with the
+// node's name.
+const EventReasonReplacedProviderID = "ReplacedProviderID"
+
+// followRecreatedInstance replaces the provider ID of a node whose instance
+// was deleted with that of the instance recreated with the node's name, if
+// there is one, and reports whether it did. The node then exists again, so
+// the cloud node lifecycle controller does not delete it.
+func (i *InstancesV2) followRecreatedInstance(ctx context.Context, node *v1.Node) (bool, error) {
+	providerID, err := ParseProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return false, fmt.Errorf("failed parsing provider id %s: %w", node.Spec.ProviderID, err)
+	}
+
+	// Look the instance up by name, as for a node that was never initialized.
+	byName := node.DeepCopy()
+	byName.Spec.ProviderID = ""
+	instance, _, err := i.getCurrentInstance(ctx, byName)
+	if err != nil {
+		if errors.Is(err, ErrInstanceNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if instance.Id == providerID.InstanceID ||
+		instance.RunState == oxide.InstanceStateDestroyed {
+		return false, nil
+	}
+
+	if err := i.replaceProviderID(ctx, node, instance); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replaceProviderID sets the provider ID of the node to that of the instance.
+// A node's provider ID cannot be changed once set, so the node is deleted and
+// created again with the same labels, annotations, taints, and spec. The
+// deletion is preconditioned on the node's UID, so that a node registered
+// again in the meantime is left alone.
+func (i *InstancesV2) replaceProviderID(
+	ctx context.Context,
+	node *v1.Node,
+	instance *oxide.Instance,
+) error {
+	nodes := i.k8sClient.CoreV1().Nodes()
+
+	current, err := nodes.Get(ctx, node.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed getting node %s: %w", node.Name, err)
+	}
+	if current.Spec.ProviderID != node.Spec.ProviderID {
+		return fmt.Errorf(
+			"node %s changed provider id from %s to %s while looking up its instance",
+			node.Name, node.Spec.ProviderID, current.Spec.ProviderID,
+		)
+	}
+
+	providerID := ProviderID{InstanceID: instance.Id}.String()
+	replacement := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            current.Name,
+			Labels:          current.Labels,
+			Annotations:     current.Annotations,
+			OwnerReferences: current.OwnerReferences,
+		},
+		Spec: *current.Spec.DeepCopy(),
+	}
+	replacement.Spec.ProviderID = providerID
+
+	if err := nodes.Delete(ctx, current.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &current.UID},
+	}); err != nil {
+		return fmt.Errorf("failed deleting node %s: %w", node.Name, err)
+	}
+	created, err := nodes.Create(ctx, replacement, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf(
+			"failed creating node %s with provider id %s: %w", node.Name, providerID, err,
+		)
+	}
+
+	klog.InfoS("replaced provider id of node with that of its recreated instance",
+		"node", klog.KObj(node), "oldProviderID", node.Spec.ProviderID,
+		"providerID", providerID)
+	if i.recorder != nil {
+		i.recorder.Eventf(created, v1.EventTypeNormal, EventReasonReplacedProviderID,
+			"Replaced provider ID %s of deleted instance with %s of instance %s "+
+				"recreated with the node's name", node.Spec.ProviderID, providerID, instance.Id)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// recreatedInstanceClient serves the instances of a project in which the
+// instance named node-1 was deleted and created again under a new ID.
+type recreatedInstanceClient struct {
+	*mockOxideClient
+
+	// recreated is the instance named node-1, or nil when there is none.
+	recreated *oxide.Instance
+}
+
+func (c *recreatedInstanceClient) InstanceView(
+	_ context.Context,
+	params oxide.InstanceViewParams,
+) (*oxide.Instance, error) {
+	if c.recreated == nil {
+		return nil, oxide.ErrObjectNotFound
+	}
+	if params.Instance == oxide.NameOrId(c.recreated.Name) ||
+		params.Instance == oxide.NameOrId(c.recreated.Id) {
+		return c.recreated, nil
+	}
+	return nil, oxide.ErrObjectNotFound
+}
+
+func TestFollowRecreatedInstance(t *testing.T) {
+	deleted := ProviderID{InstanceID: instIDOld}.String()
+	recreated := instanceRunning
+	recreated.Id = instIDNew
+
+	destroyed := recreated
+	destroyed.RunState = oxide.InstanceStateDestroyed
+
+	newNode := func() *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-1",
+				UID:         "node-1-uid",
+				Labels:      map[string]string{"role": "worker"},
+				Annotations: map[string]string{AnnotationInstanceID: instIDOld},
+			},
+			Spec: v1.NodeSpec{
+				ProviderID: deleted,
+				Taints:     []v1.Taint{{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		followRecreated bool
+		recreated       *oxide.Instance
+		wantExists      bool
+		wantProviderID  string
+	}{
+		{
+			name:            "Recreated",
+			followRecreated: true,
+			recreated:       &recreated,
+			wantExists:      true,
+			wantProviderID:  ProviderID{InstanceID: instIDNew}.String(),
+		},
+		{
+			name:           "Disabled",
+			recreated:      &recreated,
+			wantProviderID: deleted,
+		},
+		{
+			name:            "NotRecreated",
+			followRecreated: true,
+			wantProviderID:  deleted,
+		},
+		{
+			name:            "RecreatedInstanceDestroyed",
+			followRecreated: true,
+			recreated:       &destroyed,
+			wantProviderID:  deleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8sClient := fake.NewSimpleClientset(newNode())
+			recorder := record.NewFakeRecorder(10)
+			i := &InstancesV2{
+				client: &recreatedInstanceClient{
+					mockOxideClient: &mockOxideClient{},
+					recreated:       tt.recreated,
+				},
+				project:         "test",
+				k8sClient:       k8sClient,
+				followRecreated: tt.followRecreated,
+				recorder:        recorder,
+			}
+
+			exists, err := i.InstanceExists(t.Context(), newNode())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if exists != tt.wantExists {
+				t.Fatalf("exists = %v, want %v", exists, tt.wantExists)
+			}
+
+			got, err := k8sClient.CoreV1().Nodes().Get(t.Context(), "node-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Spec.ProviderID != tt.wantProviderID {
+				t.Fatalf("provider id = %q, want %q", got.Spec.ProviderID, tt.wantProviderID)
+			}
+			if got.Labels["role"] != "worker" || len(got.Spec.Taints) != 1 {
+				t.Fatalf("node lost its labels or taints: %+v", got)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if !tt.wantExists || !strings.Contains(event, EventReasonReplacedProviderID) {
+					t.Fatalf("unexpected event: %s", event)
+				}
+			default:
+				if tt.wantExists {
+					t.Fatal("expected a replaced provider id event")
+				}
+			}
+		})
+	}
+}
+
+func TestFollowRecreatedInstanceNodeChanged(t *testing.T) {
+	recreated := instanceRunning
+	recreated.Id = instIDNew
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       v1.NodeSpec{ProviderID: ProviderID{InstanceID: instIDOld}.String()},
+	}
+	// The node was registered again with its new instance in the meantime.
+	registered := node.DeepCopy()
+	registered.Spec.ProviderID = ProviderID{InstanceID: instIDNew}.String()
+
+	i := &InstancesV2{
+		client: &recreatedInstanceClient{
+			mockOxideClient: &mockOxideClient{},
+			recreated:       &recreated,
+		},
+		project:         "test",
+		k8sClient:       fake.NewSimpleClientset(registered),
+		followRecreated: true,
+	}
+
+	if _, err := i.InstanceExists(t.Context(), node); err == nil ||
+		!strings.Contains(err.Error(), "changed provider id") {
+		t.Fatalf("err = %v, want changed provider id", err)
+	}
+}
@@ -0,0 +1,321 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// serviceReconcilerWorkers is the number of services reconciled concurrently
+// by the service reconciler. Operations on the same floating IP are still
+// serialized by the load balancer's locks.
+const serviceReconcilerWorkers = 2
+
+// loadBalancerUpdater updates the load balancer of a service. It is
+// implemented by [LoadBalancer] and exists so it can be mocked in tests.
+type loadBalancerUpdater interface {
+	UpdateLoadBalancer(context.Context, string, *v1.Service, []*v1.Node) error
+}
+
+// serviceReconciler watches services of type LoadBalancer and nodes, and
+// updates the load balancers of the affected services shortly after they
+// change, rather than waiting for the service controller to resync. Events
+// for a service within the debounce period are coalesced into a single
+// update, so a burst of spec changes or node churn costs one reconcile per
+// service.
+//
+// The reconciler runs alongside the service controller without managing the
+// same things twice: the service controller still creates and deletes load
+// balancers and owns the service finalizer, while the reconciler only calls
+// [LoadBalancer.UpdateLoadBalancer] for services whose load balancer the
+// service controller already reports in the service status. Both go through
+// the same locks, so their operations on a floating IP never interleave.
+type serviceReconciler struct {
+	updater     loadBalancerUpdater
+	clusterName string
+
+	services corelisters.ServiceLister
+	nodes    corelisters.NodeLister
+
+	// debounce is how long the first event for a service is held before the
+	// service is reconciled, coalescing any further events in the meantime.
+	debounce time.Duration
+
+	queue workqueue.TypedRateLimitingInterface[string]
+}
+
+// newServiceReconciler returns a service reconciler reading services and
+// nodes from the listers. Its event handlers must be registered with
+// [serviceReconciler.addEventHandlers] for it to see any changes.
+func newServiceReconciler(
+	updater loadBalancerUpdater,
+	clusterName string,
+	services corelisters.ServiceLister,
+	nodes corelisters.NodeLister,
+	clock clock.WithTicker,
+	debounce time.Duration,
+) *serviceReconciler {
+	return &serviceReconciler{
+		updater:     updater,
+		clusterName: clusterName,
+		services:    services,
+		nodes:       nodes,
+		debounce:    debounce,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{
+				Name:  "oxide-service-reconciler",
+				Clock: clock,
+			},
+		),
+	}
+}
+
+// startServiceReconciler starts a service reconciler backed by informers
+// created from factory, and runs it until ctx is done. Services are only
+// reconciled once the informers have synced, since a partial list of nodes
+// could move a floating IP off a node that simply wasn't listed yet.
+func startServiceReconciler(
+	ctx context.Context,
+	factory informers.SharedInformerFactory,
+	updater loadBalancerUpdater,
+	clusterName string,
+	clock clock.WithTicker,
+	debounce time.Duration,
+) error {
+	serviceInformer := factory.Core().V1().Services()
+	nodeInformer := factory.Core().V1().Nodes()
+
+	r := newServiceReconciler(
+		updater, clusterName, serviceInformer.Lister(), nodeInformer.Lister(), clock, debounce,
+	)
+	if err := r.addEventHandlers(serviceInformer.Informer(), nodeInformer.Informer()); err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	go func() {
+		if !cache.WaitForCacheSync(
+			ctx.Done(), serviceInformer.Informer().HasSynced, nodeInformer.Informer().HasSynced,
+		) {
+			r.queue.ShutDown()
+			return
+		}
+		r.run(ctx, serviceReconcilerWorkers)
+	}()
+
+	return nil
+}
+
+// addEventHandlers enqueues services on changes to services and nodes.
+func (r *serviceReconciler) addEventHandlers(services, nodes cache.SharedInformer) error {
+	_, err := services.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if service, ok := obj.(*v1.Service); ok {
+				r.enqueueService(service)
+			}
+		},
+		UpdateFunc: r.onServiceUpdate,
+	})
+	if err != nil {
+		return fmt.Errorf("failed adding service event handler: %w", err)
+	}
+
+	_, err = nodes.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { r.enqueueAllServices() },
+		UpdateFunc: r.onNodeUpdate,
+		DeleteFunc: func(any) { r.enqueueAllServices() },
+	})
+	if err != nil {
+		return fmt.Errorf("failed adding node event handler: %w", err)
+	}
+
+	return nil
+}
+
+// onServiceUpdate enqueues the service unless only its status or the
+// annotations set by the cloud controller manager changed, which updating
+// the load balancer does itself.
+func (r *serviceReconciler) onServiceUpdate(oldObj, newObj any) {
+	oldService, ok := oldObj.(*v1.Service)
+	if !ok {
+		return
+	}
+	newService, ok := newObj.(*v1.Service)
+	if !ok {
+		return
+	}
+
+	if equality.Semantic.DeepEqual(oldService.Spec, newService.Spec) &&
+		maps.Equal(userAnnotations(oldService), userAnnotations(newService)) {
+		return
+	}
+
+	r.enqueueService(newService)
+}
+
+// userAnnotations returns the annotations of the service that are not set by
+// the cloud controller manager.
+func userAnnotations(service *v1.Service) map[string]string {
+	annotations := maps.Clone(service.Annotations)
+	for _, key := range []string{
+		AnnotationBackingNode, AnnotationBackingInstance, AnnotationBackingIPPool,
+		AnnotationBackingEphemeralIP,
+	} {
+		delete(annotations, key)
+	}
+	return annotations
+}
+
+// onNodeUpdate enqueues all services when the node's eligibility to back a
+// floating IP or its labels, which ingress node selectors match, changed.
+func (r *serviceReconciler) onNodeUpdate(oldObj, newObj any) {
+	oldNode, ok := oldObj.(*v1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*v1.Node)
+	if !ok {
+		return
+	}
+
+	if isEligibleLBNode(oldNode) == isEligibleLBNode(newNode) &&
+		maps.Equal(oldNode.Labels, newNode.Labels) &&
+		oldNode.Spec.ProviderID == newNode.Spec.ProviderID {
+		return
+	}
+
+	r.enqueueAllServices()
+}
+
+// enqueueService schedules the service to be reconciled once the debounce
+// period has passed, unless it is already scheduled.
+func (r *serviceReconciler) enqueueService(service *v1.Service) {
+	if !isReconciledService(service) {
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(service)
+	if err != nil {
+		klog.ErrorS(err, "failed getting service key", "service", klog.KObj(service))
+		return
+	}
+	r.queue.AddAfter(key, r.debounce)
+}
+
+// enqueueAllServices schedules every service of type LoadBalancer to be
+// reconciled.
+func (r *serviceReconciler) enqueueAllServices() {
+	services, err := r.services.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "failed listing services")
+		return
+	}
+	for _, service := range services {
+		r.enqueueService(service)
+	}
+}
+
+// isReconciledService reports whether the service has a load balancer created
+// by the service controller that the reconciler keeps up to date. Services
+// being deleted or with a load balancer class are left to the service
+// controller, which skips the latter too.
+func isReconciledService(service *v1.Service) bool {
+	return service.Spec.Type == v1.ServiceTypeLoadBalancer &&
+		service.Spec.LoadBalancerClass == nil &&
+		service.DeletionTimestamp == nil &&
+		len(service.Status.LoadBalancer.Ingress) > 0
+}
+
+// run reconciles services with workers goroutines until ctx is done.
+func (r *serviceReconciler) run(ctx context.Context, workers int) {
+	defer r.queue.ShutDown()
+
+	for range workers {
+		go func() {
+			for r.processNextItem(ctx) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+}
+
+// processNextItem reconciles the next service in the queue, retrying it with
+// backoff on failure, or after the requested delay on a
+// [cloudproviderapi.RetryError]. It returns false once the queue is shut down.
+func (r *serviceReconciler) processNextItem(ctx context.Context) bool {
+	key, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	if err := r.reconcile(ctx, key); err != nil {
+		var retryErr *cloudproviderapi.RetryError
+		if errors.As(err, &retryErr) {
+			klog.V(2).InfoS("retrying service reconcile", "service", key,
+				"reason", retryErr.Error(), "retryAfter", retryErr.RetryAfter())
+			r.queue.Forget(key)
+			r.queue.AddAfter(key, retryErr.RetryAfter())
+			return true
+		}
+
+		klog.ErrorS(err, "failed reconciling service", "service", key)
+		r.queue.AddRateLimited(key)
+		return true
+	}
+
+	r.queue.Forget(key)
+	return true
+}
+
+// reconcile updates the load balancer of the service with the given key.
+func (r *serviceReconciler) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	service, err := r.services.Services(namespace).Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed getting service %s: %w", key, err)
+	}
+	if !isReconciledService(service) {
+		return nil
+	}
+
+	nodes, err := r.nodes.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed listing nodes: %w", err)
+	}
+	// The service controller passes only the nodes it does not exclude from
+	// load balancers, so the reconciler does the same.
+	nodes = slices.DeleteFunc(nodes, excludedFromLoadBalancers)
+
+	klog.V(2).InfoS("reconciling load balancer", "service", klog.KObj(service))
+
+	return r.updater.UpdateLoadBalancer(ctx, r.clusterName, service.DeepCopy(), nodes)
+}
@@ -0,0 +1,350 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// fakeLoadBalancerUpdater records the services whose load balancers are
+// updated.
+type fakeLoadBalancerUpdater struct {
+	mu      sync.Mutex
+	updates []string
+	err     error
+}
+
+func (f *fakeLoadBalancerUpdater) UpdateLoadBalancer(
+	_ context.Context, clusterName string, service *v1.Service, nodes []*v1.Node,
+) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, fmt.Sprintf(
+		"%s/%s/%s nodes=%d", clusterName, service.Namespace, service.Name, len(nodes),
+	))
+	return f.err
+}
+
+func (f *fakeLoadBalancerUpdater) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.updates...)
+}
+
+func TestServiceReconciler(t *testing.T) {
+	const debounce = time.Second
+
+	// reconciledService returns a service of type LoadBalancer whose load
+	// balancer the service controller already created.
+	reconciledService := func(name string) *v1.Service {
+		svc := newLBService(nil)
+		svc.Name = name
+		svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "203.0.113.1"}}
+		return svc
+	}
+
+	newReconciler := func(
+		t *testing.T, services []*v1.Service, nodes ...*v1.Node,
+	) (*serviceReconciler, *fakeLoadBalancerUpdater, *clocktesting.FakeClock) {
+		t.Helper()
+
+		serviceIndexer := cache.NewIndexer(
+			cache.MetaNamespaceKeyFunc, cache.Indexers{},
+		)
+		for _, svc := range services {
+			if err := serviceIndexer.Add(svc); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		nodes = append([]*v1.Node{newLBNode("node-a", instID1, "10.0.0.5")}, nodes...)
+		for _, node := range nodes {
+			if err := nodeIndexer.Add(node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		updater := &fakeLoadBalancerUpdater{}
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		r := newServiceReconciler(
+			updater,
+			"cluster",
+			corelisters.NewServiceLister(serviceIndexer),
+			corelisters.NewNodeLister(nodeIndexer),
+			fakeClock,
+			debounce,
+		)
+		t.Cleanup(r.queue.ShutDown)
+		return r, updater, fakeClock
+	}
+
+	// waitForQueue waits for the queue to hold n services that are ready to be
+	// reconciled.
+	waitForQueue := func(t *testing.T, r *serviceReconciler, n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for r.queue.Len() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("queue length = %d, want %d", r.queue.Len(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// drain reconciles the services that are ready to be reconciled.
+	drain := func(t *testing.T, r *serviceReconciler) {
+		t.Helper()
+		for r.queue.Len() > 0 {
+			r.processNextItem(t.Context())
+		}
+	}
+
+	t.Run("CoalescesRapidUpdates", func(t *testing.T) {
+		svc := reconciledService("svc")
+		r, updater, fakeClock := newReconciler(t, []*v1.Service{svc})
+
+		// The service's spec changes several times in quick succession.
+		old := svc
+		for i := range 5 {
+			changed := old.DeepCopy()
+			changed.Spec.Ports = []v1.ServicePort{{Port: int32(8000 + i)}}
+			r.onServiceUpdate(old, changed)
+			old = changed
+			fakeClock.Step(debounce / 10)
+		}
+
+		if n := r.queue.Len(); n != 0 {
+			t.Fatalf("queue length = %d before the debounce period, want 0", n)
+		}
+
+		fakeClock.Step(debounce)
+		waitForQueue(t, r, 1)
+		drain(t, r)
+
+		want := []string{"cluster/ns/svc nodes=1"}
+		if got := updater.calls(); !slices.Equal(got, want) {
+			t.Fatalf("updates = %v, want %v", got, want)
+		}
+
+		// Nothing else is scheduled.
+		fakeClock.Step(10 * debounce)
+		time.Sleep(10 * time.Millisecond)
+		if n := r.queue.Len(); n != 0 {
+			t.Fatalf("queue length = %d after reconciling, want 0", n)
+		}
+	})
+
+	t.Run("CoalescesNodeChurn", func(t *testing.T) {
+		r, updater, fakeClock := newReconciler(t, []*v1.Service{
+			reconciledService("svc-1"), reconciledService("svc-2"),
+		})
+
+		node := newLBNode("node-b", instIDNew, "10.0.0.6")
+		for range 3 {
+			cordoned := node.DeepCopy()
+			cordoned.Spec.Unschedulable = true
+			r.onNodeUpdate(node, cordoned)
+			r.onNodeUpdate(cordoned, node)
+		}
+
+		fakeClock.Step(debounce)
+		waitForQueue(t, r, 2)
+		drain(t, r)
+
+		if got := updater.calls(); len(got) != 2 {
+			t.Fatalf("updates = %v, want one per service", got)
+		}
+	})
+
+	t.Run("IgnoresIrrelevantChanges", func(t *testing.T) {
+		svc := reconciledService("svc")
+		r, _, fakeClock := newReconciler(t, []*v1.Service{svc})
+
+		// The load balancer status and backing annotations are written by
+		// updating the load balancer.
+		updated := svc.DeepCopy()
+		updated.Status.LoadBalancer.Ingress[0].IP = "203.0.113.2"
+		updated.Annotations = map[string]string{
+			AnnotationBackingNode:        "node-a",
+			AnnotationBackingEphemeralIP: "203.0.113.2",
+		}
+		r.onServiceUpdate(svc, updated)
+
+		// Node heartbeats don't change eligibility.
+		node := newLBNode("node-a", instID1, "10.0.0.5")
+		heartbeat := node.DeepCopy()
+		heartbeat.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+		r.onNodeUpdate(node, heartbeat)
+
+		fakeClock.Step(debounce)
+		time.Sleep(10 * time.Millisecond)
+		if n := r.queue.Len(); n != 0 {
+			t.Fatalf("queue length = %d, want 0", n)
+		}
+	})
+
+	t.Run("SkipsExcludedNodes", func(t *testing.T) {
+		svc := reconciledService("svc")
+
+		excluded := newLBNode("node-b", instIDOld, "10.0.0.6")
+		excluded.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "true"}
+		deleting := newLBNode("node-c", instIDNew, "10.0.0.7")
+		deleting.DeletionTimestamp = new(metav1.Now())
+		included := newLBNode("node-d", instIDNew, "10.0.0.8")
+		included.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "false"}
+
+		r, updater, _ := newReconciler(t, []*v1.Service{svc}, excluded, deleting, included)
+		if err := r.reconcile(t.Context(), "ns/svc"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []string{"cluster/ns/svc nodes=2"}
+		if got := updater.calls(); !slices.Equal(got, want) {
+			t.Fatalf("updates = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("SkipsServicesLeftToServiceController", func(t *testing.T) {
+		pending := newLBService(nil)
+		pending.Name = "pending"
+
+		deleting := reconciledService("deleting")
+		deleting.DeletionTimestamp = new(metav1.Now())
+
+		classed := reconciledService("classed")
+		classed.Spec.LoadBalancerClass = new("example.com/lb")
+
+		clusterIP := reconciledService("cluster-ip")
+		clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+
+		services := []*v1.Service{pending, deleting, classed, clusterIP}
+		r, updater, fakeClock := newReconciler(t, services)
+		for _, svc := range services {
+			r.enqueueService(svc)
+			if err := r.reconcile(t.Context(), svc.Namespace+"/"+svc.Name); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		fakeClock.Step(debounce)
+		time.Sleep(10 * time.Millisecond)
+		if n := r.queue.Len(); n != 0 {
+			t.Fatalf("queue length = %d, want 0", n)
+		}
+
+		if got := updater.calls(); len(got) != 0 {
+			t.Fatalf("updates = %v, want none", got)
+		}
+	})
+
+	t.Run("RetriesFailures", func(t *testing.T) {
+		svc := reconciledService("svc")
+		r, updater, fakeClock := newReconciler(t, []*v1.Service{svc})
+		updater.err = errBoom
+
+		r.enqueueService(svc)
+		fakeClock.Step(debounce)
+		waitForQueue(t, r, 1)
+		drain(t, r)
+
+		// The failed service is retried with backoff.
+		fakeClock.Step(time.Second)
+		waitForQueue(t, r, 1)
+		updater.err = nil
+		drain(t, r)
+
+		if got := updater.calls(); len(got) != 2 {
+			t.Fatalf("updates = %v, want the failed update retried once", got)
+		}
+	})
+
+	t.Run("RetriesAfterRequestedDelay", func(t *testing.T) {
+		svc := reconciledService("svc")
+		r, updater, fakeClock := newReconciler(t, []*v1.Service{svc})
+		updater.err = cloudproviderapi.NewRetryError("backing node is down", time.Minute)
+
+		r.enqueueService(svc)
+		fakeClock.Step(debounce)
+		waitForQueue(t, r, 1)
+		drain(t, r)
+		updater.err = nil
+
+		// The service is not retried with backoff, but once the requested
+		// delay has passed.
+		fakeClock.Step(30 * time.Second)
+		time.Sleep(10 * time.Millisecond)
+		if n := r.queue.Len(); n != 0 {
+			t.Fatalf("queue length = %d, want the retry to wait for the requested delay", n)
+		}
+		fakeClock.Step(30 * time.Second)
+		waitForQueue(t, r, 1)
+		drain(t, r)
+
+		if got := updater.calls(); len(got) != 2 {
+			t.Fatalf("updates = %v, want the update retried once", got)
+		}
+	})
+}
+
+func TestStartServiceReconcilerWaitsForCacheSync(t *testing.T) {
+	svc := newLBService(nil)
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "203.0.113.1"}}
+	k8sClient := fake.NewSimpleClientset(svc, newLBNode("node-a", instID1, "10.0.0.5"))
+
+	// Listing the nodes takes a while.
+	listNodes := make(chan struct{})
+	k8sClient.PrependReactor("list", "nodes", func(
+		k8stesting.Action,
+	) (bool, runtime.Object, error) {
+		<-listNodes
+		return false, nil, nil
+	})
+
+	updater := &fakeLoadBalancerUpdater{}
+	if err := startServiceReconciler(
+		t.Context(),
+		informers.NewSharedInformerFactory(k8sClient, 0),
+		updater,
+		"cluster",
+		clock.RealClock{},
+		time.Millisecond,
+	); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if calls := updater.calls(); len(calls) > 0 {
+		t.Fatalf("updates = %v before the nodes were listed, want none", calls)
+	}
+
+	close(listNodes)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(updater.calls()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("service was not reconciled after the informers synced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if calls := updater.calls(); calls[0] != "cluster/ns/svc nodes=1" {
+		t.Fatalf("updates = %v, want the service reconciled with every node", calls)
+	}
+}
@@ -0,0 +1,332 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oxidecomputer/oxide.go/oxide"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// ServiceWebhookPath is the path the service validating webhook is served on.
+const ServiceWebhookPath = "/validate-service"
+
+// serviceWebhookTimeout bounds validating a single service, including the
+// Oxide API calls checking its IP pools, within the 10 second default timeout
+// of validating webhooks.
+const serviceWebhookTimeout = 5 * time.Second
+
+// maxAdmissionReviewSize bounds the size of an admission review request body.
+const maxAdmissionReviewSize = 3 * 1024 * 1024
+
+// serviceWebhook is a validating admission webhook that rejects services of
+// type LoadBalancer with invalid Oxide annotations when they are applied,
+// rather than failing when the service controller reconciles them, where the
+// error only shows up in an event.
+type serviceWebhook struct {
+	lb          *LoadBalancer
+	clusterName string
+}
+
+// ServeHTTP handles an AdmissionReview request for a service.
+func (w *serviceWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdmissionReviewSize))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed reading request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(rw, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), serviceWebhookTimeout)
+	defer cancel()
+
+	response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	if err := w.review(ctx, review.Request); err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: err.Error(),
+		}
+	}
+
+	review.Request = nil
+	review.Response = response
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed encoding response: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if _, err := rw.Write(data); err != nil {
+		klog.ErrorS(err, "failed writing admission review response")
+	}
+}
+
+// review returns an error when the service in the admission request must be
+// rejected.
+func (w *serviceWebhook) review(
+	ctx context.Context,
+	request *admissionv1.AdmissionRequest,
+) error {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return nil
+	}
+
+	var service v1.Service
+	if err := json.Unmarshal(request.Object.Raw, &service); err != nil {
+		return fmt.Errorf("failed decoding service: %w", err)
+	}
+	// The namespace of a service being created may only be set on the request.
+	if service.Namespace == "" {
+		service.Namespace = request.Namespace
+	}
+
+	// A service being deleted only has its finalizers removed, which must not
+	// be blocked by annotations that have become invalid.
+	if service.DeletionTimestamp != nil {
+		return nil
+	}
+
+	var old *v1.Service
+	if request.Operation == admissionv1.Update && len(request.OldObject.Raw) > 0 {
+		old = &v1.Service{}
+		if err := json.Unmarshal(request.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("failed decoding old service: %w", err)
+		}
+	}
+
+	return w.validateService(ctx, &service, old)
+}
+
+// validateService checks the Oxide annotations of a service of type
+// LoadBalancer the way reconciling it would, returning every problem found.
+// Services that are not managed by the cloud controller manager are valid.
+// When the service is updated, only the annotations and ports that changed
+// from old are checked, so that a service that was applied before a check
+// existed can still be updated. IP pools that cannot be checked because of an
+// Oxide API error are assumed to exist, and services sharing a floating IP
+// that cannot be listed are assumed not to conflict, so an outage does not
+// block applying services.
+func (w *serviceWebhook) validateService(
+	ctx context.Context,
+	service *v1.Service,
+	old *v1.Service,
+) error {
+	if !isValidatedService(service) {
+		return nil
+	}
+	// A service that was not managed before is checked in full.
+	if old != nil && !isValidatedService(old) {
+		old = nil
+	}
+
+	// changed reports whether any of the annotations changed from old.
+	changed := func(keys ...string) bool {
+		if old == nil {
+			return true
+		}
+		return slices.ContainsFunc(keys, func(key string) bool {
+			value, ok := service.Annotations[key]
+			oldValue, oldOk := old.Annotations[key]
+			return value != oldValue || ok != oldOk
+		})
+	}
+	portsChanged := old == nil ||
+		!equality.Semantic.DeepEqual(service.Spec.Ports, old.Spec.Ports)
+
+	errs := make([]error, 0)
+
+	if changed(AnnotationFloatingIP, AnnotationFloatingIPPool, AnnotationFloatingIPVersion) {
+		if _, err := addressAllocatorFromAnnotations(service.Annotations); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if ip, ok := service.Annotations[AnnotationFloatingIP]; ok && changed(AnnotationFloatingIP) {
+		if _, err := netip.ParseAddr(ip); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"invalid %s value %q: %w", AnnotationFloatingIP, ip, err,
+			))
+		}
+	}
+
+	if changed(AnnotationFloatingIPPool) {
+		for _, pool := range splitIPPools(service.Annotations[AnnotationFloatingIPPool]) {
+			if err := w.checkIPPool(ctx, pool); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if changed(AnnotationHostname, AnnotationHostnameMode) {
+		if _, _, err := serviceHostname(service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if changed(AnnotationOperationTimeout) {
+		if _, err := serviceOperationTimeout(service, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if mode, ok := service.Annotations[AnnotationAttachMode]; ok && changed(AnnotationAttachMode) {
+		if err := checkAttachMode(mode); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %w", AnnotationAttachMode, err))
+		}
+	}
+
+	if changed(
+		AnnotationAddressType, AnnotationFloatingIP,
+		AnnotationSharedIPKey, AnnotationFloatingIPProject,
+	) {
+		if err := checkAddressType(service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	selector, ok := service.Annotations[AnnotationIngressNodeSelector]
+	if ok && changed(AnnotationIngressNodeSelector) {
+		if _, err := labels.Parse(selector); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"invalid %s value %q: %w", AnnotationIngressNodeSelector, selector, err,
+			))
+		}
+	}
+
+	if changed(AnnotationFloatingIPDescription) {
+		// A service being created has no UID yet, so the ownership record is
+		// measured with a placeholder of the same length.
+		uid := service.UID
+		if uid == "" {
+			uid = types.UID(uuid.Nil.String())
+		}
+		_, err := floatingIPDescription(floatingIPOwner{
+			Cluster:   w.clusterName,
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			UID:       uid,
+		}, service.Annotations[AnnotationFloatingIPDescription])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if portsChanged {
+		if _, err := unsupportedPorts(service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if portsChanged || changed(AnnotationSharedIPKey) {
+		if err := w.checkSharedIP(ctx, service); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isValidatedService reports whether the webhook validates the service: it is
+// of type LoadBalancer and managed by the cloud controller manager.
+func isValidatedService(service *v1.Service) bool {
+	return service.Spec.Type == v1.ServiceTypeLoadBalancer &&
+		service.Spec.LoadBalancerClass == nil &&
+		!isIgnored(service)
+}
+
+// checkSharedIP returns an error when the service's [AnnotationSharedIPKey]
+// is invalid or its ports conflict with those of the services sharing its
+// floating IP. The services are assumed not to conflict when they cannot be
+// listed.
+func (w *serviceWebhook) checkSharedIP(ctx context.Context, service *v1.Service) error {
+	if err := checkSharedIPKey(service.Annotations[AnnotationSharedIPKey]); err != nil {
+		return err
+	}
+
+	sharing, err := w.lb.servicesSharingIP(ctx, service)
+	if err != nil {
+		klog.V(2).InfoS("failed listing services sharing the floating ip, assuming no conflict",
+			"service", klog.KObj(service), "err", err)
+		return nil
+	}
+
+	return checkSharedPorts(service, sharing)
+}
+
+// checkIPPool returns an error when the IP pool does not exist or is not
+// linked to the silo.
+func (w *serviceWebhook) checkIPPool(ctx context.Context, pool string) error {
+	_, err := w.lb.client.IpPoolView(ctx, oxide.IpPoolViewParams{Pool: oxide.NameOrId(pool)})
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, oxide.ErrObjectNotFound) {
+		return fmt.Errorf("ip pool %q in %s does not exist", pool, AnnotationFloatingIPPool)
+	}
+
+	klog.V(2).InfoS("failed checking ip pool, assuming it exists", "pool", pool, "err", err)
+	return nil
+}
+
+// serveServiceWebhook serves the service validating webhook over TLS on the
+// configured address until ctx is done.
+func serveServiceWebhook(ctx context.Context, config *WebhookConfig, webhook http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle(ServiceWebhookPath, webhook)
+
+	server := &http.Server{
+		Addr:              config.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.ErrorS(err, "failed shutting down service webhook")
+		}
+	}()
+
+	klog.InfoS("serving service validating webhook",
+		"address", config.Address, "path", ServiceWebhookPath)
+
+	err := server.ListenAndServeTLS(config.CertFile, config.KeyFile)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Fatalf("failed serving service webhook: %v", err)
+	}
+}
@@ -0,0 +1,379 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServiceWebhook(t *testing.T) {
+	newWebhook := func(objects ...runtime.Object) *serviceWebhook {
+		return &serviceWebhook{
+			clusterName: "cluster",
+			lb: &LoadBalancer{
+				project:   "test",
+				k8sClient: fake.NewSimpleClientset(objects...),
+				client: &fakeOxideLBClient{
+					IpPoolViewFn: func(
+						_ context.Context, p oxide.IpPoolViewParams,
+					) (*oxide.SiloIpPool, error) {
+						switch p.Pool {
+						case "public", "backup":
+							return &oxide.SiloIpPool{Name: oxide.Name(p.Pool)}, nil
+						case "flaky":
+							return nil, errBoom
+						}
+						return nil, oxide.ErrObjectNotFound
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		sharing := newLBService(map[string]string{AnnotationSharedIPKey: "web"})
+		sharing.Name = "other"
+		sharing.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP}}
+
+		tt := []struct {
+			name        string
+			annotations map[string]string
+			modify      func(*v1.Service)
+			// old returns the service before an update, built from the
+			// updated service.
+			old       func(*v1.Service) *v1.Service
+			errorMsgs []string
+		}{
+			{
+				name: "Valid",
+				annotations: map[string]string{
+					AnnotationFloatingIPPool:      "public, backup",
+					AnnotationHostname:            "web.example.com",
+					AnnotationIngressNodeSelector: "role=ingress",
+					AnnotationSharedIPKey:         "web",
+				},
+			},
+			{
+				name:        "PoolCheckFailsOpen",
+				annotations: map[string]string{AnnotationFloatingIPPool: "flaky"},
+			},
+			{
+				name:        "ClusterIPIgnored",
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				modify: func(s *v1.Service) {
+					s.Spec.Type = v1.ServiceTypeClusterIP
+				},
+			},
+			{
+				name:        "LoadBalancerClassIgnored",
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				modify: func(s *v1.Service) {
+					s.Spec.LoadBalancerClass = new("example.com/lb")
+				},
+			},
+			{
+				name:        "InvalidFloatingIP",
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				errorMsgs:   []string{`invalid oxide.computer/floating-ip value "bogus"`},
+			},
+			{
+				name: "MutuallyExclusive",
+				annotations: map[string]string{
+					AnnotationFloatingIP:     "203.0.113.1",
+					AnnotationFloatingIPPool: "public",
+				},
+				errorMsgs: []string{"is mutually exclusive with"},
+			},
+			{
+				name:        "MissingPool",
+				annotations: map[string]string{AnnotationFloatingIPPool: "public,missing"},
+				errorMsgs:   []string{`ip pool "missing" in oxide.computer/floating-ip-pool`},
+			},
+			{
+				name:        "InvalidHostname",
+				annotations: map[string]string{AnnotationHostname: "Not A Hostname"},
+				errorMsgs:   []string{"invalid oxide.computer/hostname value"},
+			},
+			{
+				name:        "InvalidIngressNodeSelector",
+				annotations: map[string]string{AnnotationIngressNodeSelector: "in valid"},
+				errorMsgs:   []string{"invalid oxide.computer/ingress-node-selector value"},
+			},
+			{
+				name:        "OperationTimeoutOutOfBounds",
+				annotations: map[string]string{AnnotationOperationTimeout: "2h"},
+				errorMsgs:   []string{"invalid oxide.computer/operation-timeout value"},
+			},
+			{
+				name:        "UnknownAttachMode",
+				annotations: map[string]string{AnnotationAttachMode: "anycast"},
+				errorMsgs:   []string{`unknown attach mode "anycast"`},
+			},
+			{
+				name: "EphemeralSharedIP",
+				annotations: map[string]string{
+					AnnotationAddressType: AddressTypeEphemeral,
+					AnnotationSharedIPKey: "web",
+				},
+				errorMsgs: []string{"cannot be used with oxide.computer/address-type"},
+			},
+			{
+				name: "DescriptionTooLong",
+				annotations: map[string]string{
+					AnnotationFloatingIPDescription: strings.Repeat("x", maxDescriptionLength),
+				},
+				errorMsgs: []string{"description"},
+			},
+			{
+				name: "UnsupportedPorts",
+				modify: func(s *v1.Service) {
+					s.Spec.Ports = []v1.ServicePort{{Port: 9000, Protocol: v1.ProtocolSCTP}}
+				},
+				errorMsgs: []string{"unsupported ports 9000/SCTP"},
+			},
+			{
+				name:        "SharedPortConflict",
+				annotations: map[string]string{AnnotationSharedIPKey: "web"},
+				modify: func(s *v1.Service) {
+					s.Spec.Ports = []v1.ServicePort{{Port: 443, Protocol: v1.ProtocolTCP}}
+				},
+				errorMsgs: []string{`port 443/TCP is already exposed on shared ip "web"`},
+			},
+			{
+				name:        "UpdateIgnoresUnchangedAnnotations",
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				modify: func(s *v1.Service) {
+					s.Spec.Ports = []v1.ServicePort{{Port: 9000, Protocol: v1.ProtocolSCTP}}
+				},
+				old: (*v1.Service).DeepCopy,
+			},
+			{
+				name: "UpdateChecksChangedAnnotations",
+				annotations: map[string]string{
+					AnnotationFloatingIP: "bogus",
+					AnnotationHostname:   "Not A Hostname",
+				},
+				old: func(s *v1.Service) *v1.Service {
+					old := s.DeepCopy()
+					delete(old.Annotations, AnnotationHostname)
+					return old
+				},
+				errorMsgs: []string{"invalid oxide.computer/hostname value"},
+			},
+			{
+				name: "UpdateChecksChangedPorts",
+				modify: func(s *v1.Service) {
+					s.Spec.Ports = []v1.ServicePort{{Port: 9000, Protocol: v1.ProtocolSCTP}}
+				},
+				old: func(s *v1.Service) *v1.Service {
+					old := s.DeepCopy()
+					old.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}}
+					return old
+				},
+				errorMsgs: []string{"unsupported ports 9000/SCTP"},
+			},
+			{
+				name:        "UpdateToLoadBalancerChecksEverything",
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				old: func(s *v1.Service) *v1.Service {
+					old := s.DeepCopy()
+					old.Spec.Type = v1.ServiceTypeClusterIP
+					return old
+				},
+				errorMsgs: []string{`invalid oxide.computer/floating-ip value "bogus"`},
+			},
+			{
+				name: "ReportsEveryProblem",
+				annotations: map[string]string{
+					AnnotationFloatingIPPool: "missing",
+					AnnotationHostname:       "Not A Hostname",
+				},
+				errorMsgs: []string{
+					`ip pool "missing"`,
+					"invalid oxide.computer/hostname value",
+				},
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				service := newLBService(tc.annotations)
+				service.Spec.Ports = []v1.ServicePort{{Port: 80, Protocol: v1.ProtocolTCP}}
+				if tc.modify != nil {
+					tc.modify(service)
+				}
+
+				var old *v1.Service
+				if tc.old != nil {
+					old = tc.old(service)
+				}
+
+				err := newWebhook(sharing).validateService(t.Context(), service, old)
+				if len(tc.errorMsgs) == 0 {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					return
+				}
+
+				if err == nil {
+					t.Fatalf("expected error containing %q, got nil", tc.errorMsgs)
+				}
+				for _, msg := range tc.errorMsgs {
+					if !strings.Contains(err.Error(), msg) {
+						t.Errorf("error = %v, want it to contain %q", err, msg)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("SharingListFailsOpen", func(t *testing.T) {
+		webhook := newWebhook()
+		k8sClient := webhook.lb.k8sClient.(*fake.Clientset)
+		k8sClient.PrependReactor("list", "services", func(
+			k8stesting.Action,
+		) (bool, runtime.Object, error) {
+			return true, nil, errBoom
+		})
+
+		service := newLBService(map[string]string{AnnotationSharedIPKey: "web"})
+		if err := webhook.validateService(t.Context(), service, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// An invalid key is still rejected.
+		service.Annotations[AnnotationSharedIPKey] = "Not A Key"
+		err := webhook.validateService(t.Context(), service, nil)
+		if err == nil || !strings.Contains(err.Error(), AnnotationSharedIPKey) {
+			t.Fatalf("error = %v, want an invalid %s error", err, AnnotationSharedIPKey)
+		}
+	})
+
+	t.Run("ServeHTTP", func(t *testing.T) {
+		tt := []struct {
+			name        string
+			operation   admissionv1.Operation
+			annotations map[string]string
+			// unchanged sends the service as the old object of the update.
+			unchanged bool
+			deleting  bool
+			allowed   bool
+		}{
+			{
+				name:      "AllowsValidService",
+				operation: admissionv1.Create,
+				allowed:   true,
+			},
+			{
+				name:        "RejectsInvalidService",
+				operation:   admissionv1.Update,
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				allowed:     false,
+			},
+			{
+				name:        "AllowsUnchangedInvalidService",
+				operation:   admissionv1.Update,
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				unchanged:   true,
+				allowed:     true,
+			},
+			{
+				name:        "AllowsDeletingService",
+				operation:   admissionv1.Update,
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				deleting:    true,
+				allowed:     true,
+			},
+			{
+				name:        "AllowsDelete",
+				operation:   admissionv1.Delete,
+				annotations: map[string]string{AnnotationFloatingIP: "bogus"},
+				allowed:     true,
+			},
+		}
+
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				service := newLBService(tc.annotations)
+				service.Namespace = ""
+				if tc.deleting {
+					service.DeletionTimestamp = new(metav1.Now())
+				}
+				raw, err := json.Marshal(service)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				request := &admissionv1.AdmissionRequest{
+					UID:       types.UID("review-uid"),
+					Namespace: "ns",
+					Operation: tc.operation,
+					Object:    runtime.RawExtension{Raw: raw},
+				}
+				if tc.unchanged {
+					request.OldObject = runtime.RawExtension{Raw: raw}
+				}
+
+				body, err := json.Marshal(admissionv1.AdmissionReview{Request: request})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				rec := httptest.NewRecorder()
+				newWebhook().ServeHTTP(rec, httptest.NewRequestWithContext(
+					t.Context(), http.MethodPost, ServiceWebhookPath, bytes.NewReader(body),
+				))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+				}
+
+				var review admissionv1.AdmissionReview
+				if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if review.Response == nil {
+					t.Fatal("expected a response")
+				}
+				if review.Response.UID != "review-uid" {
+					t.Errorf("response uid = %q, want %q", review.Response.UID, "review-uid")
+				}
+				if review.Response.Allowed != tc.allowed {
+					t.Errorf("allowed = %t, want %t", review.Response.Allowed, tc.allowed)
+				}
+				if !tc.allowed && (review.Response.Result == nil ||
+					!strings.Contains(review.Response.Result.Message, AnnotationFloatingIP)) {
+					t.Errorf("result = %+v, want a message naming the annotation",
+						review.Response.Result)
+				}
+			})
+		}
+	})
+
+	t.Run("ServeHTTPInvalidReview", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newWebhook().ServeHTTP(rec, httptest.NewRequestWithContext(
+			t.Context(), http.MethodPost, ServiceWebhookPath, strings.NewReader("{}"),
+		))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// startupValidationBackoff is the backoff between attempts of the startup
+// validation while the Oxide API is unreachable. It is only bounded by
+// [Config.StartupValidationTimeout].
+var startupValidationBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      30 * time.Second,
+}
+
+// oxideStartupClient is the subset of the Oxide API used to validate the token
+// and project at startup. It exists so the Oxide client can be mocked in
+// tests.
+type oxideStartupClient interface {
+	ProjectView(context.Context, oxide.ProjectViewParams) (*oxide.Project, error)
+}
+
+// validateProject checks that the Oxide API accepts the token and that the
+// project exists, so that a misconfiguration fails at startup rather than on
+// the first node or load balancer sync.
+func validateProject(ctx context.Context, client oxideStartupClient, project string) error {
+	_, err := client.ProjectView(ctx, oxide.ProjectViewParams{
+		Project: oxide.NameOrId(project),
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, oxide.ErrHTTP401):
+		return fmt.Errorf("oxide api rejected the token: %w", err)
+	case errors.Is(err, oxide.ErrObjectNotFound):
+		return fmt.Errorf("oxide project %s not found: %w", project, err)
+	default:
+		return fmt.Errorf("failed viewing oxide project %s: %w", project, err)
+	}
+}
+
+// retryStartupValidation calls validate until it succeeds, fails with an
+// error that retrying cannot fix, or maxElapsed passes, backing off between
+// attempts, so that the cloud controller manager tolerates an Oxide API that
+// is not reachable yet at boot, such as during a coordinated rack restart. It
+// returns the last error.
+func retryStartupValidation(
+	ctx context.Context,
+	clock clock.Clock,
+	backoff wait.Backoff,
+	maxElapsed time.Duration,
+	validate func(context.Context) error,
+) error {
+	deadline := clock.Now().Add(maxElapsed)
+	for {
+		err := validate(ctx)
+		if err == nil || !isRetriableStartupError(err) {
+			return err
+		}
+
+		delay := backoff.Step()
+		if clock.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("oxide api still unreachable after %s: %w", maxElapsed, err)
+		}
+		klog.InfoS("oxide api unreachable, retrying startup validation",
+			"retryAfter", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-clock.After(delay):
+		}
+	}
+}
+
+// isRetriableStartupError reports whether a failed startup validation may
+// succeed when retried, because the Oxide API could not be reached, its
+// circuit breaker is open, or it failed with a server error. Errors the Oxide
+// API returned for the request itself, such as a rejected token or a missing
+// project, are not retried.
+func isRetriableStartupError(err error) bool {
+	var httpErr *oxide.HTTPError
+	if errors.As(err, &httpErr) {
+		status := httpErr.HTTPResponse.StatusCode
+		return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) || errors.Is(err, ErrCircuitOpen)
+}
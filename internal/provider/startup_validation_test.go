@@ -0,0 +1,162 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// startupProjectClient answers project views with each of its errors in turn,
+// and with the project once they run out.
+type startupProjectClient struct {
+	errs  []error
+	calls int
+}
+
+func (c *startupProjectClient) ProjectView(
+	context.Context,
+	oxide.ProjectViewParams,
+) (*oxide.Project, error) {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	return &oxide.Project{Id: "project-id", Name: "test"}, nil
+}
+
+// oxideStatusError returns the error the Oxide client returns for a response
+// with the status and error code.
+func oxideStatusError(status int, code string) error {
+	err := &oxide.HTTPError{
+		HTTPResponse: &http.Response{
+			StatusCode: status,
+			Request:    &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/v1/projects"}},
+		},
+	}
+	if code != "" {
+		err.ErrorResponse = &oxide.ErrorResponse{ErrorCode: code}
+	}
+	return fmt.Errorf("error sending request: %w", err)
+}
+
+func TestRetryStartupValidation(t *testing.T) {
+	connectionRefused := fmt.Errorf("error sending request: %w", &url.Error{
+		Op:  http.MethodGet,
+		URL: "https://oxide.example/v1/projects/test",
+		Err: syscall.ECONNREFUSED,
+	})
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 100}
+
+	tests := []struct {
+		name       string
+		errs       []error
+		maxElapsed time.Duration
+		wantCalls  int
+		errorMsg   string
+	}{
+		{
+			name:       "ConnectivityThenSuccess",
+			errs:       []error{connectionRefused, oxideStatusError(503, ""), connectionRefused},
+			maxElapsed: time.Minute,
+			wantCalls:  4,
+		},
+		{
+			name:       "CircuitOpenThenSuccess",
+			errs:       []error{fmt.Errorf("request failed: %w", ErrCircuitOpen)},
+			maxElapsed: time.Minute,
+			wantCalls:  2,
+		},
+		{
+			name:       "Unauthenticated",
+			errs:       []error{oxideStatusError(401, "Unauthorized"), connectionRefused},
+			maxElapsed: time.Minute,
+			wantCalls:  1,
+			errorMsg:   "oxide api rejected the token",
+		},
+		{
+			name:       "ProjectNotFound",
+			errs:       []error{connectionRefused, oxideStatusError(404, "ObjectNotFound")},
+			maxElapsed: time.Minute,
+			wantCalls:  2,
+			errorMsg:   "oxide project test not found",
+		},
+		{
+			name: "MaxElapsed",
+			errs: []error{
+				connectionRefused, connectionRefused, connectionRefused, connectionRefused,
+			},
+			maxElapsed: 0,
+			wantCalls:  1,
+			errorMsg:   "oxide api still unreachable after 0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &startupProjectClient{errs: tt.errs}
+
+			err := retryStartupValidation(
+				t.Context(), clock.RealClock{}, backoff, tt.maxElapsed,
+				func(ctx context.Context) error {
+					return validateProject(ctx, client, "test")
+				},
+			)
+			if client.calls != tt.wantCalls {
+				t.Fatalf("project views = %d, want %d", client.calls, tt.wantCalls)
+			}
+			if tt.errorMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Fatalf("err = %v, want %q", err, tt.errorMsg)
+			}
+		})
+	}
+}
+
+func TestIsRetriableStartupError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "ConnectionRefused",
+			err:  &url.Error{Op: http.MethodGet, URL: "/", Err: syscall.ECONNREFUSED},
+			want: true,
+		},
+		{name: "CircuitOpen", err: ErrCircuitOpen, want: true},
+		{name: "InternalServerError", err: oxideStatusError(500, "Internal"), want: true},
+		{name: "TooManyRequests", err: oxideStatusError(429, ""), want: true},
+		{name: "Forbidden", err: oxideStatusError(403, "Forbidden")},
+		{name: "NotFound", err: oxideStatusError(404, "ObjectNotFound")},
+		{name: "Other", err: errors.New("hostname is empty")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetriableStartupError(tt.err); got != tt.want {
+				t.Fatalf("retriable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
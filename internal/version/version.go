@@ -0,0 +1,73 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package version reports the build information of the cloud controller
+// manager.
+package version
+
+import (
+	"cmp"
+	"runtime"
+	"runtime/debug"
+)
+
+// unknown is reported for build information that was not set at build time.
+const unknown = "unknown"
+
+// oxideModulePath is the module path of the Oxide Go SDK.
+const oxideModulePath = "github.com/oxidecomputer/oxide.go"
+
+// The version and git commit are set at build time with -ldflags, such as
+// "-X <module>/internal/version.version=v0.7.0", and are reported as "unknown"
+// when unset or empty.
+var (
+	version   = unknown
+	gitCommit = unknown
+)
+
+// Info is the build information of the cloud controller manager.
+type Info struct {
+	// Version is the release version, such as v0.7.0.
+	Version string
+
+	// GitCommit is the git commit the binary was built from.
+	GitCommit string
+
+	// OxideSDKVersion is the version of the Oxide Go SDK the binary was built
+	// with.
+	OxideSDKVersion string
+
+	// GoVersion is the version of Go the binary was built with.
+	GoVersion string
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:         cmp.Or(version, unknown),
+		GitCommit:       cmp.Or(gitCommit, unknown),
+		OxideSDKVersion: oxideSDKVersion(),
+		GoVersion:       runtime.Version(),
+	}
+}
+
+// oxideSDKVersion returns the version of the Oxide Go SDK the binary was built
+// with, or "unknown" when it cannot be determined.
+func oxideSDKVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return unknown
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == oxideModulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+
+	return unknown
+}
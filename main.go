@@ -4,8 +4,11 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
 
+	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/util/wait"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/cloud-provider/app"
@@ -19,24 +22,50 @@ import (
 	_ "k8s.io/component-base/metrics/prometheus/version"
 	"k8s.io/klog/v2"
 
-	_ "github.com/oxidecomputer/oxide-cloud-controller-manager/internal/provider"
+	"github.com/oxidecomputer/oxide-cloud-controller-manager/internal/provider"
+	"github.com/oxidecomputer/oxide-cloud-controller-manager/internal/version"
 )
 
 func main() {
+	provider.Register()
+
 	options, err := options.NewCloudControllerManagerOptions()
 	if err != nil {
 		klog.Fatalf("unable to initialize command options: %v", err)
 	}
 
+	additionalFlags := flag.NamedFlagSets{}
+	oxideFlags := additionalFlags.FlagSet("oxide")
+
+	var shouldPrintConfig bool
+	oxideFlags.BoolVar(
+		&shouldPrintConfig, "print-config", false,
+		"Print the resolved Oxide configuration, with secrets redacted, and exit.",
+	)
+	oxideFlags.MarkHidden("print-config")
+
 	command := app.NewCloudControllerManagerCommand(
 		options,
 		cloudInitializer,
 		app.DefaultInitFuncConstructors,
 		names.CCMControllerAliases(),
-		flag.NamedFlagSets{},
+		additionalFlags,
 		wait.NeverStop,
 	)
 
+	// Printing the configuration runs before the command builds its Kubernetes
+	// clients so it works without access to a cluster.
+	command.PreRunE = func(*cobra.Command, []string) error {
+		if shouldPrintConfig {
+			path := options.KubeCloudShared.CloudProvider.CloudConfigFile
+			if err := printConfig(os.Stdout, path); err != nil {
+				return err
+			}
+			os.Exit(0)
+		}
+		return nil
+	}
+
 	code := cli.Run(command)
 	os.Exit(code)
 }
@@ -44,6 +73,14 @@ func main() {
 func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
 	cloudConfig := config.ComponentConfig.KubeCloudShared.CloudProvider
 
+	info := version.Get()
+	klog.InfoS("oxide cloud controller manager",
+		"version", info.Version,
+		"gitCommit", info.GitCommit,
+		"oxideSDKVersion", info.OxideSDKVersion,
+		"goVersion", info.GoVersion,
+	)
+
 	cloud, err := cloudprovider.InitCloudProvider(cloudConfig.Name, cloudConfig.CloudConfigFile)
 	if err != nil {
 		klog.Fatalf("Cloud provider could not be initialized: %v", err)
@@ -52,5 +89,31 @@ func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
 		klog.Fatalf("Cloud provider is nil")
 	}
 
+	if oxide, ok := cloud.(*provider.Oxide); ok {
+		oxide.SetClusterName(config.ComponentConfig.KubeCloudShared.ClusterName)
+	}
+
 	return cloud
 }
+
+// printConfig loads the cloud provider configuration from the cloud config file
+// at path, merged with the environment exactly as the cloud provider does, and
+// writes it to w with secrets redacted.
+func printConfig(w io.Writer, path string) error {
+	var r io.Reader
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed opening cloud config: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	cfg, err := provider.LoadConfig(r)
+	if err != nil {
+		return err
+	}
+
+	return cfg.Print(w)
+}